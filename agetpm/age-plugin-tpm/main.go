@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"filippo.io/age"
+	"filippo.io/age/plugin"
+	"github.com/google/go-tpm-tools/simulator"
+	"github.com/google/go-tpm/tpm2/transport"
+	"github.com/google/go-tpm/tpmutil"
+	"github.com/ibiscum/tpm2/agetpm"
+	"github.com/ibiscum/tpm2/tpmkey"
+)
+
+// age runs plugins without flags of ours, so the TPM comes from the environment
+var defaultPath = "/dev/tpmrm0"
+
+var (
+	tpmPath  = flag.String("tpm-path", "", "Path to the TPM device (character device or a Unix socket), $AGE_PLUGIN_TPM_PATH or /dev/tpmrm0 if unset.")
+	generate = flag.Bool("generate", false, "create a TPM key and print its identity")
+	keyType  = flag.String("type", "p256", "key type: p256 (ECDH) or rsa (OAEP)")
+	pcrs     = flag.String("pcrs", "", "comma separated PCRs to bind the key to (eg 7,23)")
+	password = flag.String("password", "", "optional key password, asked for by age when decrypting")
+	out      = flag.String("o", "", "write the identity to this file instead of stdout")
+	convert  = flag.Bool("y", false, "print the recipients of the identities in the file argument (or stdin)")
+)
+
+var TPMDEVICES = []string{"/dev/tpm0", "/dev/tpmrm0"}
+
+func OpenTPM(path string) (io.ReadWriteCloser, error) {
+	if slices.Contains(TPMDEVICES, path) {
+		return tpmutil.OpenTPM(path)
+	} else if path == "simulator" {
+		return simulator.GetWithFixedSeedInsecure(1073741825)
+	} else {
+		return net.Dial("tcp", path)
+	}
+}
+
+func main() {
+	p, err := plugin.New(agetpm.Name)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	p.RegisterFlags(nil)
+	flag.Parse()
+
+	if *tpmPath == "" {
+		*tpmPath = os.Getenv("AGE_PLUGIN_TPM_PATH")
+	}
+	if *tpmPath == "" {
+		*tpmPath = defaultPath
+	}
+
+	switch {
+	case *generate:
+		keygen()
+		return
+	case *convert:
+		recipients()
+		return
+	}
+
+	var rwc io.ReadWriteCloser
+	p.HandleRecipient(func(data []byte) (age.Recipient, error) {
+		return agetpm.NewRecipient(data)
+	})
+	p.HandleIdentityAsRecipient(func(data []byte) (age.Recipient, error) {
+		i, err := agetpm.NewIdentity(data, nil)
+		if err != nil {
+			return nil, err
+		}
+		return i.Recipient(), nil
+	})
+	p.HandleIdentity(func(data []byte) (age.Identity, error) {
+		if rwc == nil {
+			if rwc, err = OpenTPM(*tpmPath); err != nil {
+				return nil, fmt.Errorf("can't open TPM %q: %v", *tpmPath, err)
+			}
+		}
+		i, err := agetpm.NewIdentity(data, transport.FromReadWriter(rwc))
+		if err != nil {
+			return nil, err
+		}
+		i.Auth = func() ([]byte, error) {
+			pw, err := p.RequestValue("TPM key password:", true)
+			return []byte(pw), err
+		}
+		return i, nil
+	})
+
+	code := p.Main()
+	if rwc != nil {
+		rwc.Close()
+	}
+	os.Exit(code)
+}
+
+func keygen() {
+	var pol *tpmkey.Policy
+	if *pcrs != "" {
+		pol = &tpmkey.Policy{}
+		for _, s := range strings.Split(*pcrs, ",") {
+			i, err := strconv.ParseUint(s, 10, 32)
+			if err != nil {
+				log.Fatalf("bad pcr %q: %v", s, err)
+			}
+			pol.PCRs = append(pol.PCRs, uint(i))
+		}
+	}
+
+	rwc, err := OpenTPM(*tpmPath)
+	if err != nil {
+		log.Fatalf("can't open TPM %q: %v", *tpmPath, err)
+	}
+	defer func() {
+		rwc.Close()
+	}()
+
+	rwr := transport.FromReadWriter(rwc)
+
+	kf, err := agetpm.Generate(rwr, *keyType, []byte(*password), pol)
+	if err != nil {
+		log.Fatalf("can't create key: %v", err)
+	}
+	r, err := agetpm.RecipientFromKeyfile(kf)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	w := os.Stdout
+	if *out != "" {
+		f, err := os.OpenFile(*out, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if err != nil {
+			log.Fatalf("can't create identity file: %v", err)
+		}
+		defer f.Close()
+		w = f
+		fmt.Fprintf(os.Stderr, "Public key: %s\n", r)
+	}
+	fmt.Fprintf(w, "# created: %s\n", time.Now().Format(time.RFC3339))
+	fmt.Fprintf(w, "# recipient: %s\n", r)
+	fmt.Fprintf(w, "%s\n", agetpm.IdentityString(kf))
+}
+
+func recipients() {
+	in := os.Stdin
+	if flag.NArg() > 0 {
+		f, err := os.Open(flag.Arg(0))
+		if err != nil {
+			log.Fatalf("can't open identity file: %v", err)
+		}
+		defer f.Close()
+		in = f
+	}
+	s := bufio.NewScanner(in)
+	s.Buffer(nil, 1<<20)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name, data, err := plugin.ParseIdentity(line)
+		if err != nil || name != agetpm.Name {
+			log.Fatalf("not an age-plugin-tpm identity: %v", err)
+		}
+		i, err := agetpm.NewIdentity(data, nil)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		fmt.Println(i.Recipient())
+	}
+	if err := s.Err(); err != nil {
+		log.Fatalf("%v", err)
+	}
+}
@@ -0,0 +1,179 @@
+// Package agetpm implements the recipient and identity of age-plugin-tpm:
+// age file keys wrapped to a key that only exists in one machine's TPM.
+//
+// Two key types are supported:
+//
+//   - P-256: the file key is wrapped like age's X25519 recipient, with an
+//     ephemeral software key whose shared secret the TPM recomputes with
+//     TPM2_ECDH_ZGen.
+//   - RSA: the file key is RSA-OAEP (SHA-256) encrypted and unwrapped with
+//     TPM2_RSA_Decrypt, as in encrypt_with_tpm_rsa.
+//
+// The recipient (age1tpm1...) holds the public key, the identity
+// (AGE-PLUGIN-TPM-1...) the TSS2 keyfile, which is useless on other TPMs.
+package agetpm
+
+import (
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+
+	"filippo.io/age"
+	"filippo.io/age/plugin"
+	keyfile "github.com/foxboron/go-tpm-keyfiles"
+	"github.com/google/go-tpm/tpm2"
+	"github.com/google/go-tpm/tpm2/transport"
+	"github.com/ibiscum/tpm2/tpmkey"
+)
+
+// Name is the plugin name, the binary is age-plugin-tpm.
+const Name = "tpm"
+
+// recipient payload types
+const (
+	typeP256 = 1
+	typeRSA  = 2
+)
+
+const (
+	stanzaP256 = "tpm-p256"
+	stanzaRSA  = "tpm-rsa"
+
+	// the OAEP label, zero terminated since the TPM hashes the terminator
+	oaepLabel = "age-plugin-tpm\x00"
+	hkdfLabel = "age-plugin-tpm/p256"
+)
+
+var b64 = base64.RawStdEncoding
+
+// Generate creates a P-256 (kind "p256") or RSA 2048 (kind "rsa") decryption
+// key under the owner SRK.
+func Generate(rwr transport.TPM, kind string, auth []byte, pol *tpmkey.Policy) (*keyfile.TPMKey, error) {
+	var template tpm2.TPMTPublic
+	switch kind {
+	case "p256":
+		template = tpmkey.ECCTemplate(tpm2.TPMECCNistP256, tpm2.TPMAlgNull, tpm2.TPMAlgNull)
+	case "rsa":
+		template = tpmkey.RSATemplate(2048, tpm2.TPMAlgNull, tpm2.TPMAlgNull)
+	default:
+		return nil, fmt.Errorf("agetpm: unknown key type %q", kind)
+	}
+	// decrypt only
+	template.ObjectAttributes.SignEncrypt = false
+
+	k, err := tpmkey.Create(rwr, template, auth, pol)
+	if err != nil {
+		return nil, err
+	}
+	k.AddOptions(keyfile.WithDescription("age-plugin-tpm"))
+	return k, nil
+}
+
+// Recipient wraps file keys to a TPM key.  It doesn't need the TPM.
+type Recipient struct {
+	data []byte
+
+	p256 *ecdh.PublicKey
+	rsa  *rsa.PublicKey
+}
+
+var _ age.Recipient = (*Recipient)(nil)
+
+// NewRecipient parses the payload of an age1tpm1... recipient.
+func NewRecipient(data []byte) (*Recipient, error) {
+	if len(data) < 2 {
+		return nil, fmt.Errorf("agetpm: short recipient")
+	}
+	r := &Recipient{
+		data: data,
+	}
+	switch data[0] {
+	case typeP256:
+		pub, err := ecdh.P256().NewPublicKey(decompress(data[1:]))
+		if err != nil {
+			return nil, fmt.Errorf("agetpm: bad P-256 recipient: %v", err)
+		}
+		r.p256 = pub
+	case typeRSA:
+		pub, err := x509.ParsePKCS1PublicKey(data[1:])
+		if err != nil {
+			return nil, fmt.Errorf("agetpm: bad RSA recipient: %v", err)
+		}
+		r.rsa = pub
+	default:
+		return nil, fmt.Errorf("agetpm: unknown recipient type %d", data[0])
+	}
+	return r, nil
+}
+
+// RecipientFromKeyfile returns the recipient of a keyfile made by Generate.
+func RecipientFromKeyfile(kf *keyfile.TPMKey) (*Recipient, error) {
+	pub, err := kf.PublicKey()
+	if err != nil {
+		return nil, fmt.Errorf("agetpm: can't read keyfile public key: %v", err)
+	}
+	var data []byte
+	switch p := pub.(type) {
+	case *ecdsa.PublicKey:
+		e, err := p.ECDH()
+		if err != nil || e.Curve() != ecdh.P256() {
+			return nil, fmt.Errorf("agetpm: only P-256 keys are supported")
+		}
+		data = append([]byte{typeP256}, compress(e.Bytes())...)
+	case *rsa.PublicKey:
+		data = append([]byte{typeRSA}, x509.MarshalPKCS1PublicKey(p)...)
+	default:
+		return nil, fmt.Errorf("agetpm: unsupported key type %T", pub)
+	}
+	return NewRecipient(data)
+}
+
+// String returns the age1tpm1... encoding.
+func (r *Recipient) String() string {
+	return plugin.EncodeRecipient(Name, r.data)
+}
+
+// tag identifies the recipient in stanzas so identities can skip stanzas
+// that aren't theirs without asking the TPM.
+func (r *Recipient) tag() string {
+	h := sha256.Sum256(r.data)
+	return b64.EncodeToString(h[:4])
+}
+
+// Wrap implements age.Recipient.
+func (r *Recipient) Wrap(fileKey []byte) ([]*age.Stanza, error) {
+	if r.rsa != nil {
+		ct, err := rsa.EncryptOAEP(sha256.New(), randReader, r.rsa, fileKey, []byte(oaepLabel))
+		if err != nil {
+			return nil, err
+		}
+		return []*age.Stanza{{
+			Type: stanzaRSA,
+			Args: []string{r.tag()},
+			Body: ct,
+		}}, nil
+	}
+
+	eph, err := ecdh.P256().GenerateKey(randReader)
+	if err != nil {
+		return nil, err
+	}
+	shared, err := eph.ECDH(r.p256)
+	if err != nil {
+		return nil, err
+	}
+	ephPub := compress(eph.PublicKey().Bytes())
+	body, err := aeadEncrypt(wrapKey(shared, ephPub, r.data[1:]), fileKey)
+	if err != nil {
+		return nil, err
+	}
+	return []*age.Stanza{{
+		Type: stanzaP256,
+		Args: []string{r.tag(), b64.EncodeToString(ephPub)},
+		Body: body,
+	}}, nil
+}
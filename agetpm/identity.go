@@ -0,0 +1,180 @@
+package agetpm
+
+import (
+	"crypto/ecdh"
+	"crypto/elliptic"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+
+	"filippo.io/age"
+	"filippo.io/age/plugin"
+	keyfile "github.com/foxboron/go-tpm-keyfiles"
+	"github.com/google/go-tpm/tpm2"
+	"github.com/google/go-tpm/tpm2/transport"
+	"github.com/ibiscum/tpm2/tpmkey"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+var randReader = rand.Reader
+
+// Identity unwraps file keys with the TPM.
+type Identity struct {
+	kf        *keyfile.TPMKey
+	recipient *Recipient
+	rwr       transport.TPM
+
+	// Auth returns the key's auth value.  It is only called for keys that
+	// have one and only once a stanza for this identity is found.
+	Auth func() ([]byte, error)
+}
+
+var _ age.Identity = (*Identity)(nil)
+
+// NewIdentity parses the payload of an AGE-PLUGIN-TPM-1... identity, the
+// DER TSS2 keyfile.  rwr is only used by Unwrap.
+func NewIdentity(data []byte, rwr transport.TPM) (*Identity, error) {
+	kf, err := keyfile.Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("agetpm: bad identity: %v", err)
+	}
+	r, err := RecipientFromKeyfile(kf)
+	if err != nil {
+		return nil, err
+	}
+	return &Identity{
+		kf:        kf,
+		recipient: r,
+		rwr:       rwr,
+	}, nil
+}
+
+// IdentityString returns the AGE-PLUGIN-TPM-1... encoding of a keyfile.
+func IdentityString(kf *keyfile.TPMKey) string {
+	return plugin.EncodeIdentity(Name, keyfile.Marshal(kf))
+}
+
+// Recipient returns the recipient matching the identity.
+func (i *Identity) Recipient() *Recipient {
+	return i.recipient
+}
+
+// Unwrap implements age.Identity.
+func (i *Identity) Unwrap(stanzas []*age.Stanza) ([]byte, error) {
+	want := stanzaP256
+	if i.recipient.rsa != nil {
+		want = stanzaRSA
+	}
+	tag := i.recipient.tag()
+
+	var mine []*age.Stanza
+	for _, s := range stanzas {
+		if s.Type == want && len(s.Args) > 0 && s.Args[0] == tag {
+			mine = append(mine, s)
+		}
+	}
+	if len(mine) == 0 {
+		return nil, age.ErrIncorrectIdentity
+	}
+
+	var auth []byte
+	if !i.kf.EmptyAuth && i.Auth != nil {
+		var err error
+		if auth, err = i.Auth(); err != nil {
+			return nil, err
+		}
+	}
+	k, err := tpmkey.Load(i.rwr, i.kf, auth)
+	if err != nil {
+		return nil, err
+	}
+	defer k.Close()
+
+	var errs []error
+	for _, s := range mine {
+		fileKey, err := i.unwrap(k, s)
+		if err == nil {
+			return fileKey, nil
+		}
+		errs = append(errs, err)
+	}
+	return nil, fmt.Errorf("agetpm: can't unwrap file key: %v", errors.Join(errs...))
+}
+
+func (i *Identity) unwrap(k *tpmkey.Key, s *age.Stanza) ([]byte, error) {
+	if s.Type == stanzaRSA {
+		if len(s.Args) != 1 {
+			return nil, fmt.Errorf("invalid %s stanza", stanzaRSA)
+		}
+		return k.DecryptOAEP(s.Body, []byte(oaepLabel), tpm2.TPMAlgSHA256)
+	}
+
+	if len(s.Args) != 2 {
+		return nil, fmt.Errorf("invalid %s stanza", stanzaP256)
+	}
+	ephPub, err := b64.DecodeString(s.Args[1])
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s stanza: %v", stanzaP256, err)
+	}
+	eph, err := ecdh.P256().NewPublicKey(decompress(ephPub))
+	if err != nil {
+		return nil, fmt.Errorf("invalid ephemeral key: %v", err)
+	}
+	shared, err := k.ECDH(eph)
+	if err != nil {
+		return nil, err
+	}
+	return aeadDecrypt(wrapKey(shared, ephPub, i.recipient.data[1:]), s.Body)
+}
+
+// wrapKey derives the key wrapping the file key from the ECDH secret, bound
+// to both public keys as age does for X25519.
+func wrapKey(shared, ephPub, recipientPub []byte) []byte {
+	salt := append(append([]byte{}, ephPub...), recipientPub...)
+	key, err := hkdf.Key(sha256.New, shared, salt, hkdfLabel, chacha20poly1305.KeySize)
+	if err != nil {
+		panic(err)
+	}
+	return key
+}
+
+// a fresh key is used for every stanza, so the nonce can be zero
+func aeadEncrypt(key, plaintext []byte) ([]byte, error) {
+	aead, err := chacha20poly1305.New(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, chacha20poly1305.NonceSize)
+	return aead.Seal(nil, nonce, plaintext, nil), nil
+}
+
+func aeadDecrypt(key, ciphertext []byte) ([]byte, error) {
+	aead, err := chacha20poly1305.New(key)
+	if err != nil {
+		return nil, err
+	}
+	// age file keys are 16 bytes
+	if len(ciphertext) != 16+aead.Overhead() {
+		return nil, fmt.Errorf("invalid stanza body length")
+	}
+	nonce := make([]byte, chacha20poly1305.NonceSize)
+	return aead.Open(nil, nonce, ciphertext, nil)
+}
+
+func compress(uncompressed []byte) []byte {
+	x, y := elliptic.Unmarshal(elliptic.P256(), uncompressed)
+	if x == nil {
+		return nil
+	}
+	return elliptic.MarshalCompressed(elliptic.P256(), x, y)
+}
+
+func decompress(compressed []byte) []byte {
+	x, y := elliptic.UnmarshalCompressed(elliptic.P256(), compressed)
+	if x == nil {
+		return nil
+	}
+	return elliptic.Marshal(elliptic.P256(), x, y)
+}
@@ -0,0 +1,50 @@
+// Package atomicfile replaces files so that readers, and a crash, see
+// either the old content or the new one: the new content is written and
+// synced to a temporary file in the same directory, then renamed over the
+// file.
+package atomicfile
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// WriteTemp writes data to a new temporary file next to name, with mode
+// perm, and returns its path, for the caller to rename over name.  The
+// file is removed if it can't be written.
+func WriteTemp(name string, data []byte, perm os.FileMode) (string, error) {
+	f, err := os.CreateTemp(filepath.Dir(name), "."+filepath.Base(name)+".*")
+	if err != nil {
+		return "", fmt.Errorf("atomicfile: %v", err)
+	}
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Chmod(perm)
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", fmt.Errorf("atomicfile: can't write %s: %v", name, err)
+	}
+	return f.Name(), nil
+}
+
+// WriteFile replaces name with data, with mode perm, through WriteTemp and
+// a rename.
+func WriteFile(name string, data []byte, perm os.FileMode) error {
+	tmp, err := WriteTemp(name, data, perm)
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, name); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("atomicfile: can't replace %s: %v", name, err)
+	}
+	return nil
+}
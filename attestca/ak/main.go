@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/hex"
+	"flag"
+	"io"
+	"log"
+	"net"
+	"os"
+	"slices"
+
+	keyfile "github.com/foxboron/go-tpm-keyfiles"
+	"github.com/google/go-tpm-tools/simulator"
+	"github.com/google/go-tpm/tpm2"
+	"github.com/google/go-tpm/tpm2/transport"
+	"github.com/google/go-tpm/tpmutil"
+	"github.com/ibiscum/tpm2/attestca"
+	"github.com/ibiscum/tpm2/tpmkey"
+)
+
+var (
+	tpmPath  = flag.String("tpm-path", "/dev/tpmrm0", "Path to the TPM device (character device or a Unix socket).")
+	keyType  = flag.String("type", "rsa", "AK type: rsa (RSA 2048, RSASSA-SHA256) or ecc (P-256, ECDSA-SHA256)")
+	password = flag.String("password", "", "optional AK password")
+	out      = flag.String("out", "ak.pem", "TSS2 keyfile of the AK")
+	pubOut   = flag.String("pub", "ak.pub", "TPM2B_PUBLIC of the AK, to hand to the CA")
+)
+
+var TPMDEVICES = []string{"/dev/tpm0", "/dev/tpmrm0"}
+
+func OpenTPM(path string) (io.ReadWriteCloser, error) {
+	if slices.Contains(TPMDEVICES, path) {
+		return tpmutil.OpenTPM(path)
+	} else if path == "simulator" {
+		return simulator.GetWithFixedSeedInsecure(1073741825)
+	} else {
+		return net.Dial("tcp", path)
+	}
+}
+
+func main() {
+	flag.Parse()
+
+	alg := map[string]tpm2.TPMAlgID{"rsa": tpm2.TPMAlgRSA, "ecc": tpm2.TPMAlgECC}[*keyType]
+	template, err := attestca.AKTemplate(alg)
+	if err != nil {
+		log.Fatalf("unknown AK type %q", *keyType)
+	}
+
+	rwc, err := OpenTPM(*tpmPath)
+	if err != nil {
+		log.Fatalf("can't open TPM %q: %v", *tpmPath, err)
+	}
+	defer func() {
+		rwc.Close()
+	}()
+
+	rwr := transport.FromReadWriter(rwc)
+
+	kf, err := tpmkey.Create(rwr, template, []byte(*password), nil)
+	if err != nil {
+		log.Fatalf("can't create AK: %v", err)
+	}
+	pub, err := kf.Pubkey.Contents()
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	name, err := tpm2.ObjectName(pub)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	f, err := os.OpenFile(*out, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		log.Fatalf("can't create keyfile: %v", err)
+	}
+	defer f.Close()
+	if err := keyfile.Encode(f, kf); err != nil {
+		log.Fatalf("can't write keyfile: %v", err)
+	}
+	if err := os.WriteFile(*pubOut, tpm2.Marshal(kf.Pubkey), 0644); err != nil {
+		log.Fatalf("can't write AK public: %v", err)
+	}
+	log.Printf("wrote %s and %s, AK name %s", *out, *pubOut, hex.EncodeToString(name.Buffer))
+}
@@ -0,0 +1,118 @@
+package attestca
+
+import (
+	"fmt"
+
+	keyfile "github.com/foxboron/go-tpm-keyfiles"
+	"github.com/google/go-tpm/tpm2"
+	"github.com/google/go-tpm/tpm2/transport"
+	"github.com/ibiscum/tpm2/tpmkey"
+)
+
+// AKTemplate returns the template of a restricted signing key, RSA 2048
+// with RSASSA-SHA256 or ECC P-256 with ECDSA-SHA256, to use as the AK.
+func AKTemplate(alg tpm2.TPMAlgID) (tpm2.TPMTPublic, error) {
+	var t tpm2.TPMTPublic
+	switch alg {
+	case tpm2.TPMAlgRSA:
+		t = tpmkey.RSATemplate(2048, tpm2.TPMAlgRSASSA, tpm2.TPMAlgSHA256)
+	case tpm2.TPMAlgECC:
+		t = tpmkey.ECCTemplate(tpm2.TPMECCNistP256, tpm2.TPMAlgECDSA, tpm2.TPMAlgSHA256)
+	default:
+		return t, fmt.Errorf("attestca: unsupported AK algorithm %v", alg)
+	}
+	t.ObjectAttributes.Restricted = true
+	return t, nil
+}
+
+// Attest creates a key from template under the owner SRK, has ak certify it
+// with nonce as qualifying data and returns the keyfile and the request to
+// send to the CA.  With creation TPM2_CertifyCreation is used, which also
+// proves the key was created by this TPM under that parent.
+func Attest(rwr transport.TPM, ak *tpmkey.Key, template tpm2.TPMTPublic, auth, nonce []byte, creation bool) (*keyfile.TPMKey, *Request, error) {
+	parent, closer, err := tpmkey.Parent(rwr, tpm2.TPMRHOwner)
+	if err != nil {
+		return nil, nil, err
+	}
+	crsp, err := tpm2.Create{
+		ParentHandle: tpm2.AuthHandle{
+			Handle: parent.Handle,
+			Name:   parent.Name,
+			Auth:   tpm2.PasswordAuth(nil),
+		},
+		InPublic: tpm2.New2B(template),
+		InSensitive: tpm2.TPM2BSensitiveCreate{
+			Sensitive: &tpm2.TPMSSensitiveCreate{
+				UserAuth: tpm2.TPM2BAuth{
+					Buffer: auth,
+				},
+			},
+		},
+	}.Execute(rwr)
+	if err != nil {
+		closer()
+		return nil, nil, fmt.Errorf("attestca: can't create key: %v", err)
+	}
+	// the simulator has 3 object slots: AK, SRK and key, so the SRK goes
+	// before certifying
+	k, err := tpmkey.LoadBlob(rwr, parent, crsp.OutPublic, crsp.OutPrivate, auth, nil)
+	closer()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer k.Close()
+
+	req := &Request{
+		Public: tpm2.Marshal(crsp.OutPublic),
+		AKName: ak.Name.Buffer,
+	}
+	var info tpm2.TPM2BAttest
+	var sig tpm2.TPMTSignature
+	if creation {
+		rsp, err := tpm2.CertifyCreation{
+			SignHandle: ak.AuthHandle(),
+			ObjectHandle: tpm2.NamedHandle{
+				Handle: k.Handle,
+				Name:   k.Name,
+			},
+			QualifyingData: tpm2.TPM2BData{
+				Buffer: nonce,
+			},
+			CreationHash: crsp.CreationHash,
+			InScheme: tpm2.TPMTSigScheme{
+				Scheme: tpm2.TPMAlgNull,
+			},
+			CreationTicket: crsp.CreationTicket,
+		}.Execute(rwr)
+		if err != nil {
+			return nil, nil, fmt.Errorf("attestca: certify creation failed: %v", err)
+		}
+		info, sig = rsp.CertifyInfo, rsp.Signature
+		req.Type = TypeCertifyCreation
+		req.CreationData = crsp.CreationData.Bytes()
+	} else {
+		rsp, err := tpm2.Certify{
+			ObjectHandle: k.AuthHandle(),
+			SignHandle:   ak.AuthHandle(),
+			QualifyingData: tpm2.TPM2BData{
+				Buffer: nonce,
+			},
+			InScheme: tpm2.TPMTSigScheme{
+				Scheme: tpm2.TPMAlgNull,
+			},
+		}.Execute(rwr)
+		if err != nil {
+			return nil, nil, fmt.Errorf("attestca: certify failed: %v", err)
+		}
+		info, sig = rsp.CertifyInfo, rsp.Signature
+		req.Type = TypeCertify
+	}
+	req.Attest = info.Bytes()
+	req.Signature = tpm2.Marshal(sig)
+
+	kf := keyfile.NewTPMKey(keyfile.OIDLoadableKey, crsp.OutPublic, crsp.OutPrivate,
+		keyfile.WithParent(tpm2.TPMRHOwner),
+		keyfile.WithUserAuth(auth),
+	)
+	return kf, req, nil
+}
@@ -0,0 +1,105 @@
+// Package attestca is a small test CA that only issues certificates for keys
+// a trusted attestation key (AK) vouches for, the backend of the certify
+// flow sign_certify_ak demonstrates.
+//
+// The client gets a nonce from the CA, creates a key under its SRK and has
+// its AK sign a TPM2_Certify (or TPM2_CertifyCreation) over it with the nonce
+// as qualifying data.  The CA checks:
+//
+//   - the AK is one it was told to trust, and is a restricted signing key
+//   - the signature over the TPMS_ATTEST is the AK's, and the TPMS_ATTEST
+//     starts with TPM_GENERATED, so the TPM made it rather than had it
+//     signed (a restricted key signs anything else)
+//   - the attestation is of the right type, carries an unused nonce and
+//     names the key the client sent (the name is recomputed from its public
+//     area, so the attributes can't be swapped)
+//   - for CertifyCreation, the creation data hashes to the certified hash
+//   - the key is FixedTPM, FixedParent and SensitiveDataOrigin: it was
+//     generated inside this TPM and can never leave it
+//
+// and then issues a leaf with a TPMResident extension recording the key and
+// AK names.  The CA key is a plain PEM file; this is for tests, not for
+// production.
+package attestca
+
+import (
+	"crypto/x509"
+	"encoding/asn1"
+	"fmt"
+)
+
+// Attestation types.
+const (
+	TypeCertify         = "certify"
+	TypeCertifyCreation = "certify-creation"
+)
+
+// Request is the JSON body POSTed to /issue.
+type Request struct {
+	Type string `json:"type"`
+	// Public is the TPM2B_PUBLIC of the key.
+	Public []byte `json:"public"`
+	// Attest is the TPMS_ATTEST the AK signed (the TPM2B_ATTEST contents)
+	// and Signature the TPMT_SIGNATURE over it.
+	Attest    []byte `json:"attest"`
+	Signature []byte `json:"signature"`
+	// AKName is the name of the AK, to pick the trusted AK to verify with.
+	AKName []byte `json:"ak_name"`
+	// CreationData is the TPMS_CREATION_DATA (the TPM2B_CREATION_DATA
+	// contents), for certify-creation.
+	CreationData []byte `json:"creation_data,omitempty"`
+
+	CommonName  string   `json:"common_name"`
+	SANs        []string `json:"sans,omitempty"`
+	ExtKeyUsage []string `json:"ext_key_usage,omitempty"`
+}
+
+// Response is the JSON reply to /issue.
+type Response struct {
+	// Certificate is the PEM leaf followed by the CA certificate.
+	Certificate string `json:"certificate"`
+}
+
+// NonceResponse is the JSON reply to /nonce.
+type NonceResponse struct {
+	Nonce []byte `json:"nonce"`
+}
+
+// OIDTPMResident identifies the TPMResident extension.  It sits under the
+// enterprise number RFC 5612 reserves for examples, as befits a test CA; a
+// real deployment would use its own arc.
+var OIDTPMResident = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 32473, 2, 1}
+
+// TPMResident is the value of the extension:
+//
+//	TPMResident ::= SEQUENCE {
+//	    version           INTEGER (1),
+//	    attestation       UTF8String,  -- "certify" or "certify-creation"
+//	    keyName           OCTET STRING,
+//	    akName            OCTET STRING,
+//	    objectAttributes  INTEGER      -- TPMA_OBJECT of the key
+//	}
+type TPMResident struct {
+	Version          int
+	Attestation      string `asn1:"utf8"`
+	KeyName          []byte
+	AKName           []byte
+	ObjectAttributes int64
+}
+
+// ParseTPMResident returns the TPMResident extension of cert, nil if it
+// has none.
+func ParseTPMResident(cert *x509.Certificate) (*TPMResident, error) {
+	for _, ext := range cert.Extensions {
+		if !ext.Id.Equal(OIDTPMResident) {
+			continue
+		}
+		var r TPMResident
+		rest, err := asn1.Unmarshal(ext.Value, &r)
+		if err != nil || len(rest) != 0 {
+			return nil, fmt.Errorf("attestca: bad TPMResident extension")
+		}
+		return &r, nil
+	}
+	return nil, nil
+}
@@ -0,0 +1,459 @@
+package attestca
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math/big"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/google/go-tpm/tpm2"
+	"github.com/ibiscum/tpm2/certgen"
+)
+
+// nonceLifetime is how long a nonce from /nonce can be used.
+const nonceLifetime = 5 * time.Minute
+
+// CA verifies attestations and issues certificates.
+type CA struct {
+	Key  crypto.Signer
+	Cert *x509.Certificate
+	// Validity of the issued certificates.
+	Validity time.Duration
+
+	mu     sync.Mutex
+	aks    map[string]*tpm2.TPMTPublic
+	nonces map[string]time.Time
+}
+
+// LoadCA reads the PEM CA key and certificate, creating an ECDSA P-256 key
+// and a self-signed certificate if neither file exists.
+func LoadCA(keyFile, certFile string) (*CA, error) {
+	keyPEM, kerr := os.ReadFile(keyFile)
+	certPEM, cerr := os.ReadFile(certFile)
+	switch {
+	case errors.Is(kerr, fs.ErrNotExist) && errors.Is(cerr, fs.ErrNotExist):
+		return createCA(keyFile, certFile)
+	case kerr != nil:
+		return nil, fmt.Errorf("attestca: can't read CA key: %v", kerr)
+	case cerr != nil:
+		return nil, fmt.Errorf("attestca: can't read CA certificate: %v", cerr)
+	}
+
+	block, _ := pem.Decode(keyPEM)
+	if block == nil {
+		return nil, fmt.Errorf("attestca: no PEM block in %s", keyFile)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("attestca: can't parse CA key: %v", err)
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("attestca: unsupported CA key %T", key)
+	}
+	block, _ = pem.Decode(certPEM)
+	if block == nil {
+		return nil, fmt.Errorf("attestca: no PEM block in %s", certFile)
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("attestca: can't parse CA certificate: %v", err)
+	}
+	return newCA(signer, cert), nil
+}
+
+func createCA(keyFile, certFile string) (*CA, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 127))
+	if err != nil {
+		return nil, err
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "attestca test CA"},
+		NotBefore:             time.Now().Add(-5 * time.Minute),
+		NotAfter:              time.Now().Add(10 * 365 * 24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8}), 0600); err != nil {
+		return nil, fmt.Errorf("attestca: can't write CA key: %v", err)
+	}
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644); err != nil {
+		return nil, fmt.Errorf("attestca: can't write CA certificate: %v", err)
+	}
+	return newCA(key, cert), nil
+}
+
+func newCA(key crypto.Signer, cert *x509.Certificate) *CA {
+	return &CA{
+		Key:      key,
+		Cert:     cert,
+		Validity: 30 * 24 * time.Hour,
+		aks:      map[string]*tpm2.TPMTPublic{},
+		nonces:   map[string]time.Time{},
+	}
+}
+
+// TrustAK adds an AK, given as a TPM2B_PUBLIC, and returns its name.  Only
+// restricted signing keys that can't leave their TPM are accepted: anything
+// else could sign a forged TPMS_ATTEST.
+func (c *CA) TrustAK(public []byte) ([]byte, error) {
+	pub, err := tpm2.Unmarshal[tpm2.TPM2BPublic](public)
+	if err != nil {
+		return nil, fmt.Errorf("attestca: bad AK public: %v", err)
+	}
+	ak, err := pub.Contents()
+	if err != nil {
+		return nil, fmt.Errorf("attestca: bad AK public: %v", err)
+	}
+	attrs := ak.ObjectAttributes
+	if !attrs.Restricted || !attrs.SignEncrypt || attrs.Decrypt || !attrs.FixedTPM {
+		return nil, fmt.Errorf("attestca: AK must be a restricted, fixedTPM signing key")
+	}
+	name, err := tpm2.ObjectName(ak)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	c.aks[hex.EncodeToString(name.Buffer)] = ak
+	c.mu.Unlock()
+	return name.Buffer, nil
+}
+
+// Nonce returns a fresh nonce for one attestation.
+func (c *CA) Nonce() ([]byte, error) {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	for n, t := range c.nonces {
+		if now.Sub(t) > nonceLifetime {
+			delete(c.nonces, n)
+		}
+	}
+	c.nonces[string(nonce)] = now
+	return nonce, nil
+}
+
+// useNonce consumes nonce, false if it was never issued, already used or
+// has expired.
+func (c *CA) useNonce(nonce []byte) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t, ok := c.nonces[string(nonce)]
+	delete(c.nonces, string(nonce))
+	return ok && time.Since(t) <= nonceLifetime
+}
+
+// Verify checks req and returns the public area of the attested key.
+func (c *CA) Verify(req *Request) (*tpm2.TPMTPublic, error) {
+	c.mu.Lock()
+	ak, ok := c.aks[hex.EncodeToString(req.AKName)]
+	c.mu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("attestca: AK %x isn't trusted", req.AKName)
+	}
+
+	sig, err := tpm2.Unmarshal[tpm2.TPMTSignature](req.Signature)
+	if err != nil {
+		return nil, fmt.Errorf("attestca: bad signature: %v", err)
+	}
+	if err := verifySignature(ak, req.Attest, sig); err != nil {
+		return nil, err
+	}
+
+	// the AK signed the attestation from here on
+	attest, err := tpm2.Unmarshal[tpm2.TPMSAttest](req.Attest)
+	if err != nil {
+		return nil, fmt.Errorf("attestca: bad attestation: %v", err)
+	}
+	// go-tpm doesn't check the magic when unmarshalling.  A restricted AK
+	// signs anything not starting with TPM_GENERATED, so without this check
+	// a forged TPMS_ATTEST would pass.
+	if err := attest.Magic.Check(); err != nil {
+		return nil, fmt.Errorf("attestca: attestation wasn't generated by the TPM: %v", err)
+	}
+	var wantType tpm2.TPMISTAttest
+	var command string
+	switch req.Type {
+	case TypeCertify:
+		wantType, command = tpm2.TPMSTAttestCertify, "TPM2_Certify"
+	case TypeCertifyCreation:
+		wantType, command = tpm2.TPMSTAttestCreation, "TPM2_CertifyCreation"
+	default:
+		return nil, fmt.Errorf("attestca: unknown attestation type %q", req.Type)
+	}
+	if attest.Type != wantType {
+		return nil, fmt.Errorf("attestca: attestation isn't a %s", command)
+	}
+	if !c.useNonce(attest.ExtraData.Buffer) {
+		return nil, fmt.Errorf("attestca: unknown, reused or expired nonce")
+	}
+
+	pub2b, err := tpm2.Unmarshal[tpm2.TPM2BPublic](req.Public)
+	if err != nil {
+		return nil, fmt.Errorf("attestca: bad key public: %v", err)
+	}
+	pub, err := pub2b.Contents()
+	if err != nil {
+		return nil, fmt.Errorf("attestca: bad key public: %v", err)
+	}
+	name, err := tpm2.ObjectName(pub)
+	if err != nil {
+		return nil, err
+	}
+
+	var attested []byte
+	switch req.Type {
+	case TypeCertify:
+		info, err := attest.Attested.Certify()
+		if err != nil {
+			return nil, err
+		}
+		attested = info.Name.Buffer
+	case TypeCertifyCreation:
+		info, err := attest.Attested.Creation()
+		if err != nil {
+			return nil, err
+		}
+		attested = info.ObjectName.Buffer
+		h, err := pub.NameAlg.Hash()
+		if err != nil {
+			return nil, err
+		}
+		digest := h.New()
+		digest.Write(req.CreationData)
+		if !bytes.Equal(digest.Sum(nil), info.CreationHash.Buffer) {
+			return nil, fmt.Errorf("attestca: creation data doesn't match the certified creation hash")
+		}
+	}
+	if !bytes.Equal(attested, name.Buffer) {
+		return nil, fmt.Errorf("attestca: attestation is for another key")
+	}
+
+	attrs := pub.ObjectAttributes
+	if !attrs.FixedTPM || !attrs.FixedParent || !attrs.SensitiveDataOrigin {
+		return nil, fmt.Errorf("attestca: key must be fixedTPM, fixedParent and sensitiveDataOrigin")
+	}
+	if attrs.Restricted {
+		return nil, fmt.Errorf("attestca: restricted keys can't use a certificate")
+	}
+	return pub, nil
+}
+
+// verifySignature checks sig over data with the AK.
+func verifySignature(ak *tpm2.TPMTPublic, data []byte, sig *tpm2.TPMTSignature) error {
+	key, err := tpm2.Pub(*ak)
+	if err != nil {
+		return err
+	}
+	var ok bool
+	switch sig.SigAlg {
+	case tpm2.TPMAlgRSASSA, tpm2.TPMAlgRSAPSS:
+		var s *tpm2.TPMSSignatureRSA
+		if sig.SigAlg == tpm2.TPMAlgRSASSA {
+			s, err = sig.Signature.RSASSA()
+		} else {
+			s, err = sig.Signature.RSAPSS()
+		}
+		if err != nil {
+			return err
+		}
+		pub, isRSA := key.(*rsa.PublicKey)
+		if !isRSA {
+			return fmt.Errorf("attestca: RSA signature from a non RSA AK")
+		}
+		h, err := s.Hash.Hash()
+		if err != nil {
+			return err
+		}
+		digest := h.New()
+		digest.Write(data)
+		if sig.SigAlg == tpm2.TPMAlgRSASSA {
+			ok = rsa.VerifyPKCS1v15(pub, h, digest.Sum(nil), s.Sig.Buffer) == nil
+		} else {
+			ok = rsa.VerifyPSS(pub, h, digest.Sum(nil), s.Sig.Buffer, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthAuto}) == nil
+		}
+	case tpm2.TPMAlgECDSA:
+		s, err := sig.Signature.ECDSA()
+		if err != nil {
+			return err
+		}
+		pub, isECC := key.(*ecdsa.PublicKey)
+		if !isECC {
+			return fmt.Errorf("attestca: ECDSA signature from a non ECC AK")
+		}
+		h, err := s.Hash.Hash()
+		if err != nil {
+			return err
+		}
+		digest := h.New()
+		digest.Write(data)
+		r := new(big.Int).SetBytes(s.SignatureR.Buffer)
+		ss := new(big.Int).SetBytes(s.SignatureS.Buffer)
+		ok = ecdsa.Verify(pub, digest.Sum(nil), r, ss)
+	default:
+		return fmt.Errorf("attestca: unsupported signature algorithm 0x%04x", uint16(sig.SigAlg))
+	}
+	if !ok {
+		return fmt.Errorf("attestca: attestation signature doesn't verify")
+	}
+	return nil
+}
+
+// Issue verifies req and returns the PEM leaf certificate followed by the CA
+// certificate.
+func (c *CA) Issue(req *Request) ([]byte, error) {
+	pub, err := c.Verify(req)
+	if err != nil {
+		return nil, err
+	}
+	key, err := tpm2.Pub(*pub)
+	if err != nil {
+		return nil, err
+	}
+
+	var opts certgen.Options
+	if err := opts.SANs(req.SANs); err != nil {
+		return nil, err
+	}
+	for _, u := range req.ExtKeyUsage {
+		eku, err := certgen.ParseExtKeyUsage(u)
+		if err != nil {
+			return nil, err
+		}
+		opts.ExtKeyUsage = append(opts.ExtKeyUsage, eku...)
+	}
+
+	name, err := tpm2.ObjectName(pub)
+	if err != nil {
+		return nil, err
+	}
+	ext, err := asn1.Marshal(TPMResident{
+		Version:          1,
+		Attestation:      req.Type,
+		KeyName:          name.Buffer,
+		AKName:           req.AKName,
+		ObjectAttributes: int64(binary.BigEndian.Uint32(tpm2.Marshal(pub.ObjectAttributes))),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 127))
+	if err != nil {
+		return nil, err
+	}
+	notBefore := time.Now().Add(-5 * time.Minute)
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: req.CommonName},
+		NotBefore:             notBefore,
+		NotAfter:              notBefore.Add(c.Validity),
+		DNSNames:              opts.DNSNames,
+		IPAddresses:           opts.IPAddresses,
+		EmailAddresses:        opts.EmailAddresses,
+		URIs:                  opts.URIs,
+		KeyUsage:              keyUsage(pub),
+		ExtKeyUsage:           opts.ExtKeyUsage,
+		BasicConstraintsValid: true,
+		ExtraExtensions:       []pkix.Extension{{Id: OIDTPMResident, Value: ext}},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, c.Cert, key, c.Key)
+	if err != nil {
+		return nil, fmt.Errorf("attestca: can't issue certificate: %v", err)
+	}
+	out := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	out = append(out, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.Cert.Raw})...)
+	return out, nil
+}
+
+// keyUsage is what the TPM will let the key do.
+func keyUsage(pub *tpm2.TPMTPublic) x509.KeyUsage {
+	var ku x509.KeyUsage
+	if pub.ObjectAttributes.SignEncrypt {
+		ku |= x509.KeyUsageDigitalSignature
+	}
+	if pub.ObjectAttributes.Decrypt {
+		switch pub.Type {
+		case tpm2.TPMAlgRSA:
+			ku |= x509.KeyUsageKeyEncipherment
+		case tpm2.TPMAlgECC:
+			ku |= x509.KeyUsageKeyAgreement
+		}
+	}
+	return ku
+}
+
+// Handler serves GET /nonce, GET /ca.crt and POST /issue.
+func (c *CA) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /nonce", func(w http.ResponseWriter, r *http.Request) {
+		nonce, err := c.Nonce()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, NonceResponse{Nonce: nonce})
+	})
+	mux.HandleFunc("GET /ca.crt", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/x-pem-file")
+		w.Write(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.Cert.Raw}))
+	})
+	mux.HandleFunc("POST /issue", func(w http.ResponseWriter, r *http.Request) {
+		var req Request
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16)).Decode(&req); err != nil {
+			http.Error(w, "bad request: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		chain, err := c.Issue(&req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		writeJSON(w, Response{Certificate: string(chain)})
+	})
+	return mux
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
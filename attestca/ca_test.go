@@ -0,0 +1,131 @@
+package attestca
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/go-tpm-tools/simulator"
+	"github.com/google/go-tpm/tpm2"
+	"github.com/google/go-tpm/tpm2/transport"
+	"github.com/ibiscum/tpm2/tpmkey"
+)
+
+// testAK creates an ECC AK on a simulator and a CA trusting it.
+func testAK(t *testing.T) (transport.TPM, *tpmkey.Key, *CA) {
+	t.Helper()
+	rwc, err := simulator.GetWithFixedSeedInsecure(1073741825)
+	if err != nil {
+		t.Fatalf("can't open simulator: %v", err)
+	}
+	t.Cleanup(func() { rwc.Close() })
+	rwr := transport.FromReadWriter(rwc)
+
+	template, err := AKTemplate(tpm2.TPMAlgECC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kf, err := tpmkey.Create(rwr, template, nil, nil)
+	if err != nil {
+		t.Fatalf("can't create AK: %v", err)
+	}
+	ak, err := tpmkey.Load(rwr, kf, nil)
+	if err != nil {
+		t.Fatalf("can't load AK: %v", err)
+	}
+	t.Cleanup(func() { ak.Close() })
+
+	ca := newCA(nil, nil)
+	if _, err := ca.TrustAK(tpm2.Marshal(kf.Pubkey)); err != nil {
+		t.Fatalf("TrustAK: %v", err)
+	}
+	return rwr, ak, ca
+}
+
+// testRequest has ak certify a new signing key over a fresh nonce.
+func testRequest(t *testing.T, rwr transport.TPM, ak *tpmkey.Key, ca *CA) *Request {
+	t.Helper()
+	nonce, err := ca.Nonce()
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := tpmkey.ECCTemplate(tpm2.TPMECCNistP256, tpm2.TPMAlgECDSA, tpm2.TPMAlgSHA256)
+	_, req, err := Attest(rwr, ak, template, nil, nonce, false)
+	if err != nil {
+		t.Fatalf("Attest: %v", err)
+	}
+	return req
+}
+
+func TestVerify(t *testing.T) {
+	rwr, ak, ca := testAK(t)
+	req := testRequest(t, rwr, ak, ca)
+	if _, err := ca.Verify(req); err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	if _, err := ca.Verify(req); err == nil || !strings.Contains(err.Error(), "nonce") {
+		t.Errorf("Verify of a replayed request = %v, want a nonce error", err)
+	}
+}
+
+// The AK signs a TPMS_ATTEST with another magic when it is hashed by the
+// TPM first: the hash ticket only says the data doesn't start with
+// TPM_GENERATED.
+func TestVerifyBadMagic(t *testing.T) {
+	rwr, ak, ca := testAK(t)
+	req := testRequest(t, rwr, ak, ca)
+
+	attest, err := tpm2.Unmarshal[tpm2.TPMSAttest](req.Attest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	forged := *attest
+	forged.Magic = 0x46414b45
+	data := tpm2.Marshal(forged)
+
+	hrsp, err := tpm2.Hash{
+		Data: tpm2.TPM2BMaxBuffer{
+			Buffer: data,
+		},
+		HashAlg:   tpm2.TPMAlgSHA256,
+		Hierarchy: tpm2.TPMRHOwner,
+	}.Execute(rwr)
+	if err != nil {
+		t.Fatalf("Hash: %v", err)
+	}
+	srsp, err := tpm2.Sign{
+		KeyHandle: ak.AuthHandle(),
+		Digest:    hrsp.OutHash,
+		InScheme: tpm2.TPMTSigScheme{
+			Scheme: tpm2.TPMAlgNull,
+		},
+		Validation: hrsp.Validation,
+	}.Execute(rwr)
+	if err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+
+	bad := *req
+	bad.Attest = data
+	bad.Signature = tpm2.Marshal(srsp.Signature)
+	if _, err := ca.Verify(&bad); err == nil || !strings.Contains(err.Error(), "wasn't generated by the TPM") {
+		t.Fatalf("Verify of a bad magic = %v, want a magic error", err)
+	}
+	// refused before the nonce was used
+	if _, err := ca.Verify(req); err != nil {
+		t.Errorf("Verify after a bad magic: %v", err)
+	}
+}
+
+func TestVerifyWrongType(t *testing.T) {
+	rwr, ak, ca := testAK(t)
+	req := testRequest(t, rwr, ak, ca)
+
+	bad := *req
+	bad.Type = TypeCertifyCreation
+	if _, err := ca.Verify(&bad); err == nil || !strings.Contains(err.Error(), "isn't a TPM2_CertifyCreation") {
+		t.Fatalf("Verify of a certify as certify-creation = %v, want a type error", err)
+	}
+	if _, err := ca.Verify(req); err != nil {
+		t.Errorf("Verify after a wrong type: %v", err)
+	}
+}
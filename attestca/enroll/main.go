@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+
+	keyfile "github.com/foxboron/go-tpm-keyfiles"
+	"github.com/google/go-tpm-tools/simulator"
+	"github.com/google/go-tpm/tpm2"
+	"github.com/google/go-tpm/tpm2/transport"
+	"github.com/google/go-tpm/tpmutil"
+	"github.com/ibiscum/tpm2/attestca"
+	"github.com/ibiscum/tpm2/tpmkey"
+)
+
+var (
+	tpmPath     = flag.String("tpm-path", "/dev/tpmrm0", "Path to the TPM device (character device or a Unix socket).")
+	akFile      = flag.String("ak", "ak.pem", "TSS2 keyfile of the AK")
+	akPassword  = flag.String("ak-password", "", "optional AK password")
+	url         = flag.String("url", "http://127.0.0.1:8080", "URL of the CA")
+	keyType     = flag.String("type", "ecc", "new key: rsa (2048) or ecc (P-256)")
+	password    = flag.String("password", "", "optional password of the new key")
+	creation    = flag.Bool("creation", false, "attest with TPM2_CertifyCreation instead of TPM2_Certify")
+	cn          = flag.String("cn", "", "common name of the certificate")
+	san         = flag.String("san", "", "comma separated subject alternative names: DNS names, IPs, emails, URIs")
+	extKeyUsage = flag.String("ext-key-usage", "", "comma separated extended key usages, eg serverAuth,clientAuth")
+	out         = flag.String("out", "key.pem", "TSS2 keyfile of the new key")
+	certOut     = flag.String("cert", "key.crt", "PEM certificate chain of the new key")
+)
+
+var TPMDEVICES = []string{"/dev/tpm0", "/dev/tpmrm0"}
+
+func OpenTPM(path string) (io.ReadWriteCloser, error) {
+	if slices.Contains(TPMDEVICES, path) {
+		return tpmutil.OpenTPM(path)
+	} else if path == "simulator" {
+		return simulator.GetWithFixedSeedInsecure(1073741825)
+	} else {
+		return net.Dial("tcp", path)
+	}
+}
+
+// decode reads the JSON reply of the CA into v.
+func decode(resp *http.Response, v any) error {
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+func main() {
+	flag.Parse()
+
+	var template tpm2.TPMTPublic
+	switch *keyType {
+	case "rsa":
+		template = tpmkey.RSATemplate(2048, tpm2.TPMAlgNull, tpm2.TPMAlgSHA256)
+	case "ecc":
+		template = tpmkey.ECCTemplate(tpm2.TPMECCNistP256, tpm2.TPMAlgNull, tpm2.TPMAlgSHA256)
+	default:
+		log.Fatalf("unknown key type %q", *keyType)
+	}
+
+	var nonce attestca.NonceResponse
+	resp, err := http.Get(*url + "/nonce")
+	if err == nil {
+		err = decode(resp, &nonce)
+	}
+	if err != nil {
+		log.Fatalf("can't get nonce: %v", err)
+	}
+
+	rwc, err := OpenTPM(*tpmPath)
+	if err != nil {
+		log.Fatalf("can't open TPM %q: %v", *tpmPath, err)
+	}
+	defer func() {
+		rwc.Close()
+	}()
+
+	rwr := transport.FromReadWriter(rwc)
+
+	b, err := os.ReadFile(*akFile)
+	if err != nil {
+		log.Fatalf("can't read AK: %v", err)
+	}
+	akf, err := keyfile.Decode(b)
+	if err != nil {
+		log.Fatalf("can't decode AK: %v", err)
+	}
+	auth := []byte(*akPassword)
+	if akf.EmptyAuth {
+		auth = nil
+	}
+	ak, err := tpmkey.Load(rwr, akf, auth)
+	if err != nil {
+		log.Fatalf("can't load AK: %v", err)
+	}
+	defer ak.Close()
+
+	kf, req, err := attestca.Attest(rwr, ak, template, []byte(*password), nonce.Nonce, *creation)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	req.CommonName = *cn
+	if *san != "" {
+		req.SANs = strings.Split(*san, ",")
+	}
+	if *extKeyUsage != "" {
+		req.ExtKeyUsage = strings.Split(*extKeyUsage, ",")
+	}
+
+	body, err := json.Marshal(req)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	var issued attestca.Response
+	resp, err = http.Post(*url+"/issue", "application/json", bytes.NewReader(body))
+	if err == nil {
+		err = decode(resp, &issued)
+	}
+	if err != nil {
+		log.Fatalf("CA refused the key: %v", err)
+	}
+
+	f, err := os.OpenFile(*out, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		log.Fatalf("can't create keyfile: %v", err)
+	}
+	defer f.Close()
+	if err := keyfile.Encode(f, kf); err != nil {
+		log.Fatalf("can't write keyfile: %v", err)
+	}
+	if err := os.WriteFile(*certOut, []byte(issued.Certificate), 0644); err != nil {
+		log.Fatalf("can't write certificate: %v", err)
+	}
+	log.Printf("wrote %s and %s", *out, *certOut)
+}
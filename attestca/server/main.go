@@ -0,0 +1,46 @@
+package main
+
+import (
+	"encoding/hex"
+	"flag"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ibiscum/tpm2/attestca"
+)
+
+var (
+	listen = flag.String("listen", "127.0.0.1:8080", "address to listen on")
+	caKey  = flag.String("ca-key", "ca.key", "PEM PKCS#8 CA key, created with --ca-cert if both are missing")
+	caCert = flag.String("ca-cert", "ca.crt", "PEM CA certificate")
+	aks    = flag.String("aks", "ak.pub", "comma separated TPM2B_PUBLIC files of the trusted AKs")
+	days   = flag.Int("days", 30, "validity of the issued certificates")
+)
+
+func main() {
+	flag.Parse()
+
+	ca, err := attestca.LoadCA(*caKey, *caCert)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	ca.Validity = time.Duration(*days) * 24 * time.Hour
+
+	for _, f := range strings.Split(*aks, ",") {
+		b, err := os.ReadFile(f)
+		if err != nil {
+			log.Fatalf("can't read AK: %v", err)
+		}
+		name, err := ca.TrustAK(b)
+		if err != nil {
+			log.Fatalf("%s: %v", f, err)
+		}
+		log.Printf("trusting AK %s from %s", hex.EncodeToString(name), f)
+	}
+
+	log.Printf("CA %q listening on http://%s", ca.Cert.Subject.CommonName, *listen)
+	log.Fatal(http.ListenAndServe(*listen, ca.Handler()))
+}
@@ -0,0 +1,302 @@
+// Package certgen builds PKCS#10 certificate requests and self-signed X.509
+// certificates signed by a TPM key, so keys made by genkey, sign_with_rsa,
+// sign_with_ecc or tpm-key/rsa_sign can get a certificate.
+//
+// The signature algorithm has to be one the key can produce: keys created
+// with a fixed scheme (RSASSA, RSAPSS or ECDSA with a given hash) only sign
+// that, and the TPM would refuse anything else half way through, so those
+// requests are refused up front.
+package certgen
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"math/big"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/google/go-tpm/tpm2"
+	"github.com/ibiscum/tpm2/tpmkey"
+)
+
+// Options describe the CSR or certificate.
+type Options struct {
+	Subject pkix.Name
+
+	DNSNames       []string
+	IPAddresses    []net.IP
+	EmailAddresses []string
+	URIs           []*url.URL
+
+	// KeyUsage defaults to digital signature, plus key encipherment for
+	// RSA keys that can decrypt and certificate signing for CAs.
+	KeyUsage    x509.KeyUsage
+	ExtKeyUsage []x509.ExtKeyUsage
+
+	// SignatureAlgorithm defaults to the key's fixed scheme, SHA256-RSA for
+	// unrestricted RSA keys and ECDSA with the curve's hash for ECC keys.
+	SignatureAlgorithm x509.SignatureAlgorithm
+
+	// Self-signed certificates only.
+	NotBefore time.Time
+	Validity  time.Duration
+	IsCA      bool
+}
+
+// SANs sorts subject alternative names into DNS names, IP addresses, email
+// addresses and URIs.
+func (o *Options) SANs(names []string) error {
+	for _, n := range names {
+		switch {
+		case net.ParseIP(n) != nil:
+			o.IPAddresses = append(o.IPAddresses, net.ParseIP(n))
+		case strings.Contains(n, "://"):
+			u, err := url.Parse(n)
+			if err != nil {
+				return fmt.Errorf("certgen: bad URI SAN %q: %v", n, err)
+			}
+			o.URIs = append(o.URIs, u)
+		case strings.Contains(n, "@"):
+			o.EmailAddresses = append(o.EmailAddresses, n)
+		default:
+			o.DNSNames = append(o.DNSNames, n)
+		}
+	}
+	return nil
+}
+
+// keyUsage returns the key usage for k, checking an explicit one.
+func (o *Options) keyUsage(k *tpmkey.Key) (x509.KeyUsage, error) {
+	attrs := k.Public.ObjectAttributes
+	ku := o.KeyUsage
+	if ku == 0 {
+		ku = x509.KeyUsageDigitalSignature
+		if k.Public.Type == tpm2.TPMAlgRSA && attrs.Decrypt {
+			ku |= x509.KeyUsageKeyEncipherment
+		}
+		if o.IsCA {
+			ku |= x509.KeyUsageCertSign | x509.KeyUsageCRLSign
+		}
+	}
+	if ku&(x509.KeyUsageKeyEncipherment|x509.KeyUsageDataEncipherment) != 0 && (k.Public.Type != tpm2.TPMAlgRSA || !attrs.Decrypt) {
+		return 0, fmt.Errorf("certgen: encipherment key usages need an RSA key that can decrypt")
+	}
+	if ku&x509.KeyUsageKeyAgreement != 0 && (k.Public.Type != tpm2.TPMAlgECC || !attrs.Decrypt) {
+		return 0, fmt.Errorf("certgen: key agreement needs an ECC key that can do ECDH")
+	}
+	return ku, nil
+}
+
+// SignatureAlgorithm returns alg if k can produce it, or the default for k
+// if alg is x509.UnknownSignatureAlgorithm.
+func SignatureAlgorithm(k *tpmkey.Key, alg x509.SignatureAlgorithm) (x509.SignatureAlgorithm, error) {
+	if !k.Public.ObjectAttributes.SignEncrypt {
+		return 0, fmt.Errorf("certgen: key can't sign")
+	}
+	if k.Public.ObjectAttributes.Restricted {
+		return 0, fmt.Errorf("certgen: restricted keys only sign data the TPM produced, not certificates")
+	}
+
+	scheme, schemeHash := k.Scheme()
+	if alg == x509.UnknownSignatureAlgorithm {
+		switch k.Public.Type {
+		case tpm2.TPMAlgRSA:
+			if scheme == tpm2.TPMAlgNull {
+				return x509.SHA256WithRSA, nil
+			}
+		case tpm2.TPMAlgECC:
+			if scheme == tpm2.TPMAlgNull {
+				schemeHash = curveHash(k)
+			}
+			scheme = tpm2.TPMAlgECDSA
+		}
+		if a, ok := algorithms[[2]tpm2.TPMAlgID{scheme, schemeHash}]; ok {
+			return a, nil
+		}
+		return 0, fmt.Errorf("certgen: no X.509 signature algorithm for %s/%s", algName(scheme), algName(schemeHash))
+	}
+
+	var want [2]tpm2.TPMAlgID
+	for s, a := range algorithms {
+		if a == alg {
+			want = s
+		}
+	}
+	switch {
+	case want[0] == 0:
+		return 0, fmt.Errorf("certgen: unsupported signature algorithm %v", alg)
+	case (want[0] == tpm2.TPMAlgECDSA) != (k.Public.Type == tpm2.TPMAlgECC):
+		return 0, fmt.Errorf("certgen: %v doesn't match the key type", alg)
+	case scheme != tpm2.TPMAlgNull && (scheme != want[0] || schemeHash != want[1]):
+		return 0, fmt.Errorf("certgen: key is restricted to %s/%s, can't sign %v", algName(scheme), algName(schemeHash), alg)
+	}
+	return alg, nil
+}
+
+// algorithms maps TPM scheme and hash to X.509 signature algorithms.
+var algorithms = map[[2]tpm2.TPMAlgID]x509.SignatureAlgorithm{
+	{tpm2.TPMAlgRSASSA, tpm2.TPMAlgSHA256}: x509.SHA256WithRSA,
+	{tpm2.TPMAlgRSASSA, tpm2.TPMAlgSHA384}: x509.SHA384WithRSA,
+	{tpm2.TPMAlgRSASSA, tpm2.TPMAlgSHA512}: x509.SHA512WithRSA,
+	{tpm2.TPMAlgRSAPSS, tpm2.TPMAlgSHA256}: x509.SHA256WithRSAPSS,
+	{tpm2.TPMAlgRSAPSS, tpm2.TPMAlgSHA384}: x509.SHA384WithRSAPSS,
+	{tpm2.TPMAlgRSAPSS, tpm2.TPMAlgSHA512}: x509.SHA512WithRSAPSS,
+	{tpm2.TPMAlgECDSA, tpm2.TPMAlgSHA256}:  x509.ECDSAWithSHA256,
+	{tpm2.TPMAlgECDSA, tpm2.TPMAlgSHA384}:  x509.ECDSAWithSHA384,
+	{tpm2.TPMAlgECDSA, tpm2.TPMAlgSHA512}:  x509.ECDSAWithSHA512,
+}
+
+func algName(alg tpm2.TPMAlgID) string {
+	names := map[tpm2.TPMAlgID]string{
+		tpm2.TPMAlgRSASSA: "RSASSA",
+		tpm2.TPMAlgRSAPSS: "RSAPSS",
+		tpm2.TPMAlgECDSA:  "ECDSA",
+		tpm2.TPMAlgSHA1:   "SHA1",
+		tpm2.TPMAlgSHA256: "SHA256",
+		tpm2.TPMAlgSHA384: "SHA384",
+		tpm2.TPMAlgSHA512: "SHA512",
+	}
+	if n, ok := names[alg]; ok {
+		return n
+	}
+	return fmt.Sprintf("0x%04x", uint16(alg))
+}
+
+// ParseSignatureAlgorithm parses the names x509.SignatureAlgorithm prints
+// (SHA256-RSA, SHA384-RSAPSS, ECDSA-SHA256...), case insensitively.
+func ParseSignatureAlgorithm(s string) (x509.SignatureAlgorithm, error) {
+	for _, a := range algorithms {
+		if strings.EqualFold(a.String(), s) {
+			return a, nil
+		}
+	}
+	return 0, fmt.Errorf("certgen: unknown signature algorithm %q", s)
+}
+
+func curveHash(k *tpmkey.Key) tpm2.TPMAlgID {
+	pub, err := k.PublicKey()
+	if err != nil {
+		return tpm2.TPMAlgSHA256
+	}
+	switch pub.(*ecdsa.PublicKey).Curve {
+	case elliptic.P384():
+		return tpm2.TPMAlgSHA384
+	case elliptic.P521():
+		return tpm2.TPMAlgSHA512
+	}
+	return tpm2.TPMAlgSHA256
+}
+
+// CreateCSR returns a DER PKCS#10 request signed by k.  Key usages go in
+// the extension request.
+func CreateCSR(k *tpmkey.Key, opts *Options) ([]byte, error) {
+	alg, err := SignatureAlgorithm(k, opts.SignatureAlgorithm)
+	if err != nil {
+		return nil, err
+	}
+	signer, err := k.Signer()
+	if err != nil {
+		return nil, err
+	}
+	exts, err := usageExtensions(k, opts)
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:            opts.Subject,
+		DNSNames:           opts.DNSNames,
+		IPAddresses:        opts.IPAddresses,
+		EmailAddresses:     opts.EmailAddresses,
+		URIs:               opts.URIs,
+		ExtraExtensions:    exts,
+		SignatureAlgorithm: alg,
+	}, signer)
+	if err != nil {
+		return nil, fmt.Errorf("certgen: can't create CSR: %v", err)
+	}
+	csr, err := x509.ParseCertificateRequest(der)
+	if err != nil {
+		return nil, err
+	}
+	if err := csr.CheckSignature(); err != nil {
+		return nil, fmt.Errorf("certgen: TPM signature doesn't verify: %v", err)
+	}
+	return der, nil
+}
+
+// SelfSign returns a DER certificate for k signed by k.
+func SelfSign(k *tpmkey.Key, opts *Options) ([]byte, error) {
+	alg, err := SignatureAlgorithm(k, opts.SignatureAlgorithm)
+	if err != nil {
+		return nil, err
+	}
+	signer, err := k.Signer()
+	if err != nil {
+		return nil, err
+	}
+	ku, err := opts.keyUsage(k)
+	if err != nil {
+		return nil, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 127))
+	if err != nil {
+		return nil, err
+	}
+	notBefore := opts.NotBefore
+	if notBefore.IsZero() {
+		notBefore = time.Now().Add(-5 * time.Minute)
+	}
+	validity := opts.Validity
+	if validity == 0 {
+		validity = 365 * 24 * time.Hour
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               opts.Subject,
+		NotBefore:             notBefore,
+		NotAfter:              notBefore.Add(validity),
+		DNSNames:              opts.DNSNames,
+		IPAddresses:           opts.IPAddresses,
+		EmailAddresses:        opts.EmailAddresses,
+		URIs:                  opts.URIs,
+		KeyUsage:              ku,
+		ExtKeyUsage:           opts.ExtKeyUsage,
+		BasicConstraintsValid: true,
+		IsCA:                  opts.IsCA,
+		SignatureAlgorithm:    alg,
+	}
+	// CreateCertificate checks the TPM's signature before returning
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, signer.Public(), signer)
+	if err != nil {
+		return nil, fmt.Errorf("certgen: can't create certificate: %v", err)
+	}
+	return der, nil
+}
+
+// usageExtensions encodes the key usage and extended key usage for a CSR,
+// which has no fields for them.
+func usageExtensions(k *tpmkey.Key, opts *Options) ([]pkix.Extension, error) {
+	ku, err := opts.keyUsage(k)
+	if err != nil {
+		return nil, err
+	}
+	kuExt, err := marshalKeyUsage(ku)
+	if err != nil {
+		return nil, err
+	}
+	exts := []pkix.Extension{kuExt}
+	if len(opts.ExtKeyUsage) > 0 {
+		ekuExt, err := marshalExtKeyUsage(opts.ExtKeyUsage)
+		if err != nil {
+			return nil, err
+		}
+		exts = append(exts, ekuExt)
+	}
+	return exts, nil
+}
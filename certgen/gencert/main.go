@@ -0,0 +1,221 @@
+package main
+
+import (
+	"encoding/pem"
+	"flag"
+	"io"
+	"log"
+	"net"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	keyfile "github.com/foxboron/go-tpm-keyfiles"
+	"github.com/google/go-tpm-tools/simulator"
+	"github.com/google/go-tpm/tpm2"
+	"github.com/google/go-tpm/tpm2/transport"
+	"github.com/google/go-tpm/tpmutil"
+	"github.com/ibiscum/tpm2/certgen"
+	"github.com/ibiscum/tpm2/tpmkey"
+)
+
+var (
+	tpmPath     = flag.String("tpm-path", "/dev/tpmrm0", "Path to the TPM device (character device or a Unix socket).")
+	key         = flag.String("key", "", "TSS2 keyfile or persistent handle (eg 0x81008001) of the key")
+	password    = flag.String("password", "", "optional key password")
+	create      = flag.String("create", "", "create a new rsa or ecc key instead of --key")
+	bits        = flag.Int("bits", 2048, "RSA key size, with --create")
+	curve       = flag.String("curve", "p256", "ECC curve: p256, p384 or p521, with --create")
+	scheme      = flag.String("scheme", "", "fix the signing scheme of the new key: rsassa, rsapss or ecdsa (default: unrestricted)")
+	hash        = flag.String("hash", "sha256", "hash of the fixed signing scheme, with --scheme")
+	keyout      = flag.String("keyout", "", "write the keyfile of the new key here (required with --create)")
+	subject     = flag.String("subject", "", "subject, eg /CN=host.example.com/O=Example")
+	san         = flag.String("san", "", "comma separated subject alternative names: DNS names, IPs, emails, URIs")
+	keyUsage    = flag.String("key-usage", "", "comma separated key usages (default: digitalSignature, plus keyEncipherment for decrypting RSA keys and keyCertSign,cRLSign with --ca)")
+	extKeyUsage = flag.String("ext-key-usage", "", "comma separated extended key usages, eg serverAuth,clientAuth")
+	sigAlg      = flag.String("sig-alg", "", "signature algorithm, eg SHA256-RSA, SHA256-RSAPSS, ECDSA-SHA384 (default: from the key)")
+	selfSigned  = flag.Bool("self-signed", false, "write a self-signed certificate instead of a CSR")
+	days        = flag.Int("days", 365, "validity of the self-signed certificate")
+	isCA        = flag.Bool("ca", false, "make the self-signed certificate a CA")
+	out         = flag.String("out", "", "PEM file to write (default: stdout)")
+)
+
+var TPMDEVICES = []string{"/dev/tpm0", "/dev/tpmrm0"}
+
+func OpenTPM(path string) (io.ReadWriteCloser, error) {
+	if slices.Contains(TPMDEVICES, path) {
+		return tpmutil.OpenTPM(path)
+	} else if path == "simulator" {
+		return simulator.GetWithFixedSeedInsecure(1073741825)
+	} else {
+		return net.Dial("tcp", path)
+	}
+}
+
+func template() tpm2.TPMTPublic {
+	schemes := map[string]tpm2.TPMAlgID{
+		"":       tpm2.TPMAlgNull,
+		"rsassa": tpm2.TPMAlgRSASSA,
+		"rsapss": tpm2.TPMAlgRSAPSS,
+		"ecdsa":  tpm2.TPMAlgECDSA,
+	}
+	hashes := map[string]tpm2.TPMAlgID{
+		"sha256": tpm2.TPMAlgSHA256,
+		"sha384": tpm2.TPMAlgSHA384,
+		"sha512": tpm2.TPMAlgSHA512,
+	}
+	curves := map[string]tpm2.TPMECCCurve{
+		"p256": tpm2.TPMECCNistP256,
+		"p384": tpm2.TPMECCNistP384,
+		"p521": tpm2.TPMECCNistP521,
+	}
+
+	s, ok := schemes[*scheme]
+	if !ok {
+		log.Fatalf("unknown scheme %q", *scheme)
+	}
+	h, ok := hashes[*hash]
+	if !ok {
+		log.Fatalf("unknown hash %q", *hash)
+	}
+	switch *create {
+	case "rsa":
+		if s == tpm2.TPMAlgECDSA {
+			log.Fatalf("ecdsa is not an RSA scheme")
+		}
+		return tpmkey.RSATemplate(*bits, s, h)
+	case "ecc":
+		c, ok := curves[*curve]
+		if !ok {
+			log.Fatalf("unknown curve %q", *curve)
+		}
+		if s != tpm2.TPMAlgNull && s != tpm2.TPMAlgECDSA {
+			log.Fatalf("%s is not an ECC scheme", *scheme)
+		}
+		return tpmkey.ECCTemplate(c, s, h)
+	}
+	log.Fatalf("unknown key type %q", *create)
+	return tpm2.TPMTPublic{}
+}
+
+func main() {
+	flag.Parse()
+
+	if (*key == "") == (*create == "") {
+		log.Fatalf("use one of --key or --create")
+	}
+	if *create != "" && *keyout == "" {
+		log.Fatalf("--keyout is required with --create, the key would be lost")
+	}
+
+	opts := &certgen.Options{
+		Validity: time.Duration(*days) * 24 * time.Hour,
+		IsCA:     *isCA,
+	}
+	var err error
+	if opts.Subject, err = certgen.ParseSubject(*subject); err != nil {
+		log.Fatalf("%v", err)
+	}
+	if *san != "" {
+		if err := opts.SANs(strings.Split(*san, ",")); err != nil {
+			log.Fatalf("%v", err)
+		}
+	}
+	if *keyUsage != "" {
+		if opts.KeyUsage, err = certgen.ParseKeyUsage(*keyUsage); err != nil {
+			log.Fatalf("%v", err)
+		}
+	}
+	if *extKeyUsage != "" {
+		if opts.ExtKeyUsage, err = certgen.ParseExtKeyUsage(*extKeyUsage); err != nil {
+			log.Fatalf("%v", err)
+		}
+	}
+	if *sigAlg != "" {
+		if opts.SignatureAlgorithm, err = certgen.ParseSignatureAlgorithm(*sigAlg); err != nil {
+			log.Fatalf("%v", err)
+		}
+	}
+
+	rwc, err := OpenTPM(*tpmPath)
+	if err != nil {
+		log.Fatalf("can't open TPM %q: %v", *tpmPath, err)
+	}
+	defer func() {
+		rwc.Close()
+	}()
+
+	rwr := transport.FromReadWriter(rwc)
+
+	var kf *keyfile.TPMKey
+	var k *tpmkey.Key
+	switch {
+	case *create != "":
+		kf, err = tpmkey.Create(rwr, template(), []byte(*password), nil)
+		if err != nil {
+			log.Fatalf("can't create key: %v", err)
+		}
+		k, err = tpmkey.Load(rwr, kf, []byte(*password))
+	case strings.HasPrefix(*key, "0x"):
+		h, perr := strconv.ParseUint(*key, 0, 32)
+		if perr != nil {
+			log.Fatalf("bad handle %q: %v", *key, perr)
+		}
+		k, err = tpmkey.LoadPersistent(rwr, tpm2.TPMHandle(h), []byte(*password), nil)
+	default:
+		b, rerr := os.ReadFile(*key)
+		if rerr != nil {
+			log.Fatalf("can't read keyfile: %v", rerr)
+		}
+		if kf, err = keyfile.Decode(b); err != nil {
+			log.Fatalf("can't decode keyfile: %v", err)
+		}
+		auth := []byte(*password)
+		if kf.EmptyAuth {
+			auth = nil
+		}
+		k, err = tpmkey.Load(rwr, kf, auth)
+	}
+	if err != nil {
+		log.Fatalf("can't load key: %v", err)
+	}
+	defer k.Close()
+
+	var block *pem.Block
+	if *selfSigned {
+		der, err := certgen.SelfSign(k, opts)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		block = &pem.Block{Type: "CERTIFICATE", Bytes: der}
+	} else {
+		der, err := certgen.CreateCSR(k, opts)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		block = &pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der}
+	}
+
+	if *create != "" {
+		f, err := os.OpenFile(*keyout, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if err != nil {
+			log.Fatalf("can't create keyfile: %v", err)
+		}
+		defer f.Close()
+		if err := keyfile.Encode(f, kf); err != nil {
+			log.Fatalf("can't write keyfile: %v", err)
+		}
+		log.Printf("wrote %s", *keyout)
+	}
+
+	if *out == "" {
+		pem.Encode(os.Stdout, block)
+		return
+	}
+	if err := os.WriteFile(*out, pem.EncodeToMemory(block), 0644); err != nil {
+		log.Fatalf("can't write output: %v", err)
+	}
+	log.Printf("wrote %s", *out)
+}
@@ -0,0 +1,147 @@
+package certgen
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+	"math/bits"
+	"strings"
+)
+
+var (
+	oidKeyUsage    = asn1.ObjectIdentifier{2, 5, 29, 15}
+	oidExtKeyUsage = asn1.ObjectIdentifier{2, 5, 29, 37}
+)
+
+var keyUsages = map[string]x509.KeyUsage{
+	"digitalSignature":  x509.KeyUsageDigitalSignature,
+	"contentCommitment": x509.KeyUsageContentCommitment,
+	"keyEncipherment":   x509.KeyUsageKeyEncipherment,
+	"dataEncipherment":  x509.KeyUsageDataEncipherment,
+	"keyAgreement":      x509.KeyUsageKeyAgreement,
+	"keyCertSign":       x509.KeyUsageCertSign,
+	"cRLSign":           x509.KeyUsageCRLSign,
+}
+
+type extKeyUsage struct {
+	usage x509.ExtKeyUsage
+	oid   asn1.ObjectIdentifier
+}
+
+var extKeyUsages = map[string]extKeyUsage{
+	"any":             {x509.ExtKeyUsageAny, asn1.ObjectIdentifier{2, 5, 29, 37, 0}},
+	"serverAuth":      {x509.ExtKeyUsageServerAuth, asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 3, 1}},
+	"clientAuth":      {x509.ExtKeyUsageClientAuth, asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 3, 2}},
+	"codeSigning":     {x509.ExtKeyUsageCodeSigning, asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 3, 3}},
+	"emailProtection": {x509.ExtKeyUsageEmailProtection, asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 3, 4}},
+	"timeStamping":    {x509.ExtKeyUsageTimeStamping, asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 3, 8}},
+	"OCSPSigning":     {x509.ExtKeyUsageOCSPSigning, asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 3, 9}},
+}
+
+// ParseKeyUsage parses a comma separated list of RFC 5280 key usage names
+// (digitalSignature, keyEncipherment, keyCertSign...), case insensitively.
+func ParseKeyUsage(s string) (x509.KeyUsage, error) {
+	var ku x509.KeyUsage
+	for _, f := range strings.Split(s, ",") {
+		u, ok := lookup(keyUsages, strings.TrimSpace(f))
+		if !ok {
+			return 0, fmt.Errorf("certgen: unknown key usage %q", f)
+		}
+		ku |= u
+	}
+	return ku, nil
+}
+
+// ParseExtKeyUsage parses a comma separated list of extended key usage
+// names (serverAuth, clientAuth, codeSigning...), case insensitively.
+func ParseExtKeyUsage(s string) ([]x509.ExtKeyUsage, error) {
+	var ekus []x509.ExtKeyUsage
+	for _, f := range strings.Split(s, ",") {
+		u, ok := lookup(extKeyUsages, strings.TrimSpace(f))
+		if !ok {
+			return nil, fmt.Errorf("certgen: unknown extended key usage %q", f)
+		}
+		ekus = append(ekus, u.usage)
+	}
+	return ekus, nil
+}
+
+func lookup[T any](m map[string]T, name string) (T, bool) {
+	for k, v := range m {
+		if strings.EqualFold(k, name) {
+			return v, true
+		}
+	}
+	var zero T
+	return zero, false
+}
+
+// marshalKeyUsage encodes the critical key usage extension the way
+// crypto/x509 does for certificates.
+func marshalKeyUsage(ku x509.KeyUsage) (pkix.Extension, error) {
+	b := []byte{bits.Reverse8(byte(ku)), bits.Reverse8(byte(ku >> 8))}
+	if b[1] == 0 {
+		b = b[:1]
+	}
+	bitLength := len(b)*8 - bits.TrailingZeros8(b[len(b)-1])
+	v, err := asn1.Marshal(asn1.BitString{Bytes: b, BitLength: bitLength})
+	if err != nil {
+		return pkix.Extension{}, err
+	}
+	return pkix.Extension{Id: oidKeyUsage, Critical: true, Value: v}, nil
+}
+
+func marshalExtKeyUsage(ekus []x509.ExtKeyUsage) (pkix.Extension, error) {
+	var oids []asn1.ObjectIdentifier
+	for _, u := range ekus {
+		found := false
+		for _, e := range extKeyUsages {
+			if e.usage == u {
+				oids = append(oids, e.oid)
+				found = true
+			}
+		}
+		if !found {
+			return pkix.Extension{}, fmt.Errorf("certgen: unsupported extended key usage %v", u)
+		}
+	}
+	v, err := asn1.Marshal(oids)
+	if err != nil {
+		return pkix.Extension{}, err
+	}
+	return pkix.Extension{Id: oidExtKeyUsage, Value: v}, nil
+}
+
+// ParseSubject parses an openssl style subject, "/CN=host/O=org/C=US".
+func ParseSubject(s string) (pkix.Name, error) {
+	var n pkix.Name
+	for _, f := range strings.Split(strings.TrimPrefix(s, "/"), "/") {
+		if f == "" {
+			continue
+		}
+		k, v, ok := strings.Cut(f, "=")
+		if !ok {
+			return n, fmt.Errorf("certgen: bad subject component %q", f)
+		}
+		switch strings.ToUpper(k) {
+		case "CN":
+			n.CommonName = v
+		case "O":
+			n.Organization = append(n.Organization, v)
+		case "OU":
+			n.OrganizationalUnit = append(n.OrganizationalUnit, v)
+		case "C":
+			n.Country = append(n.Country, v)
+		case "ST":
+			n.Province = append(n.Province, v)
+		case "L":
+			n.Locality = append(n.Locality, v)
+		case "SERIALNUMBER":
+			n.SerialNumber = v
+		default:
+			return n, fmt.Errorf("certgen: unsupported subject attribute %q", k)
+		}
+	}
+	return n, nil
+}
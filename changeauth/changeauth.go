@@ -0,0 +1,163 @@
+// Package changeauth changes the authorization value of TPM objects, NV
+// indexes and hierarchies: TPM2_ObjectChangeAuth for keys in a TSS2 keyfile
+// or a public/private pair, TPM2_NV_ChangeAuth and
+// TPM2_HierarchyChangeAuth.
+//
+// The new value always travels in a parameter encrypted session salted to
+// a primary of the null hierarchy, whose authorization is empty whatever
+// the owner's is.  A salt is needed: the session key of an unsalted session
+// derives from the old value, which for keys made by recipes such as
+// password or tpm_encrypted_session is a string anyone can read.
+package changeauth
+
+import (
+	"fmt"
+
+	keyfile "github.com/foxboron/go-tpm-keyfiles"
+	"github.com/google/go-tpm/tpm2"
+	"github.com/google/go-tpm/tpm2/transport"
+	"github.com/ibiscum/tpm2/tpmkey"
+	"github.com/ibiscum/tpm2/tpmtemplate"
+)
+
+// Key changes the authorization value of the key in keyfile k from oldAuth
+// to newAuth and returns the keyfile with the new private.  An importable
+// keyfile is imported first, so the result is a loadable one.
+//
+// The TPM doesn't revoke the old private: it still loads, with oldAuth,
+// for as long as its parent exists.  The old keyfile has to go.
+func Key(rwr transport.TPM, k *keyfile.TPMKey, oldAuth, newAuth []byte) (*keyfile.TPMKey, error) {
+	if k.Keytype.Equal(keyfile.OIDImportableKey) {
+		loadable, err := tpmkey.Import(rwr, k, nil)
+		if err != nil {
+			return nil, err
+		}
+		k = loadable
+	}
+	parent, _, closer, err := tpmkey.FindParent(rwr, k)
+	if err != nil {
+		return nil, err
+	}
+	defer closer()
+
+	priv, err := Object(rwr, parent, k.Pubkey, k.Privkey, oldAuth, newAuth)
+	if err != nil {
+		return nil, err
+	}
+	nk := *k
+	nk.Privkey = *priv
+	nk.EmptyAuth = len(newAuth) == 0
+	return &nk, nil
+}
+
+// Object loads the public/private pair under parent and changes its
+// authorization value from oldAuth to newAuth, returning the new private.
+// TPM2_ObjectChangeAuth needs the admin role, so objects with
+// adminWithPolicy set can't be changed with their password.
+func Object(rwr transport.TPM, parent *tpm2.NamedHandle, public tpm2.TPM2BPublic, private tpm2.TPM2BPrivate, oldAuth, newAuth []byte) (*tpm2.TPM2BPrivate, error) {
+	key, err := tpmkey.LoadBlob(rwr, parent, public, private, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer key.Close()
+	if key.Public.ObjectAttributes.AdminWithPolicy {
+		return nil, fmt.Errorf("changeauth: the key's admin role needs its policy (adminWithPolicy)")
+	}
+
+	salt, closer, err := saltKey(rwr)
+	if err != nil {
+		return nil, err
+	}
+	defer closer()
+
+	rsp, err := tpm2.ObjectChangeAuth{
+		ObjectHandle: tpm2.AuthHandle{
+			Handle: key.Handle,
+			Name:   key.Name,
+			Auth:   tpm2.HMAC(tpm2.TPMAlgSHA256, 16, tpm2.Auth(oldAuth), tpm2.AESEncryption(128, tpm2.EncryptIn), tpm2.Salted(salt.Handle, salt.Public)),
+		},
+		ParentHandle: tpm2.NamedHandle{
+			Handle: parent.Handle,
+			Name:   parent.Name,
+		},
+		NewAuth: tpm2.TPM2BAuth{
+			Buffer: newAuth,
+		},
+	}.Execute(rwr)
+	if err != nil {
+		return nil, fmt.Errorf("changeauth: can't change key auth: %v", err)
+	}
+	return &rsp.OutPrivate, nil
+}
+
+// Hierarchy changes the authorization value of hierarchy, one of
+// TPM_RH_OWNER, TPM_RH_ENDORSEMENT or TPM_RH_LOCKOUT, from oldAuth to
+// newAuth.
+//
+// The TPM computes the response HMAC of TPM2_HierarchyChangeAuth with the
+// new value, which go-tpm's sessions don't expect, so oldAuth is given as a
+// password and newAuth is encrypted by a second session used for that only.
+func Hierarchy(rwr transport.TPM, hierarchy tpm2.TPMHandle, oldAuth, newAuth []byte) error {
+	switch hierarchy {
+	case tpm2.TPMRHOwner, tpm2.TPMRHEndorsement, tpm2.TPMRHLockout:
+	default:
+		return fmt.Errorf("changeauth: 0x%x isn't the owner, endorsement or lockout hierarchy", hierarchy)
+	}
+
+	salt, closer, err := saltKey(rwr)
+	if err != nil {
+		return err
+	}
+	defer closer()
+
+	_, err = tpm2.HierarchyChangeAuth{
+		AuthHandle: tpm2.AuthHandle{
+			Handle: hierarchy,
+			Auth:   tpm2.PasswordAuth(oldAuth),
+		},
+		NewAuth: tpm2.TPM2BAuth{
+			Buffer: newAuth,
+		},
+	}.Execute(rwr, salt.encryptSession())
+	if err != nil {
+		return fmt.Errorf("changeauth: can't change auth of hierarchy 0x%x: %v", hierarchy, err)
+	}
+	return nil
+}
+
+// salt is a loaded key sessions are salted to.
+type salt struct {
+	Handle tpm2.TPMHandle
+	Public tpm2.TPMTPublic
+}
+
+// saltKey creates the H-2 primary in the null hierarchy, to salt sessions
+// to.  The returned func flushes it and must always be called.
+func saltKey(rwr transport.TPM) (*salt, func(), error) {
+	p, closer, err := tpmkey.Primary(rwr, tpm2.TPMRHNull, tpmtemplate.Templates["h2"])
+	if err != nil {
+		return nil, nil, err
+	}
+	rsp, err := tpm2.ReadPublic{
+		ObjectHandle: p.Handle,
+	}.Execute(rwr)
+	if err != nil {
+		closer()
+		return nil, nil, fmt.Errorf("changeauth: can't read salt key: %v", err)
+	}
+	pub, err := rsp.OutPublic.Contents()
+	if err != nil {
+		closer()
+		return nil, nil, fmt.Errorf("changeauth: can't read salt key public: %v", err)
+	}
+	return &salt{
+		Handle: p.Handle,
+		Public: *pub,
+	}, closer, nil
+}
+
+// encryptSession returns a one-off session salted to s that authorizes
+// nothing and only encrypts the first command parameter.
+func (s *salt) encryptSession() tpm2.Session {
+	return tpm2.HMAC(tpm2.TPMAlgSHA256, 16, tpm2.AESEncryption(128, tpm2.EncryptIn), tpm2.Salted(s.Handle, s.Public))
+}
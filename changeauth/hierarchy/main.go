@@ -0,0 +1,64 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"log"
+	"net"
+	"slices"
+
+	"github.com/google/go-tpm-tools/simulator"
+	"github.com/google/go-tpm/tpm2"
+	"github.com/google/go-tpm/tpm2/transport"
+	"github.com/google/go-tpm/tpmutil"
+	"github.com/ibiscum/tpm2/changeauth"
+)
+
+var (
+	tpmPath     = flag.String("tpm-path", "/dev/tpmrm0", "Path to the TPM device (character device or a Unix socket).")
+	hierarchy   = flag.String("hierarchy", "owner", "hierarchy: owner, endorsement or lockout")
+	password    = flag.String("password", "", "current hierarchy password")
+	newPassword = flag.String("new-password", "", "new hierarchy password")
+)
+
+var hierarchies = map[string]tpm2.TPMHandle{
+	"owner":       tpm2.TPMRHOwner,
+	"endorsement": tpm2.TPMRHEndorsement,
+	"lockout":     tpm2.TPMRHLockout,
+}
+
+var TPMDEVICES = []string{"/dev/tpm0", "/dev/tpmrm0"}
+
+func OpenTPM(path string) (io.ReadWriteCloser, error) {
+	if slices.Contains(TPMDEVICES, path) {
+		return tpmutil.OpenTPM(path)
+	} else if path == "simulator" {
+		return simulator.GetWithFixedSeedInsecure(1073741825)
+	} else {
+		return net.Dial("tcp", path)
+	}
+}
+
+func main() {
+	flag.Parse()
+
+	h, ok := hierarchies[*hierarchy]
+	if !ok {
+		log.Fatalf("bad hierarchy %q, one of owner, endorsement or lockout", *hierarchy)
+	}
+
+	rwc, err := OpenTPM(*tpmPath)
+	if err != nil {
+		log.Fatalf("can't open TPM %q: %v", *tpmPath, err)
+	}
+	defer func() {
+		rwc.Close()
+	}()
+
+	rwr := transport.FromReadWriter(rwc)
+
+	if err := changeauth.Hierarchy(rwr, h, []byte(*password), []byte(*newPassword)); err != nil {
+		log.Fatalf("%v", err)
+	}
+	log.Printf("changed the %s password", *hierarchy)
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"log"
+	"net"
+	"os"
+	"slices"
+	"strconv"
+
+	keyfile "github.com/foxboron/go-tpm-keyfiles"
+	"github.com/google/go-tpm-tools/simulator"
+	"github.com/google/go-tpm/tpm2"
+	"github.com/google/go-tpm/tpm2/transport"
+	"github.com/google/go-tpm/tpmutil"
+	"github.com/ibiscum/tpm2/atomicfile"
+	"github.com/ibiscum/tpm2/changeauth"
+	"github.com/ibiscum/tpm2/tpm2tools"
+	"github.com/ibiscum/tpm2/tpmkey"
+)
+
+var (
+	tpmPath     = flag.String("tpm-path", "/dev/tpmrm0", "Path to the TPM device (character device or a Unix socket).")
+	keyFile     = flag.String("key", "", "TSS2 keyfile of the key")
+	parent      = flag.String("C", "", "without -key, parent of the key: a context file (tpm2_createprimary -c, tpm2_evictcontrol -o) or a handle")
+	public      = flag.String("u", "", "without -key, TPM2B_PUBLIC of the key (tpm2_create -u)")
+	private     = flag.String("r", "", "without -key, TPM2B_PRIVATE of the key (tpm2_create -r)")
+	password    = flag.String("password", "", "current key password")
+	newPassword = flag.String("new-password", "", "new key password")
+	out         = flag.String("out", "", "keyfile or TPM2B_PRIVATE to write (default: replace the input)")
+	keep        = flag.Bool("keep", false, "keep the input when -out names another file")
+)
+
+var TPMDEVICES = []string{"/dev/tpm0", "/dev/tpmrm0"}
+
+func OpenTPM(path string) (io.ReadWriteCloser, error) {
+	if slices.Contains(TPMDEVICES, path) {
+		return tpmutil.OpenTPM(path)
+	} else if path == "simulator" {
+		return simulator.GetWithFixedSeedInsecure(1073741825)
+	} else {
+		return net.Dial("tcp", path)
+	}
+}
+
+func main() {
+	flag.Parse()
+
+	in := *keyFile
+	if in == "" {
+		in = *private
+	}
+	if in == "" || (*keyFile == "" && (*parent == "" || *public == "")) {
+		log.Fatalf("either -key or -C, -u and -r are required")
+	}
+	if *out == "" {
+		*out = in
+	}
+	b, err := os.ReadFile(in)
+	if err != nil {
+		log.Fatalf("can't read %s: %v", in, err)
+	}
+	fi, err := os.Stat(in)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	rwc, err := OpenTPM(*tpmPath)
+	if err != nil {
+		log.Fatalf("can't open TPM %q: %v", *tpmPath, err)
+	}
+	defer func() {
+		rwc.Close()
+	}()
+
+	rwr := transport.FromReadWriter(rwc)
+
+	var enc []byte
+	if *keyFile != "" {
+		k, err := keyfile.Decode(b)
+		if err != nil {
+			log.Fatalf("can't decode keyfile: %v", err)
+		}
+		nk, err := changeauth.Key(rwr, k, []byte(*password), []byte(*newPassword))
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		enc = nk.Bytes()
+	} else {
+		priv, err := tpm2tools.ParsePrivate(b)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		b, err := os.ReadFile(*public)
+		if err != nil {
+			log.Fatalf("can't read public: %v", err)
+		}
+		pub, err := tpm2tools.ParsePublic(b)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+
+		// the parent is a handle, or a context file
+		var parentHandle *tpm2.NamedHandle
+		var closer func()
+		if h, err := strconv.ParseUint(*parent, 0, 32); err == nil {
+			parentHandle, closer, err = tpmkey.Parent(rwr, tpm2.TPMHandle(h))
+			if err != nil {
+				log.Fatalf("%v", err)
+			}
+		} else {
+			b, err := os.ReadFile(*parent)
+			if err != nil {
+				log.Fatalf("can't read parent context: %v", err)
+			}
+			ctx, err := tpm2tools.ParseContext(b)
+			if err != nil {
+				log.Fatalf("%v", err)
+			}
+			if parentHandle, closer, err = ctx.Load(rwr); err != nil {
+				log.Fatalf("%v", err)
+			}
+		}
+		defer closer()
+
+		newPriv, err := changeauth.Object(rwr, parentHandle, *pub, *priv, []byte(*password), []byte(*newPassword))
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		enc = tpm2.Marshal(*newPriv)
+	}
+
+	// the new file is in place before the old one goes: the old private
+	// still loads with the old password
+	if err := atomicfile.WriteFile(*out, enc, fi.Mode().Perm()); err != nil {
+		log.Fatalf("%v", err)
+	}
+	log.Printf("wrote %s", *out)
+	if *out != in && !*keep {
+		if err := os.Remove(in); err != nil {
+			log.Fatalf("can't remove %s: %v", in, err)
+		}
+		log.Printf("removed %s", in)
+	}
+}
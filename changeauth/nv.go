@@ -0,0 +1,186 @@
+package changeauth
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/google/go-tpm/tpm2"
+	"github.com/google/go-tpm/tpm2/transport"
+)
+
+// NVPolicy returns the authPolicy an NV index needs for NV to change its
+// authorization value: PolicyAuthValue, PolicyCommandCode(NV_ChangeAuth).
+// TPM2_NV_ChangeAuth needs the admin role, which for an NV index is always
+// given by its policy.  PolicyPassword extends the same digest as
+// PolicyAuthValue.
+func NVPolicy(nameAlg tpm2.TPMIAlgHash) ([]byte, error) {
+	calc, err := tpm2.NewPolicyCalculator(nameAlg)
+	if err != nil {
+		return nil, fmt.Errorf("changeauth: %v", err)
+	}
+	if err := (tpm2.PolicyAuthValue{}).Update(calc); err != nil {
+		return nil, fmt.Errorf("changeauth: %v", err)
+	}
+	if err := (tpm2.PolicyCommandCode{Code: tpm2.TPMCCNVChangeAuth}).Update(calc); err != nil {
+		return nil, fmt.Errorf("changeauth: %v", err)
+	}
+	return calc.Hash().Digest, nil
+}
+
+// NV changes the authorization value of NV index from oldAuth to newAuth.
+// The index's authPolicy has to allow it, as NVPolicy does.
+//
+// As for Hierarchy, the TPM computes the response HMAC with the new value,
+// so the policy is satisfied with PolicyPassword rather than
+// PolicyAuthValue and newAuth is encrypted by a second session.  go-tpm
+// has neither TPM2_NV_ChangeAuth nor TPM2_PolicyPassword, so both are
+// marshalled here.
+func NV(rwr transport.TPM, index tpm2.TPMHandle, oldAuth, newAuth []byte) error {
+	rsp, err := tpm2.NVReadPublic{
+		NVIndex: index,
+	}.Execute(rwr)
+	if err != nil {
+		return fmt.Errorf("changeauth: can't read NV index 0x%x: %v", index, err)
+	}
+	pub, err := rsp.NVPublic.Contents()
+	if err != nil {
+		return fmt.Errorf("changeauth: can't read NV index public: %v", err)
+	}
+	if len(pub.AuthPolicy.Buffer) == 0 {
+		return fmt.Errorf("changeauth: NV index 0x%x has no policy, its auth can't be changed", index)
+	}
+
+	salt, closer, err := saltKey(rwr)
+	if err != nil {
+		return err
+	}
+	defer closer()
+
+	sess := passwordPolicy{tpm2.Policy(pub.NameAlg, 16, func(rwr transport.TPM, handle tpm2.TPMISHPolicy, _ tpm2.TPM2BNonce) error {
+		if err := run(rwr, tpm2.TPMCCPolicyPassword, []tpm2.TPMHandle{handle}, nil, nil, nil); err != nil {
+			return fmt.Errorf("PolicyPassword: %v", err)
+		}
+		_, err := tpm2.PolicyCommandCode{
+			PolicySession: handle,
+			Code:          tpm2.TPMCCNVChangeAuth,
+		}.Execute(rwr)
+		return err
+	}, tpm2.Password(oldAuth))}
+
+	parms := tpm2.Marshal(tpm2.TPM2BAuth{
+		Buffer: newAuth,
+	})
+	if err := run(rwr, tpm2.TPMCCNVChangeAuth, []tpm2.TPMHandle{index}, []tpm2.TPM2BName{rsp.NVName}, []tpm2.Session{sess, salt.encryptSession()}, parms); err != nil {
+		return fmt.Errorf("changeauth: can't change auth of NV index 0x%x: %v", index, err)
+	}
+	return nil
+}
+
+// passwordPolicy is a policy session satisfied with PolicyPassword.  The
+// TPM returns a nonce for it, which go-tpm doesn't expect, and an empty
+// HMAC.
+type passwordPolicy struct {
+	tpm2.Session
+}
+
+// Validate checks the response HMAC is empty.
+func (s passwordPolicy) Validate(_ tpm2.TPMRC, _ tpm2.TPMCC, _ []byte, _ []tpm2.TPM2BName, _ int, auth *tpm2.TPMSAuthResponse) error {
+	if len(auth.Authorization.Buffer) != 0 {
+		return fmt.Errorf("unexpected HMAC in response to a password policy")
+	}
+	return nil
+}
+
+// run executes command cc, with handles named names and parameters parms,
+// under sessions, the way go-tpm does for the commands it knows.  The
+// first parameter, a TPM2B, is encrypted by a decrypt session.  Response
+// parameters are ignored.
+func run(rwr transport.TPM, cc tpm2.TPMCC, handles []tpm2.TPMHandle, names []tpm2.TPM2BName, sessions []tpm2.Session, parms []byte) error {
+	for _, s := range sessions {
+		if err := s.Init(rwr); err != nil {
+			return err
+		}
+		if err := s.NewNonceCaller(); err != nil {
+			return err
+		}
+	}
+	for _, s := range sessions {
+		if s.IsDecryption() {
+			if err := s.Encrypt(parms[2:]); err != nil {
+				return err
+			}
+		}
+	}
+
+	var body []byte
+	for _, h := range handles {
+		body = binary.BigEndian.AppendUint32(body, uint32(h))
+	}
+	tag := tpm2.TPMSTNoSessions
+	if len(sessions) > 0 {
+		tag = tpm2.TPMSTSessions
+		// the first session's HMAC covers the nonces of the others
+		// that encrypt
+		var addNonces []byte
+		for _, s := range sessions[1:] {
+			if s.IsDecryption() || s.IsEncryption() {
+				addNonces = append(addNonces, s.NonceTPM().Buffer...)
+			}
+		}
+		var area []byte
+		for i, s := range sessions {
+			var nonces []byte
+			if i == 0 {
+				nonces = addNonces
+			}
+			auth, err := s.Authorize(cc, parms, nonces, names, i)
+			if err != nil {
+				return err
+			}
+			area = append(area, tpm2.Marshal(auth)...)
+		}
+		body = binary.BigEndian.AppendUint32(body, uint32(len(area)))
+		body = append(body, area...)
+	}
+	body = append(body, parms...)
+
+	cmd := binary.BigEndian.AppendUint16(nil, uint16(tag))
+	cmd = binary.BigEndian.AppendUint32(cmd, uint32(10+len(body)))
+	cmd = binary.BigEndian.AppendUint32(cmd, uint32(cc))
+	cmd = append(cmd, body...)
+
+	rsp, err := rwr.Send(cmd)
+	if err != nil {
+		return err
+	}
+	if len(rsp) < 10 {
+		return fmt.Errorf("short response")
+	}
+	if rc := tpm2.TPMRC(binary.BigEndian.Uint32(rsp[6:])); rc != tpm2.TPMRCSuccess {
+		for _, s := range sessions {
+			s.CleanupFailure(rwr)
+		}
+		return rc
+	}
+	if len(sessions) == 0 {
+		return nil
+	}
+
+	rest := rsp[10:]
+	if len(rest) < 4 || len(rest) < 4+int(binary.BigEndian.Uint32(rest)) {
+		return fmt.Errorf("short response")
+	}
+	size := 4 + int(binary.BigEndian.Uint32(rest))
+	rspParms, rest := rest[4:size], rest[size:]
+	for i, s := range sessions {
+		auth, err := tpm2.Unmarshal[tpm2.TPMSAuthResponse](rest)
+		if err != nil {
+			return fmt.Errorf("reading auth session %d: %v", i, err)
+		}
+		rest = rest[len(tpm2.Marshal(auth)):]
+		if err := s.Validate(tpm2.TPMRCSuccess, cc, rspParms, names, i, auth); err != nil {
+			return fmt.Errorf("validating auth session %d: %v", i, err)
+		}
+	}
+	return nil
+}
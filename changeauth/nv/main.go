@@ -0,0 +1,87 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"log"
+	"net"
+	"slices"
+
+	"github.com/google/go-tpm-tools/simulator"
+	"github.com/google/go-tpm/tpm2"
+	"github.com/google/go-tpm/tpm2/transport"
+	"github.com/google/go-tpm/tpmutil"
+	"github.com/ibiscum/tpm2/changeauth"
+)
+
+var (
+	tpmPath     = flag.String("tpm-path", "/dev/tpmrm0", "Path to the TPM device (character device or a Unix socket).")
+	index       = flag.Uint("index", 0x1500020, "NV index")
+	password    = flag.String("password", "", "current index password")
+	newPassword = flag.String("new-password", "", "new index password")
+	define      = flag.Bool("define", false, "first define the index, with -password and a policy allowing the change")
+	size        = flag.Uint("size", 32, "with -define, size of the index")
+)
+
+var TPMDEVICES = []string{"/dev/tpm0", "/dev/tpmrm0"}
+
+func OpenTPM(path string) (io.ReadWriteCloser, error) {
+	if slices.Contains(TPMDEVICES, path) {
+		return tpmutil.OpenTPM(path)
+	} else if path == "simulator" {
+		return simulator.GetWithFixedSeedInsecure(1073741825)
+	} else {
+		return net.Dial("tcp", path)
+	}
+}
+
+func main() {
+	flag.Parse()
+
+	rwc, err := OpenTPM(*tpmPath)
+	if err != nil {
+		log.Fatalf("can't open TPM %q: %v", *tpmPath, err)
+	}
+	defer func() {
+		rwc.Close()
+	}()
+
+	rwr := transport.FromReadWriter(rwc)
+
+	if *define {
+		policy, err := changeauth.NVPolicy(tpm2.TPMAlgSHA256)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		_, err = tpm2.NVDefineSpace{
+			AuthHandle: tpm2.TPMRHOwner,
+			Auth: tpm2.TPM2BAuth{
+				Buffer: []byte(*password),
+			},
+			PublicInfo: tpm2.New2B(
+				tpm2.TPMSNVPublic{
+					NVIndex: tpm2.TPMHandle(*index),
+					NameAlg: tpm2.TPMAlgSHA256,
+					Attributes: tpm2.TPMANV{
+						AuthWrite: true,
+						AuthRead:  true,
+						NT:        tpm2.TPMNTOrdinary,
+						NoDA:      true,
+					},
+					AuthPolicy: tpm2.TPM2BDigest{
+						Buffer: policy,
+					},
+					DataSize: uint16(*size),
+				}),
+		}.Execute(rwr)
+		if err != nil {
+			log.Fatalf("can't define NV index: %v", err)
+		}
+		log.Printf("defined NV index 0x%x", *index)
+	}
+
+	if err := changeauth.NV(rwr, tpm2.TPMHandle(*index), []byte(*password), []byte(*newPassword)); err != nil {
+		log.Fatalf("%v", err)
+	}
+	log.Printf("changed the password of NV index 0x%x", *index)
+}
@@ -0,0 +1,497 @@
+// Package clevis reads and writes the JWEs of the clevis "tpm2" pin.
+//
+// clevis encrypt tpm2 generates an AES-256-GCM JWK, seals it with
+// tpm2_create under a tpm2-tools default primary (optionally behind a
+// PolicyPCR policy) and encrypts the payload with it as a "dir" JWE.  The
+// sealed public and private blobs and the parameters needed to recreate the
+// primary travel in the protected header:
+//
+//	{"alg":"dir","enc":"A256GCM","clevis":{"pin":"tpm2","tpm2":{
+//	  "hash":"sha256","key":"ecc","pcr_bank":"sha256","pcr_ids":"7",
+//	  "jwk_pub":"...","jwk_priv":"..."}}}
+//
+// Tokens written here decrypt with clevis decrypt and the other way round.
+package clevis
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	keyfile "github.com/foxboron/go-tpm-keyfiles"
+	"github.com/google/go-tpm/tpm2"
+	"github.com/google/go-tpm/tpm2/transport"
+	"github.com/ibiscum/tpm2/tpmkey"
+	"github.com/ibiscum/tpm2/tpmtemplate"
+)
+
+// Pin is the clevis pin name.
+const Pin = "tpm2"
+
+var b64 = base64.RawURLEncoding
+
+// Config is the pin configuration, the JSON argument of
+// "clevis encrypt tpm2".  Empty fields take the clevis defaults.
+type Config struct {
+	// Hash is the name algorithm of the primary and sealed objects: sha1,
+	// sha256 (default), sha384 or sha512.
+	Hash string `json:"hash,omitempty"`
+	// Key is the primary key type: ecc (default) or rsa.
+	Key string `json:"key,omitempty"`
+	// PCRBank is the bank PCRIDs are read from, sha256 by default.
+	PCRBank string `json:"pcr_bank,omitempty"`
+	// PCRIDs is a comma separated list of PCRs ("0,7").  ParseConfig also
+	// takes a JSON array of numbers, as clevis does.
+	PCRIDs string `json:"pcr_ids,omitempty"`
+}
+
+// ParseConfig parses a clevis tpm2 pin configuration.
+func ParseConfig(data []byte) (*Config, error) {
+	var raw struct {
+		Hash      string          `json:"hash"`
+		Key       string          `json:"key"`
+		PCRBank   string          `json:"pcr_bank"`
+		PCRIDs    json.RawMessage `json:"pcr_ids"`
+		PCRDigest string          `json:"pcr_digest"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("clevis: bad config: %v", err)
+	}
+	if raw.PCRDigest != "" {
+		return nil, fmt.Errorf("clevis: pcr_digest is not supported, the current PCR values are used")
+	}
+	ids, err := pcrIDs(raw.PCRIDs)
+	if err != nil {
+		return nil, err
+	}
+	return &Config{
+		Hash:    raw.Hash,
+		Key:     raw.Key,
+		PCRBank: raw.PCRBank,
+		PCRIDs:  ids,
+	}, nil
+}
+
+// pcrIDs normalizes "7", " 0, 7" or [0,7] to "0,7".
+func pcrIDs(raw json.RawMessage) (string, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return "", nil
+	}
+	var ids []uint
+	var s string
+	if err := json.Unmarshal(raw, &ids); err != nil {
+		if err := json.Unmarshal(raw, &s); err != nil {
+			return "", fmt.Errorf("clevis: pcr_ids must be a string or an array of numbers")
+		}
+		for _, f := range strings.Split(s, ",") {
+			f = strings.TrimSpace(f)
+			if f == "" {
+				continue
+			}
+			n, err := strconv.ParseUint(f, 10, 8)
+			if err != nil || n > 23 {
+				return "", fmt.Errorf("clevis: bad PCR %q", f)
+			}
+			ids = append(ids, uint(n))
+		}
+	}
+	strs := make([]string, len(ids))
+	for i, n := range ids {
+		if n > 23 {
+			return "", fmt.Errorf("clevis: bad PCR %d", n)
+		}
+		strs[i] = strconv.Itoa(int(n))
+	}
+	return strings.Join(strs, ","), nil
+}
+
+func (c *Config) pcrs() []uint {
+	var pcrs []uint
+	for _, f := range strings.Split(c.PCRIDs, ",") {
+		if n, err := strconv.ParseUint(f, 10, 8); err == nil {
+			pcrs = append(pcrs, uint(n))
+		}
+	}
+	return pcrs
+}
+
+// withDefaults fills in what clevis-encrypt-tpm2 does for missing fields.
+func (c Config) withDefaults() Config {
+	if c.Hash == "" {
+		c.Hash = "sha256"
+	}
+	if c.Key == "" {
+		c.Key = "ecc"
+	}
+	if c.PCRBank == "" {
+		c.PCRBank = "sha256"
+	}
+	return c
+}
+
+func hashAlg(name string) (tpm2.TPMAlgID, error) {
+	switch strings.ToLower(name) {
+	case "sha1":
+		return tpm2.TPMAlgSHA1, nil
+	case "sha256":
+		return tpm2.TPMAlgSHA256, nil
+	case "sha384":
+		return tpm2.TPMAlgSHA384, nil
+	case "sha512":
+		return tpm2.TPMAlgSHA512, nil
+	}
+	return 0, fmt.Errorf("clevis: unsupported hash %q", name)
+}
+
+// primary creates the tpm2-tools default primary the JWK is sealed under.
+// The returned func flushes it.
+func primary(rwr transport.TPM, hash, key string) (*tpm2.NamedHandle, func(), error) {
+	nameAlg, err := hashAlg(hash)
+	if err != nil {
+		return nil, nil, err
+	}
+	var alg tpm2.TPMAlgID
+	switch strings.ToLower(key) {
+	case "ecc", "ecc256":
+		alg = tpm2.TPMAlgECC
+	case "rsa", "rsa2048":
+		alg = tpm2.TPMAlgRSA
+	default:
+		return nil, nil, fmt.Errorf("clevis: unsupported key type %q", key)
+	}
+	template, err := tpmtemplate.Tools(alg, nameAlg)
+	if err != nil {
+		return nil, nil, err
+	}
+	return tpmkey.Primary(rwr, tpm2.TPMRHOwner, template)
+}
+
+// tpm2Header is the clevis.tpm2 member of the protected header.
+type tpm2Header struct {
+	Hash    string `json:"hash"`
+	Key     string `json:"key"`
+	PCRBank string `json:"pcr_bank,omitempty"`
+	PCRIDs  string `json:"pcr_ids,omitempty"`
+	JWKPub  string `json:"jwk_pub"`
+	JWKPriv string `json:"jwk_priv"`
+}
+
+type header struct {
+	Alg    string `json:"alg"`
+	Enc    string `json:"enc"`
+	Clevis struct {
+		Pin  string      `json:"pin"`
+		TPM2 *tpm2Header `json:"tpm2"`
+	} `json:"clevis"`
+}
+
+// jwk is the symmetric key that gets sealed, as "jose jwk gen" writes it.
+type jwk struct {
+	Alg    string   `json:"alg"`
+	K      string   `json:"k"`
+	KeyOps []string `json:"key_ops"`
+	Kty    string   `json:"kty"`
+}
+
+// Encrypt seals a fresh content encryption key with the TPM and returns the
+// compact JWE of plaintext, as "clevis encrypt tpm2" does.  With PCRIDs set
+// the key is bound to the current values of those PCRs.
+func Encrypt(rwr transport.TPM, cfg *Config, plaintext []byte) (string, error) {
+	if cfg == nil {
+		cfg = &Config{}
+	}
+	c := cfg.withDefaults()
+	nameAlg, err := hashAlg(c.Hash)
+	if err != nil {
+		return "", err
+	}
+	bank, err := hashAlg(c.PCRBank)
+	if err != nil {
+		return "", err
+	}
+
+	cek := make([]byte, 32)
+	if _, err := rand.Read(cek); err != nil {
+		return "", err
+	}
+	sealed, err := json.Marshal(jwk{
+		Alg:    "A256GCM",
+		K:      b64.EncodeToString(cek),
+		KeyOps: []string{"encrypt", "decrypt"},
+		Kty:    "oct",
+	})
+	if err != nil {
+		return "", err
+	}
+
+	// the attributes clevis passes to tpm2_create
+	template := tpmkey.SealTemplate(nameAlg)
+	template.ObjectAttributes.AdminWithPolicy = true
+	if pcrs := c.pcrs(); len(pcrs) > 0 {
+		template.ObjectAttributes.UserWithAuth = false
+		_, digest, err := tpmkey.PolicySteps(rwr, &tpmkey.Policy{PCRs: pcrs, Bank: bank}, false, nameAlg)
+		if err != nil {
+			return "", err
+		}
+		template.AuthPolicy = tpm2.TPM2BDigest{Buffer: digest}
+	} else {
+		c.PCRBank = ""
+	}
+
+	parent, closer, err := primary(rwr, c.Hash, c.Key)
+	if err != nil {
+		return "", err
+	}
+	defer closer()
+
+	rsp, err := tpm2.Create{
+		ParentHandle: tpm2.AuthHandle{
+			Handle: parent.Handle,
+			Name:   parent.Name,
+			Auth:   tpm2.PasswordAuth(nil),
+		},
+		InPublic: tpm2.New2B(template),
+		InSensitive: tpm2.TPM2BSensitiveCreate{
+			Sensitive: &tpm2.TPMSSensitiveCreate{
+				Data: tpm2.NewTPMUSensitiveCreate(&tpm2.TPM2BSensitiveData{
+					Buffer: sealed,
+				}),
+			},
+		},
+	}.Execute(rwr)
+	if err != nil {
+		return "", fmt.Errorf("clevis: can't seal key: %v", err)
+	}
+
+	var h header
+	h.Alg = "dir"
+	h.Enc = "A256GCM"
+	h.Clevis.Pin = Pin
+	h.Clevis.TPM2 = &tpm2Header{
+		Hash:    c.Hash,
+		Key:     c.Key,
+		PCRBank: c.PCRBank,
+		PCRIDs:  c.PCRIDs,
+		JWKPub:  b64.EncodeToString(tpm2.Marshal(rsp.OutPublic)),
+		JWKPriv: b64.EncodeToString(tpm2.Marshal(rsp.OutPrivate)),
+	}
+	protected, err := json.Marshal(h)
+	if err != nil {
+		return "", err
+	}
+	return seal(cek, b64.EncodeToString(protected), plaintext)
+}
+
+// seal produces the compact "dir" JWE: the encrypted key part is empty and
+// the AAD is the encoded protected header.
+func seal(cek []byte, protected string, plaintext []byte) (string, error) {
+	gcm, err := newGCM(cek)
+	if err != nil {
+		return "", err
+	}
+	iv := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(iv); err != nil {
+		return "", err
+	}
+	out := gcm.Seal(nil, iv, plaintext, []byte(protected))
+	ct, tag := out[:len(out)-gcm.Overhead()], out[len(out)-gcm.Overhead():]
+	return strings.Join([]string{
+		protected,
+		"",
+		b64.EncodeToString(iv),
+		b64.EncodeToString(ct),
+		b64.EncodeToString(tag),
+	}, "."), nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Header returns the clevis.tpm2 parameters of a JWE, for display.
+func Header(token string) (*Config, error) {
+	h, _, err := parse(token)
+	if err != nil {
+		return nil, err
+	}
+	t := h.Clevis.TPM2
+	return &Config{
+		Hash:    t.Hash,
+		Key:     t.Key,
+		PCRBank: t.PCRBank,
+		PCRIDs:  t.PCRIDs,
+	}, nil
+}
+
+func parse(token string) (*header, []string, error) {
+	parts := strings.Split(strings.TrimSpace(token), ".")
+	if len(parts) != 5 {
+		return nil, nil, fmt.Errorf("clevis: not a compact JWE")
+	}
+	raw, err := b64.DecodeString(parts[0])
+	if err != nil {
+		return nil, nil, fmt.Errorf("clevis: bad protected header: %v", err)
+	}
+	// pcr_ids may be a string or a number array depending on the clevis
+	// version, so decode the pin part separately
+	var h struct {
+		Alg    string `json:"alg"`
+		Enc    string `json:"enc"`
+		Clevis struct {
+			Pin  string          `json:"pin"`
+			TPM2 json.RawMessage `json:"tpm2"`
+		} `json:"clevis"`
+	}
+	if err := json.Unmarshal(raw, &h); err != nil {
+		return nil, nil, fmt.Errorf("clevis: bad protected header: %v", err)
+	}
+	if h.Clevis.Pin != Pin || h.Clevis.TPM2 == nil {
+		return nil, nil, fmt.Errorf("clevis: JWE is for pin %q, not %q", h.Clevis.Pin, Pin)
+	}
+	if h.Alg != "dir" || h.Enc != "A256GCM" {
+		return nil, nil, fmt.Errorf("clevis: unsupported JWE alg %q enc %q", h.Alg, h.Enc)
+	}
+
+	var t struct {
+		Hash    string          `json:"hash"`
+		Key     string          `json:"key"`
+		PCRBank string          `json:"pcr_bank"`
+		PCRIDs  json.RawMessage `json:"pcr_ids"`
+		JWKPub  string          `json:"jwk_pub"`
+		JWKPriv string          `json:"jwk_priv"`
+	}
+	if err := json.Unmarshal(h.Clevis.TPM2, &t); err != nil {
+		return nil, nil, fmt.Errorf("clevis: bad tpm2 header: %v", err)
+	}
+	ids, err := pcrIDs(t.PCRIDs)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	out := &header{
+		Alg: h.Alg,
+		Enc: h.Enc,
+	}
+	out.Clevis.Pin = h.Clevis.Pin
+	out.Clevis.TPM2 = &tpm2Header{
+		Hash:    t.Hash,
+		Key:     t.Key,
+		PCRBank: t.PCRBank,
+		PCRIDs:  ids,
+		JWKPub:  t.JWKPub,
+		JWKPriv: t.JWKPriv,
+	}
+	return out, parts, nil
+}
+
+// Decrypt unseals the content encryption key of a clevis tpm2 JWE and
+// returns the plaintext.  PCR bound keys only unseal while the PCRs hold
+// the values they had at encryption time.
+func Decrypt(rwr transport.TPM, token string) ([]byte, error) {
+	h, parts, err := parse(token)
+	if err != nil {
+		return nil, err
+	}
+	t := h.Clevis.TPM2
+	c := Config{Hash: t.Hash, Key: t.Key, PCRBank: t.PCRBank, PCRIDs: t.PCRIDs}.withDefaults()
+
+	pubBytes, err := b64.DecodeString(t.JWKPub)
+	if err != nil {
+		return nil, fmt.Errorf("clevis: bad jwk_pub: %v", err)
+	}
+	privBytes, err := b64.DecodeString(t.JWKPriv)
+	if err != nil {
+		return nil, fmt.Errorf("clevis: bad jwk_priv: %v", err)
+	}
+	pub, err := tpm2.Unmarshal[tpm2.TPM2BPublic](pubBytes)
+	if err != nil {
+		return nil, fmt.Errorf("clevis: bad jwk_pub: %v", err)
+	}
+	priv, err := tpm2.Unmarshal[tpm2.TPM2BPrivate](privBytes)
+	if err != nil {
+		return nil, fmt.Errorf("clevis: bad jwk_priv: %v", err)
+	}
+
+	var policy []*keyfile.TPMPolicy
+	if pcrs := c.pcrs(); len(pcrs) > 0 {
+		bank, err := hashAlg(c.PCRBank)
+		if err != nil {
+			return nil, err
+		}
+		sel := tpm2.TPMLPCRSelection{
+			PCRSelections: []tpm2.TPMSPCRSelection{
+				{
+					Hash:      bank,
+					PCRSelect: tpm2.PCClientCompatible.PCRs(pcrs...),
+				},
+			},
+		}
+		// no digest: the TPM compares against the current values
+		policy = append(policy, tpmkey.PolicyPCRStep(sel, nil))
+	}
+
+	parent, closer, err := primary(rwr, c.Hash, c.Key)
+	if err != nil {
+		return nil, err
+	}
+	defer closer()
+
+	k, err := tpmkey.LoadBlob(rwr, parent, *pub, *priv, nil, policy)
+	if err != nil {
+		return nil, err
+	}
+	defer k.Close()
+
+	data, err := k.Unseal()
+	if err != nil {
+		return nil, err
+	}
+	var key jwk
+	if err := json.Unmarshal(data, &key); err != nil {
+		return nil, fmt.Errorf("clevis: sealed data is not a JWK: %v", err)
+	}
+	cek, err := b64.DecodeString(key.K)
+	if err != nil || key.Kty != "oct" || len(cek) != 32 {
+		return nil, fmt.Errorf("clevis: sealed JWK is not an AES-256 key")
+	}
+	return open(cek, parts)
+}
+
+func open(cek []byte, parts []string) ([]byte, error) {
+	if parts[1] != "" {
+		return nil, fmt.Errorf("clevis: unexpected encrypted key in dir JWE")
+	}
+	iv, err := b64.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("clevis: bad IV: %v", err)
+	}
+	ct, err := b64.DecodeString(parts[3])
+	if err != nil {
+		return nil, fmt.Errorf("clevis: bad ciphertext: %v", err)
+	}
+	tag, err := b64.DecodeString(parts[4])
+	if err != nil {
+		return nil, fmt.Errorf("clevis: bad tag: %v", err)
+	}
+	gcm, err := newGCM(cek)
+	if err != nil {
+		return nil, err
+	}
+	if len(iv) != gcm.NonceSize() || len(tag) != gcm.Overhead() {
+		return nil, fmt.Errorf("clevis: bad IV or tag length")
+	}
+	pt, err := gcm.Open(nil, iv, append(ct, tag...), []byte(parts[0]))
+	if err != nil {
+		return nil, fmt.Errorf("clevis: decryption failed: %v", err)
+	}
+	return pt, nil
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"log"
+	"net"
+	"os"
+	"slices"
+
+	"github.com/google/go-tpm-tools/simulator"
+	"github.com/google/go-tpm/tpm2/transport"
+	"github.com/google/go-tpm/tpmutil"
+	"github.com/ibiscum/tpm2/clevis"
+)
+
+var (
+	tpmPath = flag.String("tpm-path", "/dev/tpmrm0", "Path to the TPM device (character device or a Unix socket).")
+	in      = flag.String("in", "", "JWE to decrypt, stdin if unset")
+	header  = flag.Bool("header", false, "only print the tpm2 pin parameters of the JWE")
+)
+
+var TPMDEVICES = []string{"/dev/tpm0", "/dev/tpmrm0"}
+
+func OpenTPM(path string) (io.ReadWriteCloser, error) {
+	if slices.Contains(TPMDEVICES, path) {
+		return tpmutil.OpenTPM(path)
+	} else if path == "simulator" {
+		return simulator.GetWithFixedSeedInsecure(1073741825)
+	} else {
+		return net.Dial("tcp", path)
+	}
+}
+
+func main() {
+	flag.Parse()
+
+	var jwe []byte
+	var err error
+	if *in == "" {
+		jwe, err = io.ReadAll(os.Stdin)
+	} else {
+		jwe, err = os.ReadFile(*in)
+	}
+	if err != nil {
+		log.Fatalf("can't read JWE: %v", err)
+	}
+
+	if *header {
+		cfg, err := clevis.Header(string(jwe))
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		log.Printf("hash=%s key=%s pcr_bank=%s pcr_ids=%s", cfg.Hash, cfg.Key, cfg.PCRBank, cfg.PCRIDs)
+		return
+	}
+
+	rwc, err := OpenTPM(*tpmPath)
+	if err != nil {
+		log.Fatalf("can't open TPM %q: %v", *tpmPath, err)
+	}
+	defer func() {
+		rwc.Close()
+	}()
+
+	rwr := transport.FromReadWriter(rwc)
+
+	plaintext, err := clevis.Decrypt(rwr, string(jwe))
+	if err != nil {
+		log.Fatalf("can't decrypt: %v", err)
+	}
+	os.Stdout.Write(plaintext)
+}
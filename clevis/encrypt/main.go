@@ -0,0 +1,77 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"slices"
+
+	"github.com/google/go-tpm-tools/simulator"
+	"github.com/google/go-tpm/tpm2/transport"
+	"github.com/google/go-tpm/tpmutil"
+	"github.com/ibiscum/tpm2/clevis"
+)
+
+var (
+	tpmPath = flag.String("tpm-path", "/dev/tpmrm0", "Path to the TPM device (character device or a Unix socket).")
+	config  = flag.String("config", "{}", `clevis tpm2 pin configuration, eg '{"pcr_bank":"sha256","pcr_ids":"7"}'`)
+	in      = flag.String("in", "", "file to encrypt, stdin if unset")
+	out     = flag.String("out", "", "file to write the JWE to, stdout if unset")
+)
+
+var TPMDEVICES = []string{"/dev/tpm0", "/dev/tpmrm0"}
+
+func OpenTPM(path string) (io.ReadWriteCloser, error) {
+	if slices.Contains(TPMDEVICES, path) {
+		return tpmutil.OpenTPM(path)
+	} else if path == "simulator" {
+		return simulator.GetWithFixedSeedInsecure(1073741825)
+	} else {
+		return net.Dial("tcp", path)
+	}
+}
+
+func main() {
+	flag.Parse()
+
+	cfg, err := clevis.ParseConfig([]byte(*config))
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	var plaintext []byte
+	if *in == "" {
+		plaintext, err = io.ReadAll(os.Stdin)
+	} else {
+		plaintext, err = os.ReadFile(*in)
+	}
+	if err != nil {
+		log.Fatalf("can't read input: %v", err)
+	}
+
+	rwc, err := OpenTPM(*tpmPath)
+	if err != nil {
+		log.Fatalf("can't open TPM %q: %v", *tpmPath, err)
+	}
+	defer func() {
+		rwc.Close()
+	}()
+
+	rwr := transport.FromReadWriter(rwc)
+
+	jwe, err := clevis.Encrypt(rwr, cfg, plaintext)
+	if err != nil {
+		log.Fatalf("can't encrypt: %v", err)
+	}
+
+	if *out == "" {
+		fmt.Println(jwe)
+		return
+	}
+	if err := os.WriteFile(*out, []byte(jwe+"\n"), 0644); err != nil {
+		log.Fatalf("can't write JWE: %v", err)
+	}
+}
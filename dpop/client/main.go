@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+
+	keyfile "github.com/foxboron/go-tpm-keyfiles"
+	"github.com/google/go-tpm-tools/simulator"
+	"github.com/google/go-tpm/tpm2/transport"
+	"github.com/google/go-tpm/tpmutil"
+	"github.com/ibiscum/tpm2/dpop"
+	"github.com/ibiscum/tpm2/tpmkey"
+	"golang.org/x/oauth2"
+)
+
+var (
+	tpmPath  = flag.String("tpm-path", "/dev/tpmrm0", "Path to the TPM device (character device or a Unix socket).")
+	in       = flag.String("in", "key.pem", "keyfile of the P-256 DPoP key")
+	password = flag.String("password", "", "optional key password")
+	tokenURL = flag.String("token-url", "http://localhost:8080/token", "token endpoint")
+	resource = flag.String("resource", "http://localhost:8080/resource", "protected resource")
+)
+
+var TPMDEVICES = []string{"/dev/tpm0", "/dev/tpmrm0"}
+
+func OpenTPM(path string) (io.ReadWriteCloser, error) {
+	if slices.Contains(TPMDEVICES, path) {
+		return tpmutil.OpenTPM(path)
+	} else if path == "simulator" {
+		return simulator.GetWithFixedSeedInsecure(1073741825)
+	} else {
+		return net.Dial("tcp", path)
+	}
+}
+
+func main() {
+	flag.Parse()
+
+	b, err := os.ReadFile(*in)
+	if err != nil {
+		log.Fatalf("can't read keyfile: %v", err)
+	}
+	kf, err := keyfile.Decode(b)
+	if err != nil {
+		log.Fatalf("can't decode keyfile: %v", err)
+	}
+
+	rwc, err := OpenTPM(*tpmPath)
+	if err != nil {
+		log.Fatalf("can't open TPM %q: %v", *tpmPath, err)
+	}
+	defer func() {
+		rwc.Close()
+	}()
+
+	rwr := transport.FromReadWriter(rwc)
+
+	k, err := tpmkey.Load(rwr, kf, []byte(*password))
+	if err != nil {
+		log.Fatalf("can't load key: %v", err)
+	}
+	defer k.Close()
+
+	p, err := dpop.New(k)
+	if err != nil {
+		log.Fatalf("can't create proofer: %v", err)
+	}
+	log.Printf("DPoP key thumbprint %s", p.Thumbprint())
+
+	// token request: proof only, no ath
+	client := &http.Client{
+		Transport: &dpop.Transport{
+			Proofer: p,
+		},
+	}
+	form := url.Values{
+		"grant_type": {"client_credentials"},
+	}
+	resp, err := client.Post(*tokenURL, "application/x-www-form-urlencoded", strings.NewReader(form.Encode()))
+	if err != nil {
+		log.Fatalf("token request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		log.Fatalf("token request failed: %s %s", resp.Status, body)
+	}
+	var tok oauth2.Token
+	if err := json.NewDecoder(resp.Body).Decode(&tok); err != nil {
+		log.Fatalf("bad token response: %v", err)
+	}
+	log.Printf("got %s token %s", tok.TokenType, tok.AccessToken)
+
+	// resource request: proof bound to the access token
+	client = &http.Client{
+		Transport: &dpop.Transport{
+			Proofer: p,
+			Token:   oauth2.StaticTokenSource(&tok),
+		},
+	}
+	resp, err = client.Get(*resource)
+	if err != nil {
+		log.Fatalf("resource request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		log.Fatalf("can't read response: %v", err)
+	}
+	log.Printf("%s: %s", resp.Status, strings.TrimSpace(string(body)))
+}
@@ -0,0 +1,143 @@
+// Package dpop creates RFC 9449 DPoP proofs with a key held in the TPM, so
+// access tokens bound to it can't be replayed from another machine.
+package dpop
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ibiscum/tpm2/jose"
+	"github.com/ibiscum/tpm2/tpmkey"
+)
+
+// Type is the typ header value of a DPoP proof.
+const Type = "dpop+jwt"
+
+// Claims are the claims of a DPoP proof.
+type Claims struct {
+	JTI   string `json:"jti"`
+	HTM   string `json:"htm"`
+	HTU   string `json:"htu"`
+	IAT   int64  `json:"iat"`
+	ATH   string `json:"ath,omitempty"`
+	Nonce string `json:"nonce,omitempty"`
+}
+
+// Proofer signs DPoP proofs and remembers the nonces servers hand out.
+type Proofer struct {
+	signer *jose.Signer
+
+	mu     sync.Mutex
+	nonces map[string]string
+
+	// Now returns the iat time, time.Now if nil.
+	Now func() time.Time
+}
+
+// New returns a Proofer for k, which would normally be a P-256 key (ES256).
+func New(k *tpmkey.Key) (*Proofer, error) {
+	s, err := jose.NewSigner(k, "")
+	if err != nil {
+		return nil, fmt.Errorf("dpop: %v", err)
+	}
+	return &Proofer{
+		signer: s,
+		nonces: map[string]string{},
+	}, nil
+}
+
+// Thumbprint returns the JWK thumbprint of the key, the value of the cnf.jkt
+// claim of bound tokens and of the dpop_jkt authorization request parameter.
+func (p *Proofer) Thumbprint() string {
+	return p.signer.JWK().Kid
+}
+
+// Proof returns a fresh proof for a request.  accessToken, if set, is bound
+// with the ath claim; nonce is the last DPoP-Nonce of the server, if any.
+func (p *Proofer) Proof(method, uri, accessToken, nonce string) (string, error) {
+	htu, err := HTU(uri)
+	if err != nil {
+		return "", err
+	}
+	jti := make([]byte, 16)
+	if _, err := rand.Read(jti); err != nil {
+		return "", err
+	}
+	now := time.Now
+	if p.Now != nil {
+		now = p.Now
+	}
+
+	c := Claims{
+		JTI:   hex.EncodeToString(jti),
+		HTM:   method,
+		HTU:   htu,
+		IAT:   now().Unix(),
+		Nonce: nonce,
+	}
+	if accessToken != "" {
+		c.ATH = ATH(accessToken)
+	}
+	payload, err := json.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+
+	// the public key is the jwk header, there is no kid
+	jwk := *p.signer.JWK()
+	jwk.Kid, jwk.Use, jwk.Alg = "", "", ""
+	return p.signer.Sign(map[string]any{
+		"typ": Type,
+		"jwk": &jwk,
+	}, payload)
+}
+
+// Nonce returns the last nonce seen from the origin of uri.
+func (p *Proofer) Nonce(uri string) string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.nonces[origin(uri)]
+}
+
+// SetNonce records a DPoP-Nonce sent by the origin of uri.
+func (p *Proofer) SetNonce(uri, nonce string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.nonces[origin(uri)] = nonce
+}
+
+// HTU normalizes uri for the htu claim: query and fragment are dropped.
+func HTU(uri string) (string, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return "", fmt.Errorf("dpop: bad uri: %v", err)
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return "", fmt.Errorf("dpop: uri %q is not absolute", uri)
+	}
+	u.RawQuery, u.Fragment, u.RawFragment, u.User = "", "", "", nil
+	u.Scheme = strings.ToLower(u.Scheme)
+	u.Host = strings.ToLower(u.Host)
+	return u.String(), nil
+}
+
+// ATH is the base64url SHA-256 of an access token.
+func ATH(accessToken string) string {
+	h := sha256.Sum256([]byte(accessToken))
+	return b64(h[:])
+}
+
+func origin(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return uri
+	}
+	return strings.ToLower(u.Scheme + "://" + u.Host)
+}
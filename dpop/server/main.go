@@ -0,0 +1,150 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ibiscum/tpm2/dpop"
+)
+
+var (
+	listen  = flag.String("listen", "localhost:8080", "address to listen on")
+	baseURL = flag.String("url", "http://localhost:8080", "external URL of this server, used to check htu")
+	maxAge  = flag.Duration("max-age", time.Minute, "accepted iat skew")
+)
+
+// a stand-in for an authorization server and a resource server that issue
+// and accept DPoP bound access tokens
+
+type server struct {
+	mu     sync.Mutex
+	nonce  string
+	seen   map[string]bool   // jti replay cache
+	tokens map[string]string // access token -> jkt
+}
+
+func main() {
+	flag.Parse()
+
+	s := &server{
+		seen:   map[string]bool{},
+		tokens: map[string]string{},
+	}
+	s.rotateNonce()
+
+	http.HandleFunc("/token", s.token)
+	http.HandleFunc("/resource", s.resource)
+
+	log.Printf("listening on %s", *listen)
+	log.Fatal(http.ListenAndServe(*listen, nil))
+}
+
+func (s *server) rotateNonce() {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	s.mu.Lock()
+	s.nonce = hex.EncodeToString(b)
+	s.mu.Unlock()
+}
+
+// check verifies the proof of r, asking for a nonce if it has none.
+func (s *server) check(w http.ResponseWriter, r *http.Request, accessToken string) (string, bool) {
+	s.mu.Lock()
+	nonce := s.nonce
+	s.mu.Unlock()
+	w.Header().Set("DPoP-Nonce", nonce)
+
+	proof := r.Header.Get("DPoP")
+	if proof == "" {
+		return "", false
+	}
+	c, jkt, err := dpop.Verify(proof, r.Method, *baseURL+r.URL.Path, accessToken, "", time.Now(), *maxAge)
+	if err != nil {
+		log.Printf("rejected proof: %v", err)
+		return "", false
+	}
+	if c.Nonce != nonce {
+		log.Printf("proof without current nonce, asking for one")
+		return "use_dpop_nonce", false
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.seen[c.JTI] {
+		log.Printf("replayed jti %s", c.JTI)
+		return "", false
+	}
+	s.seen[c.JTI] = true
+	return jkt, true
+}
+
+func (s *server) token(w http.ResponseWriter, r *http.Request) {
+	jkt, ok := s.check(w, r, "")
+	if !ok {
+		if jkt == "" {
+			jkt = "invalid_dpop_proof"
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_ = json.NewEncoder(w).Encode(map[string]string{
+			"error": jkt,
+		})
+		return
+	}
+
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	tok := hex.EncodeToString(b)
+	s.mu.Lock()
+	s.tokens[tok] = jkt
+	s.mu.Unlock()
+	log.Printf("issued token %s bound to jkt %s", tok, jkt)
+	// a fresh nonce makes the client go through use_dpop_nonce again at
+	// the resource
+	s.rotateNonce()
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"access_token": tok,
+		"token_type":   "DPoP",
+		"expires_in":   3600,
+	})
+}
+
+func (s *server) resource(w http.ResponseWriter, r *http.Request) {
+	tok, found := strings.CutPrefix(r.Header.Get("Authorization"), "DPoP ")
+	s.mu.Lock()
+	bound, known := s.tokens[tok]
+	s.mu.Unlock()
+	if !found || !known {
+		w.Header().Set("WWW-Authenticate", `DPoP error="invalid_token", algs="ES256 ES384 RS256 PS256"`)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	jkt, ok := s.check(w, r, tok)
+	if !ok {
+		if jkt == "" {
+			jkt = "invalid_dpop_proof"
+		}
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf(`DPoP error=%q, algs="ES256 ES384 RS256 PS256"`, jkt))
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if jkt != bound {
+		log.Printf("token bound to %s presented with key %s", bound, jkt)
+		w.Header().Set("WWW-Authenticate", `DPoP error="invalid_token"`)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	fmt.Fprintf(w, "hello %s\n", jkt)
+}
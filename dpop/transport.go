@@ -0,0 +1,120 @@
+package dpop
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"golang.org/x/oauth2"
+)
+
+// Transport is an http.RoundTripper that attaches a fresh DPoP proof to
+// every request.  When the server asks for a nonce (use_dpop_nonce) the
+// request is retried once with the nonce it sent.
+type Transport struct {
+	Proofer *Proofer
+	// Token, if set, supplies the DPoP bound access token sent as
+	// "Authorization: DPoP <token>" and hashed into the ath claim.  Leave it
+	// nil for token endpoint requests.
+	Token oauth2.TokenSource
+	// Base is used to send the request, http.DefaultTransport if nil.
+	Base http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var accessToken string
+	if t.Token != nil {
+		tok, err := t.Token.Token()
+		if err != nil {
+			return nil, err
+		}
+		accessToken = tok.AccessToken
+	}
+	uri := req.URL.String()
+
+	resp, err := t.send(req, req.Body, accessToken, t.Proofer.Nonce(uri))
+	if err != nil {
+		return nil, err
+	}
+	nonce := resp.Header.Get("DPoP-Nonce")
+	if nonce == "" {
+		return resp, nil
+	}
+	t.Proofer.SetNonce(uri, nonce)
+
+	if !needsNonce(resp) {
+		return resp, nil
+	}
+	body := req.Body
+	if req.Body != nil && req.Body != http.NoBody {
+		if req.GetBody == nil {
+			// the body is gone, let the caller deal with it
+			return resp, nil
+		}
+		if body, err = req.GetBody(); err != nil {
+			return resp, nil
+		}
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	resp, err = t.send(req, body, accessToken, nonce)
+	if err != nil {
+		return nil, err
+	}
+	if n := resp.Header.Get("DPoP-Nonce"); n != "" {
+		t.Proofer.SetNonce(uri, n)
+	}
+	return resp, nil
+}
+
+func (t *Transport) send(req *http.Request, body io.ReadCloser, accessToken, nonce string) (*http.Response, error) {
+	proof, err := t.Proofer.Proof(req.Method, req.URL.String(), accessToken, nonce)
+	if err != nil {
+		return nil, fmt.Errorf("dpop: can't create proof: %v", err)
+	}
+
+	// RoundTrippers must not modify the caller's request
+	r := req.Clone(req.Context())
+	r.Body = body
+	r.Header.Set("DPoP", proof)
+	if accessToken != "" {
+		r.Header.Set("Authorization", "DPoP "+accessToken)
+	}
+
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return base.RoundTrip(r)
+}
+
+// needsNonce reports whether resp rejected the proof for lack of a nonce:
+// a resource server answers 401 with a WWW-Authenticate challenge, an
+// authorization server 400 with an OAuth error body.
+func needsNonce(resp *http.Response) bool {
+	switch resp.StatusCode {
+	case http.StatusUnauthorized:
+		for _, v := range resp.Header.Values("WWW-Authenticate") {
+			if strings.Contains(v, `error="use_dpop_nonce"`) {
+				return true
+			}
+		}
+	case http.StatusBadRequest:
+		b, err := io.ReadAll(io.LimitReader(resp.Body, 1<<16))
+		resp.Body.Close()
+		resp.Body = io.NopCloser(bytes.NewReader(b))
+		if err != nil {
+			return false
+		}
+		var e struct {
+			Error string `json:"error"`
+		}
+		return json.Unmarshal(b, &e) == nil && e.Error == "use_dpop_nonce"
+	}
+	return false
+}
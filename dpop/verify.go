@@ -0,0 +1,86 @@
+package dpop
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/ibiscum/tpm2/jose"
+)
+
+// Verify checks a proof the way a resource or authorization server would
+// (RFC 9449 section 4.3), except for jti replay tracking which is left to
+// the caller.  accessToken and nonce are only checked when set.  It returns
+// the claims and the thumbprint of the proof key.
+func Verify(proof, method, uri, accessToken, nonce string, now time.Time, maxAge time.Duration) (*Claims, string, error) {
+	header, _, err := jose.Parse(proof)
+	if err != nil {
+		return nil, "", fmt.Errorf("dpop: %v", err)
+	}
+	if typ, _ := header["typ"].(string); typ != Type {
+		return nil, "", fmt.Errorf("dpop: typ is %q, not %s", typ, Type)
+	}
+	raw, ok := header["jwk"].(map[string]any)
+	if !ok {
+		return nil, "", fmt.Errorf("dpop: no jwk header")
+	}
+	if _, ok := raw["d"]; ok {
+		return nil, "", fmt.Errorf("dpop: jwk header holds a private key")
+	}
+	b, err := json.Marshal(raw)
+	if err != nil {
+		return nil, "", err
+	}
+	var jwk jose.JWK
+	if err := json.Unmarshal(b, &jwk); err != nil {
+		return nil, "", fmt.Errorf("dpop: bad jwk header: %v", err)
+	}
+	pub, err := jwk.PublicKey()
+	if err != nil {
+		return nil, "", fmt.Errorf("dpop: %v", err)
+	}
+
+	_, payload, err := jose.Verify(proof, pub)
+	if err != nil {
+		return nil, "", fmt.Errorf("dpop: %v", err)
+	}
+	var c Claims
+	if err := json.Unmarshal(payload, &c); err != nil {
+		return nil, "", fmt.Errorf("dpop: bad claims: %v", err)
+	}
+
+	if c.JTI == "" {
+		return nil, "", fmt.Errorf("dpop: missing jti")
+	}
+	if c.HTM != method {
+		return nil, "", fmt.Errorf("dpop: htm %q doesn't match %s", c.HTM, method)
+	}
+	htu, err := HTU(uri)
+	if err != nil {
+		return nil, "", err
+	}
+	if c.HTU != htu {
+		return nil, "", fmt.Errorf("dpop: htu %q doesn't match %s", c.HTU, htu)
+	}
+	iat := time.Unix(c.IAT, 0)
+	if iat.Before(now.Add(-maxAge)) || iat.After(now.Add(maxAge)) {
+		return nil, "", fmt.Errorf("dpop: iat %v out of range", iat)
+	}
+	if nonce != "" && c.Nonce != nonce {
+		return nil, "", fmt.Errorf("dpop: stale or missing nonce")
+	}
+	if accessToken != "" && c.ATH != ATH(accessToken) {
+		return nil, "", fmt.Errorf("dpop: ath doesn't match the access token")
+	}
+
+	jkt, err := jwk.Thumbprint()
+	if err != nil {
+		return nil, "", err
+	}
+	return &c, jkt, nil
+}
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/hex"
+	"flag"
+	"io"
+	"log"
+	"net"
+	"os"
+	"slices"
+	"strconv"
+
+	keyfile "github.com/foxboron/go-tpm-keyfiles"
+	"github.com/google/go-tpm-tools/simulator"
+	"github.com/google/go-tpm/tpm2"
+	"github.com/google/go-tpm/tpm2/transport"
+	"github.com/google/go-tpm/tpmutil"
+	"github.com/ibiscum/tpm2/duplicate"
+	"github.com/ibiscum/tpm2/tpmwrap"
+)
+
+var (
+	tpmPath   = flag.String("tpm-path", "/dev/tpmrm0", "Path to the TPM device (character device or a Unix socket).")
+	keyType   = flag.String("type", "ecc", "key type: rsa, ecc, hmac, aes or sealed")
+	in        = flag.String("in", "", "HMAC secret or data to seal (default: the TPM generates the key)")
+	password  = flag.String("password", "", "optional key password")
+	parent    = flag.String("parent", "0x40000001", "parent: a hierarchy (its H-2 SRK) or a persistent storage key")
+	policy    = flag.String("policy", "select", "duplication policy: select (only to --to) or commandcode (to any parent)")
+	to        = flag.String("to", "", "the one new parent the key may be duplicated to, for --policy select: TPM2B_PUBLIC, EK certificate or public key with --template")
+	template  = flag.String("template", "", "template of a --to public key or EK certificate: h2, srk-rsa, srk-ecc, ek-rsa, ek-ecc or a high range EK, see tpmwrap.Templates")
+	encrypted = flag.Bool("encrypted-duplication", false, "set encryptedDuplication, so duplicates need an inner wrapper")
+	out       = flag.String("out", "key.pem", "keyfile to write")
+)
+
+var TPMDEVICES = []string{"/dev/tpm0", "/dev/tpmrm0"}
+
+func OpenTPM(path string) (io.ReadWriteCloser, error) {
+	if slices.Contains(TPMDEVICES, path) {
+		return tpmutil.OpenTPM(path)
+	} else if path == "simulator" {
+		return simulator.GetWithFixedSeedInsecure(1073741825)
+	} else {
+		return net.Dial("tcp", path)
+	}
+}
+
+func main() {
+	flag.Parse()
+
+	t, err := duplicate.Template(*keyType)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	t.ObjectAttributes.EncryptedDuplication = *encrypted
+	var data []byte
+	if *in != "" {
+		if data, err = os.ReadFile(*in); err != nil {
+			log.Fatalf("can't read %s: %v", *in, err)
+		}
+	} else if *keyType == "sealed" {
+		log.Fatalf("--in is required for sealed data")
+	}
+	p, err := strconv.ParseUint(*parent, 0, 32)
+	if err != nil {
+		log.Fatalf("bad parent %q: %v", *parent, err)
+	}
+
+	var digest []byte
+	var target string
+	switch *policy {
+	case "select":
+		if *to == "" {
+			log.Fatalf("--policy select needs the new parent, --to")
+		}
+		b, err := os.ReadFile(*to)
+		if err != nil {
+			log.Fatalf("can't read new parent: %v", err)
+		}
+		newParent, err := tpmwrap.ParseParent(b, *template)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		name, err := tpm2.ObjectName(newParent)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		target = "only to " + hex.EncodeToString(name.Buffer)
+		digest, err = duplicate.Policy(duplicate.Select, t.NameAlg, name.Buffer)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+	case "commandcode":
+		target = "to any parent"
+		digest, err = duplicate.Policy(duplicate.CommandCode, t.NameAlg, nil)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+	default:
+		log.Fatalf("unknown policy %q", *policy)
+	}
+
+	rwc, err := OpenTPM(*tpmPath)
+	if err != nil {
+		log.Fatalf("can't open TPM %q: %v", *tpmPath, err)
+	}
+	defer func() {
+		rwc.Close()
+	}()
+
+	rwr := transport.FromReadWriter(rwc)
+
+	kf, err := duplicate.Create(rwr, tpm2.TPMHandle(p), t, data, []byte(*password), digest)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	pub, err := kf.Pubkey.Contents()
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	name, err := tpm2.ObjectName(pub)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	f, err := os.OpenFile(*out, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		log.Fatalf("can't create keyfile: %v", err)
+	}
+	defer f.Close()
+	if err := keyfile.Encode(f, kf); err != nil {
+		log.Fatalf("can't write keyfile: %v", err)
+	}
+	log.Printf("created %s key %s, duplicable %s, wrote %s", *keyType, hex.EncodeToString(name.Buffer), target, *out)
+}
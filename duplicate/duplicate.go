@@ -0,0 +1,141 @@
+// Package duplicate moves keys between TPMs with TPM2_Duplicate and
+// TPM2_Import, the Go version of the tpm2_duplicate recipe.
+//
+// Duplication is an admin action, so a duplicable key needs an authPolicy
+// that allows it.  Two are built here:
+//
+//   - Select: PolicyDuplicationSelect bound to the name of the one new
+//     parent.  The key can go there and nowhere else, and once imported it
+//     can't be duplicated again, since its new parent is a different key.
+//   - CommandCode: PolicyOR of PolicyCommandCode(TPM2_Duplicate) and
+//     PolicyAuthValue.  The key can be duplicated to any parent, also again
+//     after import, and used with its password through either userWithAuth
+//     or the second branch.
+//
+// The duplicate is written as an importable TSS2 keyfile; with an inner
+// wrapper, the AES key the TPM picked for it has to travel separately.
+package duplicate
+
+import (
+	"fmt"
+
+	"github.com/google/go-tpm/tpm2"
+	"github.com/ibiscum/tpm2/tpmkey"
+)
+
+// Mode is the duplication policy of a key.
+type Mode int
+
+const (
+	// Select allows duplication to one new parent only.
+	Select Mode = iota
+	// CommandCode allows duplication to any parent.
+	CommandCode
+)
+
+// Policy returns the authPolicy for mode.  newParent is the name of the new
+// parent, used by Select only.
+func Policy(mode Mode, nameAlg tpm2.TPMAlgID, newParent []byte) ([]byte, error) {
+	calc, err := tpm2.NewPolicyCalculator(nameAlg)
+	if err != nil {
+		return nil, fmt.Errorf("duplicate: %v", err)
+	}
+	switch mode {
+	case Select:
+		err = tpm2.PolicyDuplicationSelect{
+			NewParentName: tpm2.TPM2BName{Buffer: newParent},
+		}.Update(calc)
+	case CommandCode:
+		var branches []tpm2.TPM2BDigest
+		branches, err = orBranches(nameAlg)
+		if err == nil {
+			err = tpm2.PolicyOr{PHashList: tpm2.TPMLDigest{Digests: branches}}.Update(calc)
+		}
+	default:
+		err = fmt.Errorf("unknown mode %d", mode)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("duplicate: %v", err)
+	}
+	return calc.Hash().Digest, nil
+}
+
+// orBranches returns the PolicyOR branches of CommandCode:
+// PolicyCommandCode(TPM2_Duplicate) and PolicyAuthValue.
+func orBranches(nameAlg tpm2.TPMAlgID) ([]tpm2.TPM2BDigest, error) {
+	var branches []tpm2.TPM2BDigest
+	for _, cmd := range []interface {
+		Update(*tpm2.PolicyCalculator) error
+	}{
+		tpm2.PolicyCommandCode{Code: tpm2.TPMCCDuplicate},
+		tpm2.PolicyAuthValue{},
+	} {
+		calc, err := tpm2.NewPolicyCalculator(nameAlg)
+		if err != nil {
+			return nil, err
+		}
+		if err := cmd.Update(calc); err != nil {
+			return nil, err
+		}
+		branches = append(branches, tpm2.TPM2BDigest{Buffer: calc.Hash().Digest})
+	}
+	return branches, nil
+}
+
+// Template returns the template of a duplicable key: "rsa" (2048),
+// "ecc" (P-256), "hmac" (SHA256), "aes" (128, any mode) or "sealed".
+// fixedTPM and fixedParent are clear, everything else is as for keys that
+// stay in the TPM.
+func Template(typ string) (tpm2.TPMTPublic, error) {
+	var t tpm2.TPMTPublic
+	switch typ {
+	case "rsa":
+		t = tpmkey.RSATemplate(2048, tpm2.TPMAlgNull, tpm2.TPMAlgSHA256)
+	case "ecc":
+		t = tpmkey.ECCTemplate(tpm2.TPMECCNistP256, tpm2.TPMAlgNull, tpm2.TPMAlgSHA256)
+	case "hmac":
+		t = tpm2.TPMTPublic{
+			Type:    tpm2.TPMAlgKeyedHash,
+			NameAlg: tpm2.TPMAlgSHA256,
+			ObjectAttributes: tpm2.TPMAObject{
+				SensitiveDataOrigin: true,
+				UserWithAuth:        true,
+				SignEncrypt:         true,
+			},
+			Parameters: tpm2.NewTPMUPublicParms(tpm2.TPMAlgKeyedHash,
+				&tpm2.TPMSKeyedHashParms{
+					Scheme: tpm2.TPMTKeyedHashScheme{
+						Scheme: tpm2.TPMAlgHMAC,
+						Details: tpm2.NewTPMUSchemeKeyedHash(tpm2.TPMAlgHMAC,
+							&tpm2.TPMSSchemeHMAC{HashAlg: tpm2.TPMAlgSHA256}),
+					},
+				}),
+		}
+	case "aes":
+		t = tpm2.TPMTPublic{
+			Type:    tpm2.TPMAlgSymCipher,
+			NameAlg: tpm2.TPMAlgSHA256,
+			ObjectAttributes: tpm2.TPMAObject{
+				SensitiveDataOrigin: true,
+				UserWithAuth:        true,
+				SignEncrypt:         true,
+				Decrypt:             true,
+			},
+			Parameters: tpm2.NewTPMUPublicParms(tpm2.TPMAlgSymCipher,
+				&tpm2.TPMSSymCipherParms{
+					Sym: tpm2.TPMTSymDefObject{
+						Algorithm: tpm2.TPMAlgAES,
+						KeyBits:   tpm2.NewTPMUSymKeyBits(tpm2.TPMAlgAES, tpm2.TPMKeyBits(128)),
+						Mode:      tpm2.NewTPMUSymMode(tpm2.TPMAlgAES, tpm2.TPMAlgNull),
+					},
+				}),
+		}
+	case "sealed":
+		t = tpmkey.SealTemplate(tpm2.TPMAlgSHA256)
+	default:
+		return t, fmt.Errorf("duplicate: unknown key type %q", typ)
+	}
+	t.ObjectAttributes.FixedTPM = false
+	t.ObjectAttributes.FixedParent = false
+	return t, nil
+}
@@ -0,0 +1,200 @@
+package duplicate
+
+import (
+	"bytes"
+	"fmt"
+
+	keyfile "github.com/foxboron/go-tpm-keyfiles"
+	"github.com/google/go-tpm/tpm2"
+	"github.com/google/go-tpm/tpm2/transport"
+	"github.com/ibiscum/tpm2/tpmkey"
+)
+
+// Create creates a duplicable key from template under parent (see
+// tpmkey.Parent) with authPolicy policy, from Policy.  data is the HMAC
+// secret or sealed data; nil has the TPM generate the key.
+func Create(rwr transport.TPM, parent tpm2.TPMHandle, template tpm2.TPMTPublic, data, auth, policy []byte) (*keyfile.TPMKey, error) {
+	template.AuthPolicy = tpm2.TPM2BDigest{Buffer: policy}
+	if data != nil {
+		template.ObjectAttributes.SensitiveDataOrigin = false
+	}
+	if len(auth) > 0 {
+		// a password protected key should count towards the lockout
+		template.ObjectAttributes.NoDA = false
+	}
+
+	p, closer, err := tpmkey.Parent(rwr, parent)
+	if err != nil {
+		return nil, err
+	}
+	defer closer()
+	parentAuth, err := tpmkey.ParentAuth(rwr, p)
+	if err != nil {
+		return nil, err
+	}
+
+	rsp, err := tpm2.Create{
+		ParentHandle: tpm2.AuthHandle{
+			Handle: p.Handle,
+			Name:   p.Name,
+			Auth:   parentAuth,
+		},
+		InPublic: tpm2.New2B(template),
+		InSensitive: tpm2.TPM2BSensitiveCreate{
+			Sensitive: &tpm2.TPMSSensitiveCreate{
+				UserAuth: tpm2.TPM2BAuth{Buffer: auth},
+				Data:     tpm2.NewTPMUSensitiveCreate(&tpm2.TPM2BSensitiveData{Buffer: data}),
+			},
+		},
+	}.Execute(rwr)
+	if err != nil {
+		return nil, fmt.Errorf("duplicate: can't create key: %v", err)
+	}
+
+	oid := keyfile.OIDLoadableKey
+	if template.Type == tpm2.TPMAlgKeyedHash && !template.ObjectAttributes.SignEncrypt {
+		oid = keyfile.OIDSealedKey
+	}
+	return keyfile.NewTPMKey(oid, rsp.OutPublic, rsp.OutPrivate,
+		keyfile.WithParent(parent),
+		keyfile.WithUserAuth(auth),
+	), nil
+}
+
+// Export duplicates the key in k to newParent and returns it as an
+// importable keyfile naming handle as its parent.  The key's authPolicy has
+// to be one of Policy's for newParent.  With inner the TPM adds an inner
+// wrapper and its AES-128 key is returned too; keys with
+// encryptedDuplication need it.
+func Export(rwr transport.TPM, k *keyfile.TPMKey, newParent *tpm2.TPMTPublic, handle tpm2.TPMHandle, inner bool) (*keyfile.TPMKey, []byte, error) {
+	pub, err := k.Pubkey.Contents()
+	if err != nil {
+		return nil, nil, fmt.Errorf("duplicate: can't read key public: %v", err)
+	}
+	newParentName, err := tpm2.ObjectName(newParent)
+	if err != nil {
+		return nil, nil, fmt.Errorf("duplicate: %v", err)
+	}
+	mode, err := policyMode(pub, newParentName.Buffer)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	key, err := tpmkey.Load(rwr, k, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer key.Close()
+
+	ext, err := tpm2.LoadExternal{
+		InPublic:  tpm2.New2B(*newParent),
+		Hierarchy: tpm2.TPMRHOwner,
+	}.Execute(rwr)
+	if err != nil {
+		return nil, nil, fmt.Errorf("duplicate: can't load new parent: %v", err)
+	}
+	defer tpm2.FlushContext{FlushHandle: ext.ObjectHandle}.Execute(rwr)
+
+	sess := tpm2.Policy(pub.NameAlg, 16, func(rwr transport.TPM, handle tpm2.TPMISHPolicy, _ tpm2.TPM2BNonce) error {
+		if mode == Select {
+			_, err := tpm2.PolicyDuplicationSelect{
+				PolicySession: handle,
+				ObjectName:    key.Name,
+				NewParentName: *newParentName,
+			}.Execute(rwr)
+			return err
+		}
+		if _, err := (tpm2.PolicyCommandCode{
+			PolicySession: handle,
+			Code:          tpm2.TPMCCDuplicate,
+		}).Execute(rwr); err != nil {
+			return err
+		}
+		branches, err := orBranches(pub.NameAlg)
+		if err != nil {
+			return err
+		}
+		_, err = tpm2.PolicyOr{
+			PolicySession: handle,
+			PHashList:     tpm2.TPMLDigest{Digests: branches},
+		}.Execute(rwr)
+		return err
+	})
+
+	cmd := tpm2.Duplicate{
+		ObjectHandle: tpm2.AuthHandle{
+			Handle: key.Handle,
+			Name:   key.Name,
+			Auth:   sess,
+		},
+		NewParentHandle: tpm2.NamedHandle{
+			Handle: ext.ObjectHandle,
+			Name:   ext.Name,
+		},
+		Symmetric: tpm2.TPMTSymDef{Algorithm: tpm2.TPMAlgNull},
+	}
+	if inner {
+		cmd.Symmetric = tpm2.TPMTSymDef{
+			Algorithm: tpm2.TPMAlgAES,
+			KeyBits:   tpm2.NewTPMUSymKeyBits(tpm2.TPMAlgAES, tpm2.TPMKeyBits(128)),
+			Mode:      tpm2.NewTPMUSymMode(tpm2.TPMAlgAES, tpm2.TPMAlgCFB),
+		}
+	}
+	rsp, err := cmd.Execute(rwr)
+	if err != nil {
+		return nil, nil, fmt.Errorf("duplicate: duplication failed: %v", err)
+	}
+
+	dup := *k
+	dup.Keytype = keyfile.OIDImportableKey
+	dup.Parent = handle
+	dup.Privkey = rsp.Duplicate
+	dup.Secret = rsp.OutSymSeed
+	var encryptionKey []byte
+	if inner {
+		encryptionKey = rsp.EncryptionKeyOut.Buffer
+	}
+	return &dup, encryptionKey, nil
+}
+
+// policyMode works out which of Policy's policies pub has for newParent.
+func policyMode(pub *tpm2.TPMTPublic, newParent []byte) (Mode, error) {
+	for _, mode := range []Mode{Select, CommandCode} {
+		digest, err := Policy(mode, pub.NameAlg, newParent)
+		if err != nil {
+			return 0, err
+		}
+		if bytes.Equal(digest, pub.AuthPolicy.Buffer) {
+			return mode, nil
+		}
+	}
+	return 0, fmt.Errorf("duplicate: the key's policy doesn't allow duplication to this parent")
+}
+
+// Import imports a duplicate made by Export, with its inner wrapper key if
+// it has one, and returns the loadable keyfile.  The imported key is loaded
+// once to check that the TPM computes the name the public area has.
+func Import(rwr transport.TPM, dup *keyfile.TPMKey, encryptionKey []byte) (*keyfile.TPMKey, error) {
+	k, err := tpmkey.Import(rwr, dup, encryptionKey)
+	if err != nil {
+		return nil, err
+	}
+	pub, err := k.Pubkey.Contents()
+	if err != nil {
+		return nil, fmt.Errorf("duplicate: can't read key public: %v", err)
+	}
+	name, err := tpm2.ObjectName(pub)
+	if err != nil {
+		return nil, fmt.Errorf("duplicate: %v", err)
+	}
+
+	key, err := tpmkey.Load(rwr, k, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer key.Close()
+	if !bytes.Equal(key.Name.Buffer, name.Buffer) {
+		return nil, fmt.Errorf("duplicate: loaded key name %x, want %x", key.Name.Buffer, name.Buffer)
+	}
+	return k, nil
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/hex"
+	"flag"
+	"io"
+	"log"
+	"net"
+	"os"
+	"slices"
+	"strconv"
+
+	keyfile "github.com/foxboron/go-tpm-keyfiles"
+	"github.com/google/go-tpm-tools/simulator"
+	"github.com/google/go-tpm/tpm2"
+	"github.com/google/go-tpm/tpm2/transport"
+	"github.com/google/go-tpm/tpmutil"
+	"github.com/ibiscum/tpm2/duplicate"
+	"github.com/ibiscum/tpm2/tpmwrap"
+)
+
+var (
+	tpmPath      = flag.String("tpm-path", "/dev/tpmrm0", "Path to the TPM device (character device or a Unix socket).")
+	key          = flag.String("key", "key.pem", "keyfile of the duplicable key")
+	to           = flag.String("to", "", "new parent: TPM2B_PUBLIC, EK certificate or public key with --template")
+	template     = flag.String("template", "", "template of a --to public key or EK certificate: h2, srk-rsa, srk-ecc, ek-rsa, ek-ecc or a high range EK, see tpmwrap.Templates")
+	parentHandle = flag.String("parent-handle", "", "new parent handle recorded in the keyfile (default: 0x40000001 for the H-2 SRK, 0x81010001/2 for an EK, 0x81000001 otherwise)")
+	inner        = flag.Bool("inner", false, "add an inner wrapper, whose key is written to --inner-key")
+	innerKey     = flag.String("inner-key", "dup.innerkey", "file to write the inner wrapper key to, to be sent apart from the duplicate")
+	out          = flag.String("out", "dup.pem", "importable TSS2 keyfile to write")
+	tools        = flag.String("tools", "", "also write PREFIX.pub, PREFIX.dpriv and PREFIX.seed for tpm2_import")
+)
+
+var TPMDEVICES = []string{"/dev/tpm0", "/dev/tpmrm0"}
+
+func OpenTPM(path string) (io.ReadWriteCloser, error) {
+	if slices.Contains(TPMDEVICES, path) {
+		return tpmutil.OpenTPM(path)
+	} else if path == "simulator" {
+		return simulator.GetWithFixedSeedInsecure(1073741825)
+	} else {
+		return net.Dial("tcp", path)
+	}
+}
+
+func main() {
+	flag.Parse()
+
+	if *to == "" {
+		log.Fatalf("--to is required")
+	}
+	b, err := os.ReadFile(*to)
+	if err != nil {
+		log.Fatalf("can't read new parent: %v", err)
+	}
+	newParent, err := tpmwrap.ParseParent(b, *template)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	newParentName, err := tpm2.ObjectName(newParent)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	handle := tpmwrap.DefaultHandle(newParent)
+	if *parentHandle != "" {
+		p, err := strconv.ParseUint(*parentHandle, 0, 32)
+		if err != nil {
+			log.Fatalf("bad parent handle %q: %v", *parentHandle, err)
+		}
+		handle = tpm2.TPMHandle(p)
+	}
+
+	b, err = os.ReadFile(*key)
+	if err != nil {
+		log.Fatalf("can't read keyfile: %v", err)
+	}
+	kf, err := keyfile.Decode(b)
+	if err != nil {
+		log.Fatalf("can't decode keyfile: %v", err)
+	}
+
+	rwc, err := OpenTPM(*tpmPath)
+	if err != nil {
+		log.Fatalf("can't open TPM %q: %v", *tpmPath, err)
+	}
+	defer func() {
+		rwc.Close()
+	}()
+
+	rwr := transport.FromReadWriter(rwc)
+
+	dup, encryptionKey, err := duplicate.Export(rwr, kf, newParent, handle, *inner)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	f, err := os.OpenFile(*out, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		log.Fatalf("can't create keyfile: %v", err)
+	}
+	defer f.Close()
+	if err := keyfile.Encode(f, dup); err != nil {
+		log.Fatalf("can't write keyfile: %v", err)
+	}
+	if *inner {
+		if err := os.WriteFile(*innerKey, encryptionKey, 0600); err != nil {
+			log.Fatalf("can't write inner key: %v", err)
+		}
+	}
+	if *tools != "" {
+		for ext, b := range map[string][]byte{
+			".pub":   tpm2.Marshal(dup.Pubkey),
+			".dpriv": tpm2.Marshal(dup.Privkey),
+			".seed":  tpm2.Marshal(dup.Secret),
+		} {
+			if err := os.WriteFile(*tools+ext, b, 0600); err != nil {
+				log.Fatalf("can't write %s: %v", *tools+ext, err)
+			}
+		}
+	}
+
+	pub, err := kf.Pubkey.Contents()
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	name, err := tpm2.ObjectName(pub)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	log.Printf("duplicated key %s to parent %s (handle 0x%x), wrote %s", hex.EncodeToString(name.Buffer), hex.EncodeToString(newParentName.Buffer), handle, *out)
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/hex"
+	"flag"
+	"io"
+	"log"
+	"net"
+	"os"
+	"slices"
+
+	keyfile "github.com/foxboron/go-tpm-keyfiles"
+	"github.com/google/go-tpm-tools/simulator"
+	"github.com/google/go-tpm/tpm2"
+	"github.com/google/go-tpm/tpm2/transport"
+	"github.com/google/go-tpm/tpmutil"
+	"github.com/ibiscum/tpm2/duplicate"
+)
+
+var (
+	tpmPath  = flag.String("tpm-path", "/dev/tpmrm0", "Path to the TPM device (character device or a Unix socket).")
+	in       = flag.String("in", "dup.pem", "importable TSS2 keyfile made by export")
+	innerKey = flag.String("inner-key", "", "inner wrapper key written by export --inner")
+	name     = flag.String("name", "", "expected key name (hex), as printed by export")
+	out      = flag.String("out", "key.pem", "loadable TSS2 keyfile to write")
+)
+
+var TPMDEVICES = []string{"/dev/tpm0", "/dev/tpmrm0"}
+
+func OpenTPM(path string) (io.ReadWriteCloser, error) {
+	if slices.Contains(TPMDEVICES, path) {
+		return tpmutil.OpenTPM(path)
+	} else if path == "simulator" {
+		return simulator.GetWithFixedSeedInsecure(1073741825)
+	} else {
+		return net.Dial("tcp", path)
+	}
+}
+
+func main() {
+	flag.Parse()
+
+	b, err := os.ReadFile(*in)
+	if err != nil {
+		log.Fatalf("can't read keyfile: %v", err)
+	}
+	dup, err := keyfile.Decode(b)
+	if err != nil {
+		log.Fatalf("can't decode keyfile: %v", err)
+	}
+	var encryptionKey []byte
+	if *innerKey != "" {
+		if encryptionKey, err = os.ReadFile(*innerKey); err != nil {
+			log.Fatalf("can't read inner key: %v", err)
+		}
+	}
+
+	pub, err := dup.Pubkey.Contents()
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	n, err := tpm2.ObjectName(pub)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	if *name != "" && *name != hex.EncodeToString(n.Buffer) {
+		log.Fatalf("key name is %x, not the expected %s", n.Buffer, *name)
+	}
+
+	rwc, err := OpenTPM(*tpmPath)
+	if err != nil {
+		log.Fatalf("can't open TPM %q: %v", *tpmPath, err)
+	}
+	defer func() {
+		rwc.Close()
+	}()
+
+	rwr := transport.FromReadWriter(rwc)
+
+	kf, err := duplicate.Import(rwr, dup, encryptionKey)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	f, err := os.OpenFile(*out, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		log.Fatalf("can't create keyfile: %v", err)
+	}
+	defer f.Close()
+	if err := keyfile.Encode(f, kf); err != nil {
+		log.Fatalf("can't write keyfile: %v", err)
+	}
+	log.Printf("imported key %x under parent 0x%x, wrote %s", n.Buffer, kf.Parent, *out)
+}
@@ -0,0 +1,346 @@
+// Package envelope encrypts files of any size with a random data encryption
+// key (DEK) that is sealed to the TPM's owner SRK, so only that TPM, and
+// optionally only with the right PCR values and/or password, can decrypt.
+//
+// The container is self-describing:
+//
+//	"TPM2ENV1" || uint32 header length || JSON header || chunks
+//
+// The JSON header carries the version, cipher, chunk size, nonce prefix,
+// the policy in readable form and the sealed DEK as a DER TSS2 sealed data
+// keyfile (which records the actual policy).  The data is cut into chunks
+// of chunk_size bytes, each sealed with AES-256-GCM under the nonce
+//
+//	nonce_prefix (7 bytes) || uint32 chunk counter || last chunk flag
+//
+// (the STREAM construction), so chunks can't be reordered, dropped or the
+// file truncated without decryption failing.  The AAD of every chunk covers
+// the magic, chunk size and nonce prefix but not the sealed key, so Rewrap
+// can seal the DEK under a new policy without touching the data.
+package envelope
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	keyfile "github.com/foxboron/go-tpm-keyfiles"
+	"github.com/google/go-tpm/tpm2/transport"
+	"github.com/ibiscum/tpm2/tpmkey"
+)
+
+const (
+	magic   = "TPM2ENV1"
+	version = 1
+
+	cipherName    = "AES-256-GCM"
+	nonceSchedule = "prefix7||uint32be(counter)||last"
+
+	// DefaultChunkSize is the plaintext size of every chunk but the last.
+	DefaultChunkSize = 64 << 10
+	maxChunkSize     = 16 << 20
+	maxHeaderSize    = 1 << 20
+
+	prefixSize = 7
+)
+
+// Header is the JSON header of a container.
+type Header struct {
+	Version       int    `json:"version"`
+	Cipher        string `json:"cipher"`
+	ChunkSize     int    `json:"chunk_size"`
+	NoncePrefix   []byte `json:"nonce_prefix"`
+	NonceSchedule string `json:"nonce_schedule"`
+	Policy        Policy `json:"policy"`
+	// SealedKey is the DEK as a DER TSS2 sealed data keyfile.
+	SealedKey []byte `json:"sealed_key,omitempty"`
+}
+
+// Policy describes what unsealing the DEK takes.  It is informational, the
+// authoritative policy is the one in the sealed keyfile.
+type Policy struct {
+	PCRs     []uint `json:"pcrs,omitempty"`
+	PCRBank  string `json:"pcr_bank,omitempty"`
+	Password bool   `json:"password"`
+}
+
+// Options control how the DEK is sealed.
+type Options struct {
+	// PCRs binds the DEK to the current values of these PCRs (SHA-256 bank).
+	PCRs []uint
+	// Password is the auth value of the sealed DEK, none if empty.
+	Password []byte
+	// ChunkSize defaults to DefaultChunkSize.
+	ChunkSize int
+}
+
+func (o *Options) policy() (*tpmkey.Policy, Policy) {
+	info := Policy{
+		Password: len(o.Password) > 0,
+	}
+	if len(o.PCRs) == 0 {
+		return nil, info
+	}
+	info.PCRs = o.PCRs
+	info.PCRBank = "sha256"
+	return &tpmkey.Policy{PCRs: o.PCRs}, info
+}
+
+// sealKey seals dek according to opts.
+func sealKey(rwr transport.TPM, dek []byte, opts *Options) ([]byte, Policy, error) {
+	pol, info := opts.policy()
+	kf, err := tpmkey.Seal(rwr, dek, opts.Password, pol)
+	if err != nil {
+		return nil, info, err
+	}
+	kf.AddOptions(keyfile.WithDescription("envelope DEK"))
+	return keyfile.Marshal(kf), info, nil
+}
+
+// UnsealKey returns the DEK of a header.
+func (h *Header) UnsealKey(rwr transport.TPM, password []byte) ([]byte, error) {
+	kf, err := keyfile.Parse(h.SealedKey)
+	if err != nil {
+		return nil, fmt.Errorf("envelope: bad sealed key: %v", err)
+	}
+	if !kf.EmptyAuth && len(password) == 0 {
+		return nil, fmt.Errorf("envelope: the data key needs a password")
+	}
+	k, err := tpmkey.Load(rwr, kf, password)
+	if err != nil {
+		return nil, err
+	}
+	defer k.Close()
+	dek, err := k.Unseal()
+	if err != nil {
+		return nil, err
+	}
+	if len(dek) != 32 {
+		return nil, fmt.Errorf("envelope: unsealed key has the wrong size")
+	}
+	return dek, nil
+}
+
+// aad binds the chunks to the immutable part of the header.
+func (h *Header) aad() []byte {
+	b := []byte(magic)
+	b = binary.BigEndian.AppendUint32(b, uint32(h.ChunkSize))
+	return append(b, h.NoncePrefix...)
+}
+
+func (h *Header) nonce(counter uint32, last bool) []byte {
+	n := make([]byte, 0, 12)
+	n = append(n, h.NoncePrefix...)
+	n = binary.BigEndian.AppendUint32(n, counter)
+	if last {
+		return append(n, 1)
+	}
+	return append(n, 0)
+}
+
+func writeHeader(w io.Writer, h *Header) error {
+	js, err := json.Marshal(h)
+	if err != nil {
+		return err
+	}
+	b := []byte(magic)
+	b = binary.BigEndian.AppendUint32(b, uint32(len(js)))
+	if _, err := w.Write(append(b, js...)); err != nil {
+		return err
+	}
+	return nil
+}
+
+// ReadHeader reads and checks the header of a container, leaving r at the
+// first chunk.
+func ReadHeader(r io.Reader) (*Header, error) {
+	pre := make([]byte, len(magic)+4)
+	if _, err := io.ReadFull(r, pre); err != nil {
+		return nil, fmt.Errorf("envelope: can't read header: %v", err)
+	}
+	if string(pre[:len(magic)]) != magic {
+		return nil, fmt.Errorf("envelope: not an envelope file")
+	}
+	n := binary.BigEndian.Uint32(pre[len(magic):])
+	if n > maxHeaderSize {
+		return nil, fmt.Errorf("envelope: header too large")
+	}
+	js := make([]byte, n)
+	if _, err := io.ReadFull(r, js); err != nil {
+		return nil, fmt.Errorf("envelope: can't read header: %v", err)
+	}
+	var h Header
+	if err := json.Unmarshal(js, &h); err != nil {
+		return nil, fmt.Errorf("envelope: bad header: %v", err)
+	}
+	switch {
+	case h.Version != version:
+		return nil, fmt.Errorf("envelope: unsupported version %d", h.Version)
+	case h.Cipher != cipherName:
+		return nil, fmt.Errorf("envelope: unsupported cipher %q", h.Cipher)
+	case h.NonceSchedule != nonceSchedule || len(h.NoncePrefix) != prefixSize:
+		return nil, fmt.Errorf("envelope: unsupported nonce schedule")
+	case h.ChunkSize <= 0 || h.ChunkSize > maxChunkSize:
+		return nil, fmt.Errorf("envelope: bad chunk size %d", h.ChunkSize)
+	}
+	return &h, nil
+}
+
+// Seal encrypts src to dst under a new DEK sealed according to opts.
+func Seal(rwr transport.TPM, dst io.Writer, src io.Reader, opts *Options) error {
+	if opts == nil {
+		opts = &Options{}
+	}
+	chunkSize := opts.ChunkSize
+	if chunkSize == 0 {
+		chunkSize = DefaultChunkSize
+	}
+	if chunkSize < 0 || chunkSize > maxChunkSize {
+		return fmt.Errorf("envelope: bad chunk size %d", chunkSize)
+	}
+
+	dek := make([]byte, 32)
+	if _, err := rand.Read(dek); err != nil {
+		return err
+	}
+	sealed, info, err := sealKey(rwr, dek, opts)
+	if err != nil {
+		return err
+	}
+	h := &Header{
+		Version:       version,
+		Cipher:        cipherName,
+		ChunkSize:     chunkSize,
+		NoncePrefix:   make([]byte, prefixSize),
+		NonceSchedule: nonceSchedule,
+		Policy:        info,
+		SealedKey:     sealed,
+	}
+	if _, err := rand.Read(h.NoncePrefix); err != nil {
+		return err
+	}
+	if err := writeHeader(dst, h); err != nil {
+		return err
+	}
+
+	aead, err := newGCM(dek)
+	if err != nil {
+		return err
+	}
+	aad := h.aad()
+
+	// read one byte ahead so the last chunk is known when it is sealed
+	br := bufio.NewReaderSize(src, chunkSize+1)
+	buf := make([]byte, chunkSize)
+	out := make([]byte, 0, chunkSize+aead.Overhead())
+	for counter := uint32(0); ; counter++ {
+		n, err := io.ReadFull(br, buf)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return err
+		}
+		last := n < chunkSize
+		if !last {
+			if _, err := br.Peek(1); err == io.EOF {
+				last = true
+			}
+		}
+		out = aead.Seal(out[:0], h.nonce(counter, last), buf[:n], aad)
+		if _, err := dst.Write(out); err != nil {
+			return err
+		}
+		if last {
+			return nil
+		}
+		if counter == ^uint32(0) {
+			return fmt.Errorf("envelope: file too large for the chunk size")
+		}
+	}
+}
+
+// Open decrypts a container from src to dst.  Plaintext is written chunk
+// by chunk as it is authenticated, so on error dst may hold a prefix of
+// the data, which must be discarded.
+func Open(rwr transport.TPM, dst io.Writer, src io.Reader, password []byte) error {
+	h, err := ReadHeader(src)
+	if err != nil {
+		return err
+	}
+	dek, err := h.UnsealKey(rwr, password)
+	if err != nil {
+		return err
+	}
+	aead, err := newGCM(dek)
+	if err != nil {
+		return err
+	}
+	aad := h.aad()
+
+	size := h.ChunkSize + aead.Overhead()
+	br := bufio.NewReaderSize(src, size+1)
+	buf := make([]byte, size)
+	out := make([]byte, 0, h.ChunkSize)
+	for counter := uint32(0); ; counter++ {
+		n, err := io.ReadFull(br, buf)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return err
+		}
+		last := n < size
+		if !last {
+			if _, err := br.Peek(1); err == io.EOF {
+				last = true
+			}
+		}
+		out, err = aead.Open(out[:0], h.nonce(counter, last), buf[:n], aad)
+		if err != nil {
+			if last {
+				return errors.New("envelope: last chunk doesn't authenticate, the file is truncated or corrupt")
+			}
+			return fmt.Errorf("envelope: chunk %d doesn't authenticate", counter)
+		}
+		if _, err := dst.Write(out); err != nil {
+			return err
+		}
+		if last {
+			return nil
+		}
+	}
+}
+
+// Rewrap copies a container from src to dst with the DEK sealed under opts
+// instead.  The chunks are copied as they are; opts.ChunkSize is ignored.
+func Rewrap(rwr transport.TPM, dst io.Writer, src io.Reader, password []byte, opts *Options) error {
+	if opts == nil {
+		opts = &Options{}
+	}
+	h, err := ReadHeader(src)
+	if err != nil {
+		return err
+	}
+	dek, err := h.UnsealKey(rwr, password)
+	if err != nil {
+		return err
+	}
+	h.SealedKey, h.Policy, err = sealKey(rwr, dek, opts)
+	if err != nil {
+		return err
+	}
+	if err := writeHeader(dst, h); err != nil {
+		return err
+	}
+	_, err = io.Copy(dst, src)
+	return err
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/google/go-tpm-tools/simulator"
+	"github.com/google/go-tpm/tpm2/transport"
+	"github.com/google/go-tpm/tpmutil"
+	"github.com/ibiscum/tpm2/envelope"
+)
+
+var (
+	tpmPath     = flag.String("tpm-path", "/dev/tpmrm0", "Path to the TPM device (character device or a Unix socket).")
+	in          = flag.String("in", "", "encrypted file to rewrap in place")
+	password    = flag.String("password", "", "current password of the data key, if it has one")
+	pcrs        = flag.String("pcrs", "", "comma separated PCRs to bind the data key to from now on")
+	newPassword = flag.String("new-password", "", "password for the data key from now on")
+)
+
+var TPMDEVICES = []string{"/dev/tpm0", "/dev/tpmrm0"}
+
+func OpenTPM(path string) (io.ReadWriteCloser, error) {
+	if slices.Contains(TPMDEVICES, path) {
+		return tpmutil.OpenTPM(path)
+	} else if path == "simulator" {
+		return simulator.GetWithFixedSeedInsecure(1073741825)
+	} else {
+		return net.Dial("tcp", path)
+	}
+}
+
+func parsePCRs(s string) []uint {
+	var pcrs []uint
+	if s == "" {
+		return nil
+	}
+	for _, f := range strings.Split(s, ",") {
+		i, err := strconv.ParseUint(f, 10, 32)
+		if err != nil {
+			log.Fatalf("bad pcr %q: %v", f, err)
+		}
+		pcrs = append(pcrs, uint(i))
+	}
+	return pcrs
+}
+
+func main() {
+	flag.Parse()
+
+	if *in == "" {
+		log.Fatalf("--in is required")
+	}
+	src, err := os.Open(*in)
+	if err != nil {
+		log.Fatalf("can't open input: %v", err)
+	}
+	defer src.Close()
+
+	rwc, err := OpenTPM(*tpmPath)
+	if err != nil {
+		log.Fatalf("can't open TPM %q: %v", *tpmPath, err)
+	}
+	defer func() {
+		rwc.Close()
+	}()
+
+	rwr := transport.FromReadWriter(rwc)
+
+	// the new container replaces the old one atomically
+	tmp, err := os.CreateTemp(filepath.Dir(*in), ".rewrap-*")
+	if err != nil {
+		log.Fatalf("can't create output: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	err = envelope.Rewrap(rwr, tmp, src, []byte(*password), &envelope.Options{
+		PCRs:     parsePCRs(*pcrs),
+		Password: []byte(*newPassword),
+	})
+	if err != nil {
+		tmp.Close()
+		log.Fatalf("can't rewrap: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		log.Fatalf("can't write output: %v", err)
+	}
+	if err := os.Rename(tmp.Name(), *in); err != nil {
+		log.Fatalf("can't replace %s: %v", *in, err)
+	}
+	log.Printf("rewrapped %s", *in)
+}
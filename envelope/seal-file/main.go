@@ -0,0 +1,97 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"log"
+	"net"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/google/go-tpm-tools/simulator"
+	"github.com/google/go-tpm/tpm2/transport"
+	"github.com/google/go-tpm/tpmutil"
+	"github.com/ibiscum/tpm2/envelope"
+)
+
+var (
+	tpmPath   = flag.String("tpm-path", "/dev/tpmrm0", "Path to the TPM device (character device or a Unix socket).")
+	in        = flag.String("in", "", "file to encrypt")
+	out       = flag.String("out", "", "encrypted file to create (default: <in>.tpmenv)")
+	pcrs      = flag.String("pcrs", "", "comma separated PCRs to bind the data key to (eg 7,23)")
+	password  = flag.String("password", "", "optional password for the data key")
+	chunkSize = flag.Int("chunk-size", envelope.DefaultChunkSize, "plaintext bytes per authenticated chunk")
+)
+
+var TPMDEVICES = []string{"/dev/tpm0", "/dev/tpmrm0"}
+
+func OpenTPM(path string) (io.ReadWriteCloser, error) {
+	if slices.Contains(TPMDEVICES, path) {
+		return tpmutil.OpenTPM(path)
+	} else if path == "simulator" {
+		return simulator.GetWithFixedSeedInsecure(1073741825)
+	} else {
+		return net.Dial("tcp", path)
+	}
+}
+
+func parsePCRs(s string) []uint {
+	var pcrs []uint
+	if s == "" {
+		return nil
+	}
+	for _, f := range strings.Split(s, ",") {
+		i, err := strconv.ParseUint(f, 10, 32)
+		if err != nil {
+			log.Fatalf("bad pcr %q: %v", f, err)
+		}
+		pcrs = append(pcrs, uint(i))
+	}
+	return pcrs
+}
+
+func main() {
+	flag.Parse()
+
+	if *in == "" {
+		log.Fatalf("--in is required")
+	}
+	if *out == "" {
+		*out = *in + ".tpmenv"
+	}
+
+	src, err := os.Open(*in)
+	if err != nil {
+		log.Fatalf("can't open input: %v", err)
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(*out, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		log.Fatalf("can't create output: %v", err)
+	}
+	defer dst.Close()
+
+	rwc, err := OpenTPM(*tpmPath)
+	if err != nil {
+		log.Fatalf("can't open TPM %q: %v", *tpmPath, err)
+	}
+	defer func() {
+		rwc.Close()
+	}()
+
+	rwr := transport.FromReadWriter(rwc)
+
+	err = envelope.Seal(rwr, dst, src, &envelope.Options{
+		PCRs:      parsePCRs(*pcrs),
+		Password:  []byte(*password),
+		ChunkSize: *chunkSize,
+	})
+	if err != nil {
+		os.Remove(*out)
+		log.Fatalf("can't seal: %v", err)
+	}
+	log.Printf("sealed %s to %s", *in, *out)
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/google/go-tpm-tools/simulator"
+	"github.com/google/go-tpm/tpm2/transport"
+	"github.com/google/go-tpm/tpmutil"
+	"github.com/ibiscum/tpm2/envelope"
+)
+
+var (
+	tpmPath  = flag.String("tpm-path", "/dev/tpmrm0", "Path to the TPM device (character device or a Unix socket).")
+	in       = flag.String("in", "", "encrypted file")
+	out      = flag.String("out", "", "decrypted file to create, - for stdout")
+	password = flag.String("password", "", "password of the data key, if it has one")
+	info     = flag.Bool("info", false, "only print the container header")
+)
+
+var TPMDEVICES = []string{"/dev/tpm0", "/dev/tpmrm0"}
+
+func OpenTPM(path string) (io.ReadWriteCloser, error) {
+	if slices.Contains(TPMDEVICES, path) {
+		return tpmutil.OpenTPM(path)
+	} else if path == "simulator" {
+		return simulator.GetWithFixedSeedInsecure(1073741825)
+	} else {
+		return net.Dial("tcp", path)
+	}
+}
+
+func main() {
+	flag.Parse()
+
+	if *in == "" {
+		log.Fatalf("--in is required")
+	}
+	src, err := os.Open(*in)
+	if err != nil {
+		log.Fatalf("can't open input: %v", err)
+	}
+	defer src.Close()
+
+	if *info {
+		h, err := envelope.ReadHeader(src)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		h.SealedKey = nil
+		b, err := json.MarshalIndent(h, "", "  ")
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		fmt.Println(string(b))
+		return
+	}
+	if *out == "" {
+		log.Fatalf("--out is required")
+	}
+
+	rwc, err := OpenTPM(*tpmPath)
+	if err != nil {
+		log.Fatalf("can't open TPM %q: %v", *tpmPath, err)
+	}
+	defer func() {
+		rwc.Close()
+	}()
+
+	rwr := transport.FromReadWriter(rwc)
+
+	if *out == "-" {
+		if err := envelope.Open(rwr, os.Stdout, src, []byte(*password)); err != nil {
+			log.Fatalf("can't unseal: %v", err)
+		}
+		return
+	}
+
+	// decrypt next to the destination and only move it in place once every
+	// chunk authenticated
+	tmp, err := os.CreateTemp(filepath.Dir(*out), ".unseal-*")
+	if err != nil {
+		log.Fatalf("can't create output: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+	if err := envelope.Open(rwr, tmp, src, []byte(*password)); err != nil {
+		tmp.Close()
+		log.Fatalf("can't unseal: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		log.Fatalf("can't write output: %v", err)
+	}
+	if _, err := os.Stat(*out); err == nil {
+		log.Fatalf("%s exists", *out)
+	}
+	if err := os.Rename(tmp.Name(), *out); err != nil {
+		log.Fatalf("can't write output: %v", err)
+	}
+	log.Printf("unsealed %s to %s", *in, *out)
+}
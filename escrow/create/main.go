@@ -0,0 +1,121 @@
+package main
+
+import (
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"flag"
+	"io"
+	"log"
+	"net"
+	"os"
+	"slices"
+	"strconv"
+
+	keyfile "github.com/foxboron/go-tpm-keyfiles"
+	"github.com/google/go-tpm-tools/simulator"
+	"github.com/google/go-tpm/tpm2"
+	"github.com/google/go-tpm/tpm2/transport"
+	"github.com/google/go-tpm/tpmutil"
+	"github.com/ibiscum/tpm2/duplicate"
+	"github.com/ibiscum/tpm2/escrow"
+)
+
+var (
+	tpmPath   = flag.String("tpm-path", "/dev/tpmrm0", "Path to the TPM device (character device or a Unix socket).")
+	keyType   = flag.String("type", "ecc", "key type: rsa, ecc, hmac, aes or sealed")
+	in        = flag.String("in", "", "HMAC secret or data to seal (default: the TPM generates the key)")
+	password  = flag.String("password", "", "optional key password")
+	parent    = flag.String("parent", "0x40000001", "parent: a hierarchy (its H-2 SRK) or a persistent storage key")
+	escrowPub = flag.String("escrow", "escrow.pub.pem", "escrow public key, RSA or EC, PEM or DER")
+	out       = flag.String("out", "key.pem", "keyfile to write")
+	escrowOut = flag.String("escrow-out", "key.escrow.pem", "escrow blob to write")
+)
+
+var TPMDEVICES = []string{"/dev/tpm0", "/dev/tpmrm0"}
+
+func OpenTPM(path string) (io.ReadWriteCloser, error) {
+	if slices.Contains(TPMDEVICES, path) {
+		return tpmutil.OpenTPM(path)
+	} else if path == "simulator" {
+		return simulator.GetWithFixedSeedInsecure(1073741825)
+	} else {
+		return net.Dial("tcp", path)
+	}
+}
+
+func main() {
+	flag.Parse()
+
+	t, err := duplicate.Template(*keyType)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	var data []byte
+	if *in != "" {
+		if data, err = os.ReadFile(*in); err != nil {
+			log.Fatalf("can't read %s: %v", *in, err)
+		}
+	} else if *keyType == "sealed" {
+		log.Fatalf("--in is required for sealed data")
+	}
+	p, err := strconv.ParseUint(*parent, 0, 32)
+	if err != nil {
+		log.Fatalf("bad parent %q: %v", *parent, err)
+	}
+
+	b, err := os.ReadFile(*escrowPub)
+	if err != nil {
+		log.Fatalf("can't read escrow key: %v", err)
+	}
+	if block, _ := pem.Decode(b); block != nil {
+		b = block.Bytes
+	}
+	pub, err := x509.ParsePKIXPublicKey(b)
+	if err != nil {
+		log.Fatalf("can't parse escrow key: %v", err)
+	}
+	escrowPublic, err := escrow.Public(pub)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	escrowName, err := tpm2.ObjectName(escrowPublic)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	rwc, err := OpenTPM(*tpmPath)
+	if err != nil {
+		log.Fatalf("can't open TPM %q: %v", *tpmPath, err)
+	}
+	defer func() {
+		rwc.Close()
+	}()
+
+	rwr := transport.FromReadWriter(rwc)
+
+	kf, blob, err := escrow.Create(rwr, tpm2.TPMHandle(p), t, data, []byte(*password), escrowPublic)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	keyPub, err := kf.Pubkey.Contents()
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	name, err := tpm2.ObjectName(keyPub)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	for file, k := range map[string]*keyfile.TPMKey{*out: kf, *escrowOut: blob} {
+		f, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if err != nil {
+			log.Fatalf("can't create keyfile: %v", err)
+		}
+		if err := keyfile.Encode(f, k); err != nil {
+			log.Fatalf("can't write keyfile: %v", err)
+		}
+		f.Close()
+	}
+	log.Printf("created %s key %s, escrowed to %s, wrote %s and %s", *keyType, hex.EncodeToString(name.Buffer), hex.EncodeToString(escrowName.Buffer), *out, *escrowOut)
+}
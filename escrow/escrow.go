@@ -0,0 +1,136 @@
+// Package escrow backs TPM keys up to an offline escrow key.
+//
+// Keys are created duplicable, with an authPolicy that only allows
+// duplication to the escrow key (duplicate.Select), and duplicated to it
+// right away.  The escrow blob is useless without the escrow private key,
+// which stays offline.  To restore, the blob is unwrapped in software with
+// that key and wrapped again to the storage parent of a replacement TPM.
+// The restored key has the same public area and name, so it can only be
+// duplicated to the escrow key too, and the old escrow blob stays valid.
+package escrow
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"fmt"
+
+	keyfile "github.com/foxboron/go-tpm-keyfiles"
+	"github.com/google/go-tpm/tpm2"
+	"github.com/google/go-tpm/tpm2/transport"
+	"github.com/ibiscum/tpm2/duplicate"
+	"github.com/ibiscum/tpm2/keyimport"
+	"github.com/ibiscum/tpm2/tpmwrap"
+)
+
+// Public returns the escrow key pub, an *rsa.PublicKey or *ecdsa.PublicKey,
+// as a TPM storage key public area, the SRK template of its type, size or
+// curve.  Its name is what the escrow policy is bound to.
+func Public(pub crypto.PublicKey) (*tpm2.TPMTPublic, error) {
+	switch p := pub.(type) {
+	case *rsa.PublicKey:
+		t := tpm2.RSASRKTemplate
+		params, _ := t.Parameters.RSADetail()
+		rsaParams := *params
+		rsaParams.KeyBits = tpm2.TPMKeyBits(p.N.BitLen())
+		t.Parameters = tpm2.NewTPMUPublicParms(tpm2.TPMAlgRSA, &rsaParams)
+		return tpmwrap.FromPublicKey(p, t)
+	case *ecdsa.PublicKey:
+		var curve tpm2.TPMECCCurve
+		switch p.Curve {
+		case elliptic.P256():
+			curve = tpm2.TPMECCNistP256
+		case elliptic.P384():
+			curve = tpm2.TPMECCNistP384
+		case elliptic.P521():
+			curve = tpm2.TPMECCNistP521
+		default:
+			return nil, fmt.Errorf("escrow: unsupported curve %s", p.Curve.Params().Name)
+		}
+		t := tpm2.ECCSRKTemplate
+		params, _ := t.Parameters.ECCDetail()
+		eccParams := *params
+		eccParams.CurveID = curve
+		t.Parameters = tpm2.NewTPMUPublicParms(tpm2.TPMAlgECC, &eccParams)
+		return tpmwrap.FromPublicKey(p, t)
+	}
+	return nil, fmt.Errorf("escrow: unsupported escrow key %T", pub)
+}
+
+// ParsePrivateKey reads a PEM or DER escrow private key, as
+// keyimport.ParsePrivateKey does: PKCS#8, PKCS#1 or SEC 1.
+func ParsePrivateKey(data []byte) (crypto.PrivateKey, error) {
+	return keyimport.ParsePrivateKey(data)
+}
+
+// Create creates a key from template under parent, duplicable to escrow
+// only, and returns its keyfile and the escrow blob: the key duplicated to
+// escrow, as an importable keyfile that still names parent.  data, auth are
+// as for duplicate.Create.
+func Create(rwr transport.TPM, parent tpm2.TPMHandle, template tpm2.TPMTPublic, data, auth []byte, escrow *tpm2.TPMTPublic) (*keyfile.TPMKey, *keyfile.TPMKey, error) {
+	if template.ObjectAttributes.EncryptedDuplication {
+		return nil, nil, fmt.Errorf("escrow: escrow blobs have no inner wrapper, clear encryptedDuplication")
+	}
+	name, err := tpm2.ObjectName(escrow)
+	if err != nil {
+		return nil, nil, fmt.Errorf("escrow: %v", err)
+	}
+	policy, err := duplicate.Policy(duplicate.Select, template.NameAlg, name.Buffer)
+	if err != nil {
+		return nil, nil, err
+	}
+	key, err := duplicate.Create(rwr, parent, template, data, auth, policy)
+	if err != nil {
+		return nil, nil, err
+	}
+	blob, _, err := duplicate.Export(rwr, key, escrow, key.Parent, false)
+	if err != nil {
+		return nil, nil, err
+	}
+	return key, blob, nil
+}
+
+// Restore unwraps the escrow blob with the escrow private key priv and
+// wraps the key again for newParent, as an importable keyfile naming handle
+// as its parent.  The key keeps its password.
+func Restore(priv crypto.PrivateKey, blob *keyfile.TPMKey, newParent *tpm2.TPMTPublic, handle tpm2.TPMHandle) (*keyfile.TPMKey, error) {
+	if !blob.Keytype.Equal(keyfile.OIDImportableKey) {
+		return nil, fmt.Errorf("escrow: keyfile type %v isn't an escrow blob", blob.Keytype)
+	}
+	signer, ok := priv.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("escrow: unsupported escrow key %T", priv)
+	}
+	escrow, err := Public(signer.Public())
+	if err != nil {
+		return nil, err
+	}
+	name, err := tpm2.ObjectName(escrow)
+	if err != nil {
+		return nil, fmt.Errorf("escrow: %v", err)
+	}
+	pub, err := blob.Pubkey.Contents()
+	if err != nil {
+		return nil, fmt.Errorf("escrow: can't read key public: %v", err)
+	}
+	policy, err := duplicate.Policy(duplicate.Select, pub.NameAlg, name.Buffer)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(policy, pub.AuthPolicy.Buffer) {
+		return nil, fmt.Errorf("escrow: the key isn't escrowed to this escrow key")
+	}
+
+	sens, err := tpmwrap.Unwrap(priv, escrow, pub, blob.Privkey.Buffer, blob.Secret.Buffer, nil)
+	if err != nil {
+		return nil, err
+	}
+	k, err := tpmwrap.Keyfile(newParent, handle, pub, sens, nil)
+	if err != nil {
+		return nil, err
+	}
+	k.Description = blob.Description
+	return k, nil
+}
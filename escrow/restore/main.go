@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/hex"
+	"flag"
+	"log"
+	"os"
+	"strconv"
+
+	keyfile "github.com/foxboron/go-tpm-keyfiles"
+	"github.com/google/go-tpm/tpm2"
+	"github.com/ibiscum/tpm2/escrow"
+	"github.com/ibiscum/tpm2/tpmwrap"
+)
+
+var (
+	escrowKey    = flag.String("escrow-key", "escrow.pem", "escrow private key, PEM or DER")
+	in           = flag.String("in", "key.escrow.pem", "escrow blob written by create")
+	to           = flag.String("to", "", "replacement parent: TPM2B_PUBLIC, EK certificate or public key with --template")
+	template     = flag.String("template", "", "template of a --to public key or EK certificate: h2, srk-rsa, srk-ecc, ek-rsa, ek-ecc or a high range EK, see tpmwrap.Templates")
+	parentHandle = flag.String("parent-handle", "", "parent handle recorded in the keyfile (default: 0x40000001 for the H-2 SRK, 0x81010001/2 for an EK, 0x81000001 otherwise)")
+	out          = flag.String("out", "restored.pem", "importable TSS2 keyfile to write")
+)
+
+func main() {
+	flag.Parse()
+
+	if *to == "" {
+		log.Fatalf("--to is required")
+	}
+	b, err := os.ReadFile(*to)
+	if err != nil {
+		log.Fatalf("can't read replacement parent: %v", err)
+	}
+	newParent, err := tpmwrap.ParseParent(b, *template)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	newParentName, err := tpm2.ObjectName(newParent)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	handle := tpmwrap.DefaultHandle(newParent)
+	if *parentHandle != "" {
+		p, err := strconv.ParseUint(*parentHandle, 0, 32)
+		if err != nil {
+			log.Fatalf("bad parent handle %q: %v", *parentHandle, err)
+		}
+		handle = tpm2.TPMHandle(p)
+	}
+
+	b, err = os.ReadFile(*escrowKey)
+	if err != nil {
+		log.Fatalf("can't read escrow key: %v", err)
+	}
+	priv, err := escrow.ParsePrivateKey(b)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	b, err = os.ReadFile(*in)
+	if err != nil {
+		log.Fatalf("can't read escrow blob: %v", err)
+	}
+	blob, err := keyfile.Decode(b)
+	if err != nil {
+		log.Fatalf("can't decode escrow blob: %v", err)
+	}
+
+	kf, err := escrow.Restore(priv, blob, newParent, handle)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	pub, err := kf.Pubkey.Contents()
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	name, err := tpm2.ObjectName(pub)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	f, err := os.OpenFile(*out, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		log.Fatalf("can't create keyfile: %v", err)
+	}
+	defer f.Close()
+	if err := keyfile.Encode(f, kf); err != nil {
+		log.Fatalf("can't write keyfile: %v", err)
+	}
+	log.Printf("restored key %s for parent %s (handle 0x%x), wrote %s", hex.EncodeToString(name.Buffer), hex.EncodeToString(newParentName.Buffer), handle, *out)
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/pem"
+	"flag"
+	"log"
+	"os"
+
+	"github.com/ibiscum/tpm2/est"
+	"github.com/ibiscum/tpm2/tls/tpmtls"
+)
+
+var (
+	url      = flag.String("url", "https://127.0.0.1:8443/.well-known/est", "EST base URL")
+	ca       = flag.String("ca", "", "PEM CA certificates to verify the server with (default: system roots)")
+	insecure = flag.Bool("insecure", false, "bootstrap: don't verify the server, check the printed fingerprints out of band instead")
+	out      = flag.String("out", "", "PEM file to write the CA certificates to (default: stdout)")
+)
+
+func main() {
+	flag.Parse()
+
+	cfg := &tls.Config{
+		// RFC 7030 section 4.1.1 bootstrap distribution
+		InsecureSkipVerify: *insecure,
+	}
+	if *ca != "" {
+		var err error
+		if cfg.RootCAs, err = tpmtls.CertPool(*ca); err != nil {
+			log.Fatalf("%v", err)
+		}
+	}
+	certs, err := est.NewClient(*url, cfg).CACerts()
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	var pemOut []byte
+	for _, cert := range certs {
+		fp := sha256.Sum256(cert.Raw)
+		log.Printf("%s SHA256:%s", cert.Subject, hex.EncodeToString(fp[:]))
+		pemOut = append(pemOut, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})...)
+	}
+	if *out == "" {
+		os.Stdout.Write(pemOut)
+		return
+	}
+	if err := os.WriteFile(*out, pemOut, 0644); err != nil {
+		log.Fatalf("can't write output: %v", err)
+	}
+	log.Printf("wrote %s", *out)
+}
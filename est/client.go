@@ -0,0 +1,219 @@
+// Package est is an RFC 7030 Enrollment over Secure Transport client for
+// keys that live in the TPM, and a minimal EST server to test it against.
+//
+// The client covers the three operations a device needs to manage its
+// certificate: /cacerts to bootstrap the trust anchor, /simpleenroll for the
+// first certificate, authenticated with HTTP basic auth, and
+// /simplereenroll to renew, authenticated with TLS client auth by the TPM
+// key holding the current certificate.  CSRs are built and signed by
+// certgen, so the private key never leaves the TPM.
+package est
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"os"
+	"strings"
+
+	keyfile "github.com/foxboron/go-tpm-keyfiles"
+	"github.com/ibiscum/tpm2/atomicfile"
+	"github.com/ibiscum/tpm2/certgen"
+	"github.com/ibiscum/tpm2/tpmkey"
+)
+
+// Client talks to one EST server.
+type Client struct {
+	// URL is the EST base, eg https://est.example.com/.well-known/est or
+	// with an arbitrary label, https://est.example.com/.well-known/est/tls.
+	URL string
+	// Username and Password are sent as HTTP basic auth, if set.
+	Username, Password string
+
+	http *http.Client
+}
+
+// NewClient returns a client for url.  cfg sets the trust anchors
+// (RootCAs) and, for reenrollment, the client certificate; nil uses the
+// system roots.
+func NewClient(url string, cfg *tls.Config) *Client {
+	return &Client{
+		URL: strings.TrimSuffix(url, "/"),
+		http: &http.Client{
+			Transport: &http.Transport{TLSClientConfig: cfg},
+		},
+	}
+}
+
+// CACerts fetches the current CA certificates.
+func (c *Client) CACerts() ([]*x509.Certificate, error) {
+	req, err := http.NewRequest(http.MethodGet, c.URL+"/cacerts", nil)
+	if err != nil {
+		return nil, err
+	}
+	return c.do(req)
+}
+
+// SimpleEnroll sends a DER CSR for a first certificate.
+func (c *Client) SimpleEnroll(csr []byte) ([]*x509.Certificate, error) {
+	return c.enroll("/simpleenroll", csr)
+}
+
+// SimpleReenroll sends a DER CSR to renew the certificate the client
+// authenticates with; the subject and SANs have to be the current ones.
+func (c *Client) SimpleReenroll(csr []byte) ([]*x509.Certificate, error) {
+	return c.enroll("/simplereenroll", csr)
+}
+
+func (c *Client) enroll(op string, csr []byte) ([]*x509.Certificate, error) {
+	body := base64.StdEncoding.EncodeToString(csr)
+	req, err := http.NewRequest(http.MethodPost, c.URL+op, strings.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/pkcs10")
+	req.Header.Set("Content-Transfer-Encoding", "base64")
+	return c.do(req)
+}
+
+func (c *Client) do(req *http.Request) ([]*x509.Certificate, error) {
+	if c.Username != "" {
+		req.SetBasicAuth(c.Username, c.Password)
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("est: %v", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("est: %v", err)
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusAccepted:
+		// manual approval, RFC 7030 section 4.2.3
+		return nil, fmt.Errorf("est: request pending, retry after %q", resp.Header.Get("Retry-After"))
+	default:
+		return nil, fmt.Errorf("est: %s %s: %s", req.URL.Path, resp.Status, strings.TrimSpace(string(body)))
+	}
+	if mt, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type")); mt != "application/pkcs7-mime" {
+		return nil, fmt.Errorf("est: unexpected content type %q", resp.Header.Get("Content-Type"))
+	}
+	der, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(string(body)), ""))
+	if err != nil {
+		return nil, fmt.Errorf("est: reply isn't base64: %v", err)
+	}
+	return parseCertsOnly(der)
+}
+
+// Enroll has k sign a CSR described by opts and enrolls it.
+func (c *Client) Enroll(k *tpmkey.Key, opts *certgen.Options) ([]*x509.Certificate, error) {
+	csr, err := certgen.CreateCSR(k, opts)
+	if err != nil {
+		return nil, err
+	}
+	return c.SimpleEnroll(csr)
+}
+
+// Reenroll renews current, the certificate the client authenticates with,
+// for k: the same key, or a new one to rekey.  Subject and SANs are copied
+// from current.
+func (c *Client) Reenroll(k *tpmkey.Key, current *x509.Certificate) ([]*x509.Certificate, error) {
+	csr, err := certgen.CreateCSR(k, &certgen.Options{
+		Subject:        current.Subject,
+		DNSNames:       current.DNSNames,
+		IPAddresses:    current.IPAddresses,
+		EmailAddresses: current.EmailAddresses,
+		URIs:           current.URIs,
+		ExtKeyUsage:    current.ExtKeyUsage,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return c.SimpleReenroll(csr)
+}
+
+// Chain returns the issued leaf followed by the CA certificates that
+// aren't already in issued, ready for Store.
+func Chain(issued, cacerts []*x509.Certificate) ([]*x509.Certificate, error) {
+	if len(issued) == 0 {
+		return nil, fmt.Errorf("est: no certificate issued")
+	}
+	chain := append([]*x509.Certificate(nil), issued...)
+	for _, ca := range cacerts {
+		dup := false
+		for _, c := range chain {
+			if bytes.Equal(c.Raw, ca.Raw) {
+				dup = true
+			}
+		}
+		if !dup {
+			chain = append(chain, ca)
+		}
+	}
+	return chain, nil
+}
+
+// Store writes the keyfile and the PEM chain.  Both are written to
+// temporary files first and renamed into place, the keyfile first.  If the
+// chain can't be renamed after the keyfile was, the old keyfile is put back
+// (or, when there was none, the new one removed), so a renewal that fails
+// half way leaves the old pair usable, and a new key never sits next to
+// the old certificate.
+func Store(keyFile, certFile string, kf *keyfile.TPMKey, chain []*x509.Certificate) error {
+	var certPEM []byte
+	for _, c := range chain {
+		certPEM = append(certPEM, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.Raw})...)
+	}
+	hadKey := true
+	oldKey, err := os.ReadFile(keyFile)
+	if errors.Is(err, fs.ErrNotExist) {
+		hadKey = false
+	} else if err != nil {
+		return fmt.Errorf("est: %v", err)
+	}
+	var oldPerm os.FileMode = 0600
+	if hadKey {
+		fi, err := os.Stat(keyFile)
+		if err != nil {
+			return fmt.Errorf("est: %v", err)
+		}
+		oldPerm = fi.Mode().Perm()
+	}
+	keyTmp, err := atomicfile.WriteTemp(keyFile, kf.Bytes(), 0600)
+	if err != nil {
+		return err
+	}
+	certTmp, err := atomicfile.WriteTemp(certFile, certPEM, 0644)
+	if err != nil {
+		os.Remove(keyTmp)
+		return err
+	}
+	if err := os.Rename(keyTmp, keyFile); err != nil {
+		os.Remove(keyTmp)
+		os.Remove(certTmp)
+		return fmt.Errorf("est: %v", err)
+	}
+	if err := os.Rename(certTmp, certFile); err != nil {
+		os.Remove(certTmp)
+		if !hadKey {
+			os.Remove(keyFile)
+			return fmt.Errorf("est: %v", err)
+		}
+		// put the old key back, as the new one was put in place
+		if rerr := atomicfile.WriteFile(keyFile, oldKey, oldPerm); rerr != nil {
+			return fmt.Errorf("est: %v, and can't restore the old %s: %v", err, keyFile, rerr)
+		}
+		return fmt.Errorf("est: %v", err)
+	}
+	return nil
+}
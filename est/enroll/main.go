@@ -0,0 +1,140 @@
+package main
+
+import (
+	"crypto/tls"
+	"flag"
+	"io"
+	"log"
+	"net"
+	"slices"
+	"strings"
+
+	"github.com/google/go-tpm-tools/simulator"
+	"github.com/google/go-tpm/tpm2"
+	"github.com/google/go-tpm/tpm2/transport"
+	"github.com/google/go-tpm/tpmutil"
+	"github.com/ibiscum/tpm2/certgen"
+	"github.com/ibiscum/tpm2/est"
+	"github.com/ibiscum/tpm2/tls/tpmtls"
+	"github.com/ibiscum/tpm2/tpmkey"
+)
+
+var (
+	tpmPath     = flag.String("tpm-path", "/dev/tpmrm0", "Path to the TPM device (character device or a Unix socket).")
+	url         = flag.String("url", "https://127.0.0.1:8443/.well-known/est", "EST base URL")
+	ca          = flag.String("ca", "", "PEM CA certificates to verify the server with, eg from cacerts (default: system roots)")
+	user        = flag.String("user", "", "user:password for HTTP basic auth")
+	keyType     = flag.String("type", "ecc", "new key: rsa or ecc")
+	bits        = flag.Int("bits", 2048, "RSA key size")
+	curve       = flag.String("curve", "p256", "ECC curve: p256, p384 or p521")
+	password    = flag.String("password", "", "optional password of the new key")
+	subject     = flag.String("subject", "", "subject, eg /CN=device1/O=Example")
+	san         = flag.String("san", "", "comma separated subject alternative names: DNS names, IPs, emails, URIs")
+	extKeyUsage = flag.String("ext-key-usage", "clientAuth", "comma separated extended key usages")
+	out         = flag.String("out", "key.pem", "TSS2 keyfile of the new key")
+	certOut     = flag.String("cert", "key.crt", "PEM certificate chain of the new key, leaf first")
+)
+
+var TPMDEVICES = []string{"/dev/tpm0", "/dev/tpmrm0"}
+
+func OpenTPM(path string) (io.ReadWriteCloser, error) {
+	if slices.Contains(TPMDEVICES, path) {
+		return tpmutil.OpenTPM(path)
+	} else if path == "simulator" {
+		return simulator.GetWithFixedSeedInsecure(1073741825)
+	} else {
+		return net.Dial("tcp", path)
+	}
+}
+
+func main() {
+	flag.Parse()
+
+	var template tpm2.TPMTPublic
+	switch *keyType {
+	case "rsa":
+		template = tpmkey.RSATemplate(*bits, tpm2.TPMAlgNull, tpm2.TPMAlgSHA256)
+	case "ecc":
+		curves := map[string]tpm2.TPMECCCurve{
+			"p256": tpm2.TPMECCNistP256,
+			"p384": tpm2.TPMECCNistP384,
+			"p521": tpm2.TPMECCNistP521,
+		}
+		c, ok := curves[*curve]
+		if !ok {
+			log.Fatalf("unknown curve %q", *curve)
+		}
+		template = tpmkey.ECCTemplate(c, tpm2.TPMAlgNull, tpm2.TPMAlgSHA256)
+	default:
+		log.Fatalf("unknown key type %q", *keyType)
+	}
+
+	opts := &certgen.Options{}
+	var err error
+	if opts.Subject, err = certgen.ParseSubject(*subject); err != nil {
+		log.Fatalf("%v", err)
+	}
+	if *san != "" {
+		if err := opts.SANs(strings.Split(*san, ",")); err != nil {
+			log.Fatalf("%v", err)
+		}
+	}
+	if *extKeyUsage != "" {
+		if opts.ExtKeyUsage, err = certgen.ParseExtKeyUsage(*extKeyUsage); err != nil {
+			log.Fatalf("%v", err)
+		}
+	}
+
+	cfg := &tls.Config{}
+	if *ca != "" {
+		if cfg.RootCAs, err = tpmtls.CertPool(*ca); err != nil {
+			log.Fatalf("%v", err)
+		}
+	}
+	client := est.NewClient(*url, cfg)
+	if *user != "" {
+		client.Username, client.Password, _ = strings.Cut(*user, ":")
+	}
+	cacerts, err := client.CACerts()
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	rwc, err := OpenTPM(*tpmPath)
+	if err != nil {
+		log.Fatalf("can't open TPM %q: %v", *tpmPath, err)
+	}
+	defer func() {
+		rwc.Close()
+	}()
+
+	rwr := transport.FromReadWriter(rwc)
+
+	kf, err := tpmkey.Create(rwr, template, []byte(*password), nil)
+	if err != nil {
+		log.Fatalf("can't create key: %v", err)
+	}
+	k, err := tpmkey.Load(rwr, kf, []byte(*password))
+	if err != nil {
+		log.Fatalf("can't load key: %v", err)
+	}
+	defer k.Close()
+
+	issued, err := client.Enroll(k, opts)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	chain, err := est.Chain(issued, cacerts)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	// refuse a certificate for some other key before storing anything
+	if _, err := tpmtls.CertificateDER(k, [][]byte{chain[0].Raw}); err != nil {
+		log.Fatalf("%v", err)
+	}
+	if err := est.Store(*out, *certOut, kf, chain); err != nil {
+		log.Fatalf("%v", err)
+	}
+	log.Printf("enrolled %s, serial %x, expires %s; wrote %s and %s",
+		chain[0].Subject, chain[0].SerialNumber, chain[0].NotAfter.Format("2006-01-02"), *out, *certOut)
+}
@@ -0,0 +1,69 @@
+package est
+
+import (
+	"crypto/x509"
+	"encoding/asn1"
+	"fmt"
+
+	"golang.org/x/crypto/cryptobyte"
+	cbasn1 "golang.org/x/crypto/cryptobyte/asn1"
+)
+
+var (
+	oidData       = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidSignedData = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+)
+
+// certsOnly encodes certificates as a degenerate CMS SignedData with no
+// signers (RFC 5652, "certs-only"), the format of EST certificate replies.
+func certsOnly(certs []*x509.Certificate) ([]byte, error) {
+	var b cryptobyte.Builder
+	b.AddASN1(cbasn1.SEQUENCE, func(b *cryptobyte.Builder) {
+		b.AddASN1ObjectIdentifier(oidSignedData)
+		b.AddASN1(cbasn1.Tag(0).Constructed().ContextSpecific(), func(b *cryptobyte.Builder) {
+			b.AddASN1(cbasn1.SEQUENCE, func(b *cryptobyte.Builder) {
+				b.AddASN1Int64(1)
+				b.AddASN1(cbasn1.SET, func(b *cryptobyte.Builder) {})
+				b.AddASN1(cbasn1.SEQUENCE, func(b *cryptobyte.Builder) {
+					b.AddASN1ObjectIdentifier(oidData)
+				})
+				b.AddASN1(cbasn1.Tag(0).Constructed().ContextSpecific(), func(b *cryptobyte.Builder) {
+					for _, c := range certs {
+						b.AddBytes(c.Raw)
+					}
+				})
+				b.AddASN1(cbasn1.SET, func(b *cryptobyte.Builder) {})
+			})
+		})
+	})
+	return b.Bytes()
+}
+
+// parseCertsOnly returns the certificates of a DER certs-only SignedData.
+func parseCertsOnly(der []byte) ([]*x509.Certificate, error) {
+	var (
+		s, content, signedData, certs cryptobyte.String
+		oid                           asn1.ObjectIdentifier
+		version                       int64
+		hasCerts                      bool
+	)
+	s = der
+	if !s.ReadASN1(&content, cbasn1.SEQUENCE) || !s.Empty() ||
+		!content.ReadASN1ObjectIdentifier(&oid) || !oid.Equal(oidSignedData) ||
+		!content.ReadASN1(&content, cbasn1.Tag(0).Constructed().ContextSpecific()) ||
+		!content.ReadASN1(&signedData, cbasn1.SEQUENCE) ||
+		!signedData.ReadASN1Integer(&version) ||
+		!signedData.SkipASN1(cbasn1.SET) ||
+		!signedData.SkipASN1(cbasn1.SEQUENCE) ||
+		!signedData.ReadOptionalASN1(&certs, &hasCerts, cbasn1.Tag(0).Constructed().ContextSpecific()) {
+		return nil, fmt.Errorf("est: malformed PKCS#7 certs-only reply")
+	}
+	if !hasCerts {
+		return nil, fmt.Errorf("est: PKCS#7 reply carries no certificates")
+	}
+	parsed, err := x509.ParseCertificates(certs)
+	if err != nil {
+		return nil, fmt.Errorf("est: bad certificate in reply: %v", err)
+	}
+	return parsed, nil
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"flag"
+	"io"
+	"log"
+	"net"
+	"os"
+	"slices"
+
+	keyfile "github.com/foxboron/go-tpm-keyfiles"
+	"github.com/google/go-tpm-tools/simulator"
+	"github.com/google/go-tpm/tpm2"
+	"github.com/google/go-tpm/tpm2/transport"
+	"github.com/google/go-tpm/tpmutil"
+	"github.com/ibiscum/tpm2/est"
+	"github.com/ibiscum/tpm2/tls/tpmtls"
+	"github.com/ibiscum/tpm2/tpmkey"
+)
+
+var (
+	tpmPath  = flag.String("tpm-path", "/dev/tpmrm0", "Path to the TPM device (character device or a Unix socket).")
+	url      = flag.String("url", "https://127.0.0.1:8443/.well-known/est", "EST base URL")
+	ca       = flag.String("ca", "", "PEM CA certificates to verify the server with (default: system roots)")
+	key      = flag.String("key", "key.pem", "TSS2 keyfile of the current key, replaced on success")
+	cert     = flag.String("cert", "key.crt", "PEM certificate chain of the current key, replaced on success")
+	password = flag.String("password", "", "optional key password, also used for the new key with --rekey")
+	rekey    = flag.Bool("rekey", false, "renew for a new key of the same type instead of the current one")
+)
+
+var TPMDEVICES = []string{"/dev/tpm0", "/dev/tpmrm0"}
+
+func OpenTPM(path string) (io.ReadWriteCloser, error) {
+	if slices.Contains(TPMDEVICES, path) {
+		return tpmutil.OpenTPM(path)
+	} else if path == "simulator" {
+		return simulator.GetWithFixedSeedInsecure(1073741825)
+	} else {
+		return net.Dial("tcp", path)
+	}
+}
+
+func main() {
+	flag.Parse()
+
+	b, err := os.ReadFile(*key)
+	if err != nil {
+		log.Fatalf("can't read keyfile: %v", err)
+	}
+	kf, err := keyfile.Decode(b)
+	if err != nil {
+		log.Fatalf("can't decode keyfile: %v", err)
+	}
+	chainPEM, err := os.ReadFile(*cert)
+	if err != nil {
+		log.Fatalf("can't read certificate: %v", err)
+	}
+
+	rwc, err := OpenTPM(*tpmPath)
+	if err != nil {
+		log.Fatalf("can't open TPM %q: %v", *tpmPath, err)
+	}
+	defer func() {
+		rwc.Close()
+	}()
+
+	rwr := transport.FromReadWriter(rwc)
+
+	auth := []byte(*password)
+	if kf.EmptyAuth {
+		auth = nil
+	}
+	k, err := tpmkey.Load(rwr, kf, auth)
+	if err != nil {
+		log.Fatalf("can't load key: %v", err)
+	}
+	defer k.Close()
+
+	// the current key authenticates the TLS connection
+	tlsCert, err := tpmtls.Certificate(k, chainPEM)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	current, err := x509.ParseCertificate(tlsCert.Certificate[0])
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	cfg := &tls.Config{Certificates: []tls.Certificate{tlsCert}}
+	if *ca != "" {
+		if cfg.RootCAs, err = tpmtls.CertPool(*ca); err != nil {
+			log.Fatalf("%v", err)
+		}
+	}
+	client := est.NewClient(*url, cfg)
+	cacerts, err := client.CACerts()
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	newKF, newK := kf, k
+	if *rekey {
+		if len(kf.Policy) > 0 {
+			log.Fatalf("can't rekey a key with a policy")
+		}
+		template := k.Public
+		template.AuthPolicy = tpm2.TPM2BDigest{}
+		template.ObjectAttributes.UserWithAuth = true
+		if newKF, err = tpmkey.Create(rwr, template, []byte(*password), nil); err != nil {
+			log.Fatalf("can't create key: %v", err)
+		}
+		if newK, err = tpmkey.Load(rwr, newKF, []byte(*password)); err != nil {
+			log.Fatalf("can't load key: %v", err)
+		}
+		defer newK.Close()
+	}
+
+	issued, err := client.Reenroll(newK, current)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	chain, err := est.Chain(issued, cacerts)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	if _, err := tpmtls.CertificateDER(newK, [][]byte{chain[0].Raw}); err != nil {
+		log.Fatalf("%v", err)
+	}
+	if err := est.Store(*key, *cert, newKF, chain); err != nil {
+		log.Fatalf("%v", err)
+	}
+	log.Printf("renewed %s, serial %x -> %x, expires %s; wrote %s and %s",
+		current.Subject, current.SerialNumber, chain[0].SerialNumber, chain[0].NotAfter.Format("2006-01-02"), *key, *cert)
+}
@@ -0,0 +1,311 @@
+package est
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"math/big"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+var (
+	oidKeyUsage    = asn1.ObjectIdentifier{2, 5, 29, 15}
+	oidExtKeyUsage = asn1.ObjectIdentifier{2, 5, 29, 37}
+)
+
+// Server is a minimal EST server backed by a software CA, a stand-in to
+// test clients against.  It issues whatever it is asked for: simpleenroll
+// only checks HTTP basic auth (or a client certificate from the CA), and
+// simplereenroll that the subject and SANs match the client certificate.
+type Server struct {
+	Key  crypto.Signer
+	Cert *x509.Certificate
+	// Users maps user names to passwords for simpleenroll; with none,
+	// anyone may enroll.
+	Users map[string]string
+	// Validity of the issued certificates.
+	Validity time.Duration
+}
+
+// LoadServer reads the PEM CA key and certificate, creating an ECDSA P-256
+// CA if neither file exists.
+func LoadServer(keyFile, certFile string) (*Server, error) {
+	keyPEM, kerr := os.ReadFile(keyFile)
+	certPEM, cerr := os.ReadFile(certFile)
+	switch {
+	case errors.Is(kerr, fs.ErrNotExist) && errors.Is(cerr, fs.ErrNotExist):
+		return createServer(keyFile, certFile)
+	case kerr != nil:
+		return nil, fmt.Errorf("est: can't read CA key: %v", kerr)
+	case cerr != nil:
+		return nil, fmt.Errorf("est: can't read CA certificate: %v", cerr)
+	}
+
+	block, _ := pem.Decode(keyPEM)
+	if block == nil {
+		return nil, fmt.Errorf("est: no PEM block in %s", keyFile)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("est: can't parse CA key: %v", err)
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("est: unsupported CA key %T", key)
+	}
+	block, _ = pem.Decode(certPEM)
+	if block == nil {
+		return nil, fmt.Errorf("est: no PEM block in %s", certFile)
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("est: can't parse CA certificate: %v", err)
+	}
+	return &Server{Key: signer, Cert: cert, Validity: 30 * 24 * time.Hour}, nil
+}
+
+func createServer(keyFile, certFile string) (*Server, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "est test CA"},
+		NotBefore:             time.Now().Add(-5 * time.Minute),
+		NotAfter:              time.Now().Add(10 * 365 * 24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8}), 0600); err != nil {
+		return nil, fmt.Errorf("est: can't write CA key: %v", err)
+	}
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644); err != nil {
+		return nil, fmt.Errorf("est: can't write CA certificate: %v", err)
+	}
+	return &Server{Key: key, Cert: cert, Validity: 30 * 24 * time.Hour}, nil
+}
+
+// TLSConfig returns a server configuration with a software certificate
+// from the CA for hosts (DNS names or IPs), accepting client certificates
+// issued by the CA.
+func (s *Server) TLSConfig(hosts []string) (*tls.Config, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	tmpl := &x509.Certificate{
+		Subject:     pkix.Name{CommonName: hosts[0]},
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	for _, h := range hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			tmpl.IPAddresses = append(tmpl.IPAddresses, ip)
+		} else {
+			tmpl.DNSNames = append(tmpl.DNSNames, h)
+		}
+	}
+	der, err := s.sign(tmpl, key.Public())
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(s.Cert)
+	return &tls.Config{
+		Certificates: []tls.Certificate{{
+			Certificate: [][]byte{der, s.Cert.Raw},
+			PrivateKey:  key,
+		}},
+		ClientAuth: tls.VerifyClientCertIfGiven,
+		ClientCAs:  pool,
+		MinVersion: tls.VersionTLS12,
+	}, nil
+}
+
+func (s *Server) sign(tmpl *x509.Certificate, pub crypto.PublicKey) ([]byte, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 127))
+	if err != nil {
+		return nil, err
+	}
+	tmpl.SerialNumber = serial
+	tmpl.NotBefore = time.Now().Add(-5 * time.Minute)
+	tmpl.NotAfter = tmpl.NotBefore.Add(s.Validity)
+	tmpl.BasicConstraintsValid = true
+	return x509.CreateCertificate(rand.Reader, tmpl, s.Cert, pub, s.Key)
+}
+
+// Issue signs csr, keeping its subject, SANs, key usage and extended key
+// usage.
+func (s *Server) Issue(csr *x509.CertificateRequest) (*x509.Certificate, error) {
+	if err := csr.CheckSignature(); err != nil {
+		return nil, fmt.Errorf("est: CSR signature doesn't verify: %v", err)
+	}
+	tmpl := &x509.Certificate{
+		Subject:        csr.Subject,
+		DNSNames:       csr.DNSNames,
+		IPAddresses:    csr.IPAddresses,
+		EmailAddresses: csr.EmailAddresses,
+		URIs:           csr.URIs,
+		KeyUsage:       x509.KeyUsageDigitalSignature,
+	}
+	for _, ext := range csr.Extensions {
+		if ext.Id.Equal(oidKeyUsage) || ext.Id.Equal(oidExtKeyUsage) {
+			tmpl.ExtraExtensions = append(tmpl.ExtraExtensions, ext)
+		}
+	}
+	der, err := s.sign(tmpl, csr.PublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("est: can't issue certificate: %v", err)
+	}
+	return x509.ParseCertificate(der)
+}
+
+// Handler serves /.well-known/est/cacerts, simpleenroll and simplereenroll.
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/est/cacerts", func(w http.ResponseWriter, r *http.Request) {
+		writeCerts(w, []*x509.Certificate{s.Cert})
+	})
+	mux.HandleFunc("POST /.well-known/est/simpleenroll", func(w http.ResponseWriter, r *http.Request) {
+		if !s.authorized(r) {
+			w.Header().Set("WWW-Authenticate", `Basic realm="est"`)
+			http.Error(w, "authentication required", http.StatusUnauthorized)
+			return
+		}
+		csr, err := readCSR(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s.respond(w, csr)
+	})
+	mux.HandleFunc("POST /.well-known/est/simplereenroll", func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+			http.Error(w, "reenrollment needs a client certificate from this CA", http.StatusUnauthorized)
+			return
+		}
+		csr, err := readCSR(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := sameIdentity(r.TLS.PeerCertificates[0], csr); err != nil {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		s.respond(w, csr)
+	})
+	return mux
+}
+
+// authorized checks the basic auth credentials for simpleenroll; a client
+// certificate from the CA is as good.
+func (s *Server) authorized(r *http.Request) bool {
+	if len(s.Users) == 0 || (r.TLS != nil && len(r.TLS.VerifiedChains) > 0) {
+		return true
+	}
+	user, pass, ok := r.BasicAuth()
+	want, known := s.Users[user]
+	return ok && known && subtle.ConstantTimeCompare([]byte(pass), []byte(want)) == 1
+}
+
+func (s *Server) respond(w http.ResponseWriter, csr *x509.CertificateRequest) {
+	cert, err := s.Issue(csr)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeCerts(w, []*x509.Certificate{cert})
+}
+
+// sameIdentity checks that a reenrollment CSR asks for the subject and
+// SANs of the current certificate, RFC 7030 section 4.2.2.
+func sameIdentity(current *x509.Certificate, csr *x509.CertificateRequest) error {
+	if current.Subject.String() != csr.Subject.String() {
+		return fmt.Errorf("est: subject %q differs from the current %q", csr.Subject, current.Subject)
+	}
+	if !slices.Equal(sans(current.DNSNames, current.IPAddresses, current.EmailAddresses, current.URIs),
+		sans(csr.DNSNames, csr.IPAddresses, csr.EmailAddresses, csr.URIs)) {
+		return fmt.Errorf("est: subject alternative names differ from the current certificate")
+	}
+	return nil
+}
+
+func sans(dns []string, ips []net.IP, emails []string, uris []*url.URL) []string {
+	out := slices.Clone(dns)
+	for _, ip := range ips {
+		out = append(out, ip.String())
+	}
+	out = append(out, emails...)
+	for _, u := range uris {
+		out = append(out, u.String())
+	}
+	slices.Sort(out)
+	return out
+}
+
+func readCSR(r *http.Request) (*x509.CertificateRequest, error) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, 1<<16))
+	if err != nil {
+		return nil, err
+	}
+	der, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(string(body)), ""))
+	if err != nil {
+		return nil, fmt.Errorf("est: CSR isn't base64: %v", err)
+	}
+	csr, err := x509.ParseCertificateRequest(der)
+	if err != nil {
+		return nil, fmt.Errorf("est: bad CSR: %v", err)
+	}
+	return csr, nil
+}
+
+func writeCerts(w http.ResponseWriter, certs []*x509.Certificate) {
+	der, err := certsOnly(certs)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	b64 := base64.StdEncoding.EncodeToString(der)
+	var body strings.Builder
+	for len(b64) > 64 {
+		body.WriteString(b64[:64] + "\r\n")
+		b64 = b64[64:]
+	}
+	body.WriteString(b64 + "\r\n")
+	w.Header().Set("Content-Type", "application/pkcs7-mime; smime-type=certs-only")
+	w.Header().Set("Content-Transfer-Encoding", "base64")
+	io.WriteString(w, body.String())
+}
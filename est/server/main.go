@@ -0,0 +1,52 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ibiscum/tpm2/est"
+)
+
+var (
+	listen = flag.String("listen", "127.0.0.1:8443", "address to listen on")
+	caKey  = flag.String("ca-key", "ca.key", "PEM PKCS#8 CA key, created with --ca-cert if both are missing")
+	caCert = flag.String("ca-cert", "ca.crt", "PEM CA certificate")
+	hosts  = flag.String("hosts", "127.0.0.1,localhost", "comma separated names and IPs of the server certificate")
+	users  = flag.String("users", "", "comma separated user:password pairs allowed to simpleenroll (default: anyone)")
+	days   = flag.Int("days", 30, "validity of the issued certificates")
+)
+
+func main() {
+	flag.Parse()
+
+	s, err := est.LoadServer(*caKey, *caCert)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	s.Validity = time.Duration(*days) * 24 * time.Hour
+	if *users != "" {
+		s.Users = map[string]string{}
+		for _, u := range strings.Split(*users, ",") {
+			name, pass, ok := strings.Cut(u, ":")
+			if !ok {
+				log.Fatalf("bad user %q, want user:password", u)
+			}
+			s.Users[name] = pass
+		}
+	}
+	cfg, err := s.TLSConfig(strings.Split(*hosts, ","))
+	if err != nil {
+		log.Fatalf("can't create server certificate: %v", err)
+	}
+
+	srv := &http.Server{
+		Addr:      *listen,
+		Handler:   s.Handler(),
+		TLSConfig: cfg,
+	}
+	log.Printf("EST server for %q on https://%s/.well-known/est", s.Cert.Subject.CommonName, *listen)
+	log.Fatal(srv.ListenAndServeTLS("", ""))
+}
@@ -0,0 +1,306 @@
+// Package jose issues compact JWS (and so JWTs) signed by keys held in the
+// TPM, and publishes their public halves as JWKs and JWK Sets.
+//
+// RS256 and PS256 need an RSA key, ES256 a P-256 and ES384 a P-384 key.
+// Keys created with a fixed signing scheme only accept the matching alg.
+package jose
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/google/go-tpm/tpm2"
+	"github.com/ibiscum/tpm2/tpmkey"
+)
+
+type alg struct {
+	name   string
+	kty    tpm2.TPMAlgID
+	curve  tpm2.TPMECCCurve
+	scheme tpm2.TPMAlgID
+	hash   crypto.Hash
+}
+
+var algs = []alg{
+	{"RS256", tpm2.TPMAlgRSA, 0, tpm2.TPMAlgRSASSA, crypto.SHA256},
+	{"PS256", tpm2.TPMAlgRSA, 0, tpm2.TPMAlgRSAPSS, crypto.SHA256},
+	{"ES256", tpm2.TPMAlgECC, tpm2.TPMECCNistP256, tpm2.TPMAlgECDSA, crypto.SHA256},
+	{"ES384", tpm2.TPMAlgECC, tpm2.TPMECCNistP384, tpm2.TPMAlgECDSA, crypto.SHA384},
+}
+
+var tpmCurves = map[string]tpm2.TPMECCCurve{
+	"P-256": tpm2.TPMECCNistP256,
+	"P-384": tpm2.TPMECCNistP384,
+	"P-521": tpm2.TPMECCNistP521,
+}
+
+func lookupAlg(name string) (alg, bool) {
+	for _, a := range algs {
+		if a.name == name {
+			return a, true
+		}
+	}
+	return alg{}, false
+}
+
+// matches reports whether the key type (and curve) suits the alg.
+func (a alg) matches(public *tpm2.TPMTPublic) bool {
+	if public.Type != a.kty {
+		return false
+	}
+	if a.kty == tpm2.TPMAlgECC {
+		d, err := public.Parameters.ECCDetail()
+		return err == nil && d.CurveID == a.curve
+	}
+	return true
+}
+
+// fixed reports whether the key is restricted to exactly this alg's scheme.
+func (a alg) fixed(public *tpm2.TPMTPublic) bool {
+	scheme, hash := tpmkey.PublicScheme(public)
+	h, err := tpmkey.HashAlg(a.hash)
+	return err == nil && scheme == a.scheme && hash == h
+}
+
+// Signer signs JWS with a TPM key.
+type Signer struct {
+	key *tpmkey.Key
+	alg alg
+	jwk *JWK
+}
+
+// NewSigner returns a Signer using alg.  If alg is empty the key's fixed
+// scheme is used, or RS256/ES256/ES384 for unrestricted keys.
+func NewSigner(k *tpmkey.Key, name string) (*Signer, error) {
+	if !k.Public.ObjectAttributes.SignEncrypt {
+		return nil, fmt.Errorf("jose: key can't sign")
+	}
+
+	if name == "" {
+		if fixed, err := fixedAlg(&k.Public); err == nil {
+			name = fixed
+		} else {
+			for _, c := range algs {
+				if c.matches(&k.Public) {
+					name = c.name
+					break
+				}
+			}
+		}
+		if name == "" {
+			return nil, fmt.Errorf("jose: no JWS alg for this key")
+		}
+	}
+	a, ok := lookupAlg(name)
+	if !ok {
+		return nil, fmt.Errorf("jose: unsupported alg %q", name)
+	}
+	if !a.matches(&k.Public) {
+		return nil, fmt.Errorf("jose: %s can't be used with this key", name)
+	}
+	if scheme, _ := k.Scheme(); scheme != tpm2.TPMAlgNull && !a.fixed(&k.Public) {
+		return nil, fmt.Errorf("jose: key is restricted to another scheme than %s", name)
+	}
+
+	pub, err := k.PublicKey()
+	if err != nil {
+		return nil, err
+	}
+	j, err := PublicJWK(pub)
+	if err != nil {
+		return nil, err
+	}
+	j.Use = "sig"
+	j.Alg = a.name
+
+	return &Signer{
+		key: k,
+		alg: a,
+		jwk: j,
+	}, nil
+}
+
+// Alg returns the JWS alg the signer uses.
+func (s *Signer) Alg() string {
+	return s.alg.name
+}
+
+// JWK returns the public key, its kid being the RFC 7638 thumbprint.
+func (s *Signer) JWK() *JWK {
+	return s.jwk
+}
+
+// Sign returns the compact serialization of payload signed with the TPM
+// key.  alg is always set in the protected header; kid is added unless the
+// header already has one or embeds the key as jwk.
+func (s *Signer) Sign(header map[string]any, payload []byte) (string, error) {
+	h := map[string]any{}
+	for k, v := range header {
+		h[k] = v
+	}
+	h["alg"] = s.alg.name
+	_, hasKid := h["kid"]
+	_, hasJWK := h["jwk"]
+	if !hasKid && !hasJWK {
+		h["kid"] = s.jwk.Kid
+	}
+
+	hb, err := json.Marshal(h)
+	if err != nil {
+		return "", err
+	}
+	signingInput := b64(hb) + "." + b64(payload)
+
+	hh := s.alg.hash.New()
+	hh.Write([]byte(signingInput))
+	hashAlg, err := tpmkey.HashAlg(s.alg.hash)
+	if err != nil {
+		return "", err
+	}
+
+	sig, err := s.key.SignDigest(hh.Sum(nil), s.alg.scheme, hashAlg)
+	if err != nil {
+		return "", err
+	}
+
+	var raw []byte
+	switch s.alg.scheme {
+	case tpm2.TPMAlgRSASSA:
+		r, err := sig.Signature.RSASSA()
+		if err != nil {
+			return "", err
+		}
+		raw = r.Sig.Buffer
+	case tpm2.TPMAlgRSAPSS:
+		r, err := sig.Signature.RSAPSS()
+		if err != nil {
+			return "", err
+		}
+		raw = r.Sig.Buffer
+	case tpm2.TPMAlgECDSA:
+		e, err := sig.Signature.ECDSA()
+		if err != nil {
+			return "", err
+		}
+		size := 32
+		if s.alg.curve == tpm2.TPMECCNistP384 {
+			size = 48
+		}
+		if raw, err = ECDSASignature(e, size); err != nil {
+			return "", err
+		}
+	}
+	return signingInput + "." + b64(raw), nil
+}
+
+// SignJWT signs claims as a JWT with typ "JWT".
+func (s *Signer) SignJWT(claims any) (string, error) {
+	p, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	return s.Sign(map[string]any{"typ": "JWT"}, p)
+}
+
+// ECDSASignature converts the R and S of a TPM ECDSA signature to the JWS
+// form: both left-padded to the curve's byte size and concatenated.  The TPM
+// strips leading zeros, so R or S may well be shorter than size.
+func ECDSASignature(sig *tpm2.TPMSSignatureECC, size int) ([]byte, error) {
+	r, s := sig.SignatureR.Buffer, sig.SignatureS.Buffer
+	if len(r) > size || len(s) > size {
+		return nil, fmt.Errorf("jose: ECDSA signature larger than the curve")
+	}
+	out := make([]byte, 2*size)
+	copy(out[size-len(r):size], r)
+	copy(out[2*size-len(s):], s)
+	return out, nil
+}
+
+// Parse splits a compact JWS without verifying it.
+func Parse(token string) (header map[string]any, payload []byte, err error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, nil, fmt.Errorf("jose: malformed compact JWS")
+	}
+	hb, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, nil, fmt.Errorf("jose: bad header encoding: %v", err)
+	}
+	if err := json.Unmarshal(hb, &header); err != nil {
+		return nil, nil, fmt.Errorf("jose: bad header: %v", err)
+	}
+	payload, err = base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, nil, fmt.Errorf("jose: bad payload encoding: %v", err)
+	}
+	return header, payload, nil
+}
+
+// Verify checks a compact JWS against pub and returns its header and
+// payload.  It is the software counterpart of Signer.Sign, eg for a relying
+// party holding the JWKS.  PS256 signatures are accepted with any salt
+// length, so it also takes tokens from TPMs using the maximum salt, which
+// strict RFC 7518 verifiers refuse.
+func Verify(token string, pub crypto.PublicKey) (map[string]any, []byte, error) {
+	header, payload, err := Parse(token)
+	if err != nil {
+		return nil, nil, err
+	}
+	name, _ := header["alg"].(string)
+	a, ok := lookupAlg(name)
+	if !ok {
+		return nil, nil, fmt.Errorf("jose: unsupported alg %q", name)
+	}
+
+	i := strings.LastIndexByte(token, '.')
+	sig, err := base64.RawURLEncoding.DecodeString(token[i+1:])
+	if err != nil {
+		return nil, nil, fmt.Errorf("jose: bad signature encoding: %v", err)
+	}
+	h := a.hash.New()
+	h.Write([]byte(token[:i]))
+	digest := h.Sum(nil)
+
+	switch p := pub.(type) {
+	case *rsa.PublicKey:
+		switch a.scheme {
+		case tpm2.TPMAlgRSASSA:
+			err = rsa.VerifyPKCS1v15(p, a.hash, digest, sig)
+		case tpm2.TPMAlgRSAPSS:
+			// RFC 7518 wants a salt as long as the hash, but TPMs that
+			// aren't FIPS style sign with the maximum: take either, as
+			// attestca does
+			err = rsa.VerifyPSS(p, a.hash, digest, sig, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthAuto})
+		default:
+			err = fmt.Errorf("%s needs an EC key", name)
+		}
+	case *ecdsa.PublicKey:
+		crv, size, cerr := curveParams(p.Curve)
+		if cerr != nil {
+			return nil, nil, cerr
+		}
+		if a.kty != tpm2.TPMAlgECC || a.curve != tpmCurves[crv] {
+			return nil, nil, fmt.Errorf("jose: %s can't be used with a %s key", name, crv)
+		}
+		if len(sig) != 2*size {
+			return nil, nil, fmt.Errorf("jose: bad ECDSA signature length")
+		}
+		r := new(big.Int).SetBytes(sig[:size])
+		s := new(big.Int).SetBytes(sig[size:])
+		if !ecdsa.Verify(p, digest, r, s) {
+			err = fmt.Errorf("signature mismatch")
+		}
+	default:
+		err = fmt.Errorf("unsupported key type %T", pub)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("jose: verification failed: %v", err)
+	}
+	return header, payload, nil
+}
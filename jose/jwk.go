@@ -0,0 +1,229 @@
+package jose
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+
+	keyfile "github.com/foxboron/go-tpm-keyfiles"
+	"github.com/google/go-tpm/tpm2"
+)
+
+// JWK is the public part of an RSA or EC key (RFC 7517).
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid,omitempty"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+
+	// RSA
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+
+	// EC
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// JWKS is a JWK Set document.
+type JWKS struct {
+	Keys []*JWK `json:"keys"`
+}
+
+// PublicJWK converts an *rsa.PublicKey or *ecdsa.PublicKey to a JWK whose
+// kid is its RFC 7638 thumbprint.
+func PublicJWK(pub crypto.PublicKey) (*JWK, error) {
+	var j *JWK
+	switch p := pub.(type) {
+	case *rsa.PublicKey:
+		j = &JWK{
+			Kty: "RSA",
+			N:   b64(p.N.Bytes()),
+			E:   b64(big.NewInt(int64(p.E)).Bytes()),
+		}
+	case *ecdsa.PublicKey:
+		crv, size, err := curveParams(p.Curve)
+		if err != nil {
+			return nil, err
+		}
+		j = &JWK{
+			Kty: "EC",
+			Crv: crv,
+			X:   b64(p.X.FillBytes(make([]byte, size))),
+			Y:   b64(p.Y.FillBytes(make([]byte, size))),
+		}
+	default:
+		return nil, fmt.Errorf("jose: unsupported key type %T", pub)
+	}
+
+	kid, err := j.Thumbprint()
+	if err != nil {
+		return nil, err
+	}
+	j.Kid = kid
+	return j, nil
+}
+
+// Thumbprint returns the base64url SHA-256 RFC 7638 thumbprint of the key:
+// the hash of the required members only, in lexicographic order and
+// without whitespace.
+func (j *JWK) Thumbprint() (string, error) {
+	var canonical string
+	switch j.Kty {
+	case "RSA":
+		canonical = fmt.Sprintf(`{"e":%q,"kty":"RSA","n":%q}`, j.E, j.N)
+	case "EC":
+		canonical = fmt.Sprintf(`{"crv":%q,"kty":"EC","x":%q,"y":%q}`, j.Crv, j.X, j.Y)
+	default:
+		return "", fmt.Errorf("jose: unsupported kty %q", j.Kty)
+	}
+	h := sha256.Sum256([]byte(canonical))
+	return b64(h[:]), nil
+}
+
+// PublicKey converts the JWK back to an *rsa.PublicKey or *ecdsa.PublicKey.
+func (j *JWK) PublicKey() (crypto.PublicKey, error) {
+	switch j.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(j.N)
+		if err != nil {
+			return nil, fmt.Errorf("jose: bad n: %v", err)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(j.E)
+		if err != nil {
+			return nil, fmt.Errorf("jose: bad e: %v", err)
+		}
+		if len(e) == 0 || len(e) > 4 {
+			return nil, fmt.Errorf("jose: bad e")
+		}
+		return &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch j.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("jose: unsupported crv %q", j.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(j.X)
+		if err != nil {
+			return nil, fmt.Errorf("jose: bad x: %v", err)
+		}
+		y, err := base64.RawURLEncoding.DecodeString(j.Y)
+		if err != nil {
+			return nil, fmt.Errorf("jose: bad y: %v", err)
+		}
+		pub := &ecdsa.PublicKey{
+			Curve: curve,
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}
+		if !curve.IsOnCurve(pub.X, pub.Y) {
+			return nil, fmt.Errorf("jose: point not on curve")
+		}
+		return pub, nil
+	}
+	return nil, fmt.Errorf("jose: unsupported kty %q", j.Kty)
+}
+
+// KeyfileJWK returns the JWK of a TSS2 keyfile's public key.  The TPM is not
+// needed.  alg is filled in when the key is fixed to a scheme JWS knows.
+func KeyfileJWK(k *keyfile.TPMKey) (*JWK, error) {
+	pub, err := k.PublicKey()
+	if err != nil {
+		return nil, fmt.Errorf("jose: can't read keyfile public key: %v", err)
+	}
+	j, err := PublicJWK(pub)
+	if err != nil {
+		return nil, err
+	}
+	j.Use = "sig"
+
+	public, err := k.Pubkey.Contents()
+	if err != nil {
+		return nil, err
+	}
+	if alg, err := fixedAlg(public); err == nil {
+		j.Alg = alg
+	}
+	return j, nil
+}
+
+// ReadJWKS builds a JWK Set from the public keys of several keyfiles.
+func ReadJWKS(paths ...string) (*JWKS, error) {
+	set := &JWKS{
+		Keys: []*JWK{},
+	}
+	for _, p := range paths {
+		b, err := os.ReadFile(p)
+		if err != nil {
+			return nil, err
+		}
+		k, err := keyfile.Decode(b)
+		if err != nil {
+			return nil, fmt.Errorf("jose: can't decode keyfile %s: %v", p, err)
+		}
+		j, err := KeyfileJWK(k)
+		if err != nil {
+			return nil, fmt.Errorf("jose: %s: %v", p, err)
+		}
+		set.Keys = append(set.Keys, j)
+	}
+	return set, nil
+}
+
+// Key returns the JWK with the given kid.
+func (s *JWKS) Key(kid string) (*JWK, bool) {
+	for _, k := range s.Keys {
+		if k.Kid == kid {
+			return k, true
+		}
+	}
+	return nil, false
+}
+
+// Marshal encodes the set as indented JSON.
+func (s *JWKS) Marshal() ([]byte, error) {
+	return json.MarshalIndent(s, "", "  ")
+}
+
+func curveParams(c elliptic.Curve) (string, int, error) {
+	switch c {
+	case elliptic.P256():
+		return "P-256", 32, nil
+	case elliptic.P384():
+		return "P-384", 48, nil
+	case elliptic.P521():
+		return "P-521", 66, nil
+	}
+	return "", 0, fmt.Errorf("jose: unsupported curve %v", c.Params().Name)
+}
+
+// fixedAlg maps the scheme a TPM key is restricted to onto its JWS alg.
+func fixedAlg(public *tpm2.TPMTPublic) (string, error) {
+	for _, a := range algs {
+		if a.matches(public) && a.fixed(public) {
+			return a.name, nil
+		}
+	}
+	return "", fmt.Errorf("jose: key has no fixed JWS scheme")
+}
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
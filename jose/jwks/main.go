@@ -0,0 +1,45 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/ibiscum/tpm2/jose"
+)
+
+var (
+	out = flag.String("out", "", "file to write the JWKS to (default: stdout)")
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [-out jwks.json] keyfile.pem...\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	set, err := jose.ReadJWKS(flag.Args()...)
+	if err != nil {
+		log.Fatalf("can't build JWKS: %v", err)
+	}
+	b, err := set.Marshal()
+	if err != nil {
+		log.Fatalf("can't encode JWKS: %v", err)
+	}
+	b = append(b, '\n')
+
+	if *out == "" {
+		os.Stdout.Write(b)
+		return
+	}
+	if err := os.WriteFile(*out, b, 0644); err != nil {
+		log.Fatalf("can't write JWKS: %v", err)
+	}
+}
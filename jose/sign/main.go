@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"slices"
+	"time"
+
+	keyfile "github.com/foxboron/go-tpm-keyfiles"
+	"github.com/google/go-tpm-tools/simulator"
+	"github.com/google/go-tpm/tpm2/transport"
+	"github.com/google/go-tpm/tpmutil"
+	"github.com/ibiscum/tpm2/jose"
+	"github.com/ibiscum/tpm2/tpmkey"
+)
+
+var (
+	tpmPath  = flag.String("tpm-path", "/dev/tpmrm0", "Path to the TPM device (character device or a Unix socket).")
+	in       = flag.String("in", "key.pem", "keyfile of the signing key")
+	password = flag.String("password", "", "optional key password")
+	alg      = flag.String("alg", "", "RS256, PS256, ES256 or ES384 (default: picked from the key)")
+	claims   = flag.String("claims", `{"sub":"test"}`, "JWT claims as JSON; iat and exp are added when missing")
+	lifetime = flag.Duration("lifetime", time.Hour, "JWT lifetime used for exp")
+)
+
+var TPMDEVICES = []string{"/dev/tpm0", "/dev/tpmrm0"}
+
+func OpenTPM(path string) (io.ReadWriteCloser, error) {
+	if slices.Contains(TPMDEVICES, path) {
+		return tpmutil.OpenTPM(path)
+	} else if path == "simulator" {
+		return simulator.GetWithFixedSeedInsecure(1073741825)
+	} else {
+		return net.Dial("tcp", path)
+	}
+}
+
+func main() {
+	flag.Parse()
+
+	c := map[string]any{}
+	if err := json.Unmarshal([]byte(*claims), &c); err != nil {
+		log.Fatalf("bad claims: %v", err)
+	}
+	now := time.Now()
+	if _, ok := c["iat"]; !ok {
+		c["iat"] = now.Unix()
+	}
+	if _, ok := c["exp"]; !ok {
+		c["exp"] = now.Add(*lifetime).Unix()
+	}
+
+	b, err := os.ReadFile(*in)
+	if err != nil {
+		log.Fatalf("can't read keyfile: %v", err)
+	}
+	kf, err := keyfile.Decode(b)
+	if err != nil {
+		log.Fatalf("can't decode keyfile: %v", err)
+	}
+
+	rwc, err := OpenTPM(*tpmPath)
+	if err != nil {
+		log.Fatalf("can't open TPM %q: %v", *tpmPath, err)
+	}
+	defer func() {
+		rwc.Close()
+	}()
+
+	rwr := transport.FromReadWriter(rwc)
+
+	k, err := tpmkey.Load(rwr, kf, []byte(*password))
+	if err != nil {
+		log.Fatalf("can't load key: %v", err)
+	}
+	defer k.Close()
+
+	s, err := jose.NewSigner(k, *alg)
+	if err != nil {
+		log.Fatalf("can't create signer: %v", err)
+	}
+
+	token, err := s.SignJWT(c)
+	if err != nil {
+		log.Fatalf("can't sign: %v", err)
+	}
+
+	// check the TPM signature the way a relying party would
+	pub, err := s.JWK().PublicKey()
+	if err != nil {
+		log.Fatalf("can't read public key: %v", err)
+	}
+	if _, _, err := jose.Verify(token, pub); err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	log.Printf("signed %s JWT, kid %s", s.Alg(), s.JWK().Kid)
+	fmt.Println(token)
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/hex"
+	"flag"
+	"io"
+	"log"
+	"net"
+	"os"
+	"slices"
+	"strconv"
+
+	keyfile "github.com/foxboron/go-tpm-keyfiles"
+	"github.com/google/go-tpm-tools/simulator"
+	"github.com/google/go-tpm/tpm2"
+	"github.com/google/go-tpm/tpm2/transport"
+	"github.com/google/go-tpm/tpmutil"
+	"github.com/ibiscum/tpm2/keyimport"
+)
+
+var (
+	tpmPath  = flag.String("tpm-path", "/dev/tpmrm0", "Path to the TPM device (character device or a Unix socket).")
+	in       = flag.String("in", "", "key to import: PEM or DER private key, JWK, or the raw secret with --type hmac|aes|sealed")
+	keyType  = flag.String("type", "auto", "auto (private key or JWK), hmac, aes or sealed")
+	hash     = flag.String("hash", "sha256", "HMAC hash: sha1, sha256, sha384 or sha512 (JWKs with an HS alg bring their own)")
+	password = flag.String("password", "", "optional password of the imported key")
+	parent   = flag.String("parent", "0x40000001", "parent: a hierarchy (its H-2 SRK) or a persistent storage key")
+	wrap     = flag.Bool("wrap", false, "encrypt the key to the parent (outer seed wrapper plus inner AES wrapper) before it is sent to the TPM")
+	out      = flag.String("out", "", "TSS2 keyfile to write")
+)
+
+var TPMDEVICES = []string{"/dev/tpm0", "/dev/tpmrm0"}
+
+func OpenTPM(path string) (io.ReadWriteCloser, error) {
+	if slices.Contains(TPMDEVICES, path) {
+		return tpmutil.OpenTPM(path)
+	} else if path == "simulator" {
+		return simulator.GetWithFixedSeedInsecure(1073741825)
+	} else {
+		return net.Dial("tcp", path)
+	}
+}
+
+func main() {
+	flag.Parse()
+
+	if *in == "" || *out == "" {
+		log.Fatalf("--in and --out are required")
+	}
+	hashes := map[string]tpm2.TPMAlgID{
+		"sha1":   tpm2.TPMAlgSHA1,
+		"sha256": tpm2.TPMAlgSHA256,
+		"sha384": tpm2.TPMAlgSHA384,
+		"sha512": tpm2.TPMAlgSHA512,
+	}
+	h, ok := hashes[*hash]
+	if !ok {
+		log.Fatalf("unknown hash %q", *hash)
+	}
+	p, err := strconv.ParseUint(*parent, 0, 32)
+	if err != nil {
+		log.Fatalf("bad parent %q: %v", *parent, err)
+	}
+
+	data, err := os.ReadFile(*in)
+	if err != nil {
+		log.Fatalf("can't read key: %v", err)
+	}
+	k, err := keyimport.Parse(data, *keyType, h, []byte(*password))
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	rwc, err := OpenTPM(*tpmPath)
+	if err != nil {
+		log.Fatalf("can't open TPM %q: %v", *tpmPath, err)
+	}
+	defer func() {
+		rwc.Close()
+	}()
+
+	rwr := transport.FromReadWriter(rwc)
+
+	kf, err := keyimport.Import(rwr, tpm2.TPMHandle(p), k, *wrap)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	name, err := tpm2.ObjectName(&k.Public)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	f, err := os.OpenFile(*out, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		log.Fatalf("can't create keyfile: %v", err)
+	}
+	defer f.Close()
+	if err := keyfile.Encode(f, kf); err != nil {
+		log.Fatalf("can't write keyfile: %v", err)
+	}
+	log.Printf("imported %s key, name %s, wrote %s", keyAlg(&k.Public), hex.EncodeToString(name.Buffer), *out)
+}
+
+func keyAlg(pub *tpm2.TPMTPublic) string {
+	switch pub.Type {
+	case tpm2.TPMAlgRSA:
+		return "RSA"
+	case tpm2.TPMAlgECC:
+		return "ECC"
+	case tpm2.TPMAlgKeyedHash:
+		if !pub.ObjectAttributes.SignEncrypt {
+			return "sealed"
+		}
+		return "HMAC"
+	case tpm2.TPMAlgSymCipher:
+		return "AES"
+	}
+	return "unknown"
+}
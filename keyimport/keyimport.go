@@ -0,0 +1,278 @@
+// Package keyimport brings externally generated keys into the TPM: RSA and
+// EC private keys (P-256, P-384, P-521), raw HMAC secrets and AES keys, read
+// from PEM, DER or JWK.  The key is converted to a TPM public and sensitive
+// area and imported under a storage parent with TPM2_Import, giving a
+// loadable TSS2 keyfile.
+//
+// By default the sensitive area goes to the TPM in the clear, as
+// tpm_import_external_rsa and tpmkey.ImportRSA do, which is fine on a
+// trusted host talking to a local TPM.  With wrapping it is encrypted to the
+// parent first (tpmwrap), with an inner AES wrapper on top, so nothing on
+// the way to the TPM sees the key.
+package keyimport
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"fmt"
+
+	keyfile "github.com/foxboron/go-tpm-keyfiles"
+	"github.com/google/go-tpm/tpm2"
+	"github.com/google/go-tpm/tpm2/transport"
+	"github.com/ibiscum/tpm2/tpmkey"
+	"github.com/ibiscum/tpm2/tpmwrap"
+)
+
+// Key is an external key in TPM form.
+type Key struct {
+	Public    tpm2.TPMTPublic
+	Sensitive tpm2.TPMTSensitive
+}
+
+// FromPrivateKey converts an *rsa.PrivateKey or *ecdsa.PrivateKey.  The key
+// gets no fixed scheme, so it can sign with any scheme and hash and decrypt
+// (RSA) or do ECDH (ECC).
+func FromPrivateKey(priv crypto.PrivateKey, auth []byte) (*Key, error) {
+	attrs := tpm2.TPMAObject{
+		UserWithAuth: true,
+		SignEncrypt:  true,
+		Decrypt:      true,
+	}
+	switch p := priv.(type) {
+	case *rsa.PrivateKey:
+		if len(p.Primes) != 2 {
+			return nil, fmt.Errorf("keyimport: multi-prime RSA keys aren't supported")
+		}
+		exp := uint32(p.E)
+		if exp == 65537 {
+			// 0 is the TPM's encoding for the default exponent
+			exp = 0
+		}
+		return &Key{
+			Public: tpm2.TPMTPublic{
+				Type:             tpm2.TPMAlgRSA,
+				NameAlg:          tpm2.TPMAlgSHA256,
+				ObjectAttributes: attrs,
+				Parameters: tpm2.NewTPMUPublicParms(tpm2.TPMAlgRSA,
+					&tpm2.TPMSRSAParms{
+						Symmetric: tpm2.TPMTSymDefObject{Algorithm: tpm2.TPMAlgNull},
+						Scheme:    tpm2.TPMTRSAScheme{Scheme: tpm2.TPMAlgNull},
+						Exponent:  exp,
+						KeyBits:   tpm2.TPMKeyBits(p.N.BitLen()),
+					}),
+				Unique: tpm2.NewTPMUPublicID(tpm2.TPMAlgRSA,
+					&tpm2.TPM2BPublicKeyRSA{Buffer: p.N.Bytes()}),
+			},
+			Sensitive: tpm2.TPMTSensitive{
+				SensitiveType: tpm2.TPMAlgRSA,
+				AuthValue:     tpm2.TPM2BAuth{Buffer: auth},
+				Sensitive: tpm2.NewTPMUSensitiveComposite(tpm2.TPMAlgRSA,
+					&tpm2.TPM2BPrivateKeyRSA{Buffer: p.Primes[0].Bytes()}),
+			},
+		}, nil
+	case *ecdsa.PrivateKey:
+		curves := map[elliptic.Curve]tpm2.TPMECCCurve{
+			elliptic.P256(): tpm2.TPMECCNistP256,
+			elliptic.P384(): tpm2.TPMECCNistP384,
+			elliptic.P521(): tpm2.TPMECCNistP521,
+		}
+		curve, ok := curves[p.Curve]
+		if !ok {
+			return nil, fmt.Errorf("keyimport: unsupported curve %s", p.Curve.Params().Name)
+		}
+		ecdhPriv, err := p.ECDH()
+		if err != nil {
+			return nil, fmt.Errorf("keyimport: bad EC key: %v", err)
+		}
+		// uncompressed point, 0x04 || X || Y
+		point := ecdhPriv.PublicKey().Bytes()
+		size := (len(point) - 1) / 2
+		return &Key{
+			Public: tpm2.TPMTPublic{
+				Type:             tpm2.TPMAlgECC,
+				NameAlg:          tpm2.TPMAlgSHA256,
+				ObjectAttributes: attrs,
+				Parameters: tpm2.NewTPMUPublicParms(tpm2.TPMAlgECC,
+					&tpm2.TPMSECCParms{
+						Symmetric: tpm2.TPMTSymDefObject{Algorithm: tpm2.TPMAlgNull},
+						Scheme:    tpm2.TPMTECCScheme{Scheme: tpm2.TPMAlgNull},
+						CurveID:   curve,
+						KDF:       tpm2.TPMTKDFScheme{Scheme: tpm2.TPMAlgNull},
+					}),
+				Unique: tpm2.NewTPMUPublicID(tpm2.TPMAlgECC,
+					&tpm2.TPMSECCPoint{
+						X: tpm2.TPM2BECCParameter{Buffer: point[1 : 1+size]},
+						Y: tpm2.TPM2BECCParameter{Buffer: point[1+size:]},
+					}),
+			},
+			Sensitive: tpm2.TPMTSensitive{
+				SensitiveType: tpm2.TPMAlgECC,
+				AuthValue:     tpm2.TPM2BAuth{Buffer: auth},
+				Sensitive: tpm2.NewTPMUSensitiveComposite(tpm2.TPMAlgECC,
+					&tpm2.TPM2BECCParameter{Buffer: ecdhPriv.Bytes()}),
+			},
+		}, nil
+	}
+	return nil, fmt.Errorf("keyimport: unsupported key type %T", priv)
+}
+
+// HMACKey converts an HMAC secret for use with hash.
+func HMACKey(secret []byte, hash tpm2.TPMAlgID, auth []byte) (*Key, error) {
+	if len(secret) == 0 {
+		return nil, fmt.Errorf("keyimport: empty HMAC secret")
+	}
+	public := tpm2.TPMTPublic{
+		Type:    tpm2.TPMAlgKeyedHash,
+		NameAlg: tpm2.TPMAlgSHA256,
+		ObjectAttributes: tpm2.TPMAObject{
+			UserWithAuth: true,
+			SignEncrypt:  true,
+		},
+		Parameters: tpm2.NewTPMUPublicParms(tpm2.TPMAlgKeyedHash,
+			&tpm2.TPMSKeyedHashParms{
+				Scheme: tpm2.TPMTKeyedHashScheme{
+					Scheme: tpm2.TPMAlgHMAC,
+					Details: tpm2.NewTPMUSchemeKeyedHash(tpm2.TPMAlgHMAC,
+						&tpm2.TPMSSchemeHMAC{HashAlg: hash}),
+				},
+			}),
+	}
+	return symmetric(public, tpm2.NewTPMUSensitiveComposite(tpm2.TPMAlgKeyedHash,
+		&tpm2.TPM2BSensitiveData{Buffer: secret}), secret, auth)
+}
+
+// AESKey converts a 128, 192 or 256 bit AES key.  No mode is fixed, the
+// caller of TPM2_EncryptDecrypt picks it.
+func AESKey(key []byte, auth []byte) (*Key, error) {
+	switch len(key) {
+	case 16, 24, 32:
+	default:
+		return nil, fmt.Errorf("keyimport: AES keys are 16, 24 or 32 bytes, not %d", len(key))
+	}
+	public := tpm2.TPMTPublic{
+		Type:    tpm2.TPMAlgSymCipher,
+		NameAlg: tpm2.TPMAlgSHA256,
+		ObjectAttributes: tpm2.TPMAObject{
+			UserWithAuth: true,
+			SignEncrypt:  true,
+			Decrypt:      true,
+		},
+		Parameters: tpm2.NewTPMUPublicParms(tpm2.TPMAlgSymCipher,
+			&tpm2.TPMSSymCipherParms{
+				Sym: tpm2.TPMTSymDefObject{
+					Algorithm: tpm2.TPMAlgAES,
+					KeyBits:   tpm2.NewTPMUSymKeyBits(tpm2.TPMAlgAES, tpm2.TPMKeyBits(len(key)*8)),
+					Mode:      tpm2.NewTPMUSymMode(tpm2.TPMAlgAES, tpm2.TPMAlgNull),
+				},
+			}),
+	}
+	return symmetric(public, tpm2.NewTPMUSensitiveComposite(tpm2.TPMAlgSymCipher,
+		&tpm2.TPM2BSymKey{Buffer: key}), key, auth)
+}
+
+// SealedData converts a secret to a sealed data object, which only
+// TPM2_Unseal reads back, like tpmkey.Seal makes inside the TPM.
+func SealedData(data []byte, auth []byte) (*Key, error) {
+	if len(data) == 0 || len(data) > 128 {
+		return nil, fmt.Errorf("keyimport: sealed data is 1 to 128 bytes, not %d", len(data))
+	}
+	public := tpmkey.SealTemplate(tpm2.TPMAlgSHA256)
+	public.ObjectAttributes.FixedTPM = false
+	public.ObjectAttributes.FixedParent = false
+	public.ObjectAttributes.NoDA = len(auth) == 0
+	return symmetric(public, tpm2.NewTPMUSensitiveComposite(tpm2.TPMAlgKeyedHash,
+		&tpm2.TPM2BSensitiveData{Buffer: data}), data, auth)
+}
+
+// symmetric fills in the obfuscation value and the unique field, which for
+// keyed-hash and symmetric objects is H_nameAlg(seedValue || key).
+func symmetric(public tpm2.TPMTPublic, composite tpm2.TPMUSensitiveComposite, key, auth []byte) (*Key, error) {
+	h, err := public.NameAlg.Hash()
+	if err != nil {
+		return nil, err
+	}
+	seed := make([]byte, h.Size())
+	if _, err := rand.Read(seed); err != nil {
+		return nil, err
+	}
+	digest := h.New()
+	digest.Write(seed)
+	digest.Write(key)
+	unique := &tpm2.TPM2BDigest{Buffer: digest.Sum(nil)}
+	if public.Type == tpm2.TPMAlgKeyedHash {
+		public.Unique = tpm2.NewTPMUPublicID(tpm2.TPMAlgKeyedHash, unique)
+	} else {
+		public.Unique = tpm2.NewTPMUPublicID(tpm2.TPMAlgSymCipher, unique)
+	}
+	return &Key{
+		Public: public,
+		Sensitive: tpm2.TPMTSensitive{
+			SensitiveType: public.Type,
+			AuthValue:     tpm2.TPM2BAuth{Buffer: auth},
+			SeedValue:     tpm2.TPM2BDigest{Buffer: seed},
+			Sensitive:     composite,
+		},
+	}, nil
+}
+
+// Import imports k under parent, a hierarchy (its H-2 ECC SRK is used, see
+// tpmkey.Parent), a persistent storage key or an EK, and returns a loadable
+// keyfile naming that parent.  With wrap the key is wrapped to the parent in
+// software with inner and outer wrappers first.
+func Import(rwr transport.TPM, parent tpm2.TPMHandle, k *Key, wrap bool) (*keyfile.TPMKey, error) {
+	p, closer, err := tpmkey.Parent(rwr, parent)
+	if err != nil {
+		return nil, err
+	}
+	defer closer()
+	parentAuth, err := tpmkey.ParentAuth(rwr, p)
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := tpm2.Import{
+		ParentHandle: tpm2.AuthHandle{
+			Handle: p.Handle,
+			Name:   p.Name,
+			Auth:   parentAuth,
+		},
+		ObjectPublic: tpm2.New2B(k.Public),
+		Symmetric:    tpm2.TPMTSymDef{Algorithm: tpm2.TPMAlgNull},
+	}
+	if wrap {
+		rsp, err := tpm2.ReadPublic{ObjectHandle: p.Handle}.Execute(rwr)
+		if err != nil {
+			return nil, fmt.Errorf("keyimport: can't read parent: %v", err)
+		}
+		parentPub, err := rsp.OutPublic.Contents()
+		if err != nil {
+			return nil, err
+		}
+		blob, err := tpmwrap.Wrap(parentPub, &k.Public, &k.Sensitive, true)
+		if err != nil {
+			return nil, err
+		}
+		cmd.Duplicate = blob.Duplicate
+		cmd.InSymSeed = blob.Seed
+		cmd.EncryptionKey = tpm2.TPM2BData{Buffer: blob.EncryptionKey}
+		cmd.Symmetric = blob.Symmetric()
+	} else {
+		cmd.Duplicate = tpm2.TPM2BPrivate{Buffer: tpm2.Marshal(tpm2.New2B(k.Sensitive))}
+	}
+	rsp, err := cmd.Execute(rwr)
+	if err != nil {
+		return nil, fmt.Errorf("keyimport: can't import key: %v", err)
+	}
+
+	oid := keyfile.OIDLoadableKey
+	if k.Public.Type == tpm2.TPMAlgKeyedHash && !k.Public.ObjectAttributes.SignEncrypt {
+		oid = keyfile.OIDSealedKey
+	}
+	return keyfile.NewTPMKey(oid, tpm2.New2B(k.Public), rsp.OutPrivate,
+		keyfile.WithParent(parent),
+		keyfile.WithUserAuth(k.Sensitive.AuthValue.Buffer),
+	), nil
+}
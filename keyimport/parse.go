@@ -0,0 +1,188 @@
+package keyimport
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/google/go-tpm/tpm2"
+	"github.com/ibiscum/tpm2/jose"
+)
+
+// Key types for Parse.
+const (
+	TypeAuto = "auto"
+	TypeHMAC = "hmac"
+	TypeAES  = "aes"
+	// TypeSealed is a secret to seal as is, whatever it looks like.
+	TypeSealed = "sealed"
+)
+
+// Parse converts the key in data.  With TypeAuto data is a PEM or DER
+// PKCS#8, PKCS#1 or SEC1 private key or a JWK (RSA, EC, or oct with an
+// HS256/384/512 or A128/192/256 alg).  With TypeHMAC or TypeAES data is an
+// oct JWK or the raw secret.  hash is the HMAC hash when the JWK doesn't
+// name one.  With TypeSealed data is the secret itself.
+func Parse(data []byte, typ string, hash tpm2.TPMAlgID, auth []byte) (*Key, error) {
+	if typ == TypeSealed {
+		return SealedData(data, auth)
+	}
+	if j, ok := parseJWK(data); ok {
+		return j.key(typ, hash, auth)
+	}
+	switch typ {
+	case TypeHMAC:
+		return HMACKey(data, hash, auth)
+	case TypeAES:
+		return AESKey(data, auth)
+	case TypeAuto:
+	default:
+		return nil, fmt.Errorf("keyimport: unknown key type %q", typ)
+	}
+
+	priv, err := ParsePrivateKey(data)
+	if err != nil {
+		return nil, err
+	}
+	return FromPrivateKey(priv, auth)
+}
+
+// ParsePrivateKey reads a PEM or DER PKCS#8, SEC1 or PKCS#1 private key.
+// Encrypted PEM keys are refused.
+func ParsePrivateKey(data []byte) (crypto.PrivateKey, error) {
+	der := data
+	if block, _ := pem.Decode(data); block != nil {
+		if strings.Contains(block.Type, "ENCRYPTED") || block.Headers["Proc-Type"] != "" {
+			return nil, fmt.Errorf("keyimport: encrypted private keys aren't supported, decrypt with openssl first")
+		}
+		der = block.Bytes
+	}
+	if k, err := x509.ParsePKCS8PrivateKey(der); err == nil {
+		return k, nil
+	}
+	if k, err := x509.ParseECPrivateKey(der); err == nil {
+		return k, nil
+	}
+	if k, err := x509.ParsePKCS1PrivateKey(der); err == nil {
+		return k, nil
+	}
+	return nil, fmt.Errorf("keyimport: not a PKCS#8, SEC1 or PKCS#1 private key")
+}
+
+// privateJWK is a JWK with its private members.
+type privateJWK struct {
+	jose.JWK
+	D string `json:"d,omitempty"`
+	P string `json:"p,omitempty"`
+	Q string `json:"q,omitempty"`
+	K string `json:"k,omitempty"`
+}
+
+func parseJWK(data []byte) (*privateJWK, bool) {
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 || data[0] != '{' {
+		return nil, false
+	}
+	var j privateJWK
+	if err := json.Unmarshal(data, &j); err != nil || j.Kty == "" {
+		return nil, false
+	}
+	return &j, true
+}
+
+func (j *privateJWK) key(typ string, hash tpm2.TPMAlgID, auth []byte) (*Key, error) {
+	if j.Kty != "oct" {
+		if typ != TypeAuto {
+			return nil, fmt.Errorf("keyimport: %s JWK isn't an %s key", j.Kty, typ)
+		}
+		priv, err := j.privateKey()
+		if err != nil {
+			return nil, err
+		}
+		return FromPrivateKey(priv, auth)
+	}
+
+	k, err := b64(j.K, "k")
+	if err != nil {
+		return nil, err
+	}
+	hashes := map[string]tpm2.TPMAlgID{
+		"HS256": tpm2.TPMAlgSHA256,
+		"HS384": tpm2.TPMAlgSHA384,
+		"HS512": tpm2.TPMAlgSHA512,
+	}
+	if h, ok := hashes[j.Alg]; ok {
+		if typ == TypeAES {
+			return nil, fmt.Errorf("keyimport: JWK is an %s key, not AES", j.Alg)
+		}
+		return HMACKey(k, h, auth)
+	}
+	if typ == TypeAES || (typ == TypeAuto && strings.HasPrefix(j.Alg, "A")) {
+		return AESKey(k, auth)
+	}
+	if typ == TypeHMAC {
+		return HMACKey(k, hash, auth)
+	}
+	return nil, fmt.Errorf("keyimport: oct JWK with alg %q, the key type (hmac or aes) has to be given", j.Alg)
+}
+
+func (j *privateJWK) privateKey() (crypto.PrivateKey, error) {
+	pub, err := j.PublicKey()
+	if err != nil {
+		return nil, err
+	}
+	d, err := b64(j.D, "d")
+	if err != nil {
+		return nil, err
+	}
+	switch p := pub.(type) {
+	case *rsa.PublicKey:
+		prime1, err := b64(j.P, "p")
+		if err != nil {
+			return nil, err
+		}
+		prime2, err := b64(j.Q, "q")
+		if err != nil {
+			return nil, err
+		}
+		k := &rsa.PrivateKey{
+			PublicKey: *p,
+			D:         new(big.Int).SetBytes(d),
+			Primes:    []*big.Int{new(big.Int).SetBytes(prime1), new(big.Int).SetBytes(prime2)},
+		}
+		if err := k.Validate(); err != nil {
+			return nil, fmt.Errorf("keyimport: bad RSA JWK: %v", err)
+		}
+		k.Precompute()
+		return k, nil
+	case *ecdsa.PublicKey:
+		k := &ecdsa.PrivateKey{PublicKey: *p, D: new(big.Int).SetBytes(d)}
+		// d has to go with x and y
+		ecdhPriv, err := k.ECDH()
+		if err != nil {
+			return nil, fmt.Errorf("keyimport: bad EC JWK: %v", err)
+		}
+		ecdhPub, err := p.ECDH()
+		if err != nil || !ecdhPriv.PublicKey().Equal(ecdhPub) {
+			return nil, fmt.Errorf("keyimport: EC JWK d doesn't match x and y")
+		}
+		return k, nil
+	}
+	return nil, fmt.Errorf("keyimport: unsupported JWK")
+}
+
+func b64(s, member string) ([]byte, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(b) == 0 {
+		return nil, fmt.Errorf("keyimport: JWK has a missing or bad %q", member)
+	}
+	return b, nil
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+
+	"github.com/ibiscum/tpm2/luks"
+)
+
+var (
+	image = flag.String("image", "luks.img", "LUKS2 device, image or detached header")
+)
+
+func main() {
+	flag.Parse()
+
+	f, err := os.Open(*image)
+	if err != nil {
+		log.Fatalf("can't open image: %v", err)
+	}
+	defer f.Close()
+
+	h, err := luks.ReadHeader(f)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	fmt.Printf("UUID:     %s\n", h.UUID)
+	fmt.Printf("Epoch:    %d\n", h.SeqID)
+	fmt.Printf("Keyslots:\n")
+	var ids []string
+	for id := range h.Metadata.Keyslots {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		ks, err := h.Keyslot(id)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		fmt.Printf("  %s: %s, %s, area %d+%d\n", id, ks.KDF.Type, ks.Area.Encryption, ks.Area.Offset, ks.Area.Size)
+	}
+	fmt.Printf("Tokens:\n")
+	for _, id := range h.TokenIDs("") {
+		typ := h.TokenType(id)
+		if typ != luks.TokenTypeTPM2 {
+			fmt.Printf("  %s: %s\n", id, typ)
+			continue
+		}
+		t, err := h.TPM2Token(id)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		fmt.Printf("  %s: %s\n", id, typ)
+		fmt.Printf("\tkeyslots:     %v\n", t.Keyslots)
+		fmt.Printf("\tpcrs:         %s:%v\n", t.PCRBank, t.PCRs)
+		fmt.Printf("\tprimary:      %s\n", t.PrimaryAlg)
+		fmt.Printf("\tpolicy hash:  %x\n", []byte(t.PolicyHash))
+		fmt.Printf("\tpin:          %v\n", t.PIN)
+		fmt.Printf("\tblob:         %d bytes\n", len(t.Blob))
+	}
+}
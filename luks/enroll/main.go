@@ -0,0 +1,106 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"log"
+	"net"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/google/go-tpm-tools/simulator"
+	"github.com/google/go-tpm/tpm2/transport"
+	"github.com/google/go-tpm/tpmutil"
+	"github.com/ibiscum/tpm2/luks"
+)
+
+var (
+	tpmPath    = flag.String("tpm-path", "/dev/tpmrm0", "Path to the TPM device (character device or a Unix socket).")
+	image      = flag.String("image", "luks.img", "LUKS2 device, image or detached header")
+	passphrase = flag.String("passphrase", "", "passphrase of an existing keyslot")
+	pcrs       = flag.String("pcrs", "7", "comma separated PCRs to seal to")
+	bank       = flag.String("pcr-bank", "sha256", "PCR bank: sha256 or sha1")
+	pin        = flag.String("pin", "", "optional PIN needed to unlock as well")
+	wipe       = flag.Bool("wipe", false, "remove the existing systemd-tpm2 tokens and their keyslots first")
+)
+
+var TPMDEVICES = []string{"/dev/tpm0", "/dev/tpmrm0"}
+
+func OpenTPM(path string) (io.ReadWriteCloser, error) {
+	if slices.Contains(TPMDEVICES, path) {
+		return tpmutil.OpenTPM(path)
+	} else if path == "simulator" {
+		return simulator.GetWithFixedSeedInsecure(1073741825)
+	} else {
+		return net.Dial("tcp", path)
+	}
+}
+
+func main() {
+	flag.Parse()
+
+	if *passphrase == "" {
+		log.Fatalf("--passphrase is required")
+	}
+	var pcrList []uint
+	if *pcrs != "" {
+		for _, s := range strings.Split(*pcrs, ",") {
+			i, err := strconv.ParseUint(s, 10, 32)
+			if err != nil {
+				log.Fatalf("bad pcr %q: %v", s, err)
+			}
+			pcrList = append(pcrList, uint(i))
+		}
+	}
+	var pinBytes []byte
+	if *pin != "" {
+		pinBytes = []byte(*pin)
+	}
+
+	f, err := os.OpenFile(*image, os.O_RDWR, 0)
+	if err != nil {
+		log.Fatalf("can't open image: %v", err)
+	}
+	defer f.Close()
+
+	if *wipe {
+		h, err := luks.ReadHeader(f)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		for _, id := range h.TokenIDs(luks.TokenTypeTPM2) {
+			t, err := h.TPM2Token(id)
+			if err != nil {
+				log.Fatalf("%v", err)
+			}
+			for _, ks := range t.Keyslots {
+				if err := h.RemoveKeyslot(f, ks); err != nil {
+					log.Fatalf("%v", err)
+				}
+				log.Printf("wiped keyslot %s", ks)
+			}
+			delete(h.Metadata.Tokens, id)
+		}
+		if err := h.Write(f); err != nil {
+			log.Fatalf("%v", err)
+		}
+	}
+
+	rwc, err := OpenTPM(*tpmPath)
+	if err != nil {
+		log.Fatalf("can't open TPM %q: %v", *tpmPath, err)
+	}
+	defer func() {
+		rwc.Close()
+	}()
+
+	rwr := transport.FromReadWriter(rwc)
+
+	slot, token, err := luks.EnrollTPM2(rwr, f, []byte(*passphrase), pcrList, *bank, pinBytes)
+	if err != nil {
+		log.Fatalf("can't enroll: %v", err)
+	}
+	log.Printf("enrolled keyslot %s, token %s", slot, token)
+}
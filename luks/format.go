@@ -0,0 +1,93 @@
+package luks
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+)
+
+// the layout cryptsetup luksFormat picks by default
+const (
+	defaultHdrSize       = 0x4000
+	defaultKeyslotsSize  = 16<<20 - 2*defaultHdrSize
+	defaultSegmentOffset = 16 << 20
+	volumeKeySize        = 64
+)
+
+// Format writes a new LUKS2 header (aes-xts-plain64, 512 bit volume key)
+// with one keyslot opened by passphrase, laid out as cryptsetup luksFormat
+// does: the data segment starts at 16 MiB.  It is meant for test images; the
+// data segment is not touched, so d holds an empty but valid volume.
+func Format(d Device, passphrase []byte, kdf KDF) (*Header, error) {
+	vk := make([]byte, volumeKeySize)
+	if _, err := rand.Read(vk); err != nil {
+		return nil, err
+	}
+	digestSalt := make([]byte, 32)
+	if _, err := rand.Read(digestSalt); err != nil {
+		return nil, err
+	}
+	dk, err := (&KDF{Type: "pbkdf2", Hash: "sha256", Iterations: 1000, Salt: digestSalt}).derive(vk, 32)
+	if err != nil {
+		return nil, err
+	}
+
+	segment, err := json.Marshal(map[string]any{
+		"type":        "crypt",
+		"offset":      fmt.Sprint(defaultSegmentOffset),
+		"size":        "dynamic",
+		"iv_tweak":    "0",
+		"encryption":  encryption,
+		"sector_size": sectorSize,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	h := &Header{
+		HdrSize: defaultHdrSize,
+		UUID:    uuid(),
+		Metadata: Metadata{
+			Keyslots: map[string]json.RawMessage{},
+			Tokens:   map[string]json.RawMessage{},
+			Segments: map[string]json.RawMessage{
+				"0": segment,
+			},
+			Digests: map[string]*Digest{
+				"0": {
+					Type:       "pbkdf2",
+					Keyslots:   []string{},
+					Segments:   []string{"0"},
+					Hash:       "sha256",
+					Iterations: 1000,
+					Salt:       digestSalt,
+					Digest:     dk,
+				},
+			},
+			Config: Config{
+				JSONSize:     defaultHdrSize - binHeaderSize,
+				KeyslotsSize: defaultKeyslotsSize,
+			},
+		},
+	}
+	if _, err := rand.Read(h.salt[:]); err != nil {
+		return nil, err
+	}
+	if _, err := h.AddKeyslot(d, vk, passphrase, kdf); err != nil {
+		return nil, err
+	}
+	if err := h.Write(d); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+func uuid() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(err)
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
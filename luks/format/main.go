@@ -0,0 +1,50 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+
+	"github.com/ibiscum/tpm2/luks"
+)
+
+var (
+	image      = flag.String("image", "luks.img", "image file to create")
+	size       = flag.Int64("size", 32<<20, "image size in bytes; the data segment starts at 16 MiB")
+	passphrase = flag.String("passphrase", "", "passphrase of keyslot 0")
+	kdf        = flag.String("kdf", "argon2id", "keyslot KDF: argon2id or pbkdf2")
+)
+
+func main() {
+	flag.Parse()
+
+	if *passphrase == "" {
+		log.Fatalf("--passphrase is required")
+	}
+
+	var k luks.KDF
+	switch *kdf {
+	case "argon2id":
+		// far below what cryptsetup benchmarks to, this is a test image
+		k = luks.KDF{Type: "argon2id", Time: 4, Memory: 32 * 1024, CPUs: 1}
+	case "pbkdf2":
+		k = luks.KDF{Type: "pbkdf2", Hash: "sha256", Iterations: 100000}
+	default:
+		log.Fatalf("unknown KDF %q", *kdf)
+	}
+
+	f, err := os.OpenFile(*image, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		log.Fatalf("can't create image: %v", err)
+	}
+	defer f.Close()
+	if err := f.Truncate(*size); err != nil {
+		log.Fatalf("can't size image: %v", err)
+	}
+
+	h, err := luks.Format(f, []byte(*passphrase), k)
+	if err != nil {
+		log.Fatalf("can't format: %v", err)
+	}
+	log.Printf("formatted %s, UUID %s", *image, h.UUID)
+}
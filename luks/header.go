@@ -0,0 +1,256 @@
+// Package luks reads and writes LUKS2 headers: the binary header, the JSON
+// metadata area and the keyslots, enough to enroll and use a systemd-tpm2
+// token the way systemd-cryptenroll and systemd-cryptsetup do.
+//
+// Everything works on an io.ReaderAt/io.WriterAt, so a plain file holding a
+// LUKS2 header (cryptsetup --header, or a whole volume image) is enough; no
+// root, device mapper or block device is involved.  Only the header is
+// touched, the data segment is never read.
+//
+// See the LUKS2 on-disk format specification:
+// https://gitlab.com/cryptsetup/LUKS2-docs
+package luks
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// Device is where the header lives, typically an *os.File.
+type Device interface {
+	io.ReaderAt
+	io.WriterAt
+}
+
+const (
+	binHeaderSize = 4096
+
+	magicPrimary   = "LUKS\xba\xbe"
+	magicSecondary = "SKUL\xba\xbe"
+)
+
+// offsets of the binary header fields
+const (
+	offMagic     = 0
+	offVersion   = 6
+	offHdrSize   = 8
+	offSeqID     = 16
+	offLabel     = 24
+	offCsumAlg   = 72
+	offSalt      = 104
+	offUUID      = 168
+	offSubsystem = 208
+	offHdrOffset = 256
+	offCsum      = 448
+)
+
+// the header sizes cryptsetup allows; the secondary header is searched for
+// at each of them if the primary is damaged
+var hdrSizes = []uint64{0x4000, 0x8000, 0x10000, 0x20000, 0x40000, 0x80000, 0x100000, 0x200000, 0x400000}
+
+// Header is a LUKS2 header: the binary part of the primary copy and the
+// decoded JSON metadata.
+type Header struct {
+	HdrSize   uint64
+	SeqID     uint64
+	Label     string
+	Subsystem string
+	UUID      string
+	salt      [64]byte
+
+	Metadata Metadata
+}
+
+// Metadata is the JSON area.  Keyslots, tokens and segments are kept as raw
+// JSON so types this package doesn't know survive a rewrite.
+type Metadata struct {
+	Keyslots map[string]json.RawMessage `json:"keyslots"`
+	Tokens   map[string]json.RawMessage `json:"tokens"`
+	Segments map[string]json.RawMessage `json:"segments"`
+	Digests  map[string]*Digest         `json:"digests"`
+	Config   Config                     `json:"config"`
+}
+
+// Config is the "config" object of the metadata.
+type Config struct {
+	JSONSize     uint64          `json:"json_size,string"`
+	KeyslotsSize uint64          `json:"keyslots_size,string"`
+	Flags        []string        `json:"flags,omitempty"`
+	Requirements json.RawMessage `json:"requirements,omitempty"`
+}
+
+// Digest verifies a volume key, and so the keyslots it is linked to.
+type Digest struct {
+	Type       string   `json:"type"`
+	Keyslots   []string `json:"keyslots"`
+	Segments   []string `json:"segments"`
+	Hash       string   `json:"hash"`
+	Iterations int      `json:"iterations"`
+	Salt       []byte   `json:"salt"`
+	Digest     []byte   `json:"digest"`
+}
+
+// ReadHeader reads the LUKS2 header of d.  Of the two header copies the
+// valid one with the highest sequence id wins, as in cryptsetup.
+func ReadHeader(d io.ReaderAt) (*Header, error) {
+	primary, perr := readHeaderAt(d, 0, magicPrimary)
+	var secondary *Header
+	var serr error
+	if perr == nil {
+		secondary, serr = readHeaderAt(d, primary.HdrSize, magicSecondary)
+	} else {
+		for _, off := range hdrSizes {
+			if secondary, serr = readHeaderAt(d, off, magicSecondary); serr == nil {
+				break
+			}
+		}
+	}
+
+	switch {
+	case perr == nil && serr == nil:
+		if secondary.SeqID > primary.SeqID {
+			return secondary, nil
+		}
+		return primary, nil
+	case perr == nil:
+		return primary, nil
+	case serr == nil:
+		return secondary, nil
+	}
+	return nil, fmt.Errorf("luks: no valid LUKS2 header: %v", perr)
+}
+
+func readHeaderAt(d io.ReaderAt, off uint64, magic string) (*Header, error) {
+	bin := make([]byte, binHeaderSize)
+	if _, err := d.ReadAt(bin, int64(off)); err != nil {
+		return nil, fmt.Errorf("can't read header: %v", err)
+	}
+	if string(bin[offMagic:offMagic+6]) != magic {
+		return nil, fmt.Errorf("bad magic")
+	}
+	if v := binary.BigEndian.Uint16(bin[offVersion:]); v != 2 {
+		return nil, fmt.Errorf("unsupported LUKS version %d", v)
+	}
+	h := &Header{
+		HdrSize:   binary.BigEndian.Uint64(bin[offHdrSize:]),
+		SeqID:     binary.BigEndian.Uint64(bin[offSeqID:]),
+		Label:     cstring(bin[offLabel : offLabel+48]),
+		UUID:      cstring(bin[offUUID : offUUID+40]),
+		Subsystem: cstring(bin[offSubsystem : offSubsystem+48]),
+	}
+	copy(h.salt[:], bin[offSalt:offSalt+64])
+	if binary.BigEndian.Uint64(bin[offHdrOffset:]) != off {
+		return nil, fmt.Errorf("header offset mismatch")
+	}
+	if h.HdrSize <= binHeaderSize || h.HdrSize > hdrSizes[len(hdrSizes)-1] {
+		return nil, fmt.Errorf("bad header size %d", h.HdrSize)
+	}
+	if alg := cstring(bin[offCsumAlg : offCsumAlg+32]); alg != "sha256" {
+		return nil, fmt.Errorf("unsupported checksum algorithm %q", alg)
+	}
+
+	area := make([]byte, h.HdrSize-binHeaderSize)
+	if _, err := d.ReadAt(area, int64(off+binHeaderSize)); err != nil {
+		return nil, fmt.Errorf("can't read JSON area: %v", err)
+	}
+	sum := checksum(bin, area)
+	if !bytes.Equal(sum, bin[offCsum:offCsum+len(sum)]) {
+		return nil, fmt.Errorf("header checksum mismatch")
+	}
+
+	if i := bytes.IndexByte(area, 0); i >= 0 {
+		area = area[:i]
+	}
+	if err := json.Unmarshal(area, &h.Metadata); err != nil {
+		return nil, fmt.Errorf("bad JSON metadata: %v", err)
+	}
+	if h.Metadata.Config.JSONSize != h.HdrSize-binHeaderSize {
+		return nil, fmt.Errorf("json_size doesn't match the header size")
+	}
+	return h, nil
+}
+
+// checksum hashes the binary header, with the checksum field zeroed, and
+// the JSON area.
+func checksum(bin, area []byte) []byte {
+	b := append([]byte{}, bin...)
+	clear(b[offCsum : offCsum+64])
+	h := sha256.New()
+	h.Write(b)
+	h.Write(area)
+	return h.Sum(nil)
+}
+
+// Write writes both header copies with the next sequence id, primary first.
+func (h *Header) Write(d io.WriterAt) error {
+	js, err := json.Marshal(&h.Metadata)
+	if err != nil {
+		return fmt.Errorf("luks: can't encode metadata: %v", err)
+	}
+	areaSize := h.HdrSize - binHeaderSize
+	if uint64(len(js)) >= areaSize {
+		return fmt.Errorf("luks: metadata (%d bytes) doesn't fit the %d byte JSON area", len(js), areaSize)
+	}
+	area := make([]byte, areaSize)
+	copy(area, js)
+
+	h.SeqID++
+	for _, c := range []struct {
+		off   uint64
+		magic string
+	}{
+		{0, magicPrimary},
+		{h.HdrSize, magicSecondary},
+	} {
+		bin := make([]byte, binHeaderSize)
+		copy(bin[offMagic:], c.magic)
+		binary.BigEndian.PutUint16(bin[offVersion:], 2)
+		binary.BigEndian.PutUint64(bin[offHdrSize:], h.HdrSize)
+		binary.BigEndian.PutUint64(bin[offSeqID:], h.SeqID)
+		copy(bin[offLabel:offLabel+47], h.Label)
+		copy(bin[offCsumAlg:], "sha256")
+		copy(bin[offSalt:], h.salt[:])
+		copy(bin[offUUID:offUUID+39], h.UUID)
+		copy(bin[offSubsystem:offSubsystem+47], h.Subsystem)
+		binary.BigEndian.PutUint64(bin[offHdrOffset:], c.off)
+		copy(bin[offCsum:], checksum(bin, area))
+
+		if _, err := d.WriteAt(bin, int64(c.off)); err != nil {
+			return fmt.Errorf("luks: can't write header: %v", err)
+		}
+		if _, err := d.WriteAt(area, int64(c.off+binHeaderSize)); err != nil {
+			return fmt.Errorf("luks: can't write JSON area: %v", err)
+		}
+	}
+	return nil
+}
+
+// keyslotsOffset is where the keyslots area starts, right after the two
+// header copies.
+func (h *Header) keyslotsOffset() uint64 {
+	return 2 * h.HdrSize
+}
+
+// freeID returns the lowest unused id of a keyslot or token map; LUKS2 has
+// 32 of each.
+func freeID[T any](m map[string]T) (string, error) {
+	for i := 0; i < 32; i++ {
+		id := strconv.Itoa(i)
+		if _, ok := m[id]; !ok {
+			return id, nil
+		}
+	}
+	return "", fmt.Errorf("luks: no free slot")
+}
+
+func cstring(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	return string(b)
+}
@@ -0,0 +1,415 @@
+package luks
+
+import (
+	"crypto/aes"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"slices"
+	"sort"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/xts"
+)
+
+const (
+	sectorSize = 512
+	afStripes  = 4000
+	// keyslot areas are aligned like cryptsetup does
+	areaAlign = 4096
+	// the only keyslot cipher supported, the cryptsetup default
+	encryption = "aes-xts-plain64"
+)
+
+// ErrPassphrase is returned when no keyslot opens with a passphrase.
+var ErrPassphrase = errors.New("luks: no keyslot matches the passphrase")
+
+// Keyslot is a "luks2" keyslot: the volume key, anti-forensic split and
+// encrypted with a key derived from a passphrase.
+type Keyslot struct {
+	Type     string `json:"type"`
+	KeySize  int    `json:"key_size"`
+	AF       AF     `json:"af"`
+	Area     Area   `json:"area"`
+	KDF      KDF    `json:"kdf"`
+	Priority *int   `json:"priority,omitempty"`
+}
+
+// AF is the anti-forensic splitter of a keyslot.
+type AF struct {
+	Type    string `json:"type"`
+	Stripes int    `json:"stripes"`
+	Hash    string `json:"hash"`
+}
+
+// Area is where a keyslot's split key is stored.
+type Area struct {
+	Type       string `json:"type"`
+	Offset     uint64 `json:"offset,string"`
+	Size       uint64 `json:"size,string"`
+	Encryption string `json:"encryption"`
+	KeySize    int    `json:"key_size"`
+}
+
+// KDF derives the area key from the passphrase, pbkdf2 or argon2i/argon2id.
+type KDF struct {
+	Type       string `json:"type"`
+	Hash       string `json:"hash,omitempty"`
+	Iterations int    `json:"iterations,omitempty"`
+	Time       int    `json:"time,omitempty"`
+	Memory     int    `json:"memory,omitempty"`
+	CPUs       int    `json:"cpus,omitempty"`
+	Salt       []byte `json:"salt"`
+}
+
+// MinimalKDF is what systemd-cryptenroll uses for keyslots whose passphrase
+// is a random secret (TPM2, FIDO2, recovery keys): stretching it buys
+// nothing, so PBKDF2-SHA512 with the minimum iteration count.
+var MinimalKDF = KDF{
+	Type:       "pbkdf2",
+	Hash:       "sha512",
+	Iterations: 1000,
+}
+
+func hashFunc(name string) (func() hash.Hash, error) {
+	switch name {
+	case "sha1":
+		return sha1.New, nil
+	case "sha256":
+		return sha256.New, nil
+	case "sha512":
+		return sha512.New, nil
+	}
+	return nil, fmt.Errorf("luks: unsupported hash %q", name)
+}
+
+// Keyslot decodes a keyslot of the metadata.
+func (h *Header) Keyslot(id string) (*Keyslot, error) {
+	raw, ok := h.Metadata.Keyslots[id]
+	if !ok {
+		return nil, fmt.Errorf("luks: no keyslot %s", id)
+	}
+	var ks Keyslot
+	if err := json.Unmarshal(raw, &ks); err != nil {
+		return nil, fmt.Errorf("luks: bad keyslot %s: %v", id, err)
+	}
+	return &ks, nil
+}
+
+func (k *KDF) derive(passphrase []byte, size int) ([]byte, error) {
+	switch k.Type {
+	case "pbkdf2":
+		hf, err := hashFunc(k.Hash)
+		if err != nil {
+			return nil, err
+		}
+		return pbkdf2.Key(hf, string(passphrase), k.Salt, k.Iterations, size)
+	case "argon2i":
+		return argon2.Key(passphrase, k.Salt, uint32(k.Time), uint32(k.Memory), uint8(k.CPUs), uint32(size)), nil
+	case "argon2id":
+		return argon2.IDKey(passphrase, k.Salt, uint32(k.Time), uint32(k.Memory), uint8(k.CPUs), uint32(size)), nil
+	}
+	return nil, fmt.Errorf("luks: unsupported KDF %q", k.Type)
+}
+
+// OpenKeyslot tries passphrase on every keyslot, or only on the ids given,
+// and returns the volume key and the keyslot that opened.
+func (h *Header) OpenKeyslot(d io.ReaderAt, passphrase []byte, ids ...string) ([]byte, string, error) {
+	if len(ids) == 0 {
+		for id := range h.Metadata.Keyslots {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+	}
+	for _, id := range ids {
+		vk, err := h.openKeyslot(d, id, passphrase)
+		if err != nil {
+			return nil, "", err
+		}
+		if vk != nil {
+			return vk, id, nil
+		}
+	}
+	return nil, "", ErrPassphrase
+}
+
+// openKeyslot returns nil, nil if the passphrase is wrong.
+func (h *Header) openKeyslot(d io.ReaderAt, id string, passphrase []byte) ([]byte, error) {
+	ks, err := h.Keyslot(id)
+	if err != nil {
+		return nil, err
+	}
+	if ks.Type != "luks2" {
+		// eg a reencrypt keyslot, which has no passphrase
+		return nil, nil
+	}
+	if ks.Area.Type != "raw" || ks.Area.Encryption != encryption {
+		return nil, fmt.Errorf("luks: keyslot %s: unsupported area %s/%s", id, ks.Area.Type, ks.Area.Encryption)
+	}
+	if ks.AF.Type != "luks1" {
+		return nil, fmt.Errorf("luks: keyslot %s: unsupported AF %q", id, ks.AF.Type)
+	}
+
+	key, err := ks.KDF.derive(passphrase, ks.Area.KeySize)
+	if err != nil {
+		return nil, err
+	}
+	c, err := xts.NewCipher(aes.NewCipher, key)
+	if err != nil {
+		return nil, err
+	}
+
+	n := ks.KeySize * ks.AF.Stripes
+	split := make([]byte, roundUp(uint64(n), sectorSize))
+	if uint64(len(split)) > ks.Area.Size {
+		return nil, fmt.Errorf("luks: keyslot %s: area too small", id)
+	}
+	if _, err := d.ReadAt(split, int64(ks.Area.Offset)); err != nil {
+		return nil, fmt.Errorf("luks: can't read keyslot %s: %v", id, err)
+	}
+	for s := 0; s < len(split); s += sectorSize {
+		c.Decrypt(split[s:s+sectorSize], split[s:s+sectorSize], uint64(s/sectorSize))
+	}
+
+	hf, err := hashFunc(ks.AF.Hash)
+	if err != nil {
+		return nil, err
+	}
+	vk := afMerge(split[:n], ks.KeySize, ks.AF.Stripes, hf)
+
+	ok, err := h.verify(id, vk)
+	if err != nil || !ok {
+		return nil, err
+	}
+	return vk, nil
+}
+
+// verify checks vk against the digest keyslot id is linked to.
+func (h *Header) verify(id string, vk []byte) (bool, error) {
+	for _, dg := range h.Metadata.Digests {
+		if !slices.Contains(dg.Keyslots, id) {
+			continue
+		}
+		if dg.Type != "pbkdf2" {
+			return false, fmt.Errorf("luks: unsupported digest %q", dg.Type)
+		}
+		hf, err := hashFunc(dg.Hash)
+		if err != nil {
+			return false, err
+		}
+		sum, err := pbkdf2.Key(hf, string(vk), dg.Salt, dg.Iterations, len(dg.Digest))
+		if err != nil {
+			return false, err
+		}
+		return subtle.ConstantTimeCompare(sum, dg.Digest) == 1, nil
+	}
+	return false, fmt.Errorf("luks: keyslot %s has no digest", id)
+}
+
+// AddKeyslot stores vk, which must be the volume key (eg from OpenKeyslot),
+// in a new keyslot opened by passphrase and returns its id.  The salt of kdf
+// is filled in.  The header is not written.
+func (h *Header) AddKeyslot(d Device, vk, passphrase []byte, kdf KDF) (string, error) {
+	var digest *Digest
+	for _, dg := range h.Metadata.Digests {
+		if slices.Contains(dg.Segments, "0") {
+			digest = dg
+		}
+	}
+	if digest == nil {
+		return "", fmt.Errorf("luks: no digest for segment 0")
+	}
+	if len(digest.Keyslots) > 0 {
+		ok, err := h.verify(digest.Keyslots[0], vk)
+		if err != nil {
+			return "", err
+		}
+		if !ok {
+			return "", fmt.Errorf("luks: volume key doesn't match the digest")
+		}
+	}
+
+	id, err := freeID(h.Metadata.Keyslots)
+	if err != nil {
+		return "", err
+	}
+
+	afHash := kdf.Hash
+	if afHash == "" {
+		afHash = "sha256"
+	}
+	hf, err := hashFunc(afHash)
+	if err != nil {
+		return "", err
+	}
+	n := len(vk) * afStripes
+	size := roundUp(uint64(n), areaAlign)
+	off, err := h.freeArea(size)
+	if err != nil {
+		return "", err
+	}
+
+	kdf.Salt = make([]byte, 32)
+	if _, err := rand.Read(kdf.Salt); err != nil {
+		return "", err
+	}
+	key, err := kdf.derive(passphrase, len(vk))
+	if err != nil {
+		return "", err
+	}
+	c, err := xts.NewCipher(aes.NewCipher, key)
+	if err != nil {
+		return "", fmt.Errorf("luks: %d byte volume keys are not supported: %v", len(vk), err)
+	}
+
+	split, err := afSplit(vk, afStripes, hf)
+	if err != nil {
+		return "", err
+	}
+	split = append(split, make([]byte, roundUp(uint64(n), sectorSize)-uint64(n))...)
+	for s := 0; s < len(split); s += sectorSize {
+		c.Encrypt(split[s:s+sectorSize], split[s:s+sectorSize], uint64(s/sectorSize))
+	}
+	area := make([]byte, size)
+	copy(area, split)
+	if _, err := d.WriteAt(area, int64(off)); err != nil {
+		return "", fmt.Errorf("luks: can't write keyslot: %v", err)
+	}
+
+	raw, err := json.Marshal(&Keyslot{
+		Type:    "luks2",
+		KeySize: len(vk),
+		AF: AF{
+			Type:    "luks1",
+			Stripes: afStripes,
+			Hash:    afHash,
+		},
+		Area: Area{
+			Type:       "raw",
+			Offset:     off,
+			Size:       size,
+			Encryption: encryption,
+			KeySize:    len(vk),
+		},
+		KDF: kdf,
+	})
+	if err != nil {
+		return "", err
+	}
+	if h.Metadata.Keyslots == nil {
+		h.Metadata.Keyslots = map[string]json.RawMessage{}
+	}
+	h.Metadata.Keyslots[id] = raw
+	digest.Keyslots = append(digest.Keyslots, id)
+	return id, nil
+}
+
+// RemoveKeyslot drops a keyslot from the metadata and wipes its area, and
+// unlinks it from digests and tokens.  The header is not written.
+func (h *Header) RemoveKeyslot(d Device, id string) error {
+	ks, err := h.Keyslot(id)
+	if err != nil {
+		return err
+	}
+	if _, err := d.WriteAt(make([]byte, ks.Area.Size), int64(ks.Area.Offset)); err != nil {
+		return fmt.Errorf("luks: can't wipe keyslot %s: %v", id, err)
+	}
+	delete(h.Metadata.Keyslots, id)
+	for _, dg := range h.Metadata.Digests {
+		dg.Keyslots = slices.DeleteFunc(dg.Keyslots, func(s string) bool { return s == id })
+	}
+	for tid, raw := range h.Metadata.Tokens {
+		var t struct {
+			Keyslots []string `json:"keyslots"`
+		}
+		if json.Unmarshal(raw, &t) == nil && slices.Contains(t.Keyslots, id) {
+			delete(h.Metadata.Tokens, tid)
+		}
+	}
+	return nil
+}
+
+// freeArea finds room for a keyslot area of size bytes.
+func (h *Header) freeArea(size uint64) (uint64, error) {
+	type span struct{ start, end uint64 }
+	var used []span
+	for id := range h.Metadata.Keyslots {
+		ks, err := h.Keyslot(id)
+		if err != nil {
+			return 0, err
+		}
+		used = append(used, span{ks.Area.Offset, ks.Area.Offset + ks.Area.Size})
+	}
+	sort.Slice(used, func(i, j int) bool { return used[i].start < used[j].start })
+
+	off := h.keyslotsOffset()
+	end := off + h.Metadata.Config.KeyslotsSize
+	for _, u := range used {
+		if off+size <= u.start {
+			break
+		}
+		off = max(off, roundUp(u.end, areaAlign))
+	}
+	if off+size > end {
+		return 0, fmt.Errorf("luks: no room for another keyslot")
+	}
+	return off, nil
+}
+
+// afSplit and afMerge are the LUKS anti-forensic splitter: the key is
+// expanded to stripes blocks that all have to be intact to recover it.
+func afSplit(key []byte, stripes int, hf func() hash.Hash) ([]byte, error) {
+	n := len(key)
+	out := make([]byte, n*stripes)
+	if _, err := rand.Read(out[:n*(stripes-1)]); err != nil {
+		return nil, err
+	}
+	d := make([]byte, n)
+	for i := 0; i < stripes-1; i++ {
+		subtle.XORBytes(d, d, out[i*n:(i+1)*n])
+		d = diffuse(d, hf)
+	}
+	subtle.XORBytes(out[(stripes-1)*n:], d, key)
+	return out, nil
+}
+
+func afMerge(split []byte, n, stripes int, hf func() hash.Hash) []byte {
+	d := make([]byte, n)
+	for i := 0; i < stripes-1; i++ {
+		subtle.XORBytes(d, d, split[i*n:(i+1)*n])
+		d = diffuse(d, hf)
+	}
+	key := make([]byte, n)
+	subtle.XORBytes(key, d, split[(stripes-1)*n:])
+	return key
+}
+
+// diffuse hashes each digest sized chunk of b prefixed with its index.
+func diffuse(b []byte, hf func() hash.Hash) []byte {
+	h := hf()
+	size := h.Size()
+	out := make([]byte, 0, len(b))
+	for i := 0; i*size < len(b); i++ {
+		chunk := b[i*size : min((i+1)*size, len(b))]
+		h.Reset()
+		var idx [4]byte
+		binary.BigEndian.PutUint32(idx[:], uint32(i))
+		h.Write(idx[:])
+		h.Write(chunk)
+		out = append(out, h.Sum(nil)[:len(chunk)]...)
+	}
+	return out
+}
+
+func roundUp(n, align uint64) uint64 {
+	return (n + align - 1) / align * align
+}
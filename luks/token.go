@@ -0,0 +1,63 @@
+package luks
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+// TokenType returns the type of token id, "" if it doesn't exist.
+func (h *Header) TokenType(id string) string {
+	var t struct {
+		Type string `json:"type"`
+	}
+	if err := json.Unmarshal(h.Metadata.Tokens[id], &t); err != nil {
+		return ""
+	}
+	return t.Type
+}
+
+// TokenIDs returns the ids of the tokens of type typ, all tokens if typ is
+// empty, in order.
+func (h *Header) TokenIDs(typ string) []string {
+	var ids []string
+	for id := range h.Metadata.Tokens {
+		if typ == "" || h.TokenType(id) == typ {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// AddToken adds a token and returns its id.  The token must marshal to an
+// object with "type" and "keyslots" members naming existing keyslots.  The
+// header is not written.
+func (h *Header) AddToken(token any) (string, error) {
+	raw, err := json.Marshal(token)
+	if err != nil {
+		return "", fmt.Errorf("luks: can't encode token: %v", err)
+	}
+	var t struct {
+		Type     string   `json:"type"`
+		Keyslots []string `json:"keyslots"`
+	}
+	if err := json.Unmarshal(raw, &t); err != nil || t.Type == "" {
+		return "", fmt.Errorf("luks: token has no type")
+	}
+	for _, ks := range t.Keyslots {
+		if _, ok := h.Metadata.Keyslots[ks]; !ok {
+			return "", fmt.Errorf("luks: token refers to missing keyslot %s", ks)
+		}
+	}
+
+	id, err := freeID(h.Metadata.Tokens)
+	if err != nil {
+		return "", err
+	}
+	if h.Metadata.Tokens == nil {
+		h.Metadata.Tokens = map[string]json.RawMessage{}
+	}
+	h.Metadata.Tokens[id] = raw
+	return id, nil
+}
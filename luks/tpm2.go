@@ -0,0 +1,365 @@
+package luks
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/google/go-tpm/tpm2"
+	"github.com/google/go-tpm/tpm2/transport"
+	"github.com/ibiscum/tpm2/tpmkey"
+	"github.com/ibiscum/tpm2/tpmtemplate"
+)
+
+// TokenTypeTPM2 is the token type systemd-cryptenroll --tpm2-device writes.
+const TokenTypeTPM2 = "systemd-tpm2"
+
+// srkHandle is where newer systemd versions keep the SRK they seal under.
+const srkHandle = tpm2.TPMHandle(0x81000001)
+
+// TPM2Token is a systemd-tpm2 token.  The blob is a keyed-hash object
+// sealing a random secret whose base64 encoding is the passphrase of the
+// token's keyslot.
+type TPM2Token struct {
+	Type     string   `json:"type"`
+	Keyslots []string `json:"keyslots"`
+	// Blob is the sealed object, TPM2B_PRIVATE || TPM2B_PUBLIC.
+	Blob []byte `json:"tpm2-blob"`
+	PCRs []uint `json:"tpm2-pcrs"`
+	// PCRBank is "sha1" or "sha256".
+	PCRBank string `json:"tpm2-pcr-bank,omitempty"`
+	// PrimaryAlg is the type of the primary the blob was sealed under,
+	// "ecc" or "rsa".
+	PrimaryAlg string   `json:"tpm2-primary-alg,omitempty"`
+	PolicyHash hexBytes `json:"tpm2-policy-hash"`
+	PIN        bool     `json:"tpm2-pin"`
+
+	// written by newer systemd versions, for signed PCR policies, salted
+	// PINs and SRK sealing
+	PubkeyPCRs []uint `json:"tpm2_pubkey_pcrs,omitempty"`
+	Pubkey     []byte `json:"tpm2_pubkey,omitempty"`
+	Salt       []byte `json:"tpm2_salt,omitempty"`
+	SRK        []byte `json:"tpm2_srk,omitempty"`
+}
+
+// hexBytes is a []byte encoded as a hex string in JSON.
+type hexBytes []byte
+
+func (b hexBytes) MarshalJSON() ([]byte, error) {
+	return json.Marshal(hex.EncodeToString(b))
+}
+
+func (b *hexBytes) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	d, err := hex.DecodeString(s)
+	*b = d
+	return err
+}
+
+// TPM2Token decodes token id.
+func (h *Header) TPM2Token(id string) (*TPM2Token, error) {
+	if typ := h.TokenType(id); typ != TokenTypeTPM2 {
+		return nil, fmt.Errorf("luks: token %s is %q, not %s", id, typ, TokenTypeTPM2)
+	}
+	var t TPM2Token
+	if err := json.Unmarshal(h.Metadata.Tokens[id], &t); err != nil {
+		return nil, fmt.Errorf("luks: bad token %s: %v", id, err)
+	}
+	return &t, nil
+}
+
+func pcrBank(name string) (tpm2.TPMAlgID, error) {
+	switch name {
+	case "", "sha256":
+		return tpm2.TPMAlgSHA256, nil
+	case "sha1":
+		return tpm2.TPMAlgSHA1, nil
+	}
+	return 0, fmt.Errorf("luks: unsupported PCR bank %q", name)
+}
+
+// pinAuth is the auth value of the sealed object for a PIN, its SHA-256
+// as systemd 252 does.
+func pinAuth(pin []byte) []byte {
+	if pin == nil {
+		return nil
+	}
+	sum := sha256.Sum256(pin)
+	return sum[:]
+}
+
+// sealTemplate is the sealed object systemd creates: no userWithAuth, so
+// the policy is always needed, and a fixed unique.
+func sealTemplate(policy []byte) tpm2.TPMTPublic {
+	return tpm2.TPMTPublic{
+		Type:    tpm2.TPMAlgKeyedHash,
+		NameAlg: tpm2.TPMAlgSHA256,
+		ObjectAttributes: tpm2.TPMAObject{
+			FixedTPM:    true,
+			FixedParent: true,
+		},
+		AuthPolicy: tpm2.TPM2BDigest{Buffer: policy},
+		Parameters: tpm2.NewTPMUPublicParms(tpm2.TPMAlgKeyedHash,
+			&tpm2.TPMSKeyedHashParms{
+				Scheme: tpm2.TPMTKeyedHashScheme{
+					Scheme: tpm2.TPMAlgNull,
+				},
+			}),
+		Unique: tpm2.NewTPMUPublicID(tpm2.TPMAlgKeyedHash, &tpm2.TPM2BDigest{
+			Buffer: make([]byte, 32),
+		}),
+	}
+}
+
+// primary creates the primary systemd seals under: the tpm2-tools default
+// ECC primary, RSA if the TPM can't do ECC, or the persistent SRK for tokens
+// that say so.
+func primary(rwr transport.TPM, alg string, srk bool) (*tpm2.NamedHandle, string, func(), error) {
+	if srk {
+		h, closer, err := tpmkey.Parent(rwr, srkHandle)
+		return h, alg, closer, err
+	}
+	algs := []string{"ecc", "rsa"}
+	if alg != "" {
+		algs = []string{alg}
+	}
+	var errs []error
+	for _, a := range algs {
+		id := tpm2.TPMAlgECC
+		if a == "rsa" {
+			id = tpm2.TPMAlgRSA
+		} else if a != "ecc" {
+			return nil, "", nil, fmt.Errorf("luks: unsupported primary algorithm %q", a)
+		}
+		template, err := tpmtemplate.Tools(id, tpm2.TPMAlgSHA256)
+		if err != nil {
+			return nil, "", nil, err
+		}
+		h, closer, err := tpmkey.Primary(rwr, tpm2.TPMRHOwner, template)
+		if err == nil {
+			return h, a, closer, nil
+		}
+		errs = append(errs, err)
+	}
+	return nil, "", nil, errors.Join(errs...)
+}
+
+// SealTPM2 seals secret to the current values of pcrs in bank and, if pin
+// is set, to the PIN.  The returned token has no keyslots yet.
+func SealTPM2(rwr transport.TPM, secret []byte, pcrs []uint, bank string, pin []byte) (*TPM2Token, error) {
+	if len(pcrs) == 0 && pin == nil {
+		return nil, fmt.Errorf("luks: need PCRs or a PIN to seal to")
+	}
+	alg, err := pcrBank(bank)
+	if err != nil {
+		return nil, err
+	}
+	if bank == "" {
+		bank = "sha256"
+	}
+	_, policy, err := tpmkey.PolicySteps(rwr, &tpmkey.Policy{PCRs: pcrs, Bank: alg}, pin != nil, tpm2.TPMAlgSHA256)
+	if err != nil {
+		return nil, err
+	}
+
+	parent, primaryAlg, closer, err := primary(rwr, "", false)
+	if err != nil {
+		return nil, err
+	}
+	defer closer()
+
+	rsp, err := tpm2.Create{
+		ParentHandle: tpm2.AuthHandle{
+			Handle: parent.Handle,
+			Name:   parent.Name,
+			Auth:   tpm2.PasswordAuth(nil),
+		},
+		InPublic: tpm2.New2B(sealTemplate(policy)),
+		InSensitive: tpm2.TPM2BSensitiveCreate{
+			Sensitive: &tpm2.TPMSSensitiveCreate{
+				UserAuth: tpm2.TPM2BAuth{
+					Buffer: pinAuth(pin),
+				},
+				Data: tpm2.NewTPMUSensitiveCreate(&tpm2.TPM2BSensitiveData{
+					Buffer: secret,
+				}),
+			},
+		},
+	}.Execute(rwr)
+	if err != nil {
+		return nil, fmt.Errorf("luks: can't seal secret: %v", err)
+	}
+
+	if pcrs == nil {
+		pcrs = []uint{}
+	}
+	return &TPM2Token{
+		Type:       TokenTypeTPM2,
+		Keyslots:   []string{},
+		Blob:       append(tpm2.Marshal(rsp.OutPrivate), tpm2.Marshal(rsp.OutPublic)...),
+		PCRs:       pcrs,
+		PCRBank:    bank,
+		PrimaryAlg: primaryAlg,
+		PolicyHash: policy,
+		PIN:        pin != nil,
+	}, nil
+}
+
+// Unseal returns the secret of the token.  It fails if the PCRs no longer
+// have the values the secret was sealed to, or the PIN is wrong.
+func (t *TPM2Token) Unseal(rwr transport.TPM, pin []byte) ([]byte, error) {
+	switch {
+	case len(t.Pubkey) > 0:
+		return nil, fmt.Errorf("luks: signed PCR policies are not supported")
+	case len(t.Salt) > 0:
+		return nil, fmt.Errorf("luks: salted PINs are not supported")
+	case t.PIN && pin == nil:
+		return nil, fmt.Errorf("luks: token needs a PIN")
+	case len(t.PCRs) == 0 && !t.PIN:
+		return nil, fmt.Errorf("luks: tokens without PCRs or PIN are not supported")
+	}
+	if !t.PIN {
+		pin = nil
+	}
+
+	priv, pub, err := splitBlob(t.Blob)
+	if err != nil {
+		return nil, err
+	}
+	alg, err := pcrBank(t.PCRBank)
+	if err != nil {
+		return nil, err
+	}
+
+	// systemd compares the policy session digest with the token before
+	// unsealing; doing it up front tells PCR changes apart from other errors
+	steps, policy, err := tpmkey.PolicySteps(rwr, &tpmkey.Policy{PCRs: t.PCRs, Bank: alg}, pin != nil, tpm2.TPMAlgSHA256)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(policy, t.PolicyHash) {
+		return nil, fmt.Errorf("luks: PCR policy doesn't match, the PCRs changed since enrollment")
+	}
+
+	parent, _, closer, err := primary(rwr, t.PrimaryAlg, len(t.SRK) > 0)
+	if err != nil {
+		return nil, err
+	}
+	defer closer()
+
+	k, err := tpmkey.LoadBlob(rwr, parent, *pub, *priv, pinAuth(pin), steps)
+	if err != nil {
+		return nil, err
+	}
+	defer k.Close()
+	return k.Unseal()
+}
+
+func splitBlob(blob []byte) (*tpm2.TPM2BPrivate, *tpm2.TPM2BPublic, error) {
+	if len(blob) < 2 || len(blob) < 2+int(binary.BigEndian.Uint16(blob)) {
+		return nil, nil, fmt.Errorf("luks: short tpm2-blob")
+	}
+	n := 2 + int(binary.BigEndian.Uint16(blob))
+	priv, err := tpm2.Unmarshal[tpm2.TPM2BPrivate](blob[:n])
+	if err != nil {
+		return nil, nil, fmt.Errorf("luks: bad tpm2-blob private: %v", err)
+	}
+	pub, err := tpm2.Unmarshal[tpm2.TPM2BPublic](blob[n:])
+	if err != nil {
+		return nil, nil, fmt.Errorf("luks: bad tpm2-blob public: %v", err)
+	}
+	return priv, pub, nil
+}
+
+// keyslotPassphrase turns a token secret into the keyslot passphrase, as
+// systemd does so the passphrase can be typed in if need be.
+func keyslotPassphrase(secret []byte) []byte {
+	return []byte(base64.StdEncoding.EncodeToString(secret))
+}
+
+// EnrollTPM2 adds a keyslot opened by a random secret sealed to the TPM,
+// and a systemd-tpm2 token holding it, like
+//
+//	systemd-cryptenroll --tpm2-device=auto --tpm2-pcrs=7 [--tpm2-with-pin=yes]
+//
+// passphrase has to open an existing keyslot.  It returns the new keyslot
+// and token ids.
+func EnrollTPM2(rwr transport.TPM, d Device, passphrase []byte, pcrs []uint, bank string, pin []byte) (string, string, error) {
+	h, err := ReadHeader(d)
+	if err != nil {
+		return "", "", err
+	}
+	vk, _, err := h.OpenKeyslot(d, passphrase)
+	if err != nil {
+		return "", "", err
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", "", err
+	}
+	token, err := SealTPM2(rwr, secret, pcrs, bank, pin)
+	if err != nil {
+		return "", "", err
+	}
+
+	slot, err := h.AddKeyslot(d, vk, keyslotPassphrase(secret), MinimalKDF)
+	if err != nil {
+		return "", "", err
+	}
+	token.Keyslots = []string{slot}
+	tid, err := h.AddToken(token)
+	if err != nil {
+		return "", "", err
+	}
+	if err := h.Write(d); err != nil {
+		return "", "", err
+	}
+	return slot, tid, nil
+}
+
+// UnlockTPM2 unseals the systemd-tpm2 tokens of the header in turn until
+// one opens its keyslot.  It returns the keyslot passphrase, which can be
+// handed to cryptsetup open --key-file=-, and the volume key.
+func UnlockTPM2(rwr transport.TPM, d io.ReaderAt, pin []byte) ([]byte, []byte, error) {
+	h, err := ReadHeader(d)
+	if err != nil {
+		return nil, nil, err
+	}
+	ids := h.TokenIDs(TokenTypeTPM2)
+	if len(ids) == 0 {
+		return nil, nil, fmt.Errorf("luks: no %s token", TokenTypeTPM2)
+	}
+
+	var errs []error
+	for _, id := range ids {
+		t, err := h.TPM2Token(id)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		secret, err := t.Unseal(rwr, pin)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("token %s: %v", id, err))
+			continue
+		}
+		pass := keyslotPassphrase(secret)
+		vk, _, err := h.OpenKeyslot(d, pass, t.Keyslots...)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("token %s: %v", id, err))
+			continue
+		}
+		return pass, vk, nil
+	}
+	return nil, nil, errors.Join(errs...)
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"log"
+	"net"
+	"os"
+	"slices"
+
+	"github.com/google/go-tpm-tools/simulator"
+	"github.com/google/go-tpm/tpm2/transport"
+	"github.com/google/go-tpm/tpmutil"
+	"github.com/ibiscum/tpm2/luks"
+)
+
+var (
+	tpmPath   = flag.String("tpm-path", "/dev/tpmrm0", "Path to the TPM device (character device or a Unix socket).")
+	image     = flag.String("image", "luks.img", "LUKS2 device, image or detached header")
+	pin       = flag.String("pin", "", "PIN, for tokens enrolled with one")
+	volumeKey = flag.String("volume-key-file", "", "also write the volume key to this file")
+)
+
+var TPMDEVICES = []string{"/dev/tpm0", "/dev/tpmrm0"}
+
+func OpenTPM(path string) (io.ReadWriteCloser, error) {
+	if slices.Contains(TPMDEVICES, path) {
+		return tpmutil.OpenTPM(path)
+	} else if path == "simulator" {
+		return simulator.GetWithFixedSeedInsecure(1073741825)
+	} else {
+		return net.Dial("tcp", path)
+	}
+}
+
+func main() {
+	flag.Parse()
+
+	var pinBytes []byte
+	if *pin != "" {
+		pinBytes = []byte(*pin)
+	}
+
+	f, err := os.Open(*image)
+	if err != nil {
+		log.Fatalf("can't open image: %v", err)
+	}
+	defer f.Close()
+
+	rwc, err := OpenTPM(*tpmPath)
+	if err != nil {
+		log.Fatalf("can't open TPM %q: %v", *tpmPath, err)
+	}
+	defer func() {
+		rwc.Close()
+	}()
+
+	rwr := transport.FromReadWriter(rwc)
+
+	pass, vk, err := luks.UnlockTPM2(rwr, f, pinBytes)
+	if err != nil {
+		log.Fatalf("can't unlock: %v", err)
+	}
+	if *volumeKey != "" {
+		if err := os.WriteFile(*volumeKey, vk, 0600); err != nil {
+			log.Fatalf("can't write volume key: %v", err)
+		}
+	}
+	// the keyslot passphrase, for cryptsetup open --key-file=-
+	os.Stdout.Write(pass)
+}
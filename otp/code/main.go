@@ -0,0 +1,110 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"log"
+	"net"
+	"os"
+	"slices"
+	"time"
+
+	keyfile "github.com/foxboron/go-tpm-keyfiles"
+	"github.com/google/go-tpm-tools/simulator"
+	"github.com/google/go-tpm/tpm2/transport"
+	"github.com/google/go-tpm/tpmutil"
+	"github.com/ibiscum/tpm2/atomicfile"
+	"github.com/ibiscum/tpm2/otp"
+	"github.com/ibiscum/tpm2/tpmkey"
+)
+
+var (
+	tpmPath  = flag.String("tpm-path", "/dev/tpmrm0", "Path to the TPM device (character device or a Unix socket).")
+	in       = flag.String("in", "otp.pem", "keyfile written by enroll")
+	password = flag.String("password", "", "optional key password")
+	counter  = flag.Int64("counter", -1, "HOTP counter (defaults to the counter in the keyfile, which is then advanced)")
+	at       = flag.Int64("time", 0, "TOTP unix time (defaults to now)")
+)
+
+var TPMDEVICES = []string{"/dev/tpm0", "/dev/tpmrm0"}
+
+func OpenTPM(path string) (io.ReadWriteCloser, error) {
+	if slices.Contains(TPMDEVICES, path) {
+		return tpmutil.OpenTPM(path)
+	} else if path == "simulator" {
+		return simulator.GetWithFixedSeedInsecure(1073741825)
+	} else {
+		return net.Dial("tcp", path)
+	}
+}
+
+func main() {
+	flag.Parse()
+
+	b, err := os.ReadFile(*in)
+	if err != nil {
+		log.Fatalf("can't read keyfile: %v", err)
+	}
+	fi, err := os.Stat(*in)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	kf, err := keyfile.Decode(b)
+	if err != nil {
+		log.Fatalf("can't decode keyfile: %v", err)
+	}
+	p, err := otp.FromKeyfile(kf)
+	if err != nil {
+		log.Fatalf("keyfile has no otp parameters: %v", err)
+	}
+
+	rwc, err := OpenTPM(*tpmPath)
+	if err != nil {
+		log.Fatalf("can't open TPM %q: %v", *tpmPath, err)
+	}
+	defer func() {
+		rwc.Close()
+	}()
+
+	rwr := transport.FromReadWriter(rwc)
+
+	k, err := tpmkey.Load(rwr, kf, []byte(*password))
+	if err != nil {
+		log.Fatalf("can't load key: %v", err)
+	}
+	defer k.Close()
+
+	g := &otp.Generator{
+		Key:    k,
+		Params: p,
+	}
+
+	var code string
+	if p.Type == "hotp" {
+		c := p.Counter
+		if *counter >= 0 {
+			c = uint64(*counter)
+		}
+		code, err = g.HOTP(c)
+		// a server accepts each counter once: the next one goes back in
+		// the keyfile before the code is shown, unless it was given
+		if err == nil && *counter < 0 {
+			p.Counter = c + 1
+			kf.AddOptions(keyfile.WithDescription(p.URI()))
+			if err := atomicfile.WriteFile(*in, kf.Bytes(), fi.Mode().Perm()); err != nil {
+				log.Fatalf("can't store the next counter: %v", err)
+			}
+		}
+	} else {
+		t := time.Now()
+		if *at != 0 {
+			t = time.Unix(*at, 0)
+		}
+		code, err = g.TOTP(t)
+	}
+	if err != nil {
+		log.Fatalf("can't generate code: %v", err)
+	}
+
+	log.Printf("%s: %s", p.Label, code)
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"log"
+	"net"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	keyfile "github.com/foxboron/go-tpm-keyfiles"
+	"github.com/google/go-tpm-tools/simulator"
+	"github.com/google/go-tpm/tpm2/transport"
+	"github.com/google/go-tpm/tpmutil"
+	"github.com/ibiscum/tpm2/otp"
+	"github.com/ibiscum/tpm2/tpmkey"
+)
+
+var (
+	tpmPath  = flag.String("tpm-path", "/dev/tpmrm0", "Path to the TPM device (character device or a Unix socket).")
+	uri      = flag.String("uri", "", "otpauth:// URI holding the base32 shared secret")
+	out      = flag.String("out", "otp.pem", "keyfile to write")
+	pcrs     = flag.String("pcrs", "", "comma separated PCRs to bind the key to (eg 7,23)")
+	password = flag.String("password", "", "optional key password")
+)
+
+var TPMDEVICES = []string{"/dev/tpm0", "/dev/tpmrm0"}
+
+func OpenTPM(path string) (io.ReadWriteCloser, error) {
+	if slices.Contains(TPMDEVICES, path) {
+		return tpmutil.OpenTPM(path)
+	} else if path == "simulator" {
+		return simulator.GetWithFixedSeedInsecure(1073741825)
+	} else {
+		return net.Dial("tcp", path)
+	}
+}
+
+func main() {
+	flag.Parse()
+
+	p, err := otp.ParseURI(*uri)
+	if err != nil {
+		log.Fatalf("can't parse uri: %v", err)
+	}
+
+	var pol *tpmkey.Policy
+	if *pcrs != "" {
+		pol = &tpmkey.Policy{}
+		for _, s := range strings.Split(*pcrs, ",") {
+			i, err := strconv.ParseUint(s, 10, 32)
+			if err != nil {
+				log.Fatalf("bad pcr %q: %v", s, err)
+			}
+			pol.PCRs = append(pol.PCRs, uint(i))
+		}
+	}
+
+	rwc, err := OpenTPM(*tpmPath)
+	if err != nil {
+		log.Fatalf("can't open TPM %q: %v", *tpmPath, err)
+	}
+	defer func() {
+		rwc.Close()
+	}()
+
+	rwr := transport.FromReadWriter(rwc)
+
+	log.Printf("======= enroll %s ========", p.URI())
+
+	k, err := otp.Enroll(rwr, p, []byte(*password), pol)
+	if err != nil {
+		log.Fatalf("can't enroll secret: %v", err)
+	}
+
+	f, err := os.OpenFile(*out, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		log.Fatalf("can't create keyfile: %v", err)
+	}
+	defer f.Close()
+	if err := keyfile.Encode(f, k); err != nil {
+		log.Fatalf("can't write keyfile: %v", err)
+	}
+
+	log.Printf("wrote %s", *out)
+}
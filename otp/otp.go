@@ -0,0 +1,185 @@
+// Package otp generates RFC 4226 (HOTP) and RFC 6238 (TOTP) one-time
+// passwords with the shared secret held as a TPM HMAC key.
+//
+// The secret from an otpauth:// URI is imported once with Enroll and never
+// leaves the TPM afterwards; only the dynamic truncation of the HMAC output
+// is done in software.
+package otp
+
+import (
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	keyfile "github.com/foxboron/go-tpm-keyfiles"
+	"github.com/google/go-tpm/tpm2"
+	"github.com/google/go-tpm/tpm2/transport"
+	"github.com/ibiscum/tpm2/tpmkey"
+)
+
+// Params are the otpauth:// parameters of an enrollment.
+type Params struct {
+	// Type is "totp" or "hotp".
+	Type      string
+	Label     string
+	Issuer    string
+	Secret    []byte
+	Algorithm tpm2.TPMAlgID
+	Digits    int
+	// Period is the TOTP time step.
+	Period time.Duration
+	// Counter is the next HOTP counter.
+	Counter uint64
+}
+
+var algorithms = map[string]tpm2.TPMAlgID{
+	"SHA1":   tpm2.TPMAlgSHA1,
+	"SHA256": tpm2.TPMAlgSHA256,
+	"SHA512": tpm2.TPMAlgSHA512,
+}
+
+func algorithmName(alg tpm2.TPMAlgID) string {
+	for k, v := range algorithms {
+		if v == alg {
+			return k
+		}
+	}
+	return ""
+}
+
+// ParseURI parses an otpauth:// URI as written by most authenticator apps.
+// Unset parameters take the RFC defaults: SHA1, 6 digits and a 30s period.
+func ParseURI(s string) (*Params, error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "otpauth" {
+		return nil, fmt.Errorf("otp: not an otpauth URI")
+	}
+	p := &Params{
+		Type:      strings.ToLower(u.Host),
+		Label:     strings.TrimPrefix(u.Path, "/"),
+		Algorithm: tpm2.TPMAlgSHA1,
+		Digits:    6,
+		Period:    30 * time.Second,
+	}
+	if p.Type != "totp" && p.Type != "hotp" {
+		return nil, fmt.Errorf("otp: unknown type %q", u.Host)
+	}
+
+	q := u.Query()
+	p.Issuer = q.Get("issuer")
+	if v := q.Get("secret"); v != "" {
+		p.Secret, err = base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(strings.ToUpper(strings.TrimRight(v, "=")))
+		if err != nil {
+			return nil, fmt.Errorf("otp: bad secret: %v", err)
+		}
+	}
+	if v := q.Get("algorithm"); v != "" {
+		alg, ok := algorithms[strings.ToUpper(v)]
+		if !ok {
+			return nil, fmt.Errorf("otp: unsupported algorithm %q", v)
+		}
+		p.Algorithm = alg
+	}
+	if v := q.Get("digits"); v != "" {
+		p.Digits, err = strconv.Atoi(v)
+		if err != nil || p.Digits < 6 || p.Digits > 10 {
+			return nil, fmt.Errorf("otp: bad digits %q", v)
+		}
+	}
+	if v := q.Get("period"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("otp: bad period %q", v)
+		}
+		p.Period = time.Duration(n) * time.Second
+	}
+	if v := q.Get("counter"); v != "" {
+		p.Counter, err = strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("otp: bad counter %q", v)
+		}
+	}
+	return p, nil
+}
+
+// URI returns the otpauth:// URI for p without the secret.
+func (p *Params) URI() string {
+	q := url.Values{}
+	if p.Issuer != "" {
+		q.Set("issuer", p.Issuer)
+	}
+	q.Set("algorithm", algorithmName(p.Algorithm))
+	q.Set("digits", strconv.Itoa(p.Digits))
+	if p.Type == "totp" {
+		q.Set("period", strconv.Itoa(int(p.Period/time.Second)))
+	} else {
+		q.Set("counter", strconv.FormatUint(p.Counter, 10))
+	}
+	u := url.URL{
+		Scheme:   "otpauth",
+		Host:     p.Type,
+		Path:     "/" + p.Label,
+		RawQuery: q.Encode(),
+	}
+	return u.String()
+}
+
+// Enroll imports the shared secret as a TPM HMAC key and returns the keyfile.
+// The secret-less URI is kept as the keyfile description so codes can be
+// generated from the keyfile alone.
+func Enroll(rwr transport.TPM, p *Params, auth []byte, pol *tpmkey.Policy) (*keyfile.TPMKey, error) {
+	if len(p.Secret) == 0 {
+		return nil, fmt.Errorf("otp: empty secret")
+	}
+	k, err := tpmkey.CreateHMAC(rwr, p.Secret, p.Algorithm, auth, pol)
+	if err != nil {
+		return nil, err
+	}
+	k.AddOptions(keyfile.WithDescription(p.URI()))
+	return k, nil
+}
+
+// FromKeyfile recovers the parameters stored by Enroll.
+func FromKeyfile(k *keyfile.TPMKey) (*Params, error) {
+	return ParseURI(k.Description)
+}
+
+// Generator computes codes with a loaded HMAC key.
+type Generator struct {
+	Key    *tpmkey.Key
+	Params *Params
+}
+
+// HOTP returns the code for counter.
+func (g *Generator) HOTP(counter uint64) (string, error) {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], counter)
+	mac, err := g.Key.HMAC(msg[:])
+	if err != nil {
+		return "", err
+	}
+	return Truncate(mac, g.Params.Digits), nil
+}
+
+// TOTP returns the code for the time step containing t.
+func (g *Generator) TOTP(t time.Time) (string, error) {
+	return g.HOTP(uint64(t.Unix()) / uint64(g.Params.Period/time.Second))
+}
+
+// Truncate applies the RFC 4226 dynamic truncation to an HMAC value.
+func Truncate(mac []byte, digits int) string {
+	offset := mac[len(mac)-1] & 0x0f
+	bin := binary.BigEndian.Uint32(mac[offset:offset+4]) & 0x7fffffff
+	mod := uint64(1)
+	for i := 0; i < digits; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", digits, uint64(bin)%mod)
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	keyfile "github.com/foxboron/go-tpm-keyfiles"
+	"github.com/google/go-tpm-tools/simulator"
+	"github.com/google/go-tpm/tpm2/transport"
+	"github.com/google/go-tpm/tpmutil"
+	"github.com/ibiscum/tpm2/sealedconfig"
+)
+
+var (
+	tpmPath     = flag.String("tpm-path", "/dev/tpmrm0", "Path to the TPM device (character device or a Unix socket).")
+	in          = flag.String("in", "", "encrypted YAML or JSON file, updated in place")
+	keyfiles    = flag.String("keyfiles", "", "comma separated TSS2 keyfiles of RSA recipient keys, to recover the data key")
+	password    = flag.String("password", "", "password of the sealed data key or RSA key")
+	rsaKeys     = flag.String("rsa", "", "comma separated PEM public keys or TSS2 keyfiles of RSA recipients to add")
+	seal        = flag.Bool("seal", false, "add a recipient sealed to this TPM's SRK")
+	name        = flag.String("name", "", "name of the new sealed recipient (default: hostname)")
+	pcrs        = flag.String("pcrs", "", "comma separated PCRs to bind the new sealed recipient to (eg 7,23)")
+	newPassword = flag.String("new-password", "", "optional password for the new sealed recipient")
+)
+
+var TPMDEVICES = []string{"/dev/tpm0", "/dev/tpmrm0"}
+
+func OpenTPM(path string) (io.ReadWriteCloser, error) {
+	if slices.Contains(TPMDEVICES, path) {
+		return tpmutil.OpenTPM(path)
+	} else if path == "simulator" {
+		return simulator.GetWithFixedSeedInsecure(1073741825)
+	} else {
+		return net.Dial("tcp", path)
+	}
+}
+
+func parsePCRs(s string) []uint {
+	var pcrs []uint
+	if s == "" {
+		return nil
+	}
+	for _, f := range strings.Split(s, ",") {
+		i, err := strconv.ParseUint(f, 10, 32)
+		if err != nil {
+			log.Fatalf("bad pcr %q: %v", f, err)
+		}
+		pcrs = append(pcrs, uint(i))
+	}
+	return pcrs
+}
+
+func main() {
+	flag.Parse()
+
+	if *in == "" {
+		log.Fatalf("--in is required")
+	}
+	data, err := os.ReadFile(*in)
+	if err != nil {
+		log.Fatalf("can't read input: %v", err)
+	}
+
+	opts := &sealedconfig.DecryptOptions{
+		Auth: []byte(*password),
+	}
+	if *keyfiles != "" {
+		for _, f := range strings.Split(*keyfiles, ",") {
+			b, err := os.ReadFile(f)
+			if err != nil {
+				log.Fatalf("can't read keyfile: %v", err)
+			}
+			kf, err := keyfile.Decode(b)
+			if err != nil {
+				log.Fatalf("can't decode keyfile %s: %v", f, err)
+			}
+			opts.Keyfiles = append(opts.Keyfiles, kf)
+		}
+	}
+
+	var recipients []*sealedconfig.Recipient
+	if *rsaKeys != "" {
+		for _, f := range strings.Split(*rsaKeys, ",") {
+			b, err := os.ReadFile(f)
+			if err != nil {
+				log.Fatalf("can't read RSA key: %v", err)
+			}
+			pub, err := sealedconfig.ParseRSAPublicKey(b)
+			if err != nil {
+				log.Fatalf("%s: %v", f, err)
+			}
+			rname := strings.TrimSuffix(filepath.Base(f), filepath.Ext(f))
+			recipients = append(recipients, sealedconfig.RSARecipient(rname, pub))
+		}
+	}
+
+	rwc, err := OpenTPM(*tpmPath)
+	if err != nil {
+		log.Fatalf("can't open TPM %q: %v", *tpmPath, err)
+	}
+	defer func() {
+		rwc.Close()
+	}()
+
+	rwr := transport.FromReadWriter(rwc)
+
+	if *seal {
+		if *name == "" {
+			*name, _ = os.Hostname()
+		}
+		recipients = append(recipients, sealedconfig.SealedRecipient(rwr, *name, parsePCRs(*pcrs), []byte(*newPassword)))
+	}
+	if len(recipients) == 0 {
+		log.Fatalf("nothing to add, use --rsa and/or --seal")
+	}
+
+	enc, err := sealedconfig.AddRecipients(rwr, data, opts, recipients...)
+	if err != nil {
+		log.Fatalf("can't add recipients: %v", err)
+	}
+
+	// write a new file and rename it over the old one
+	tmp, err := os.CreateTemp(filepath.Dir(*in), ".sealedconfig-*")
+	if err != nil {
+		log.Fatalf("can't create temporary file: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(enc); err != nil {
+		log.Fatalf("can't write output: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		log.Fatalf("can't write output: %v", err)
+	}
+	if fi, err := os.Stat(*in); err == nil {
+		os.Chmod(tmp.Name(), fi.Mode().Perm())
+	}
+	if err := os.Rename(tmp.Name(), *in); err != nil {
+		log.Fatalf("can't replace %s: %v", *in, err)
+	}
+	log.Printf("added %d recipients to %s", len(recipients), *in)
+}
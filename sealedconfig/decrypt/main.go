@@ -0,0 +1,109 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"log"
+	"net"
+	"os"
+	"slices"
+	"strings"
+
+	keyfile "github.com/foxboron/go-tpm-keyfiles"
+	"github.com/google/go-tpm-tools/simulator"
+	"github.com/google/go-tpm/tpm2/transport"
+	"github.com/google/go-tpm/tpmutil"
+	"github.com/ibiscum/tpm2/sealedconfig"
+)
+
+var (
+	tpmPath  = flag.String("tpm-path", "/dev/tpmrm0", "Path to the TPM device (character device or a Unix socket).")
+	in       = flag.String("in", "", "encrypted YAML or JSON file")
+	out      = flag.String("out", "", "decrypted file to create (default: stdout)")
+	keyfiles = flag.String("keyfiles", "", "comma separated TSS2 keyfiles of RSA recipient keys")
+	password = flag.String("password", "", "password of the sealed data key or RSA key")
+	info     = flag.Bool("info", false, "only print the recipients")
+)
+
+var TPMDEVICES = []string{"/dev/tpm0", "/dev/tpmrm0"}
+
+func OpenTPM(path string) (io.ReadWriteCloser, error) {
+	if slices.Contains(TPMDEVICES, path) {
+		return tpmutil.OpenTPM(path)
+	} else if path == "simulator" {
+		return simulator.GetWithFixedSeedInsecure(1073741825)
+	} else {
+		return net.Dial("tcp", path)
+	}
+}
+
+func main() {
+	flag.Parse()
+
+	if *in == "" {
+		log.Fatalf("--in is required")
+	}
+	data, err := os.ReadFile(*in)
+	if err != nil {
+		log.Fatalf("can't read input: %v", err)
+	}
+
+	if *info {
+		meta, err := sealedconfig.ReadMetadata(data)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		if meta.EncryptedRegex != "" {
+			log.Printf("encrypted regex: %s", meta.EncryptedRegex)
+		}
+		for _, r := range meta.Recipients {
+			switch r.Type {
+			case sealedconfig.TypeSealed:
+				log.Printf("%s %q pcrs %v", r.Type, r.Name, r.PCRs)
+			default:
+				log.Printf("%s %q %s", r.Type, r.Name, r.Fingerprint)
+			}
+		}
+		return
+	}
+
+	opts := &sealedconfig.DecryptOptions{
+		Auth: []byte(*password),
+	}
+	if *keyfiles != "" {
+		for _, f := range strings.Split(*keyfiles, ",") {
+			b, err := os.ReadFile(f)
+			if err != nil {
+				log.Fatalf("can't read keyfile: %v", err)
+			}
+			kf, err := keyfile.Decode(b)
+			if err != nil {
+				log.Fatalf("can't decode keyfile %s: %v", f, err)
+			}
+			opts.Keyfiles = append(opts.Keyfiles, kf)
+		}
+	}
+
+	rwc, err := OpenTPM(*tpmPath)
+	if err != nil {
+		log.Fatalf("can't open TPM %q: %v", *tpmPath, err)
+	}
+	defer func() {
+		rwc.Close()
+	}()
+
+	rwr := transport.FromReadWriter(rwc)
+
+	dec, err := sealedconfig.Decrypt(rwr, data, opts)
+	if err != nil {
+		log.Fatalf("can't decrypt: %v", err)
+	}
+	if *out == "" {
+		os.Stdout.Write(dec)
+		return
+	}
+	if err := os.WriteFile(*out, dec, 0600); err != nil {
+		log.Fatalf("can't write output: %v", err)
+	}
+	log.Printf("decrypted %s to %s", *in, *out)
+}
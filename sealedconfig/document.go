@@ -0,0 +1,187 @@
+package sealedconfig
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// document is a parsed YAML or JSON file.  JSON is parsed with the YAML
+// parser (JSON is YAML) and written back as JSON.
+type document struct {
+	root *yaml.Node // the top level mapping
+	json bool
+}
+
+func parse(data []byte) (*document, error) {
+	var n yaml.Node
+	if err := yaml.Unmarshal(data, &n); err != nil {
+		return nil, fmt.Errorf("sealedconfig: can't parse document: %v", err)
+	}
+	if n.Kind != yaml.DocumentNode || len(n.Content) != 1 || n.Content[0].Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("sealedconfig: the document must be a mapping")
+	}
+	return &document{
+		root: n.Content[0],
+		json: bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")),
+	}, nil
+}
+
+// get returns the value of a top level key and its index in root.Content.
+func (d *document) get(key string) (*yaml.Node, int) {
+	for i := 0; i+1 < len(d.root.Content); i += 2 {
+		if d.root.Content[i].Value == key {
+			return d.root.Content[i+1], i
+		}
+	}
+	return nil, -1
+}
+
+func (d *document) set(key string, value *yaml.Node) {
+	if _, i := d.get(key); i >= 0 {
+		d.root.Content[i+1] = value
+		return
+	}
+	d.root.Content = append(d.root.Content, &yaml.Node{
+		Kind:  yaml.ScalarNode,
+		Tag:   "!!str",
+		Value: key,
+	}, value)
+}
+
+func (d *document) remove(key string) {
+	if _, i := d.get(key); i >= 0 {
+		d.root.Content = append(d.root.Content[:i], d.root.Content[i+2:]...)
+	}
+}
+
+// leaf is a scalar value with the keys (and sequence indexes) leading to it.
+type leaf struct {
+	path []string
+	// index[i] is set when path[i] is a sequence index, not a mapping key
+	index []bool
+	node  *yaml.Node
+}
+
+// leaves returns the scalar values of the document in document order,
+// skipping the top level key skip.
+func (d *document) leaves(skip string) ([]leaf, error) {
+	var out []leaf
+	var walk func(n *yaml.Node, path []string, index []bool) error
+	walk = func(n *yaml.Node, path []string, index []bool) error {
+		switch n.Kind {
+		case yaml.MappingNode:
+			for i := 0; i+1 < len(n.Content); i += 2 {
+				k := n.Content[i].Value
+				if len(path) == 0 && k == skip {
+					continue
+				}
+				if err := walk(n.Content[i+1], append(path[:len(path):len(path)], k), append(index[:len(index):len(index)], false)); err != nil {
+					return err
+				}
+			}
+		case yaml.SequenceNode:
+			for i, c := range n.Content {
+				if err := walk(c, append(path[:len(path):len(path)], strconv.Itoa(i)), append(index[:len(index):len(index)], true)); err != nil {
+					return err
+				}
+			}
+		case yaml.ScalarNode:
+			out = append(out, leaf{path: path, index: index, node: n})
+		case yaml.AliasNode:
+			return fmt.Errorf("sealedconfig: YAML aliases are not supported (at %s)", strings.Join(path, "."))
+		}
+		return nil
+	}
+	if err := walk(d.root, nil, nil); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (d *document) marshal() ([]byte, error) {
+	if d.json {
+		var b bytes.Buffer
+		if err := writeJSON(&b, d.root, ""); err != nil {
+			return nil, err
+		}
+		b.WriteByte('\n')
+		return b.Bytes(), nil
+	}
+	var b bytes.Buffer
+	enc := yaml.NewEncoder(&b)
+	enc.SetIndent(2)
+	if err := enc.Encode(d.root); err != nil {
+		return nil, fmt.Errorf("sealedconfig: can't encode document: %v", err)
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// writeJSON writes a node tree as indented JSON, keeping the key order.
+func writeJSON(b *bytes.Buffer, n *yaml.Node, indent string) error {
+	switch n.Kind {
+	case yaml.MappingNode:
+		if len(n.Content) == 0 {
+			b.WriteString("{}")
+			return nil
+		}
+		b.WriteString("{\n")
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			k, err := json.Marshal(n.Content[i].Value)
+			if err != nil {
+				return err
+			}
+			b.WriteString(indent + "  ")
+			b.Write(k)
+			b.WriteString(": ")
+			if err := writeJSON(b, n.Content[i+1], indent+"  "); err != nil {
+				return err
+			}
+			if i+2 < len(n.Content) {
+				b.WriteByte(',')
+			}
+			b.WriteByte('\n')
+		}
+		b.WriteString(indent + "}")
+	case yaml.SequenceNode:
+		if len(n.Content) == 0 {
+			b.WriteString("[]")
+			return nil
+		}
+		b.WriteString("[\n")
+		for i, c := range n.Content {
+			b.WriteString(indent + "  ")
+			if err := writeJSON(b, c, indent+"  "); err != nil {
+				return err
+			}
+			if i+1 < len(n.Content) {
+				b.WriteByte(',')
+			}
+			b.WriteByte('\n')
+		}
+		b.WriteString(indent + "]")
+	case yaml.ScalarNode:
+		switch n.ShortTag() {
+		case "!!int", "!!float", "!!bool":
+			b.WriteString(n.Value)
+		case "!!null":
+			b.WriteString("null")
+		default:
+			v, err := json.Marshal(n.Value)
+			if err != nil {
+				return err
+			}
+			b.Write(v)
+		}
+	default:
+		return fmt.Errorf("sealedconfig: can't write YAML node kind %d as JSON", n.Kind)
+	}
+	return nil
+}
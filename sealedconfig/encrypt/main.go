@@ -0,0 +1,117 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/google/go-tpm-tools/simulator"
+	"github.com/google/go-tpm/tpm2/transport"
+	"github.com/google/go-tpm/tpmutil"
+	"github.com/ibiscum/tpm2/sealedconfig"
+)
+
+var (
+	tpmPath        = flag.String("tpm-path", "/dev/tpmrm0", "Path to the TPM device (character device or a Unix socket).")
+	in             = flag.String("in", "", "YAML or JSON file to encrypt")
+	out            = flag.String("out", "", "encrypted file to create (default: stdout)")
+	seal           = flag.Bool("seal", true, "seal the data key to this TPM's SRK")
+	name           = flag.String("name", "", "name of this machine's sealed recipient (default: hostname)")
+	pcrs           = flag.String("pcrs", "", "comma separated PCRs to bind the sealed data key to (eg 7,23)")
+	password       = flag.String("password", "", "optional password for the sealed data key")
+	rsaKeys        = flag.String("rsa", "", "comma separated PEM public keys or TSS2 keyfiles of RSA recipients")
+	encryptedRegex = flag.String("encrypted-regex", "", "only encrypt values with a key matching this regex on their path")
+)
+
+var TPMDEVICES = []string{"/dev/tpm0", "/dev/tpmrm0"}
+
+func OpenTPM(path string) (io.ReadWriteCloser, error) {
+	if slices.Contains(TPMDEVICES, path) {
+		return tpmutil.OpenTPM(path)
+	} else if path == "simulator" {
+		return simulator.GetWithFixedSeedInsecure(1073741825)
+	} else {
+		return net.Dial("tcp", path)
+	}
+}
+
+func parsePCRs(s string) []uint {
+	var pcrs []uint
+	if s == "" {
+		return nil
+	}
+	for _, f := range strings.Split(s, ",") {
+		i, err := strconv.ParseUint(f, 10, 32)
+		if err != nil {
+			log.Fatalf("bad pcr %q: %v", f, err)
+		}
+		pcrs = append(pcrs, uint(i))
+	}
+	return pcrs
+}
+
+func main() {
+	flag.Parse()
+
+	if *in == "" {
+		log.Fatalf("--in is required")
+	}
+	data, err := os.ReadFile(*in)
+	if err != nil {
+		log.Fatalf("can't read input: %v", err)
+	}
+
+	opts := &sealedconfig.EncryptOptions{
+		EncryptedRegex: *encryptedRegex,
+	}
+	if *rsaKeys != "" {
+		for _, f := range strings.Split(*rsaKeys, ",") {
+			b, err := os.ReadFile(f)
+			if err != nil {
+				log.Fatalf("can't read RSA key: %v", err)
+			}
+			pub, err := sealedconfig.ParseRSAPublicKey(b)
+			if err != nil {
+				log.Fatalf("%s: %v", f, err)
+			}
+			rname := strings.TrimSuffix(filepath.Base(f), filepath.Ext(f))
+			opts.Recipients = append(opts.Recipients, sealedconfig.RSARecipient(rname, pub))
+		}
+	}
+
+	if *seal {
+		rwc, err := OpenTPM(*tpmPath)
+		if err != nil {
+			log.Fatalf("can't open TPM %q: %v", *tpmPath, err)
+		}
+		defer func() {
+			rwc.Close()
+		}()
+
+		rwr := transport.FromReadWriter(rwc)
+
+		if *name == "" {
+			*name, _ = os.Hostname()
+		}
+		opts.Recipients = append(opts.Recipients, sealedconfig.SealedRecipient(rwr, *name, parsePCRs(*pcrs), []byte(*password)))
+	}
+
+	enc, err := sealedconfig.Encrypt(data, opts)
+	if err != nil {
+		log.Fatalf("can't encrypt: %v", err)
+	}
+	if *out == "" {
+		os.Stdout.Write(enc)
+		return
+	}
+	if err := os.WriteFile(*out, enc, 0644); err != nil {
+		log.Fatalf("can't write output: %v", err)
+	}
+	log.Printf("encrypted %s to %s for %d recipients", *in, *out, len(opts.Recipients))
+}
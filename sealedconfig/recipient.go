@@ -0,0 +1,249 @@
+package sealedconfig
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strings"
+
+	keyfile "github.com/foxboron/go-tpm-keyfiles"
+	"github.com/google/go-tpm/tpm2"
+	"github.com/google/go-tpm/tpm2/transport"
+	"github.com/ibiscum/tpm2/tpmkey"
+)
+
+// Recipient types.
+const (
+	TypeSealed = "tpm-sealed"
+	TypeRSA    = "tpm-rsa"
+)
+
+// oaepLabel is the RSA-OAEP label of wrapped data keys, with the zero byte
+// the TPM expects.
+var oaepLabel = []byte("sealedconfig\x00")
+
+// Recipient is a wrapped copy of the data key.  Recipients to encrypt to
+// are made with SealedRecipient and RSARecipient.
+type Recipient struct {
+	Type string `yaml:"type" json:"type"`
+	Name string `yaml:"name,omitempty" json:"name,omitempty"`
+
+	// SealedKey is the base64 DER TSS2 sealed data keyfile holding the
+	// data key, for tpm-sealed.
+	SealedKey string `yaml:"sealed_key,omitempty" json:"sealed_key,omitempty"`
+	PCRs      []uint `yaml:"pcrs,omitempty,flow" json:"pcrs,omitempty"`
+
+	// Fingerprint identifies the RSA key and EncKey is the base64 RSA-OAEP
+	// (SHA-256) encrypted data key, for tpm-rsa.
+	Fingerprint string `yaml:"fingerprint,omitempty" json:"fingerprint,omitempty"`
+	EncKey      string `yaml:"enc_key,omitempty" json:"enc_key,omitempty"`
+
+	rwr  transport.TPM
+	auth []byte
+	pub  *rsa.PublicKey
+}
+
+// SealedRecipient seals the data key to the SRK of rwr, bound to the
+// current values of pcrs (SHA-256 bank) if any and with auth as the
+// password if not empty.
+func SealedRecipient(rwr transport.TPM, name string, pcrs []uint, auth []byte) *Recipient {
+	return &Recipient{
+		Type: TypeSealed,
+		Name: name,
+		PCRs: pcrs,
+		rwr:  rwr,
+		auth: auth,
+	}
+}
+
+// RSARecipient encrypts the data key to pub, the public part of a TPM RSA
+// decryption key, which may live on another machine.
+func RSARecipient(name string, pub *rsa.PublicKey) *Recipient {
+	return &Recipient{
+		Type: TypeRSA,
+		Name: name,
+		pub:  pub,
+	}
+}
+
+// Fingerprint returns the fingerprint recorded for an RSA key, the
+// SHA-256 of its PKIX encoding.
+func Fingerprint(pub *rsa.PublicKey) (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(der)
+	return "SHA256:" + base64.RawStdEncoding.EncodeToString(sum[:]), nil
+}
+
+// ParseRSAPublicKey returns the RSA public key of a PEM "PUBLIC KEY" or a
+// TSS2 keyfile, so recipients can be added from either.
+func ParseRSAPublicKey(data []byte) (*rsa.PublicKey, error) {
+	var pub any
+	block, _ := pem.Decode(data)
+	switch {
+	case block == nil:
+		return nil, fmt.Errorf("sealedconfig: no PEM data")
+	case block.Type == "PUBLIC KEY":
+		p, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("sealedconfig: bad public key: %v", err)
+		}
+		pub = p
+	case block.Type == "TSS2 PRIVATE KEY":
+		kf, err := keyfile.Parse(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("sealedconfig: bad keyfile: %v", err)
+		}
+		if pub, err = kf.PublicKey(); err != nil {
+			return nil, fmt.Errorf("sealedconfig: bad keyfile: %v", err)
+		}
+	default:
+		return nil, fmt.Errorf("sealedconfig: unexpected PEM block %q", block.Type)
+	}
+	rsaPub, ok := pub.(*rsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("sealedconfig: not an RSA key")
+	}
+	return rsaPub, nil
+}
+
+// wrap returns the recipient entry holding dek.
+func (r *Recipient) wrap(dek []byte) (*Recipient, error) {
+	switch r.Type {
+	case TypeSealed:
+		var pol *tpmkey.Policy
+		if len(r.PCRs) > 0 {
+			pol = &tpmkey.Policy{PCRs: r.PCRs}
+		}
+		kf, err := tpmkey.Seal(r.rwr, dek, r.auth, pol)
+		if err != nil {
+			return nil, err
+		}
+		kf.AddOptions(keyfile.WithDescription("sealedconfig data key"))
+		return &Recipient{
+			Type:      TypeSealed,
+			Name:      r.Name,
+			SealedKey: base64.StdEncoding.EncodeToString(keyfile.Marshal(kf)),
+			PCRs:      r.PCRs,
+		}, nil
+	case TypeRSA:
+		fp, err := Fingerprint(r.pub)
+		if err != nil {
+			return nil, fmt.Errorf("sealedconfig: bad RSA key: %v", err)
+		}
+		ct, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, r.pub, dek, oaepLabel)
+		if err != nil {
+			return nil, fmt.Errorf("sealedconfig: can't encrypt to %s: %v", fp, err)
+		}
+		return &Recipient{
+			Type:        TypeRSA,
+			Name:        r.Name,
+			Fingerprint: fp,
+			EncKey:      base64.StdEncoding.EncodeToString(ct),
+		}, nil
+	}
+	return nil, fmt.Errorf("sealedconfig: unknown recipient type %q", r.Type)
+}
+
+// unsealKey unwraps a tpm-sealed recipient on rwr.
+func (r *Recipient) unsealKey(rwr transport.TPM, auth []byte) ([]byte, error) {
+	der, err := base64.StdEncoding.DecodeString(r.SealedKey)
+	if err != nil {
+		return nil, fmt.Errorf("bad sealed key: %v", err)
+	}
+	kf, err := keyfile.Parse(der)
+	if err != nil {
+		return nil, fmt.Errorf("bad sealed key: %v", err)
+	}
+	if kf.EmptyAuth {
+		auth = nil
+	} else if len(auth) == 0 {
+		return nil, fmt.Errorf("the sealed key needs a password")
+	}
+	k, err := tpmkey.Load(rwr, kf, auth)
+	if err != nil {
+		return nil, err
+	}
+	defer k.Close()
+	return k.Unseal()
+}
+
+// decryptKey unwraps a tpm-rsa recipient with kf.
+func (r *Recipient) decryptKey(rwr transport.TPM, kf *keyfile.TPMKey, auth []byte) ([]byte, error) {
+	ct, err := base64.StdEncoding.DecodeString(r.EncKey)
+	if err != nil {
+		return nil, fmt.Errorf("bad encrypted key: %v", err)
+	}
+	if kf.EmptyAuth {
+		auth = nil
+	}
+	k, err := tpmkey.Load(rwr, kf, auth)
+	if err != nil {
+		return nil, err
+	}
+	defer k.Close()
+	return k.DecryptOAEP(ct, oaepLabel, tpm2.TPMAlgSHA256)
+}
+
+// dataKey recovers the data key from the first recipient that works:
+// sealed ones first, then those whose RSA key is in opts.Keyfiles.
+func (m *Metadata) dataKey(rwr transport.TPM, opts *DecryptOptions) ([]byte, error) {
+	if opts == nil {
+		opts = &DecryptOptions{}
+	}
+	fps := map[string]*keyfile.TPMKey{}
+	for _, kf := range opts.Keyfiles {
+		pub, err := kf.PublicKey()
+		if err != nil {
+			continue
+		}
+		if rsaPub, ok := pub.(*rsa.PublicKey); ok {
+			if fp, err := Fingerprint(rsaPub); err == nil {
+				fps[fp] = kf
+			}
+		}
+	}
+
+	var errs []string
+	try := func(r *Recipient, dek []byte, err error) bool {
+		if err == nil && len(dek) != 32 {
+			err = errors.New("wrong key size")
+		}
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s %s: %v", r.Type, r.Name, err))
+			return false
+		}
+		return true
+	}
+	for _, r := range m.Recipients {
+		if r.Type != TypeSealed {
+			continue
+		}
+		if dek, err := r.unsealKey(rwr, opts.Auth); try(r, dek, err) {
+			return dek, nil
+		}
+	}
+	for _, r := range m.Recipients {
+		if r.Type != TypeRSA {
+			continue
+		}
+		kf, ok := fps[r.Fingerprint]
+		if !ok {
+			continue
+		}
+		if dek, err := r.decryptKey(rwr, kf, opts.Auth); try(r, dek, err) {
+			return dek, nil
+		}
+	}
+	if len(errs) == 0 {
+		return nil, fmt.Errorf("sealedconfig: no usable recipient, tpm-rsa recipients need the keyfile of their key")
+	}
+	return nil, fmt.Errorf("sealedconfig: can't recover the data key: %s", strings.Join(errs, "; "))
+}
@@ -0,0 +1,403 @@
+// Package sealedconfig encrypts individual values of YAML or JSON
+// configuration files, sops style, under a data key only TPMs can recover.
+//
+// Keys and structure stay readable; each encrypted leaf becomes
+//
+//	ENC[AES256_GCM,data:...,iv:...,tag:...,type:str]
+//
+// with the path of the value as AAD, so values can't be moved around.  A
+// "sealedconfig" top level key holds the metadata: the recipients the data
+// key is wrapped to and an HMAC over every value of the document, encrypted
+// or not, so no value can be changed, added or dropped undetected.
+//
+// The data key is wrapped to any number of machine recipients:
+//
+//   - tpm-sealed: sealed to the SRK of the machine running the encryption
+//     (as srk_seal_unseal does), optionally bound to PCRs
+//   - tpm-rsa: RSA-OAEP encrypted to a TPM resident RSA key of another
+//     machine, from its public key alone (as encrypt_with_tpm_rsa does)
+//
+// Services call Unmarshal at start up with their TPM and, for tpm-rsa
+// recipients, the keyfile of their RSA key.
+package sealedconfig
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	keyfile "github.com/foxboron/go-tpm-keyfiles"
+	"github.com/google/go-tpm/tpm2/transport"
+	"gopkg.in/yaml.v3"
+)
+
+// MetadataKey is the top level key holding the metadata.
+const MetadataKey = "sealedconfig"
+
+// version 3 MACs each path component with whether it is a mapping key or a
+// sequence index.  Version 2 MACed the components alone and version 1 the
+// path joined with ':', as the AAD is; both are still read.
+const version = 3
+
+// Metadata is the value of the MetadataKey.
+type Metadata struct {
+	Version int `yaml:"version" json:"version"`
+	// EncryptedRegex selects the values to encrypt: those with a key on
+	// their path that matches.  All values are encrypted if it is empty.
+	EncryptedRegex string       `yaml:"encrypted_regex,omitempty" json:"encrypted_regex,omitempty"`
+	Recipients     []*Recipient `yaml:"recipients" json:"recipients"`
+	// MAC is the hex HMAC-SHA256 of all values.
+	MAC string `yaml:"mac" json:"mac"`
+}
+
+// EncryptOptions control Encrypt.
+type EncryptOptions struct {
+	// EncryptedRegex, see Metadata.
+	EncryptedRegex string
+	// Recipients the data key is wrapped to, see SealedRecipient and
+	// RSARecipient.  At least one is needed.
+	Recipients []*Recipient
+}
+
+// DecryptOptions give what it takes to recover the data key.
+type DecryptOptions struct {
+	// Keyfiles are the TSS2 keyfiles of RSA decryption keys, for tpm-rsa
+	// recipients.
+	Keyfiles []*keyfile.TPMKey
+	// Auth is the auth value of the sealed data key or the RSA key.
+	Auth []byte
+}
+
+var valueRE = regexp.MustCompile(`^ENC\[AES256_GCM,data:([^,]*),iv:([^,]+),tag:([^,]+),type:(str|int|float|bool)\]$`)
+
+// Encrypt encrypts the selected values of a YAML or JSON document under a
+// new data key wrapped to opts.Recipients.
+func Encrypt(data []byte, opts *EncryptOptions) ([]byte, error) {
+	if opts == nil || len(opts.Recipients) == 0 {
+		return nil, fmt.Errorf("sealedconfig: no recipients")
+	}
+	d, err := parse(data)
+	if err != nil {
+		return nil, err
+	}
+	if n, _ := d.get(MetadataKey); n != nil {
+		return nil, fmt.Errorf("sealedconfig: document is already encrypted")
+	}
+	var re *regexp.Regexp
+	if opts.EncryptedRegex != "" {
+		if re, err = regexp.Compile(opts.EncryptedRegex); err != nil {
+			return nil, fmt.Errorf("sealedconfig: bad encrypted regex: %v", err)
+		}
+	}
+
+	dek := make([]byte, 32)
+	if _, err := rand.Read(dek); err != nil {
+		return nil, err
+	}
+	meta := &Metadata{
+		Version:        version,
+		EncryptedRegex: opts.EncryptedRegex,
+	}
+	for _, r := range opts.Recipients {
+		w, err := r.wrap(dek)
+		if err != nil {
+			return nil, err
+		}
+		meta.Recipients = append(meta.Recipients, w)
+	}
+
+	leaves, err := d.leaves(MetadataKey)
+	if err != nil {
+		return nil, err
+	}
+	mac := newMAC(dek, meta)
+	for _, l := range leaves {
+		typ, ok := valueType(l.node)
+		mac.add(l, typ)
+		if !ok || !selected(re, l.path) {
+			continue
+		}
+		v, err := encryptValue(dek, l.path, typ, l.node.Value)
+		if err != nil {
+			return nil, err
+		}
+		l.node.Value = v
+		l.node.Tag = "!!str"
+		l.node.Style = 0
+	}
+	meta.MAC = mac.sum()
+
+	if err := d.setMetadata(meta); err != nil {
+		return nil, err
+	}
+	return d.marshal()
+}
+
+// Decrypt returns the document with all values decrypted and the metadata
+// removed, after checking the MAC.
+func Decrypt(rwr transport.TPM, data []byte, opts *DecryptOptions) ([]byte, error) {
+	d, err := decrypt(rwr, data, opts)
+	if err != nil {
+		return nil, err
+	}
+	return d.marshal()
+}
+
+// Unmarshal decrypts a document and unmarshals it into v, with the YAML or
+// JSON decoder as appropriate.
+func Unmarshal(rwr transport.TPM, data []byte, v any, opts *DecryptOptions) error {
+	d, err := decrypt(rwr, data, opts)
+	if err != nil {
+		return err
+	}
+	if d.json {
+		b, err := d.marshal()
+		if err != nil {
+			return err
+		}
+		return json.Unmarshal(b, v)
+	}
+	return d.root.Decode(v)
+}
+
+func decrypt(rwr transport.TPM, data []byte, opts *DecryptOptions) (*document, error) {
+	d, meta, err := parseEncrypted(data)
+	if err != nil {
+		return nil, err
+	}
+	dek, err := meta.dataKey(rwr, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	leaves, err := d.leaves(MetadataKey)
+	if err != nil {
+		return nil, err
+	}
+	mac := newMAC(dek, meta)
+	for _, l := range leaves {
+		typ, _ := valueType(l.node)
+		if m := valueRE.FindStringSubmatch(l.node.Value); m != nil && typ == "str" {
+			pt, err := decryptValue(dek, l.path, m)
+			if err != nil {
+				return nil, err
+			}
+			typ = m[4]
+			l.node.Value = pt
+			l.node.Tag = "!!" + typ
+			l.node.Style = 0
+		}
+		mac.add(l, typ)
+	}
+	want, err := hex.DecodeString(meta.MAC)
+	if err != nil || !hmac.Equal(want, mac.h.Sum(nil)) {
+		return nil, fmt.Errorf("sealedconfig: MAC mismatch, the document was modified")
+	}
+
+	d.remove(MetadataKey)
+	return d, nil
+}
+
+// AddRecipients wraps the data key of an encrypted document to more
+// recipients.  The values and MAC don't change.
+func AddRecipients(rwr transport.TPM, data []byte, opts *DecryptOptions, recipients ...*Recipient) ([]byte, error) {
+	d, meta, err := parseEncrypted(data)
+	if err != nil {
+		return nil, err
+	}
+	dek, err := meta.dataKey(rwr, opts)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range recipients {
+		w, err := r.wrap(dek)
+		if err != nil {
+			return nil, err
+		}
+		meta.Recipients = append(meta.Recipients, w)
+	}
+	if err := d.setMetadata(meta); err != nil {
+		return nil, err
+	}
+	return d.marshal()
+}
+
+// ReadMetadata returns the metadata of an encrypted document.
+func ReadMetadata(data []byte) (*Metadata, error) {
+	_, meta, err := parseEncrypted(data)
+	return meta, err
+}
+
+func parseEncrypted(data []byte) (*document, *Metadata, error) {
+	d, err := parse(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	n, _ := d.get(MetadataKey)
+	if n == nil {
+		return nil, nil, fmt.Errorf("sealedconfig: document is not encrypted")
+	}
+	var meta Metadata
+	if err := n.Decode(&meta); err != nil {
+		return nil, nil, fmt.Errorf("sealedconfig: bad metadata: %v", err)
+	}
+	if meta.Version < 1 || meta.Version > version {
+		return nil, nil, fmt.Errorf("sealedconfig: unsupported version %d", meta.Version)
+	}
+	return d, &meta, nil
+}
+
+func (d *document) setMetadata(meta *Metadata) error {
+	var n yaml.Node
+	if err := n.Encode(meta); err != nil {
+		return err
+	}
+	d.set(MetadataKey, &n)
+	return nil
+}
+
+// valueType maps a scalar's tag to the type recorded for it; ok is false
+// for values that aren't encrypted (null and odd tags).
+func valueType(n *yaml.Node) (string, bool) {
+	switch n.ShortTag() {
+	case "!!str":
+		return "str", true
+	case "!!int":
+		return "int", true
+	case "!!float":
+		return "float", true
+	case "!!bool":
+		return "bool", true
+	}
+	return strings.TrimPrefix(n.ShortTag(), "!!"), false
+}
+
+func selected(re *regexp.Regexp, path []string) bool {
+	if re == nil {
+		return true
+	}
+	for _, k := range path {
+		if re.MatchString(k) {
+			return true
+		}
+	}
+	return false
+}
+
+func aad(path []string) []byte {
+	return []byte(strings.Join(path, ":") + ":")
+}
+
+func encryptValue(dek []byte, path []string, typ, value string) (string, error) {
+	gcm, err := newGCM(dek)
+	if err != nil {
+		return "", err
+	}
+	iv := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(iv); err != nil {
+		return "", err
+	}
+	out := gcm.Seal(nil, iv, []byte(value), aad(path))
+	ct, tag := out[:len(out)-gcm.Overhead()], out[len(out)-gcm.Overhead():]
+	b64 := base64.StdEncoding
+	return fmt.Sprintf("ENC[AES256_GCM,data:%s,iv:%s,tag:%s,type:%s]",
+		b64.EncodeToString(ct), b64.EncodeToString(iv), b64.EncodeToString(tag), typ), nil
+}
+
+func decryptValue(dek []byte, path []string, m []string) (string, error) {
+	b64 := base64.StdEncoding
+	ct, err1 := b64.DecodeString(m[1])
+	iv, err2 := b64.DecodeString(m[2])
+	tag, err3 := b64.DecodeString(m[3])
+	if err1 != nil || err2 != nil || err3 != nil {
+		return "", fmt.Errorf("sealedconfig: bad encrypted value at %s", strings.Join(path, "."))
+	}
+	gcm, err := newGCM(dek)
+	if err != nil {
+		return "", err
+	}
+	if len(iv) != gcm.NonceSize() {
+		return "", fmt.Errorf("sealedconfig: bad IV at %s", strings.Join(path, "."))
+	}
+	pt, err := gcm.Open(nil, iv, append(ct, tag...), aad(path))
+	if err != nil {
+		return "", fmt.Errorf("sealedconfig: can't decrypt value at %s", strings.Join(path, "."))
+	}
+	return string(pt), nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// docMAC is the HMAC over the plaintext values.  Every field, and each
+// component of a path with its kind, is length prefixed so values can't
+// bleed into each other and a document can't be restructured: {"a:b": v}
+// and {a: {b: v}}, or {a: [v]} and {a: {"0": v}}, have the same AAD but not
+// the same MAC.
+type docMAC struct {
+	h interface {
+		Write([]byte) (int, error)
+		Sum([]byte) []byte
+	}
+	version int
+}
+
+func newMAC(dek []byte, meta *Metadata) *docMAC {
+	key, err := hkdf.Key(sha256.New, dek, nil, "sealedconfig mac", 32)
+	if err != nil {
+		panic(err)
+	}
+	m := &docMAC{h: hmac.New(sha256.New, key), version: meta.Version}
+	m.write(fmt.Sprint(meta.Version))
+	m.write(meta.EncryptedRegex)
+	return m
+}
+
+func (m *docMAC) write(s string) {
+	m.h.Write(binary.BigEndian.AppendUint32(nil, uint32(len(s))))
+	m.h.Write([]byte(s))
+}
+
+// add MACs the plaintext value of l, whose YAML type is typ.
+func (m *docMAC) add(l leaf, typ string) {
+	switch m.version {
+	case 1:
+		m.write(strings.Join(l.path, ":"))
+	case 2:
+		m.write(fmt.Sprint(len(l.path)))
+		for _, k := range l.path {
+			m.write(k)
+		}
+	default:
+		m.write(fmt.Sprint(len(l.path)))
+		for i, k := range l.path {
+			if l.index[i] {
+				m.write("index")
+			} else {
+				m.write("key")
+			}
+			m.write(k)
+		}
+	}
+	m.write(typ)
+	m.write(l.node.Value)
+}
+
+func (m *docMAC) sum() string {
+	return hex.EncodeToString(m.h.Sum(nil))
+}
@@ -0,0 +1,67 @@
+package sealedconfig
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/go-tpm-tools/simulator"
+	"github.com/google/go-tpm/tpm2/transport"
+)
+
+func testTPM(t *testing.T) transport.TPM {
+	t.Helper()
+	rwc, err := simulator.GetWithFixedSeedInsecure(1073741825)
+	if err != nil {
+		t.Fatalf("can't open simulator: %v", err)
+	}
+	t.Cleanup(func() { rwc.Close() })
+	return transport.FromReadWriter(rwc)
+}
+
+// Values keep their AAD when the document is restructured around them, so
+// only the MAC catches it.
+func TestRestructured(t *testing.T) {
+	rwr := testTPM(t)
+	for _, tc := range []struct {
+		name     string
+		doc      string
+		old, new string
+	}{
+		{
+			name: "list to map",
+			doc:  "a:\n  - x\n",
+			old:  "\n  - ENC[",
+			new:  "\n  \"0\": ENC[",
+		},
+		{
+			name: "nested map to key with a colon",
+			doc:  "a:\n  b: x\n",
+			old:  "a:\n  b: ENC[",
+			new:  "a:b: ENC[",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			enc, err := Encrypt([]byte(tc.doc), &EncryptOptions{
+				Recipients: []*Recipient{SealedRecipient(rwr, "test", nil, nil)},
+			})
+			if err != nil {
+				t.Fatalf("Encrypt: %v", err)
+			}
+			dec, err := Decrypt(rwr, enc, nil)
+			if err != nil {
+				t.Fatalf("Decrypt: %v", err)
+			}
+			if string(dec) != tc.doc {
+				t.Errorf("Decrypt = %q, want %q", dec, tc.doc)
+			}
+
+			if !strings.Contains(string(enc), tc.old) {
+				t.Fatalf("encrypted document has no %q:\n%s", tc.old, enc)
+			}
+			moved := strings.Replace(string(enc), tc.old, tc.new, 1)
+			if _, err := Decrypt(rwr, []byte(moved), nil); err == nil || !strings.Contains(err.Error(), "MAC mismatch") {
+				t.Errorf("Decrypt of the restructured document = %v, want a MAC mismatch", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	keyfile "github.com/foxboron/go-tpm-keyfiles"
+	"github.com/google/go-tpm-tools/simulator"
+	"github.com/google/go-tpm/tpm2/transport"
+	"github.com/google/go-tpm/tpmutil"
+	"github.com/ibiscum/tpm2/sigv4"
+	"github.com/ibiscum/tpm2/tpmkey"
+)
+
+var (
+	tpmPath     = flag.String("tpm-path", "/dev/tpmrm0", "Path to the TPM device (character device or a Unix socket).")
+	in          = flag.String("in", "aws.pem", "keyfile written by enroll")
+	password    = flag.String("password", "", "optional key password")
+	accessKeyID = flag.String("access-key-id", "AKIDEXAMPLE", "AWS access key id")
+	region      = flag.String("region", "us-east-1", "AWS region")
+	service     = flag.String("service", "service", "AWS service")
+	method      = flag.String("method", "GET", "HTTP method")
+	reqURL      = flag.String("url", "https://example.amazonaws.com/", "request URL")
+	data        = flag.String("data", "", "request body")
+	date        = flag.String("date", "", "X-Amz-Date to sign with (eg 20150830T123600Z), defaults to now")
+)
+
+var TPMDEVICES = []string{"/dev/tpm0", "/dev/tpmrm0"}
+
+func OpenTPM(path string) (io.ReadWriteCloser, error) {
+	if slices.Contains(TPMDEVICES, path) {
+		return tpmutil.OpenTPM(path)
+	} else if path == "simulator" {
+		return simulator.GetWithFixedSeedInsecure(1073741825)
+	} else {
+		return net.Dial("tcp", path)
+	}
+}
+
+func main() {
+	flag.Parse()
+
+	t := time.Now()
+	if *date != "" {
+		var err error
+		t, err = time.Parse("20060102T150405Z", *date)
+		if err != nil {
+			log.Fatalf("bad date: %v", err)
+		}
+	}
+
+	b, err := os.ReadFile(*in)
+	if err != nil {
+		log.Fatalf("can't read keyfile: %v", err)
+	}
+	kf, err := keyfile.Decode(b)
+	if err != nil {
+		log.Fatalf("can't decode keyfile: %v", err)
+	}
+
+	rwc, err := OpenTPM(*tpmPath)
+	if err != nil {
+		log.Fatalf("can't open TPM %q: %v", *tpmPath, err)
+	}
+	defer func() {
+		rwc.Close()
+	}()
+
+	rwr := transport.FromReadWriter(rwc)
+
+	k, err := tpmkey.Load(rwr, kf, []byte(*password))
+	if err != nil {
+		log.Fatalf("can't load key: %v", err)
+	}
+	defer k.Close()
+
+	signer := &sigv4.Signer{
+		Key:         k,
+		AccessKeyID: *accessKeyID,
+		Region:      *region,
+		Service:     *service,
+	}
+
+	req, err := http.NewRequest(*method, *reqURL, strings.NewReader(*data))
+	if err != nil {
+		log.Fatalf("can't create request: %v", err)
+	}
+	if *data == "" {
+		req.Body = http.NoBody
+	}
+
+	payloadHash, err := sigv4.PayloadHash(req)
+	if err != nil {
+		log.Fatalf("can't hash payload: %v", err)
+	}
+	if err := signer.Sign(req, payloadHash, t); err != nil {
+		log.Fatalf("can't sign request: %v", err)
+	}
+
+	log.Printf("canonical request:\n%s", signer.CanonicalRequest(req, payloadHash))
+	log.Printf("X-Amz-Date: %s", req.Header.Get("X-Amz-Date"))
+	log.Printf("Authorization: %s", req.Header.Get("Authorization"))
+}
@@ -0,0 +1,318 @@
+// Package sigv4 signs HTTP requests with AWS Signature Version 4 where the
+// secret access key only exists inside the TPM.
+//
+// The secret is imported once, prefixed with "AWS4", as a TPM HMAC-SHA256
+// key.  The date/region/service/aws4_request signing-key chain and the final
+// signature are all computed with TPM HMAC operations.
+package sigv4
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	keyfile "github.com/foxboron/go-tpm-keyfiles"
+	"github.com/google/go-tpm/tpm2"
+	"github.com/google/go-tpm/tpm2/transport"
+	"github.com/ibiscum/tpm2/tpmkey"
+)
+
+const (
+	algorithm  = "AWS4-HMAC-SHA256"
+	timeFormat = "20060102T150405Z"
+	dateFormat = "20060102"
+
+	// UnsignedPayload may be set as X-Amz-Content-Sha256 to skip body hashing.
+	UnsignedPayload = "UNSIGNED-PAYLOAD"
+)
+
+// headers never included in the signature since proxies rewrite them
+var ignoredHeaders = map[string]bool{
+	"authorization":   true,
+	"user-agent":      true,
+	"x-amzn-trace-id": true,
+	"expect":          true,
+}
+
+// Enroll imports secretAccessKey as the "AWS4"+secret HMAC-SHA256 key SigV4
+// starts its signing-key chain with.
+func Enroll(rwr transport.TPM, secretAccessKey string, auth []byte, pol *tpmkey.Policy) (*keyfile.TPMKey, error) {
+	return tpmkey.CreateHMAC(rwr, []byte("AWS4"+secretAccessKey), tpm2.TPMAlgSHA256, auth, pol)
+}
+
+// Signer signs requests for one region and service.
+type Signer struct {
+	// Key is the HMAC key created by Enroll.
+	Key          *tpmkey.Key
+	AccessKeyID  string
+	SessionToken string
+	Region       string
+	Service      string
+
+	// DisableURIPathEscaping signs the path as S3 wants it: not normalized,
+	// and URI-encoded once from its decoded form rather than a second time
+	// over the encoded one.
+	DisableURIPathEscaping bool
+}
+
+// SigningKey derives the aws4_request signing key for the given day.  Every
+// HMAC runs on the TPM: the first with the resident key, the rest with the
+// intermediate keys loaded for a single operation.
+func (s *Signer) SigningKey(t time.Time) ([]byte, error) {
+	kDate, err := s.Key.HMAC([]byte(t.UTC().Format(dateFormat)))
+	if err != nil {
+		return nil, err
+	}
+	rwr := s.Key.TPM()
+	kRegion, err := tpmkey.HMACExternal(rwr, kDate, tpm2.TPMAlgSHA256, []byte(s.Region))
+	if err != nil {
+		return nil, err
+	}
+	kService, err := tpmkey.HMACExternal(rwr, kRegion, tpm2.TPMAlgSHA256, []byte(s.Service))
+	if err != nil {
+		return nil, err
+	}
+	return tpmkey.HMACExternal(rwr, kService, tpm2.TPMAlgSHA256, []byte("aws4_request"))
+}
+
+// Sign adds the X-Amz-Date and Authorization headers to req.  payloadHash
+// is the hex SHA-256 of the body, or UnsignedPayload.
+func (s *Signer) Sign(req *http.Request, payloadHash string, t time.Time) error {
+	t = t.UTC()
+	req.Header.Set("X-Amz-Date", t.Format(timeFormat))
+	if s.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.SessionToken)
+	}
+
+	creq, signedHeaders := s.canonicalRequest(req, payloadHash)
+	scope := strings.Join([]string{t.Format(dateFormat), s.Region, s.Service, "aws4_request"}, "/")
+	sts := StringToSign(t, scope, creq)
+
+	key, err := s.SigningKey(t)
+	if err != nil {
+		return err
+	}
+	sig, err := tpmkey.HMACExternal(s.Key.TPM(), key, tpm2.TPMAlgSHA256, []byte(sts))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		algorithm, s.AccessKeyID, scope, signedHeaders, hex.EncodeToString(sig)))
+	return nil
+}
+
+// StringToSign builds the SigV4 string to sign for a canonical request.
+func StringToSign(t time.Time, scope, canonicalRequest string) string {
+	h := sha256.Sum256([]byte(canonicalRequest))
+	return strings.Join([]string{
+		algorithm,
+		t.UTC().Format(timeFormat),
+		scope,
+		hex.EncodeToString(h[:]),
+	}, "\n")
+}
+
+// CanonicalRequest returns the canonical form of req that gets signed.
+func (s *Signer) CanonicalRequest(req *http.Request, payloadHash string) string {
+	creq, _ := s.canonicalRequest(req, payloadHash)
+	return creq
+}
+
+func (s *Signer) canonicalRequest(req *http.Request, payloadHash string) (string, string) {
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+
+	headers := map[string][]string{
+		"host": {host},
+	}
+	for k, v := range req.Header {
+		lk := strings.ToLower(k)
+		if ignoredHeaders[lk] || lk == "host" {
+			continue
+		}
+		headers[lk] = append(headers[lk], v...)
+	}
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	var ch strings.Builder
+	for _, k := range names {
+		vals := make([]string, len(headers[k]))
+		for i, v := range headers[k] {
+			vals[i] = strings.Join(strings.Fields(v), " ")
+		}
+		ch.WriteString(k + ":" + strings.Join(vals, ",") + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	path := uriPath(req.URL)
+	if s.DisableURIPathEscaping {
+		if p, err := url.PathUnescape(path); err == nil {
+			path = p
+		}
+	} else {
+		path = normalizePath(path)
+	}
+	path = escape(path, false)
+
+	return strings.Join([]string{
+		req.Method,
+		path,
+		canonicalQuery(req.URL.Query()),
+		ch.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n"), signedHeaders
+}
+
+// uriPath returns the path as the request line carries it: u.Opaque when
+// set, as net/http sends that as is, the escaped path otherwise.
+func uriPath(u *url.URL) string {
+	p := u.EscapedPath()
+	if u.Opaque != "" {
+		p = u.Opaque
+		// "//host/path", with the host
+		if strings.HasPrefix(p, "//") {
+			p = p[2:]
+			if i := strings.IndexByte(p, '/'); i >= 0 {
+				p = p[i:]
+			} else {
+				p = ""
+			}
+		}
+	}
+	if p == "" {
+		p = "/"
+	}
+	return p
+}
+
+// normalizePath removes the "." and ".." segments and empty segments from
+// path, as RFC 3986 and SigV4 for services but S3 want, keeping a trailing
+// slash.
+func normalizePath(path string) string {
+	var segs []string
+	for _, seg := range strings.Split(path, "/") {
+		switch seg {
+		case "", ".":
+		case "..":
+			if len(segs) > 0 {
+				segs = segs[:len(segs)-1]
+			}
+		default:
+			segs = append(segs, seg)
+		}
+	}
+	out := "/" + strings.Join(segs, "/")
+	if len(segs) > 0 && (strings.HasSuffix(path, "/") || strings.HasSuffix(path, "/.") || strings.HasSuffix(path, "/..")) {
+		out += "/"
+	}
+	return out
+}
+
+func canonicalQuery(q url.Values) string {
+	keys := make([]string, 0, len(q))
+	for k := range q {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var parts []string
+	for _, k := range keys {
+		vals := append([]string(nil), q[k]...)
+		sort.Strings(vals)
+		for _, v := range vals {
+			parts = append(parts, escape(k, true)+"="+escape(v, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// escape percent-encodes everything but the RFC 3986 unreserved characters
+// (and '/' unless encodeSlash is set).
+func escape(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if ('A' <= c && c <= 'Z') || ('a' <= c && c <= 'z') || ('0' <= c && c <= '9') ||
+			c == '-' || c == '_' || c == '.' || c == '~' || (c == '/' && !encodeSlash) {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
+
+// PayloadHash reads and restores the request body and returns its hex
+// SHA-256, honouring a preset X-Amz-Content-Sha256 header.
+func PayloadHash(req *http.Request) (string, error) {
+	if v := req.Header.Get("X-Amz-Content-Sha256"); v != "" {
+		return v, nil
+	}
+	if req.Body == nil || req.Body == http.NoBody {
+		h := sha256.Sum256(nil)
+		return hex.EncodeToString(h[:]), nil
+	}
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		return "", err
+	}
+	req.Body.Close()
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	h := sha256.Sum256(body)
+	return hex.EncodeToString(h[:]), nil
+}
+
+// Transport is an http.RoundTripper that signs every request.
+type Transport struct {
+	Signer *Signer
+	// Base is used to send the signed request, http.DefaultTransport if nil.
+	Base http.RoundTripper
+	// Now returns the signing time, time.Now if nil.
+	Now func() time.Time
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// RoundTrippers must not modify the caller's request
+	r := req.Clone(req.Context())
+	if req.Body != nil && req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		r.Body = body
+	}
+
+	payloadHash, err := PayloadHash(r)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now
+	if t.Now != nil {
+		now = t.Now
+	}
+	if err := t.Signer.Sign(r, payloadHash, now()); err != nil {
+		return nil, fmt.Errorf("sigv4: can't sign request: %v", err)
+	}
+
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return base.RoundTrip(r)
+}
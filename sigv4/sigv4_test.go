@@ -0,0 +1,209 @@
+package sigv4
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-tpm-tools/simulator"
+	"github.com/google/go-tpm/tpm2/transport"
+	"github.com/ibiscum/tpm2/tpmkey"
+)
+
+const testSecret = "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"
+
+// testSigner enrolls the secret of the SigV4 test suite on a simulator.
+func testSigner(t *testing.T) *Signer {
+	t.Helper()
+	rwc, err := simulator.GetWithFixedSeedInsecure(1073741825)
+	if err != nil {
+		t.Fatalf("can't open simulator: %v", err)
+	}
+	t.Cleanup(func() { rwc.Close() })
+	rwr := transport.FromReadWriter(rwc)
+
+	kf, err := Enroll(rwr, testSecret, nil, nil)
+	if err != nil {
+		t.Fatalf("Enroll: %v", err)
+	}
+	k, err := tpmkey.Load(rwr, kf, nil)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	t.Cleanup(func() { k.Close() })
+	return &Signer{
+		Key:         k,
+		AccessKeyID: "AKIDEXAMPLE",
+		Region:      "us-east-1",
+		Service:     "service",
+	}
+}
+
+var testDate = time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC)
+
+func TestSigningKey(t *testing.T) {
+	s := testSigner(t)
+	got, err := s.SigningKey(testDate)
+	if err != nil {
+		t.Fatalf("SigningKey: %v", err)
+	}
+
+	key := []byte("AWS4" + testSecret)
+	for _, m := range []string{"20150830", "us-east-1", "service", "aws4_request"} {
+		h := hmac.New(sha256.New, key)
+		h.Write([]byte(m))
+		key = h.Sum(nil)
+	}
+	if !hmac.Equal(got, key) {
+		t.Errorf("SigningKey = %x, want %x", got, key)
+	}
+}
+
+// The get-vanilla, get-vanilla-query-order-key-case, post-vanilla and path
+// normalization and encoding cases of the SigV4 test suite.
+func TestSignVectors(t *testing.T) {
+	s := testSigner(t)
+	for _, tc := range []struct {
+		name, method, url string
+		// opaque is the path as sent, for the suite's paths that aren't
+		// valid in a URL
+		opaque     string
+		creq, auth string
+	}{
+		{
+			name:   "get-vanilla",
+			method: "GET",
+			url:    "https://example.amazonaws.com/",
+			creq:   "GET\n/\n\nhost:example.amazonaws.com\nx-amz-date:20150830T123600Z\n\nhost;x-amz-date\ne3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+			auth:   "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=host;x-amz-date, Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31",
+		},
+		{
+			name:   "get-vanilla-query-order-key-case",
+			method: "GET",
+			url:    "https://example.amazonaws.com/?Param2=value2&Param1=value1",
+			creq:   "GET\n/\nParam1=value1&Param2=value2\nhost:example.amazonaws.com\nx-amz-date:20150830T123600Z\n\nhost;x-amz-date\ne3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+			auth:   "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=host;x-amz-date, Signature=b97d918cfa904a5beff61c982a1b6f458b799221646efd99d3219ec94cdf2500",
+		},
+		{
+			name:   "post-vanilla",
+			method: "POST",
+			url:    "https://example.amazonaws.com/",
+			creq:   "POST\n/\n\nhost:example.amazonaws.com\nx-amz-date:20150830T123600Z\n\nhost;x-amz-date\ne3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+			auth:   "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=host;x-amz-date, Signature=5da7c1a2acd57cee7505fc6676e4e544621c30862966e37dddb68e92efbe5d6b",
+		},
+		{
+			name:   "get-relative",
+			method: "GET",
+			url:    "https://example.amazonaws.com/example/..",
+			creq:   "GET\n/\n\nhost:example.amazonaws.com\nx-amz-date:20150830T123600Z\n\nhost;x-amz-date\ne3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+			auth:   "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=host;x-amz-date, Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31",
+		},
+		{
+			name:   "get-relative-relative",
+			method: "GET",
+			url:    "https://example.amazonaws.com/example1/example2/../..",
+			creq:   "GET\n/\n\nhost:example.amazonaws.com\nx-amz-date:20150830T123600Z\n\nhost;x-amz-date\ne3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+			auth:   "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=host;x-amz-date, Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31",
+		},
+		{
+			name:   "get-slash",
+			method: "GET",
+			url:    "https://example.amazonaws.com//",
+			creq:   "GET\n/\n\nhost:example.amazonaws.com\nx-amz-date:20150830T123600Z\n\nhost;x-amz-date\ne3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+			auth:   "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=host;x-amz-date, Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31",
+		},
+		{
+			name:   "get-slash-dot-slash",
+			method: "GET",
+			url:    "https://example.amazonaws.com/./",
+			creq:   "GET\n/\n\nhost:example.amazonaws.com\nx-amz-date:20150830T123600Z\n\nhost;x-amz-date\ne3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+			auth:   "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=host;x-amz-date, Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31",
+		},
+		{
+			name:   "get-slash-pointless-dot",
+			method: "GET",
+			url:    "https://example.amazonaws.com/./example",
+			creq:   "GET\n/example\n\nhost:example.amazonaws.com\nx-amz-date:20150830T123600Z\n\nhost;x-amz-date\ne3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+			auth:   "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=host;x-amz-date, Signature=ef75d96142cf21edca26f06005da7988e4f8dc83a165a80865db7089db637ec5",
+		},
+		{
+			name:   "get-slashes",
+			method: "GET",
+			url:    "https://example.amazonaws.com//example//",
+			creq:   "GET\n/example/\n\nhost:example.amazonaws.com\nx-amz-date:20150830T123600Z\n\nhost;x-amz-date\ne3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+			auth:   "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=host;x-amz-date, Signature=9a624bd73a37c9a373b5312afbebe7a714a789de108f0bdfe846570885f57e84",
+		},
+		{
+			name:   "get-space",
+			method: "GET",
+			url:    "https://example.amazonaws.com/",
+			opaque: "/example space/",
+			creq:   "GET\n/example%20space/\n\nhost:example.amazonaws.com\nx-amz-date:20150830T123600Z\n\nhost;x-amz-date\ne3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+			auth:   "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=host;x-amz-date, Signature=652487583200325589f1fba4c7e578f72c47cb61beeca81406b39ddec1366741",
+		},
+		{
+			name:   "get-unreserved",
+			method: "GET",
+			url:    "https://example.amazonaws.com/-._~0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz",
+			creq:   "GET\n/-._~0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz\n\nhost:example.amazonaws.com\nx-amz-date:20150830T123600Z\n\nhost;x-amz-date\ne3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+			auth:   "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=host;x-amz-date, Signature=07ef7494c76fa4850883e2b006601f940f8a34d404d0cfa977f52a65bbf5f24f",
+		},
+		{
+			name:   "get-utf8",
+			method: "GET",
+			url:    "https://example.amazonaws.com/",
+			opaque: "/ሴ",
+			creq:   "GET\n/%E1%88%B4\n\nhost:example.amazonaws.com\nx-amz-date:20150830T123600Z\n\nhost;x-amz-date\ne3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+			auth:   "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=host;x-amz-date, Signature=8318018e0b0f223aa2bbf98705b62bb787dc9c0e678f255a891fd03141be5d85",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			req, err := http.NewRequest(tc.method, tc.url, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.URL.Opaque = tc.opaque
+			payloadHash, err := PayloadHash(req)
+			if err != nil {
+				t.Fatalf("PayloadHash: %v", err)
+			}
+			if err := s.Sign(req, payloadHash, testDate); err != nil {
+				t.Fatalf("Sign: %v", err)
+			}
+			if got := s.CanonicalRequest(req, payloadHash); got != tc.creq {
+				t.Errorf("CanonicalRequest =\n%s\nwant\n%s", got, tc.creq)
+			}
+			if got := req.Header.Get("Authorization"); got != tc.auth {
+				t.Errorf("Authorization =\n%s\nwant\n%s", got, tc.auth)
+			}
+		})
+	}
+}
+
+// Paths as net/http sends them: encoded a second time, and normalized, for
+// services but S3; encoded once from the decoded path, as is, for S3.
+func TestCanonicalPath(t *testing.T) {
+	for _, tc := range []struct {
+		url  string
+		s3   bool
+		want string
+	}{
+		{"https://example.amazonaws.com/a b/", false, "/a%2520b/"},
+		{"https://example.amazonaws.com/a@b$c/./d", false, "/a%40b%24c/d"},
+		{"https://example.amazonaws.com/a b/", true, "/a%20b/"},
+		{"https://example.amazonaws.com/a@b$c&d+e,f;g=h", true, "/a%40b%24c%26d%2Be%2Cf%3Bg%3Dh"},
+		{"https://example.amazonaws.com//a/../b", true, "//a/../b"},
+	} {
+		req, err := http.NewRequest("GET", tc.url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s := &Signer{DisableURIPathEscaping: tc.s3}
+		if got := strings.Split(s.CanonicalRequest(req, UnsignedPayload), "\n")[1]; got != tc.want {
+			t.Errorf("canonical path of %s (s3 %v) = %s, want %s", tc.url, tc.s3, got, tc.want)
+		}
+	}
+}
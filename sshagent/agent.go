@@ -0,0 +1,390 @@
+// Package sshagent is an ssh-agent serving keys that live in the TPM, loaded
+// from TSS2 keyfiles or persistent handles.  RSA keys sign ssh-rsa,
+// rsa-sha2-256 and rsa-sha2-512, ECC keys ecdsa-sha2-nistp256/384.
+//
+// It also writes SSHSIG file signatures, as made by "ssh-keygen -Y sign".
+package sshagent
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	keyfile "github.com/foxboron/go-tpm-keyfiles"
+	"github.com/google/go-tpm/tpm2"
+	"github.com/google/go-tpm/tpm2/transport"
+	"github.com/ibiscum/tpm2/tpmkey"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// Config controls how the agent treats keys that aren't in the TPM.
+type Config struct {
+	// TPMOnly refuses Add, Remove and RemoveAll so clients can neither put
+	// software keys into the agent nor drop the TPM keys.
+	TPMOnly bool
+	// Prompt is asked for the auth value of a key that needs one and was
+	// added without it, the first time the key is used, and again after a
+	// wrong one.  Without Prompt, a key whose auth value was wrong isn't
+	// used again.
+	Prompt func(comment string) ([]byte, error)
+}
+
+// tpmKey is a key served by the agent.  Keyfiles are only loaded for the
+// duration of a signature so any number of them fit in the TPM's few object
+// slots, even without a resource manager.
+type tpmKey struct {
+	rwr        transport.TPM
+	kf         *keyfile.TPMKey
+	persistent *tpmkey.Key
+	auth       []byte
+	pub        ssh.PublicKey
+	comment    string
+	needAuth   bool
+}
+
+// Agent implements agent.ExtendedAgent.
+type Agent struct {
+	cfg Config
+
+	// the TPM is shared by all keys and connections, so every TPM command
+	// goes through mu
+	mu         sync.Mutex
+	keys       []*tpmKey
+	keyring    agent.Agent
+	locked     bool
+	passphrase []byte
+}
+
+var _ agent.ExtendedAgent = (*Agent)(nil)
+
+var errLocked = errors.New("sshagent: agent is locked")
+
+// New returns an empty agent.
+func New(cfg Config) *Agent {
+	return &Agent{
+		cfg:     cfg,
+		keyring: agent.NewKeyring(),
+	}
+}
+
+// AddKeyfile serves the key of a TSS2 keyfile.  If the keyfile says the key
+// has an auth value and auth is nil, Config.Prompt is asked on first use.
+func (a *Agent) AddKeyfile(rwr transport.TPM, kf *keyfile.TPMKey, auth []byte, comment string) error {
+	pub, err := kf.PublicKey()
+	if err != nil {
+		return fmt.Errorf("sshagent: can't read keyfile public key: %v", err)
+	}
+	sp, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		return fmt.Errorf("sshagent: %v", err)
+	}
+	if comment == "" {
+		comment = kf.Description
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.keys = append(a.keys, &tpmKey{
+		rwr:      rwr,
+		kf:       kf,
+		auth:     auth,
+		pub:      sp,
+		comment:  comment,
+		needAuth: !kf.EmptyAuth && auth == nil,
+	})
+	return nil
+}
+
+// AddPersistent serves the key at a persistent handle.  Persistent objects
+// don't say whether they have an auth value, so auth is taken as given.
+func (a *Agent) AddPersistent(rwr transport.TPM, handle tpm2.TPMHandle, auth []byte, comment string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	k, err := tpmkey.LoadPersistent(rwr, handle, auth, nil)
+	if err != nil {
+		return err
+	}
+	pub, err := k.PublicKey()
+	if err != nil {
+		return err
+	}
+	sp, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		return fmt.Errorf("sshagent: %v", err)
+	}
+	if comment == "" {
+		comment = fmt.Sprintf("tpm:0x%x", uint32(handle))
+	}
+	a.keys = append(a.keys, &tpmKey{
+		rwr:        rwr,
+		persistent: k,
+		auth:       auth,
+		pub:        sp,
+		comment:    comment,
+	})
+	return nil
+}
+
+// sign loads the key if needed and signs data with algo.
+func (e *tpmKey) sign(data []byte, algo string) (*ssh.Signature, error) {
+	k := e.persistent
+	if k == nil {
+		var err error
+		k, err = tpmkey.Load(e.rwr, e.kf, e.auth)
+		if err != nil {
+			return nil, err
+		}
+		defer k.Close()
+	} else {
+		k.SetAuth(e.auth)
+	}
+
+	cs, err := k.Signer()
+	if err != nil {
+		return nil, err
+	}
+	s, err := ssh.NewSignerFromSigner(cs)
+	if err != nil {
+		return nil, fmt.Errorf("sshagent: %v", err)
+	}
+	as, ok := s.(ssh.AlgorithmSigner)
+	if !ok {
+		return nil, fmt.Errorf("sshagent: %s keys can't be used", s.PublicKey().Type())
+	}
+	return as.SignWithAlgorithm(rand.Reader, data, algo)
+}
+
+// Close forgets all TPM keys.  Persistent keys stay in the TPM.
+func (a *Agent) Close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.keys = nil
+	return nil
+}
+
+// List implements agent.Agent.
+func (a *Agent) List() ([]*agent.Key, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.locked {
+		return nil, nil
+	}
+
+	var ids []*agent.Key
+	for _, k := range a.keys {
+		pub := k.pub
+		ids = append(ids, &agent.Key{
+			Format:  pub.Type(),
+			Blob:    pub.Marshal(),
+			Comment: k.comment,
+		})
+	}
+	soft, err := a.keyring.List()
+	if err != nil {
+		return nil, err
+	}
+	return append(ids, soft...), nil
+}
+
+// Sign implements agent.Agent.
+func (a *Agent) Sign(key ssh.PublicKey, data []byte) (*ssh.Signature, error) {
+	return a.SignWithFlags(key, data, 0)
+}
+
+// SignWithFlags implements agent.ExtendedAgent.
+func (a *Agent) SignWithFlags(key ssh.PublicKey, data []byte, flags agent.SignatureFlags) (*ssh.Signature, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.locked {
+		return nil, errLocked
+	}
+
+	k := a.find(key)
+	if k == nil {
+		if ka, ok := a.keyring.(agent.ExtendedAgent); ok {
+			return ka.SignWithFlags(key, data, flags)
+		}
+		return a.keyring.Sign(key, data)
+	}
+
+	algo := key.Type()
+	if algo == ssh.KeyAlgoRSA {
+		switch {
+		case flags&agent.SignatureFlagRsaSha512 != 0:
+			algo = ssh.KeyAlgoRSASHA512
+		case flags&agent.SignatureFlagRsaSha256 != 0:
+			algo = ssh.KeyAlgoRSASHA256
+		}
+	}
+
+	if k.needAuth {
+		if a.cfg.Prompt == nil {
+			return nil, fmt.Errorf("sshagent: key %q needs an auth value", k.comment)
+		}
+		auth, err := a.cfg.Prompt(k.comment)
+		if err != nil {
+			return nil, err
+		}
+		k.auth = auth
+	}
+
+	sig, err := k.sign(data, algo)
+	if err != nil {
+		if tpmkey.AuthFailed(err) {
+			// ssh clients retry on their own and each try with the
+			// wrong value counts towards the TPM's dictionary attack
+			// lockout: forget it and ask for another one, or, without
+			// Prompt, stop using the key
+			k.auth = nil
+			k.needAuth = true
+		}
+		return nil, err
+	}
+	k.needAuth = false
+	return sig, nil
+}
+
+func (a *Agent) find(key ssh.PublicKey) *tpmKey {
+	blob := key.Marshal()
+	for _, k := range a.keys {
+		if bytes.Equal(k.pub.Marshal(), blob) {
+			return k
+		}
+	}
+	return nil
+}
+
+// Add implements agent.Agent.  Software keys go into an in-memory keyring
+// unless Config.TPMOnly is set.
+func (a *Agent) Add(key agent.AddedKey) error {
+	if a.cfg.TPMOnly {
+		return fmt.Errorf("sshagent: refusing to add software key")
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.locked {
+		return errLocked
+	}
+	return a.keyring.Add(key)
+}
+
+// Remove implements agent.Agent.  Persistent keys stay in the TPM.
+func (a *Agent) Remove(key ssh.PublicKey) error {
+	if a.cfg.TPMOnly {
+		return fmt.Errorf("sshagent: refusing to remove keys")
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.locked {
+		return errLocked
+	}
+
+	if k := a.find(key); k != nil {
+		for i := range a.keys {
+			if a.keys[i] == k {
+				a.keys = append(a.keys[:i], a.keys[i+1:]...)
+				break
+			}
+		}
+		return nil
+	}
+	return a.keyring.Remove(key)
+}
+
+// RemoveAll implements agent.Agent.
+func (a *Agent) RemoveAll() error {
+	if a.cfg.TPMOnly {
+		return fmt.Errorf("sshagent: refusing to remove keys")
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.locked {
+		return errLocked
+	}
+
+	a.keys = nil
+	return a.keyring.RemoveAll()
+}
+
+// Lock implements agent.Agent.
+func (a *Agent) Lock(passphrase []byte) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.locked {
+		return errLocked
+	}
+	a.locked = true
+	a.passphrase = append([]byte(nil), passphrase...)
+	return nil
+}
+
+// Unlock implements agent.Agent.
+func (a *Agent) Unlock(passphrase []byte) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if !a.locked {
+		return fmt.Errorf("sshagent: agent is not locked")
+	}
+	if subtle.ConstantTimeCompare(passphrase, a.passphrase) != 1 {
+		return fmt.Errorf("sshagent: incorrect passphrase")
+	}
+	a.locked = false
+	a.passphrase = nil
+	return nil
+}
+
+// Signers implements agent.Agent.  The TPM signers sign through the agent,
+// so the lock and auth prompt still apply.
+func (a *Agent) Signers() ([]ssh.Signer, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.locked {
+		return nil, errLocked
+	}
+
+	var signers []ssh.Signer
+	for _, k := range a.keys {
+		signers = append(signers, &agentSigner{a: a, pub: k.pub})
+	}
+	soft, err := a.keyring.Signers()
+	if err != nil {
+		return nil, err
+	}
+	return append(signers, soft...), nil
+}
+
+// Extension implements agent.ExtendedAgent.
+func (a *Agent) Extension(extensionType string, contents []byte) ([]byte, error) {
+	return nil, agent.ErrExtensionUnsupported
+}
+
+// agentSigner signs through the agent so the TPM stays serialized.
+type agentSigner struct {
+	a   *Agent
+	pub ssh.PublicKey
+}
+
+func (s *agentSigner) PublicKey() ssh.PublicKey {
+	return s.pub
+}
+
+func (s *agentSigner) Sign(_ io.Reader, data []byte) (*ssh.Signature, error) {
+	return s.a.Sign(s.pub, data)
+}
+
+func (s *agentSigner) SignWithAlgorithm(_ io.Reader, data []byte, algorithm string) (*ssh.Signature, error) {
+	var flags agent.SignatureFlags
+	switch algorithm {
+	case ssh.KeyAlgoRSASHA256:
+		flags = agent.SignatureFlagRsaSha256
+	case ssh.KeyAlgoRSASHA512:
+		flags = agent.SignatureFlagRsaSha512
+	}
+	return s.a.SignWithFlags(s.pub, data, flags)
+}
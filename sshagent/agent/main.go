@@ -0,0 +1,162 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+
+	keyfile "github.com/foxboron/go-tpm-keyfiles"
+	"github.com/google/go-tpm-tools/simulator"
+	"github.com/google/go-tpm/tpm2"
+	"github.com/google/go-tpm/tpm2/transport"
+	"github.com/google/go-tpm/tpmutil"
+	"github.com/ibiscum/tpm2/sshagent"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/term"
+)
+
+var (
+	tpmPath  = flag.String("tpm-path", "/dev/tpmrm0", "Path to the TPM device (character device or a Unix socket).")
+	socket   = flag.String("socket", filepath.Join(os.TempDir(), "tpm-ssh-agent.sock"), "agent socket to listen on")
+	keyfiles = flag.String("keyfiles", "", "comma separated TSS2 keyfiles to serve")
+	handles  = flag.String("handles", "", "comma separated persistent handles to serve (eg 0x81008002)")
+	password = flag.String("password", "", "auth value for all keys; keyfiles with auth are prompted for otherwise")
+	tpmOnly  = flag.Bool("tpm-only", true, "refuse adding or removing keys")
+)
+
+var TPMDEVICES = []string{"/dev/tpm0", "/dev/tpmrm0"}
+
+func OpenTPM(path string) (io.ReadWriteCloser, error) {
+	if slices.Contains(TPMDEVICES, path) {
+		return tpmutil.OpenTPM(path)
+	} else if path == "simulator" {
+		return simulator.GetWithFixedSeedInsecure(1073741825)
+	} else {
+		return net.Dial("tcp", path)
+	}
+}
+
+// prompt reads a key password from the controlling terminal.
+func prompt(comment string) ([]byte, error) {
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return nil, fmt.Errorf("no terminal to ask for the password of %q: %v", comment, err)
+	}
+	defer tty.Close()
+	fmt.Fprintf(tty, "password for %s: ", comment)
+	pw, err := term.ReadPassword(int(tty.Fd()))
+	fmt.Fprintln(tty)
+	return pw, err
+}
+
+func main() {
+	flag.Parse()
+
+	rwc, err := OpenTPM(*tpmPath)
+	if err != nil {
+		log.Fatalf("can't open TPM %q: %v", *tpmPath, err)
+	}
+	defer func() {
+		rwc.Close()
+	}()
+
+	rwr := transport.FromReadWriter(rwc)
+
+	a := sshagent.New(sshagent.Config{
+		TPMOnly: *tpmOnly,
+		Prompt:  prompt,
+	})
+	defer a.Close()
+
+	var auth []byte
+	if *password != "" {
+		auth = []byte(*password)
+	}
+
+	if *keyfiles != "" {
+		for _, f := range strings.Split(*keyfiles, ",") {
+			b, err := os.ReadFile(f)
+			if err != nil {
+				log.Fatalf("can't read keyfile: %v", err)
+			}
+			kf, err := keyfile.Decode(b)
+			if err != nil {
+				log.Fatalf("can't decode keyfile %s: %v", f, err)
+			}
+			comment := kf.Description
+			if comment == "" {
+				comment = f
+			}
+			if err := a.AddKeyfile(rwr, kf, auth, comment); err != nil {
+				log.Fatalf("can't add %s: %v", f, err)
+			}
+		}
+	}
+	if *handles != "" {
+		for _, h := range strings.Split(*handles, ",") {
+			v, err := strconv.ParseUint(h, 0, 32)
+			if err != nil {
+				log.Fatalf("bad handle %q: %v", h, err)
+			}
+			if err := a.AddPersistent(rwr, tpm2.TPMHandle(v), auth, ""); err != nil {
+				log.Fatalf("can't add handle %s: %v", h, err)
+			}
+		}
+	}
+
+	ids, err := a.List()
+	if err != nil {
+		log.Fatalf("can't list keys: %v", err)
+	}
+	if len(ids) == 0 {
+		log.Fatalf("no keys, use --keyfiles or --handles")
+	}
+	for _, id := range ids {
+		pub, err := ssh.ParsePublicKey(id.Blob)
+		if err != nil {
+			log.Fatalf("can't parse key: %v", err)
+		}
+		fmt.Printf("%s %s\n", strings.TrimSpace(string(ssh.MarshalAuthorizedKey(pub))), id.Comment)
+	}
+
+	os.Remove(*socket)
+	l, err := net.Listen("unix", *socket)
+	if err != nil {
+		log.Fatalf("can't listen on %s: %v", *socket, err)
+	}
+	if err := os.Chmod(*socket, 0600); err != nil {
+		log.Fatalf("can't restrict socket: %v", err)
+	}
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sigs
+		l.Close()
+	}()
+
+	log.Printf("export SSH_AUTH_SOCK=%s", *socket)
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			break
+		}
+		go func() {
+			defer c.Close()
+			if err := agent.ServeAgent(a, c); err != nil && err != io.EOF {
+				log.Printf("agent connection: %v", err)
+			}
+		}()
+	}
+	os.Remove(*socket)
+}
@@ -0,0 +1,152 @@
+package sshagent
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"hash"
+	"io"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// SSHSIG format, see PROTOCOL.sshsig in the OpenSSH sources.
+const (
+	sigMagic   = "SSHSIG"
+	sigVersion = 1
+	sigPEM     = "SSH SIGNATURE"
+)
+
+type sshsigBlob struct {
+	Version   uint32
+	PublicKey []byte
+	Namespace string
+	Reserved  string
+	HashAlg   string
+	Signature []byte
+}
+
+type sshsigSigned struct {
+	Namespace string
+	Reserved  string
+	HashAlg   string
+	Hash      []byte
+}
+
+func sshsigHash(alg string) (hash.Hash, error) {
+	switch alg {
+	case "sha256":
+		return sha256.New(), nil
+	case "sha512":
+		return sha512.New(), nil
+	}
+	return nil, fmt.Errorf("sshagent: unsupported SSHSIG hash %q", alg)
+}
+
+// signedData returns what the key actually signs for message.
+func signedData(namespace, hashAlg string, message io.Reader) ([]byte, error) {
+	h, err := sshsigHash(hashAlg)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.Copy(h, message); err != nil {
+		return nil, err
+	}
+	return append([]byte(sigMagic), ssh.Marshal(sshsigSigned{
+		Namespace: namespace,
+		HashAlg:   hashAlg,
+		Hash:      h.Sum(nil),
+	})...), nil
+}
+
+// SignFile returns an armored SSHSIG signature of message in namespace (eg
+// "git" or "file"), the same as "ssh-keygen -Y sign -n namespace" writes.
+// Like ssh-keygen the message is hashed with SHA-512 and RSA keys sign
+// rsa-sha2-512.  a is typically the TPM Agent but any agent will do.
+func SignFile(a agent.ExtendedAgent, key ssh.PublicKey, namespace string, message io.Reader) ([]byte, error) {
+	if namespace == "" {
+		return nil, fmt.Errorf("sshagent: SSHSIG needs a namespace")
+	}
+	data, err := signedData(namespace, "sha512", message)
+	if err != nil {
+		return nil, err
+	}
+
+	var flags agent.SignatureFlags
+	if key.Type() == ssh.KeyAlgoRSA {
+		flags = agent.SignatureFlagRsaSha512
+	}
+	sig, err := a.SignWithFlags(key, data, flags)
+	if err != nil {
+		return nil, err
+	}
+
+	blob := append([]byte(sigMagic), ssh.Marshal(sshsigBlob{
+		Version:   sigVersion,
+		PublicKey: key.Marshal(),
+		Namespace: namespace,
+		HashAlg:   "sha512",
+		Signature: ssh.Marshal(sig),
+	})...)
+
+	// ssh-keygen wraps at 70 columns, pem.Encode at 64; both are read back
+	// fine but match it anyway
+	enc := base64.StdEncoding.EncodeToString(blob)
+	var b strings.Builder
+	b.WriteString("-----BEGIN " + sigPEM + "-----\n")
+	for len(enc) > 70 {
+		b.WriteString(enc[:70] + "\n")
+		enc = enc[70:]
+	}
+	b.WriteString(enc + "\n")
+	b.WriteString("-----END " + sigPEM + "-----\n")
+	return []byte(b.String()), nil
+}
+
+// VerifyFile checks an armored SSHSIG signature over message and returns the
+// signing key.  The caller decides whether it trusts that key, as
+// ssh-keygen -Y verify does with its allowed signers file.
+func VerifyFile(armored []byte, namespace string, message io.Reader) (ssh.PublicKey, error) {
+	block, _ := pem.Decode(armored)
+	if block == nil || block.Type != sigPEM {
+		return nil, fmt.Errorf("sshagent: no SSH SIGNATURE block")
+	}
+	if !bytes.HasPrefix(block.Bytes, []byte(sigMagic)) {
+		return nil, fmt.Errorf("sshagent: bad SSHSIG magic")
+	}
+	var s sshsigBlob
+	if err := ssh.Unmarshal(block.Bytes[len(sigMagic):], &s); err != nil {
+		return nil, fmt.Errorf("sshagent: bad SSHSIG blob: %v", err)
+	}
+	if s.Version != sigVersion {
+		return nil, fmt.Errorf("sshagent: unsupported SSHSIG version %d", s.Version)
+	}
+	if s.Namespace != namespace {
+		return nil, fmt.Errorf("sshagent: signature is for namespace %q, not %q", s.Namespace, namespace)
+	}
+	pub, err := ssh.ParsePublicKey(s.PublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("sshagent: bad public key: %v", err)
+	}
+	var sig ssh.Signature
+	if err := ssh.Unmarshal(s.Signature, &sig); err != nil {
+		return nil, fmt.Errorf("sshagent: bad signature: %v", err)
+	}
+	if pub.Type() == ssh.KeyAlgoRSA && sig.Format == ssh.KeyAlgoRSA {
+		return nil, fmt.Errorf("sshagent: SHA-1 RSA signatures are not accepted")
+	}
+
+	data, err := signedData(s.Namespace, s.HashAlg, message)
+	if err != nil {
+		return nil, err
+	}
+	if err := pub.Verify(data, &sig); err != nil {
+		return nil, fmt.Errorf("sshagent: verification failed: %v", err)
+	}
+	return pub, nil
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"slices"
+
+	keyfile "github.com/foxboron/go-tpm-keyfiles"
+	"github.com/google/go-tpm-tools/simulator"
+	"github.com/google/go-tpm/tpm2/transport"
+	"github.com/google/go-tpm/tpmutil"
+	"github.com/ibiscum/tpm2/sshagent"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+var (
+	tpmPath   = flag.String("tpm-path", "/dev/tpmrm0", "Path to the TPM device (character device or a Unix socket).")
+	in        = flag.String("in", "", "TSS2 keyfile to sign with; if unset the key given by --pub is used through $SSH_AUTH_SOCK")
+	pubFile   = flag.String("pub", "", "authorized_keys style public key to sign with through the agent")
+	password  = flag.String("password", "", "optional key password")
+	namespace = flag.String("n", "file", "SSHSIG namespace (git uses \"git\")")
+	file      = flag.String("file", "", "file to sign; the signature is written to file.sig")
+	verify    = flag.Bool("verify", false, "verify file.sig instead of signing")
+)
+
+var TPMDEVICES = []string{"/dev/tpm0", "/dev/tpmrm0"}
+
+func OpenTPM(path string) (io.ReadWriteCloser, error) {
+	if slices.Contains(TPMDEVICES, path) {
+		return tpmutil.OpenTPM(path)
+	} else if path == "simulator" {
+		return simulator.GetWithFixedSeedInsecure(1073741825)
+	} else {
+		return net.Dial("tcp", path)
+	}
+}
+
+func main() {
+	flag.Parse()
+
+	msg, err := os.ReadFile(*file)
+	if err != nil {
+		log.Fatalf("can't read file: %v", err)
+	}
+
+	if *verify {
+		sig, err := os.ReadFile(*file + ".sig")
+		if err != nil {
+			log.Fatalf("can't read signature: %v", err)
+		}
+		pub, err := sshagent.VerifyFile(sig, *namespace, bytes.NewReader(msg))
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		log.Printf("good %q signature by %s", *namespace, ssh.FingerprintSHA256(pub))
+		return
+	}
+
+	var a agent.ExtendedAgent
+	var pub ssh.PublicKey
+	if *in != "" {
+		b, err := os.ReadFile(*in)
+		if err != nil {
+			log.Fatalf("can't read keyfile: %v", err)
+		}
+		kf, err := keyfile.Decode(b)
+		if err != nil {
+			log.Fatalf("can't decode keyfile: %v", err)
+		}
+
+		rwc, err := OpenTPM(*tpmPath)
+		if err != nil {
+			log.Fatalf("can't open TPM %q: %v", *tpmPath, err)
+		}
+		defer func() {
+			rwc.Close()
+		}()
+
+		rwr := transport.FromReadWriter(rwc)
+
+		ta := sshagent.New(sshagent.Config{})
+		defer ta.Close()
+		if err := ta.AddKeyfile(rwr, kf, []byte(*password), *in); err != nil {
+			log.Fatalf("can't load key: %v", err)
+		}
+		signers, err := ta.Signers()
+		if err != nil {
+			log.Fatalf("can't list keys: %v", err)
+		}
+		a, pub = ta, signers[0].PublicKey()
+	} else {
+		sock := os.Getenv("SSH_AUTH_SOCK")
+		c, err := net.Dial("unix", sock)
+		if err != nil {
+			log.Fatalf("can't reach agent at %q: %v", sock, err)
+		}
+		defer c.Close()
+		a = agent.NewClient(c)
+
+		b, err := os.ReadFile(*pubFile)
+		if err != nil {
+			log.Fatalf("can't read public key: %v", err)
+		}
+		pub, _, _, _, err = ssh.ParseAuthorizedKey(b)
+		if err != nil {
+			log.Fatalf("can't parse public key: %v", err)
+		}
+	}
+
+	sig, err := sshagent.SignFile(a, pub, *namespace, bytes.NewReader(msg))
+	if err != nil {
+		log.Fatalf("can't sign: %v", err)
+	}
+	if err := os.WriteFile(*file+".sig", sig, 0644); err != nil {
+		log.Fatalf("can't write signature: %v", err)
+	}
+	log.Printf("wrote %s.sig", *file)
+	fmt.Printf("%s", ssh.MarshalAuthorizedKey(pub))
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"crypto/tls"
+	"flag"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"slices"
+
+	"github.com/google/go-tpm-tools/simulator"
+	"github.com/google/go-tpm/tpm2/transport"
+	"github.com/google/go-tpm/tpmutil"
+	"github.com/ibiscum/tpm2/tls/tpmtls"
+)
+
+var (
+	tpmPath    = flag.String("tpm-path", "/dev/tpmrm0", "Path to the TPM device (character device or a Unix socket).")
+	key        = flag.String("key", "", "TSS2 keyfile or persistent handle (eg 0x81008001) of the client key (mTLS)")
+	password   = flag.String("password", "", "optional key password")
+	cert       = flag.String("cert", "", "PEM certificate chain of the client key, leaf first")
+	ca         = flag.String("ca", "", "PEM CA certificates to verify the server with")
+	url        = flag.String("url", "https://127.0.0.1:8443/", "URL to fetch")
+	serverName = flag.String("server-name", "", "name to verify the server certificate against (default: from --url)")
+	tlsVersion = flag.String("tls-version", "", "only offer TLS 1.2 or 1.3 (default: both)")
+)
+
+var TPMDEVICES = []string{"/dev/tpm0", "/dev/tpmrm0"}
+
+func OpenTPM(path string) (io.ReadWriteCloser, error) {
+	if slices.Contains(TPMDEVICES, path) {
+		return tpmutil.OpenTPM(path)
+	} else if path == "simulator" {
+		return simulator.GetWithFixedSeedInsecure(1073741825)
+	} else {
+		return net.Dial("tcp", path)
+	}
+}
+
+func versions(v string) (uint16, uint16) {
+	switch v {
+	case "":
+		return tls.VersionTLS12, tls.VersionTLS13
+	case "1.2":
+		return tls.VersionTLS12, tls.VersionTLS12
+	case "1.3":
+		return tls.VersionTLS13, tls.VersionTLS13
+	}
+	log.Fatalf("bad --tls-version %q, use 1.2 or 1.3", v)
+	return 0, 0
+}
+
+func main() {
+	flag.Parse()
+
+	minVersion, maxVersion := versions(*tlsVersion)
+	cfg := &tls.Config{
+		ServerName: *serverName,
+		MinVersion: minVersion,
+		MaxVersion: maxVersion,
+	}
+	if *ca != "" {
+		pool, err := tpmtls.CertPool(*ca)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		cfg.RootCAs = pool
+	}
+
+	if *key != "" {
+		if *cert == "" {
+			log.Fatalf("--cert is required with --key")
+		}
+		chain, err := os.ReadFile(*cert)
+		if err != nil {
+			log.Fatalf("can't read certificate: %v", err)
+		}
+
+		rwc, err := OpenTPM(*tpmPath)
+		if err != nil {
+			log.Fatalf("can't open TPM %q: %v", *tpmPath, err)
+		}
+		defer func() {
+			rwc.Close()
+		}()
+
+		rwr := transport.FromReadWriter(rwc)
+
+		k, err := tpmtls.LoadKey(rwr, *key, []byte(*password))
+		if err != nil {
+			log.Fatalf("can't load key: %v", err)
+		}
+		defer k.Close()
+
+		tlsCert, err := tpmtls.Certificate(k, chain)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		cfg.Certificates = []tls.Certificate{tlsCert}
+	}
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig: cfg,
+		},
+	}
+	rsp, err := client.Get(*url)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	defer rsp.Body.Close()
+	body, err := io.ReadAll(rsp.Body)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	log.Printf("%s over %s %s: %s", rsp.Status, tls.VersionName(rsp.TLS.Version),
+		tls.CipherSuiteName(rsp.TLS.CipherSuite), body)
+}
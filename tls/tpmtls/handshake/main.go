@@ -0,0 +1,340 @@
+package main
+
+import (
+	"bufio"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/google/go-tpm-tools/simulator"
+	"github.com/google/go-tpm/tpm2"
+	"github.com/google/go-tpm/tpm2/transport"
+	"github.com/google/go-tpm/tpmutil"
+	"github.com/ibiscum/tpm2/tls/tpmtls"
+	"github.com/ibiscum/tpm2/tpmkey"
+)
+
+var (
+	tpmPath = flag.String("tpm-path", "simulator", "Path to the TPM device (character device or a Unix socket).")
+)
+
+var TPMDEVICES = []string{"/dev/tpm0", "/dev/tpmrm0"}
+
+func OpenTPM(path string) (io.ReadWriteCloser, error) {
+	if slices.Contains(TPMDEVICES, path) {
+		return tpmutil.OpenTPM(path)
+	} else if path == "simulator" {
+		return simulator.GetWithFixedSeedInsecure(1073741825)
+	} else {
+		return net.Dial("tcp", path)
+	}
+}
+
+// lockedTPM serializes commands, the server and client sides of the
+// handshake share one TPM.
+type lockedTPM struct {
+	mu  sync.Mutex
+	tpm transport.TPM
+}
+
+func (l *lockedTPM) Send(cmd []byte) ([]byte, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.tpm.Send(cmd)
+}
+
+// recorder remembers how the TPM signer was asked to sign.
+type recorder struct {
+	crypto.Signer
+	mu   sync.Mutex
+	used string
+}
+
+func (r *recorder) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	alg := "PKCS1"
+	switch {
+	case isPSS(opts):
+		alg = "PSS"
+	case isECDSA(r.Public()):
+		alg = "ECDSA"
+	}
+	r.mu.Lock()
+	r.used = fmt.Sprintf("%s-%v", alg, opts.HashFunc())
+	r.mu.Unlock()
+	return r.Signer.Sign(rand, digest, opts)
+}
+
+func isPSS(opts crypto.SignerOpts) bool {
+	_, ok := opts.(*rsa.PSSOptions)
+	return ok
+}
+
+func isECDSA(pub crypto.PublicKey) bool {
+	_, ok := pub.(*ecdsa.PublicKey)
+	return ok
+}
+
+type testCase struct {
+	name     string
+	template tpm2.TPMTPublic
+	version  uint16
+	// schemes overrides the schemes the certificates advertise
+	schemes []tls.SignatureScheme
+	fail    bool
+}
+
+var cases = []testCase{
+	{
+		name:     "TLS 1.2 RSA-PKCS1",
+		template: tpmkey.RSATemplate(2048, tpm2.TPMAlgNull, tpm2.TPMAlgSHA256),
+		version:  tls.VersionTLS12,
+		schemes:  []tls.SignatureScheme{tls.PKCS1WithSHA256},
+	},
+	{
+		name:     "TLS 1.2 RSA-PSS",
+		template: tpmkey.RSATemplate(2048, tpm2.TPMAlgNull, tpm2.TPMAlgSHA256),
+		version:  tls.VersionTLS12,
+	},
+	{
+		name:     "TLS 1.2 ECDSA P-256",
+		template: tpmkey.ECCTemplate(tpm2.TPMECCNistP256, tpm2.TPMAlgNull, tpm2.TPMAlgSHA256),
+		version:  tls.VersionTLS12,
+	},
+	{
+		name:     "TLS 1.3 RSA-PSS",
+		template: tpmkey.RSATemplate(2048, tpm2.TPMAlgNull, tpm2.TPMAlgSHA256),
+		version:  tls.VersionTLS13,
+	},
+	{
+		name:     "TLS 1.3 ECDSA P-256",
+		template: tpmkey.ECCTemplate(tpm2.TPMECCNistP256, tpm2.TPMAlgNull, tpm2.TPMAlgSHA256),
+		version:  tls.VersionTLS13,
+	},
+	{
+		name:     "TLS 1.3 ECDSA P-384",
+		template: tpmkey.ECCTemplate(tpm2.TPMECCNistP384, tpm2.TPMAlgNull, tpm2.TPMAlgSHA384),
+		version:  tls.VersionTLS13,
+	},
+	{
+		name:     "TLS 1.2 RSASSA-only key",
+		template: tpmkey.RSATemplate(2048, tpm2.TPMAlgRSASSA, tpm2.TPMAlgSHA256),
+		version:  tls.VersionTLS12,
+	},
+	{
+		// TLS 1.3 has no PKCS#1 v1.5 handshake signatures
+		name:     "TLS 1.3 RSASSA-only key",
+		template: tpmkey.RSATemplate(2048, tpm2.TPMAlgRSASSA, tpm2.TPMAlgSHA256),
+		version:  tls.VersionTLS13,
+		fail:     true,
+	},
+}
+
+// ca is a throwaway software CA issuing the test certificates.
+type ca struct {
+	key  *ecdsa.PrivateKey
+	cert *x509.Certificate
+	pool *x509.CertPool
+}
+
+func newCA() (*ca, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "tpmtls test CA"},
+		NotBefore:             time.Now().Add(-time.Minute),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return &ca{key: key, cert: cert, pool: pool}, nil
+}
+
+func (c *ca) issue(pub crypto.PublicKey, cn string, eku x509.ExtKeyUsage) ([]byte, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 64))
+	if err != nil {
+		return nil, err
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{eku},
+	}
+	if eku == x509.ExtKeyUsageServerAuth {
+		tmpl.IPAddresses = []net.IP{net.IPv4(127, 0, 0, 1)}
+	}
+	return x509.CreateCertificate(rand.Reader, tmpl, c.cert, pub, c.key)
+}
+
+// tlsKey creates a key from template and returns its certificate.
+func tlsKey(rwr transport.TPM, c *ca, template tpm2.TPMTPublic, cn string, eku x509.ExtKeyUsage) (*tpmkey.Key, tls.Certificate, *recorder, error) {
+	kf, err := tpmkey.Create(rwr, template, nil, nil)
+	if err != nil {
+		return nil, tls.Certificate{}, nil, err
+	}
+	k, err := tpmkey.Load(rwr, kf, nil)
+	if err != nil {
+		return nil, tls.Certificate{}, nil, err
+	}
+	pub, err := k.PublicKey()
+	if err != nil {
+		k.Close()
+		return nil, tls.Certificate{}, nil, err
+	}
+	der, err := c.issue(pub, cn, eku)
+	if err != nil {
+		k.Close()
+		return nil, tls.Certificate{}, nil, err
+	}
+	cert, err := tpmtls.CertificateDER(k, [][]byte{der})
+	if err != nil {
+		k.Close()
+		return nil, tls.Certificate{}, nil, err
+	}
+	rec := &recorder{Signer: cert.PrivateKey.(crypto.Signer)}
+	cert.PrivateKey = rec
+	return k, cert, rec, nil
+}
+
+func run(rwr transport.TPM, c *ca, tc testCase) (string, error) {
+	sk, serverCert, serverRec, err := tlsKey(rwr, c, tc.template, "server", x509.ExtKeyUsageServerAuth)
+	if err != nil {
+		return "", err
+	}
+	defer sk.Close()
+	ck, clientCert, clientRec, err := tlsKey(rwr, c, tc.template, "client", x509.ExtKeyUsageClientAuth)
+	if err != nil {
+		return "", err
+	}
+	defer ck.Close()
+	if tc.schemes != nil {
+		serverCert.SupportedSignatureAlgorithms = tc.schemes
+		clientCert.SupportedSignatureAlgorithms = tc.schemes
+	}
+
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    c.pool,
+		MinVersion:   tc.version,
+		MaxVersion:   tc.version,
+	})
+	if err != nil {
+		return "", err
+	}
+	defer ln.Close()
+
+	serverErr := make(chan error, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			serverErr <- err
+			return
+		}
+		defer conn.Close()
+		line, err := bufio.NewReader(conn).ReadString('\n')
+		if err != nil {
+			serverErr <- err
+			return
+		}
+		_, err = conn.Write([]byte("echo " + line))
+		serverErr <- err
+	}()
+
+	conn, err := tls.Dial("tcp", ln.Addr().String(), &tls.Config{
+		Certificates: []tls.Certificate{clientCert},
+		RootCAs:      c.pool,
+		MinVersion:   tc.version,
+		MaxVersion:   tc.version,
+	})
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte("ping\n")); err != nil {
+		return "", err
+	}
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	if err := <-serverErr; err != nil {
+		return "", err
+	}
+	if line != "echo ping\n" {
+		return "", fmt.Errorf("unexpected reply %q", line)
+	}
+
+	st := conn.ConnectionState()
+	return fmt.Sprintf("%s %s, server signed %s, client signed %s",
+		tls.VersionName(st.Version), tls.CipherSuiteName(st.CipherSuite), serverRec.used, clientRec.used), nil
+}
+
+func main() {
+	flag.Parse()
+
+	rwc, err := OpenTPM(*tpmPath)
+	if err != nil {
+		log.Fatalf("can't open TPM %q: %v", *tpmPath, err)
+	}
+	defer func() {
+		rwc.Close()
+	}()
+
+	rwr := &lockedTPM{tpm: transport.FromReadWriter(rwc)}
+
+	c, err := newCA()
+	if err != nil {
+		log.Fatalf("can't create CA: %v", err)
+	}
+
+	failed := false
+	for _, tc := range cases {
+		res, err := run(rwr, c, tc)
+		switch {
+		case err == nil && !tc.fail:
+			log.Printf("PASS %-26s %s", tc.name, res)
+		case err != nil && tc.fail:
+			log.Printf("PASS %-26s refused as expected: %v", tc.name, err)
+		case err != nil:
+			log.Printf("FAIL %-26s %v", tc.name, err)
+			failed = true
+		default:
+			log.Printf("FAIL %-26s should have been refused: %s", tc.name, res)
+			failed = true
+		}
+	}
+	if failed {
+		log.Fatalf("some handshakes failed")
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"slices"
+
+	"github.com/google/go-tpm-tools/simulator"
+	"github.com/google/go-tpm/tpm2/transport"
+	"github.com/google/go-tpm/tpmutil"
+	"github.com/ibiscum/tpm2/tls/tpmtls"
+)
+
+var (
+	tpmPath    = flag.String("tpm-path", "/dev/tpmrm0", "Path to the TPM device (character device or a Unix socket).")
+	key        = flag.String("key", "", "TSS2 keyfile or persistent handle (eg 0x81008001) of the server key")
+	password   = flag.String("password", "", "optional key password")
+	cert       = flag.String("cert", "", "PEM certificate chain of the server key, leaf first")
+	clientCA   = flag.String("client-ca", "", "PEM CA certificates to require and verify client certificates with (mTLS)")
+	listen     = flag.String("listen", "127.0.0.1:8443", "address to listen on")
+	tlsVersion = flag.String("tls-version", "", "only accept TLS 1.2 or 1.3 (default: both)")
+)
+
+var TPMDEVICES = []string{"/dev/tpm0", "/dev/tpmrm0"}
+
+func OpenTPM(path string) (io.ReadWriteCloser, error) {
+	if slices.Contains(TPMDEVICES, path) {
+		return tpmutil.OpenTPM(path)
+	} else if path == "simulator" {
+		return simulator.GetWithFixedSeedInsecure(1073741825)
+	} else {
+		return net.Dial("tcp", path)
+	}
+}
+
+func versions(v string) (uint16, uint16) {
+	switch v {
+	case "":
+		return tls.VersionTLS12, tls.VersionTLS13
+	case "1.2":
+		return tls.VersionTLS12, tls.VersionTLS12
+	case "1.3":
+		return tls.VersionTLS13, tls.VersionTLS13
+	}
+	log.Fatalf("bad --tls-version %q, use 1.2 or 1.3", v)
+	return 0, 0
+}
+
+func main() {
+	flag.Parse()
+
+	if *key == "" || *cert == "" {
+		log.Fatalf("--key and --cert are required")
+	}
+	chain, err := os.ReadFile(*cert)
+	if err != nil {
+		log.Fatalf("can't read certificate: %v", err)
+	}
+
+	rwc, err := OpenTPM(*tpmPath)
+	if err != nil {
+		log.Fatalf("can't open TPM %q: %v", *tpmPath, err)
+	}
+	defer func() {
+		rwc.Close()
+	}()
+
+	rwr := transport.FromReadWriter(rwc)
+
+	k, err := tpmtls.LoadKey(rwr, *key, []byte(*password))
+	if err != nil {
+		log.Fatalf("can't load key: %v", err)
+	}
+	defer k.Close()
+
+	tlsCert, err := tpmtls.Certificate(k, chain)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	minVersion, maxVersion := versions(*tlsVersion)
+	cfg := &tls.Config{
+		Certificates: []tls.Certificate{tlsCert},
+		MinVersion:   minVersion,
+		MaxVersion:   maxVersion,
+	}
+	if *clientCA != "" {
+		cfg.ClientCAs, err = tpmtls.CertPool(*clientCA)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		client := "anonymous"
+		if len(r.TLS.PeerCertificates) > 0 {
+			client = r.TLS.PeerCertificates[0].Subject.CommonName
+		}
+		log.Printf("%s %s from %s over %s %s", r.Method, r.URL.Path, client,
+			tls.VersionName(r.TLS.Version), tls.CipherSuiteName(r.TLS.CipherSuite))
+		fmt.Fprintf(w, "hello %s\n", client)
+	})
+
+	srv := &http.Server{
+		Addr:      *listen,
+		TLSConfig: cfg,
+	}
+	log.Printf("listening on https://%s/ with %v", *listen, tlsCert.SupportedSignatureAlgorithms)
+	if err := srv.ListenAndServeTLS("", ""); err != nil {
+		log.Fatalf("%v", err)
+	}
+}
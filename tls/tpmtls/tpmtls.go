@@ -0,0 +1,193 @@
+// Package tpmtls builds crypto/tls certificates whose private key stays in
+// the TPM, for Go servers and mTLS clients.
+//
+// The handshake signature (CertificateVerify in TLS 1.3, ServerKeyExchange
+// in TLS 1.2) is made by tpmkey's crypto.Signer: RSASSA-PKCS1-v1_5 or
+// RSA-PSS for RSA keys, ECDSA for ECC keys.  The certificate advertises only
+// the signature schemes the key can actually produce, so keys created with a
+// fixed scheme negotiate correctly: an RSASSA-only key is limited to TLS 1.2
+// (TLS 1.3 requires PSS for RSA) and an ECDSA key to its curve's hash under
+// TLS 1.3.
+package tpmtls
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	keyfile "github.com/foxboron/go-tpm-keyfiles"
+	"github.com/google/go-tpm/tpm2"
+	"github.com/google/go-tpm/tpm2/transport"
+	"github.com/ibiscum/tpm2/tpmkey"
+)
+
+// LoadKey loads a TLS key given either as the path of a TSS2 PEM keyfile
+// or as a persistent handle ("0x81008001").
+func LoadKey(rwr transport.TPM, key string, auth []byte) (*tpmkey.Key, error) {
+	if strings.HasPrefix(key, "0x") {
+		h, err := strconv.ParseUint(key, 0, 32)
+		if err != nil {
+			return nil, fmt.Errorf("tpmtls: bad handle %q: %v", key, err)
+		}
+		return tpmkey.LoadPersistent(rwr, tpm2.TPMHandle(h), auth, nil)
+	}
+	b, err := os.ReadFile(key)
+	if err != nil {
+		return nil, fmt.Errorf("tpmtls: can't read keyfile: %v", err)
+	}
+	kf, err := keyfile.Decode(b)
+	if err != nil {
+		return nil, fmt.Errorf("tpmtls: can't decode keyfile: %v", err)
+	}
+	if kf.EmptyAuth {
+		auth = nil
+	}
+	return tpmkey.Load(rwr, kf, auth)
+}
+
+// Certificate returns a tls.Certificate for k with the PEM certificate
+// chain, leaf first.  The leaf's public key must be k's.
+func Certificate(k *tpmkey.Key, chainPEM []byte) (tls.Certificate, error) {
+	var chain [][]byte
+	for rest := chainPEM; ; {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type == "CERTIFICATE" {
+			chain = append(chain, block.Bytes)
+		}
+	}
+	if len(chain) == 0 {
+		return tls.Certificate{}, fmt.Errorf("tpmtls: no certificate in chain")
+	}
+	return CertificateDER(k, chain)
+}
+
+// CertificateDER is Certificate for DER certificates.
+func CertificateDER(k *tpmkey.Key, chain [][]byte) (tls.Certificate, error) {
+	if len(chain) == 0 {
+		return tls.Certificate{}, fmt.Errorf("tpmtls: no certificate in chain")
+	}
+	leaf, err := x509.ParseCertificate(chain[0])
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("tpmtls: bad certificate: %v", err)
+	}
+	signer, err := k.Signer()
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	if !samePublic(leaf.PublicKey, signer.Public()) {
+		return tls.Certificate{}, fmt.Errorf("tpmtls: certificate doesn't match the TPM key")
+	}
+	schemes, err := SignatureSchemes(k)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{
+		Certificate:                  chain,
+		PrivateKey:                   signer,
+		Leaf:                         leaf,
+		SupportedSignatureAlgorithms: schemes,
+	}, nil
+}
+
+// SignatureSchemes returns the TLS signature schemes k can sign, most
+// preferred first.
+func SignatureSchemes(k *tpmkey.Key) ([]tls.SignatureScheme, error) {
+	scheme, hash := k.Scheme()
+	switch k.Public.Type {
+	case tpm2.TPMAlgRSA:
+		pss := map[tpm2.TPMAlgID]tls.SignatureScheme{
+			tpm2.TPMAlgSHA256: tls.PSSWithSHA256,
+			tpm2.TPMAlgSHA384: tls.PSSWithSHA384,
+			tpm2.TPMAlgSHA512: tls.PSSWithSHA512,
+		}
+		pkcs1 := map[tpm2.TPMAlgID]tls.SignatureScheme{
+			tpm2.TPMAlgSHA256: tls.PKCS1WithSHA256,
+			tpm2.TPMAlgSHA384: tls.PKCS1WithSHA384,
+			tpm2.TPMAlgSHA512: tls.PKCS1WithSHA512,
+		}
+		switch scheme {
+		case tpm2.TPMAlgNull:
+			// SHA-512 is left out, plenty of TPMs don't implement it
+			return []tls.SignatureScheme{
+				tls.PSSWithSHA256, tls.PSSWithSHA384,
+				tls.PKCS1WithSHA256, tls.PKCS1WithSHA384,
+			}, nil
+		case tpm2.TPMAlgRSAPSS:
+			if s, ok := pss[hash]; ok {
+				return []tls.SignatureScheme{s}, nil
+			}
+		case tpm2.TPMAlgRSASSA:
+			if s, ok := pkcs1[hash]; ok {
+				return []tls.SignatureScheme{s}, nil
+			}
+		}
+		return nil, fmt.Errorf("tpmtls: RSA key scheme %v/%v can't be used for TLS", scheme, hash)
+	case tpm2.TPMAlgECC:
+		ecdsaSchemes := map[tpm2.TPMAlgID]tls.SignatureScheme{
+			tpm2.TPMAlgSHA256: tls.ECDSAWithP256AndSHA256,
+			tpm2.TPMAlgSHA384: tls.ECDSAWithP384AndSHA384,
+			tpm2.TPMAlgSHA512: tls.ECDSAWithP521AndSHA512,
+		}
+		if scheme != tpm2.TPMAlgNull && scheme != tpm2.TPMAlgECDSA {
+			return nil, fmt.Errorf("tpmtls: ECC key scheme %v can't be used for TLS", scheme)
+		}
+		if scheme == tpm2.TPMAlgNull {
+			// the hash TLS 1.3 pairs with the curve
+			pub, err := k.PublicKey()
+			if err != nil {
+				return nil, err
+			}
+			switch pub.(*ecdsa.PublicKey).Curve {
+			case elliptic.P256():
+				hash = tpm2.TPMAlgSHA256
+			case elliptic.P384():
+				hash = tpm2.TPMAlgSHA384
+			case elliptic.P521():
+				hash = tpm2.TPMAlgSHA512
+			}
+		}
+		if s, ok := ecdsaSchemes[hash]; ok {
+			return []tls.SignatureScheme{s}, nil
+		}
+		return nil, fmt.Errorf("tpmtls: ECC key hash %v can't be used for TLS", hash)
+	}
+	return nil, fmt.Errorf("tpmtls: unsupported key type %v", k.Public.Type)
+}
+
+// CertPool reads a PEM file of CA certificates.
+func CertPool(file string) (*x509.CertPool, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("tpmtls: can't read CA certificates: %v", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(b) {
+		return nil, fmt.Errorf("tpmtls: no certificates in %s", file)
+	}
+	return pool, nil
+}
+
+func samePublic(a, b crypto.PublicKey) bool {
+	switch a := a.(type) {
+	case *rsa.PublicKey:
+		return a.Equal(b)
+	case *ecdsa.PublicKey:
+		return a.Equal(b)
+	}
+	ad, err1 := x509.MarshalPKIXPublicKey(a)
+	bd, err2 := x509.MarshalPKIXPublicKey(b)
+	return err1 == nil && err2 == nil && bytes.Equal(ad, bd)
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"io"
+	"log"
+	"net"
+	"os"
+	"slices"
+	"strings"
+
+	keyfile "github.com/foxboron/go-tpm-keyfiles"
+	"github.com/google/go-tpm-tools/simulator"
+	"github.com/google/go-tpm/tpm2/transport"
+	"github.com/google/go-tpm/tpmutil"
+	"github.com/ibiscum/tpm2/tokensource"
+	"github.com/ibiscum/tpm2/tpmkey"
+)
+
+var (
+	tpmPath  = flag.String("tpm-path", "/dev/tpmrm0", "Path to the TPM device (character device or a Unix socket).")
+	in       = flag.String("in", "sa.pem", "TSS2 keyfile holding the RSA key")
+	password = flag.String("password", "", "optional key password")
+	tokenURL = flag.String("token-url", "http://localhost:8080/token", "token endpoint")
+	issuer   = flag.String("issuer", "svc@example.com", "assertion issuer")
+	subject  = flag.String("subject", "svc@example.com", "assertion subject")
+	scopes   = flag.String("scopes", "", "space separated scopes")
+	keyID    = flag.String("kid", "", "optional key id")
+)
+
+var TPMDEVICES = []string{"/dev/tpm0", "/dev/tpmrm0"}
+
+func OpenTPM(path string) (io.ReadWriteCloser, error) {
+	if slices.Contains(TPMDEVICES, path) {
+		return tpmutil.OpenTPM(path)
+	} else if path == "simulator" {
+		return simulator.GetWithFixedSeedInsecure(1073741825)
+	} else {
+		return net.Dial("tcp", path)
+	}
+}
+
+func main() {
+	flag.Parse()
+
+	b, err := os.ReadFile(*in)
+	if err != nil {
+		log.Fatalf("can't read keyfile: %v", err)
+	}
+	kf, err := keyfile.Decode(b)
+	if err != nil {
+		log.Fatalf("can't decode keyfile: %v", err)
+	}
+
+	rwc, err := OpenTPM(*tpmPath)
+	if err != nil {
+		log.Fatalf("can't open TPM %q: %v", *tpmPath, err)
+	}
+	defer func() {
+		rwc.Close()
+	}()
+
+	rwr := transport.FromReadWriter(rwc)
+
+	k, err := tpmkey.Load(rwr, kf, []byte(*password))
+	if err != nil {
+		log.Fatalf("can't load key: %v", err)
+	}
+	defer k.Close()
+
+	signer, err := k.Signer()
+	if err != nil {
+		log.Fatalf("can't get signer: %v", err)
+	}
+
+	conf := &tokensource.Config{
+		Signer:   signer,
+		KeyID:    *keyID,
+		Issuer:   *issuer,
+		Subject:  *subject,
+		TokenURL: *tokenURL,
+		Scopes:   strings.Fields(*scopes),
+	}
+	ts := conf.TokenSource(context.Background())
+
+	// the second call is served from the cache
+	for i := 0; i < 2; i++ {
+		tok, err := ts.Token()
+		if err != nil {
+			log.Fatalf("can't get token: %v", err)
+		}
+		log.Printf("token: %s expires %s", tok.AccessToken, tok.Expiry)
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"flag"
+	"io"
+	"log"
+	"net"
+	"os"
+	"slices"
+
+	keyfile "github.com/foxboron/go-tpm-keyfiles"
+	"github.com/google/go-tpm-tools/simulator"
+	"github.com/google/go-tpm/tpm2/transport"
+	"github.com/google/go-tpm/tpmutil"
+	"github.com/ibiscum/tpm2/tpmkey"
+)
+
+var (
+	tpmPath  = flag.String("tpm-path", "/dev/tpmrm0", "Path to the TPM device (character device or a Unix socket).")
+	pemFile  = flag.String("pemFile", "private.pem", "PKCS#8 RSA private key to import")
+	out      = flag.String("out", "sa.pem", "TSS2 keyfile to write")
+	password = flag.String("password", "", "optional key password")
+)
+
+var TPMDEVICES = []string{"/dev/tpm0", "/dev/tpmrm0"}
+
+func OpenTPM(path string) (io.ReadWriteCloser, error) {
+	if slices.Contains(TPMDEVICES, path) {
+		return tpmutil.OpenTPM(path)
+	} else if path == "simulator" {
+		return simulator.GetWithFixedSeedInsecure(1073741825)
+	} else {
+		return net.Dial("tcp", path)
+	}
+}
+
+func main() {
+	flag.Parse()
+
+	kdata, err := os.ReadFile(*pemFile)
+	if err != nil {
+		log.Fatalf("can't read key: %v", err)
+	}
+	block, _ := pem.Decode(kdata)
+	if block == nil {
+		log.Fatalf("failed to decode PEM block containing the key")
+	}
+	pvp, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		log.Fatalf("failed to parse PEM block containing the key %v", err)
+	}
+	pv, ok := pvp.(*rsa.PrivateKey)
+	if !ok {
+		log.Fatalf("not an RSA key")
+	}
+
+	rwc, err := OpenTPM(*tpmPath)
+	if err != nil {
+		log.Fatalf("can't open TPM %q: %v", *tpmPath, err)
+	}
+	defer func() {
+		rwc.Close()
+	}()
+
+	rwr := transport.FromReadWriter(rwc)
+
+	log.Printf("======= import RSA key ========")
+
+	k, err := tpmkey.ImportRSA(rwr, pv, []byte(*password))
+	if err != nil {
+		log.Fatalf("can't import key: %v", err)
+	}
+
+	f, err := os.OpenFile(*out, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		log.Fatalf("can't create keyfile: %v", err)
+	}
+	defer f.Close()
+	if err := keyfile.Encode(f, k); err != nil {
+		log.Fatalf("can't write keyfile: %v", err)
+	}
+
+	log.Printf("wrote %s", *out)
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	keyfile "github.com/foxboron/go-tpm-keyfiles"
+	"github.com/ibiscum/tpm2/tokensource"
+)
+
+var (
+	listen   = flag.String("listen", "localhost:8080", "address to listen on")
+	pubFile  = flag.String("public", "sa.pem", "PEM public key, certificate or TSS2 keyfile of the client")
+	audience = flag.String("audience", "http://localhost:8080/token", "expected assertion audience")
+	lifetime = flag.Duration("lifetime", time.Hour, "access token lifetime")
+)
+
+// a stand-in for an OAuth2 token endpoint accepting RFC 7523 JWT bearer assertions
+
+func main() {
+	flag.Parse()
+
+	pub, err := loadPublic(*pubFile)
+	if err != nil {
+		log.Fatalf("can't read public key: %v", err)
+	}
+
+	http.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			tokenError(w, "invalid_request", err.Error())
+			return
+		}
+		if r.PostForm.Get("grant_type") != tokensource.GrantType {
+			tokenError(w, "unsupported_grant_type", r.PostForm.Get("grant_type"))
+			return
+		}
+		sub, err := verify(pub, r.PostForm.Get("assertion"))
+		if err != nil {
+			log.Printf("rejected assertion: %v", err)
+			tokenError(w, "invalid_grant", err.Error())
+			return
+		}
+
+		b := make([]byte, 16)
+		_, _ = rand.Read(b)
+		tok := hex.EncodeToString(b)
+		log.Printf("issued token %s to %s (scope %q)", tok, sub, r.PostForm.Get("scope"))
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"access_token": tok,
+			"token_type":   "Bearer",
+			"expires_in":   int64(lifetime.Seconds()),
+		})
+	})
+
+	log.Printf("listening on %s", *listen)
+	log.Fatal(http.ListenAndServe(*listen, nil))
+}
+
+func tokenError(w http.ResponseWriter, code, desc string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	_ = json.NewEncoder(w).Encode(map[string]string{
+		"error":             code,
+		"error_description": desc,
+	})
+}
+
+func verify(pub *rsa.PublicKey, assertion string) (string, error) {
+	parts := strings.Split(assertion, ".")
+	if len(parts) != 3 {
+		return "", fmt.Errorf("malformed assertion")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	if err := decodePart(parts[0], &header); err != nil {
+		return "", err
+	}
+	if header.Alg != "RS256" {
+		return "", fmt.Errorf("unexpected alg %q", header.Alg)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", err
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], sig); err != nil {
+		return "", fmt.Errorf("bad signature: %v", err)
+	}
+
+	var claims struct {
+		Iss string `json:"iss"`
+		Sub string `json:"sub"`
+		Aud string `json:"aud"`
+		Exp int64  `json:"exp"`
+	}
+	if err := decodePart(parts[1], &claims); err != nil {
+		return "", err
+	}
+	if claims.Aud != *audience {
+		return "", fmt.Errorf("unexpected audience %q", claims.Aud)
+	}
+	if time.Now().Unix() > claims.Exp {
+		return "", fmt.Errorf("assertion expired")
+	}
+	return claims.Sub, nil
+}
+
+func decodePart(s string, v any) error {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+func loadPublic(path string) (*rsa.PublicKey, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var pub any
+	if k, err := keyfile.Decode(b); err == nil {
+		pub, err = k.PublicKey()
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		block, _ := pem.Decode(b)
+		if block == nil {
+			return nil, fmt.Errorf("no PEM data in %s", path)
+		}
+		switch block.Type {
+		case "CERTIFICATE":
+			c, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return nil, err
+			}
+			pub = c.PublicKey
+		default:
+			pub, err = x509.ParsePKIXPublicKey(block.Bytes)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	rpub, ok := pub.(*rsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("not an RSA public key")
+	}
+	return rpub, nil
+}
@@ -0,0 +1,179 @@
+// Package tokensource provides an oauth2.TokenSource that authenticates with
+// an RFC 7523 JWT bearer assertion signed (RS256) by a TPM resident key.
+package tokensource
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// GrantType is the RFC 7523 JWT bearer grant.
+const GrantType = "urn:ietf:params:oauth:grant-type:jwt-bearer"
+
+// Config describes the assertion and the token endpoint it is posted to.
+type Config struct {
+	// Signer signs the assertion, typically a tpmkey.Key Signer over an RSA key.
+	Signer crypto.Signer
+	// KeyID is put into the JWT header as kid when set.
+	KeyID string
+
+	Issuer  string
+	Subject string
+	// Audience defaults to TokenURL.
+	Audience string
+	TokenURL string
+	Scopes   []string
+
+	// Lifetime of the assertion, 5 minutes if unset.
+	Lifetime time.Duration
+	// HTTPClient posts to the token endpoint, http.DefaultClient if nil.
+	HTTPClient *http.Client
+}
+
+// TokenSource returns a token source that caches the access token and only
+// signs a new assertion once it is about to expire.
+func (c *Config) TokenSource(ctx context.Context) oauth2.TokenSource {
+	return oauth2.ReuseTokenSource(nil, &jwtSource{
+		ctx:  ctx,
+		conf: c,
+	})
+}
+
+// Assertion builds and signs the JWT bearer assertion.
+func (c *Config) Assertion(now time.Time) (string, error) {
+	if _, ok := c.Signer.Public().(*rsa.PublicKey); !ok {
+		return "", fmt.Errorf("tokensource: RS256 needs an RSA key")
+	}
+
+	lifetime := c.Lifetime
+	if lifetime == 0 {
+		lifetime = 5 * time.Minute
+	}
+	aud := c.Audience
+	if aud == "" {
+		aud = c.TokenURL
+	}
+	jti := make([]byte, 16)
+	if _, err := rand.Read(jti); err != nil {
+		return "", err
+	}
+
+	header := map[string]string{
+		"alg": "RS256",
+		"typ": "JWT",
+	}
+	if c.KeyID != "" {
+		header["kid"] = c.KeyID
+	}
+	claims := map[string]any{
+		"iss": c.Issuer,
+		"sub": c.Subject,
+		"aud": aud,
+		"iat": now.Unix(),
+		"exp": now.Add(lifetime).Unix(),
+		"jti": hex.EncodeToString(jti),
+	}
+
+	h, err := json.Marshal(header)
+	if err != nil {
+		return "", err
+	}
+	p, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	signingInput := base64.RawURLEncoding.EncodeToString(h) + "." + base64.RawURLEncoding.EncodeToString(p)
+
+	digest := sha256.Sum256([]byte(signingInput))
+	sig, err := c.Signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+	if err != nil {
+		return "", fmt.Errorf("tokensource: can't sign assertion: %v", err)
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+type jwtSource struct {
+	ctx  context.Context
+	conf *Config
+}
+
+type tokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+func (s *jwtSource) Token() (*oauth2.Token, error) {
+	assertion, err := s.conf.Assertion(time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	v := url.Values{
+		"grant_type": {GrantType},
+		"assertion":  {assertion},
+	}
+	if len(s.conf.Scopes) > 0 {
+		v.Set("scope", strings.Join(s.conf.Scopes, " "))
+	}
+
+	req, err := http.NewRequestWithContext(s.ctx, http.MethodPost, s.conf.TokenURL, strings.NewReader(v.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	client := s.conf.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("tokensource: can't reach token endpoint: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, &oauth2.RetrieveError{
+			Response: resp,
+			Body:     body,
+		}
+	}
+
+	var tr tokenResponse
+	if err := json.Unmarshal(body, &tr); err != nil {
+		return nil, fmt.Errorf("tokensource: bad token response: %v", err)
+	}
+	if tr.AccessToken == "" {
+		return nil, fmt.Errorf("tokensource: token response has no access_token")
+	}
+
+	tok := &oauth2.Token{
+		AccessToken:  tr.AccessToken,
+		TokenType:    tr.TokenType,
+		RefreshToken: tr.RefreshToken,
+	}
+	if tr.ExpiresIn > 0 {
+		tok.Expiry = time.Now().Add(time.Duration(tr.ExpiresIn) * time.Second)
+	}
+	return tok, nil
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/ibiscum/tpm2/tpm2print"
+)
+
+var (
+	typ    = flag.String("type", "", "structure type, one of "+strings.Join(tpm2print.Types, ", ")+" (default: guessed)")
+	format = flag.String("format", "text", "output format, text or json")
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [-type TYPE] [-format text|json] file...\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if *format != "text" && *format != "json" {
+		log.Fatalf("unknown format %q", *format)
+	}
+
+	for i, file := range flag.Args() {
+		b, err := os.ReadFile(file)
+		if err != nil {
+			log.Fatalf("can't read %s: %v", file, err)
+		}
+		f, err := tpm2print.Decode(b, *typ)
+		if err != nil {
+			log.Fatalf("%s: %v", file, err)
+		}
+		if flag.NArg() > 1 {
+			f = append(tpm2print.Fields{{Key: "file", Value: file}}, f...)
+		}
+		if *format == "json" {
+			j, err := json.MarshalIndent(f, "", "  ")
+			if err != nil {
+				log.Fatalf("%v", err)
+			}
+			fmt.Println(string(j))
+			continue
+		}
+		if i > 0 {
+			fmt.Println("---")
+		}
+		if err := f.WriteText(os.Stdout); err != nil {
+			log.Fatalf("%v", err)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package tpm2print
+
+import (
+	"encoding/hex"
+	"fmt"
+
+	keyfile "github.com/foxboron/go-tpm-keyfiles"
+	"github.com/google/go-tpm/tpm2"
+)
+
+// decodeKeyfile decodes a TSS2 PEM keyfile: its fields, policies and the
+// public and private areas it holds.
+func decodeKeyfile(data []byte) (Fields, error) {
+	k, err := keyfile.Decode(data)
+	if err != nil {
+		return nil, err
+	}
+	var typ string
+	switch {
+	case k.Keytype.Equal(keyfile.OIDLoadableKey):
+		typ = "loadable"
+	case k.Keytype.Equal(keyfile.OIDImportableKey):
+		typ = "importable"
+	case k.Keytype.Equal(keyfile.OIDSealedKey):
+		typ = "sealed"
+	case k.Keytype.Equal(keyfile.OIDOldLoadableKey):
+		typ = "loadable (old OID)"
+	default:
+		typ = "unknown"
+	}
+	f := Fields{
+		{"key-type", Fields{{"value", typ}, {"oid", k.Keytype.String()}}},
+		{"empty-auth", k.EmptyAuth},
+		{"parent", handle(k.Parent)},
+	}
+	if k.Description != "" {
+		f = append(f, Field{"description", k.Description})
+	}
+	if len(k.Policy) > 0 {
+		f = append(f, Field{"policy", policy(k.Policy)})
+	}
+	if len(k.AuthPolicy) > 0 {
+		var ap []Fields
+		for _, p := range k.AuthPolicy {
+			ap = append(ap, Fields{{"name", p.Name}, {"policy", policy(p.Policy)}})
+		}
+		f = append(f, Field{"auth-policy", ap})
+	}
+	if len(k.Secret.Buffer) > 0 {
+		f = append(f, Field{"secret", decodeSecret(k.Secret.Buffer)})
+	}
+
+	pub, err := k.Pubkey.Contents()
+	if err != nil {
+		return nil, fmt.Errorf("bad public: %v", err)
+	}
+	p, err := publicFields(pub)
+	if err != nil {
+		return nil, err
+	}
+	f = append(f, Field{"public", p})
+	// an importable key's private is a duplicate, a loadable key's one is
+	// wrapped by its parent; both start with their integrity HMAC
+	priv, err := decodePrivate(k.Privkey.Buffer, "integrity-hmac", "encrypted-sensitive")
+	if err != nil {
+		return nil, fmt.Errorf("bad private: %v", err)
+	}
+	return append(f, Field{"private", priv}), nil
+}
+
+// policy lists policy steps, each a command code and its arguments.
+func policy(steps []*keyfile.TPMPolicy) []Fields {
+	var l []Fields
+	for _, s := range steps {
+		l = append(l, Fields{
+			{"command", command(tpm2.TPMCC(s.CommandCode))},
+			{"arguments", hex.EncodeToString(s.CommandPolicy)},
+		})
+	}
+	return l
+}
@@ -0,0 +1,244 @@
+package tpm2print
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+
+	"github.com/google/go-tpm/tpm2"
+)
+
+// algorithm names as tpm2-tools spells them, Part 2 table 9
+var algNames = map[tpm2.TPMAlgID]string{
+	tpm2.TPMAlgRSA:          "rsa",
+	tpm2.TPMAlgTDES:         "tdes",
+	tpm2.TPMAlgSHA1:         "sha1",
+	tpm2.TPMAlgHMAC:         "hmac",
+	tpm2.TPMAlgAES:          "aes",
+	tpm2.TPMAlgMGF1:         "mgf1",
+	tpm2.TPMAlgKeyedHash:    "keyedhash",
+	tpm2.TPMAlgXOR:          "xor",
+	tpm2.TPMAlgSHA256:       "sha256",
+	tpm2.TPMAlgSHA384:       "sha384",
+	tpm2.TPMAlgSHA512:       "sha512",
+	tpm2.TPMAlgNull:         "null",
+	tpm2.TPMAlgSM3256:       "sm3_256",
+	tpm2.TPMAlgSM4:          "sm4",
+	tpm2.TPMAlgRSASSA:       "rsassa",
+	tpm2.TPMAlgRSAES:        "rsaes",
+	tpm2.TPMAlgRSAPSS:       "rsapss",
+	tpm2.TPMAlgOAEP:         "oaep",
+	tpm2.TPMAlgECDSA:        "ecdsa",
+	tpm2.TPMAlgECDH:         "ecdh",
+	tpm2.TPMAlgECDAA:        "ecdaa",
+	tpm2.TPMAlgSM2:          "sm2",
+	tpm2.TPMAlgECSchnorr:    "ecschnorr",
+	tpm2.TPMAlgECMQV:        "ecmqv",
+	tpm2.TPMAlgKDF1SP80056A: "kdf1_sp800_56a",
+	tpm2.TPMAlgKDF2:         "kdf2",
+	tpm2.TPMAlgKDF1SP800108: "kdf1_sp800_108",
+	tpm2.TPMAlgECC:          "ecc",
+	tpm2.TPMAlgSymCipher:    "symcipher",
+	tpm2.TPMAlgCamellia:     "camellia",
+	tpm2.TPMAlgSHA3256:      "sha3_256",
+	tpm2.TPMAlgSHA3384:      "sha3_384",
+	tpm2.TPMAlgSHA3512:      "sha3_512",
+	tpm2.TPMAlgCTR:          "ctr",
+	tpm2.TPMAlgOFB:          "ofb",
+	tpm2.TPMAlgCBC:          "cbc",
+	tpm2.TPMAlgCFB:          "cfb",
+	tpm2.TPMAlgECB:          "ecb",
+}
+
+var curveNames = map[tpm2.TPMECCCurve]string{
+	tpm2.TPMECCNistP192: "nistp192",
+	tpm2.TPMECCNistP224: "nistp224",
+	tpm2.TPMECCNistP256: "nistp256",
+	tpm2.TPMECCNistP384: "nistp384",
+	tpm2.TPMECCNistP521: "nistp521",
+	tpm2.TPMECCBNP256:   "bnp256",
+	tpm2.TPMECCBNP638:   "bnp638",
+	tpm2.TPMECCSM2P256:  "sm2p256",
+}
+
+var attestNames = map[tpm2.TPMST]string{
+	tpm2.TPMSTAttestNV:           "nv",
+	tpm2.TPMSTAttestCommandAudit: "command_audit",
+	tpm2.TPMSTAttestSessionAudit: "session_audit",
+	tpm2.TPMSTAttestCertify:      "certify",
+	tpm2.TPMSTAttestQuote:        "quote",
+	tpm2.TPMSTAttestTime:         "time",
+	tpm2.TPMSTAttestCreation:     "creation",
+	tpm2.TPMSTAttestNVDigest:     "nv_digest",
+}
+
+// policy commands, the ones a keyfile policy or a policy digest is made of
+var commandNames = map[tpm2.TPMCC]string{
+	tpm2.TPMCCPolicyNV:                "PolicyNV",
+	tpm2.TPMCCPolicySecret:            "PolicySecret",
+	tpm2.TPMCCPolicySigned:            "PolicySigned",
+	tpm2.TPMCCPolicyAuthorize:         "PolicyAuthorize",
+	tpm2.TPMCCPolicyAuthValue:         "PolicyAuthValue",
+	tpm2.TPMCCPolicyCommandCode:       "PolicyCommandCode",
+	tpm2.TPMCCPolicyCounterTimer:      "PolicyCounterTimer",
+	tpm2.TPMCCPolicyCpHash:            "PolicyCpHash",
+	tpm2.TPMCCPolicyLocality:          "PolicyLocality",
+	tpm2.TPMCCPolicyNameHash:          "PolicyNameHash",
+	tpm2.TPMCCPolicyOR:                "PolicyOR",
+	tpm2.TPMCCPolicyTicket:            "PolicyTicket",
+	tpm2.TPMCCPolicyPCR:               "PolicyPCR",
+	tpm2.TPMCCPolicyPhysicalPresence:  "PolicyPhysicalPresence",
+	tpm2.TPMCCPolicyDuplicationSelect: "PolicyDuplicationSelect",
+	tpm2.TPMCCPolicyPassword:          "PolicyPassword",
+	tpm2.TPMCCPolicyNvWritten:         "PolicyNvWritten",
+	tpm2.TPMCCPolicyTemplate:          "PolicyTemplate",
+	tpm2.TPMCCPolicyAuthorizeNV:       "PolicyAuthorizeNV",
+}
+
+var handleNames = map[tpm2.TPMHandle]string{
+	tpm2.TPMRHOwner:       "owner",
+	tpm2.TPMRHNull:        "null",
+	tpm2.TPMRHLockout:     "lockout",
+	tpm2.TPMRHEndorsement: "endorsement",
+	tpm2.TPMRHPlatform:    "platform",
+}
+
+// object attributes by bit, as tpm2-tools names them
+var objectAttributes = []string{
+	1:  "fixedtpm",
+	2:  "stclear",
+	4:  "fixedparent",
+	5:  "sensitivedataorigin",
+	6:  "userwithauth",
+	7:  "adminwithpolicy",
+	8:  "firmwarelimited",
+	10: "noda",
+	11: "encryptedduplication",
+	16: "restricted",
+	17: "decrypt",
+	18: "sign",
+	19: "x509sign",
+}
+
+// NV attributes by bit; bits 4 to 7 are the index type
+var nvAttributes = []string{
+	0:  "ppwrite",
+	1:  "ownerwrite",
+	2:  "authwrite",
+	3:  "policywrite",
+	10: "policy_delete",
+	11: "writelocked",
+	12: "writeall",
+	13: "writedefine",
+	14: "write_stclear",
+	15: "globallock",
+	16: "ppread",
+	17: "ownerread",
+	18: "authread",
+	19: "policyread",
+	25: "no_da",
+	26: "orderly",
+	27: "clear_stclear",
+	28: "readlocked",
+	29: "written",
+	30: "platformcreate",
+	31: "read_stclear",
+}
+
+var nvTypes = map[uint32]string{
+	0: "ordinary",
+	1: "counter",
+	2: "bits",
+	4: "extend",
+	8: "pinfail",
+	9: "pinpass",
+}
+
+// alg is an algorithm by name and value, like tpm2_print's
+//
+//	value: sha256
+//	raw: 0xb
+func alg(a tpm2.TPMAlgID) Fields {
+	name, ok := algNames[a]
+	if !ok {
+		name = "unknown"
+	}
+	return Fields{{"value", name}, {"raw", fmt.Sprintf("0x%x", uint16(a))}}
+}
+
+// algName is the name of a, or its value if it has none.
+func algName(a tpm2.TPMAlgID) string {
+	if name, ok := algNames[a]; ok {
+		return name
+	}
+	return fmt.Sprintf("0x%x", uint16(a))
+}
+
+func curve(c tpm2.TPMECCCurve) Fields {
+	name, ok := curveNames[c]
+	if !ok {
+		name = "unknown"
+	}
+	return Fields{{"value", name}, {"raw", fmt.Sprintf("0x%x", uint16(c))}}
+}
+
+// handle names a hierarchy, or gives the handle's type otherwise.
+func handle(h tpm2.TPMHandle) Fields {
+	name, ok := handleNames[h]
+	if !ok {
+		switch h >> 24 {
+		case 0x01:
+			name = "nv"
+		case 0x02:
+			name = "hmac session"
+		case 0x03:
+			name = "policy session"
+		case 0x80:
+			name = "transient"
+		case 0x81:
+			name = "persistent"
+		default:
+			name = "unknown"
+		}
+	}
+	return Fields{{"value", name}, {"raw", fmt.Sprintf("0x%x", uint32(h))}}
+}
+
+func command(cc tpm2.TPMCC) Fields {
+	name, ok := commandNames[cc]
+	if !ok {
+		name = "unknown"
+	}
+	return Fields{{"value", name}, {"raw", fmt.Sprintf("0x%x", uint32(cc))}}
+}
+
+// bits lists the names of the bits set in raw, names[i] being bit i.
+func bits(raw uint32, names []string) []string {
+	var set []string
+	for i, name := range names {
+		if name != "" && raw&(1<<i) != 0 {
+			set = append(set, name)
+		}
+	}
+	return set
+}
+
+func objectAttrs(a tpm2.TPMAObject) Fields {
+	raw := binary.BigEndian.Uint32(tpm2.Marshal(a))
+	return Fields{
+		{"value", strings.Join(bits(raw, objectAttributes), "|")},
+		{"raw", fmt.Sprintf("0x%x", raw)},
+	}
+}
+
+func nvAttrs(a tpm2.TPMANV) Fields {
+	raw := binary.BigEndian.Uint32(tpm2.Marshal(a))
+	typ, ok := nvTypes[raw>>4&0xf]
+	if !ok {
+		typ = fmt.Sprintf("0x%x", raw>>4&0xf)
+	}
+	return Fields{
+		{"value", strings.Join(append(bits(raw, nvAttributes), "nt="+typ), "|")},
+		{"raw", fmt.Sprintf("0x%x", raw)},
+	}
+}
@@ -0,0 +1,480 @@
+package tpm2print
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/google/go-tpm/tpm2"
+	"github.com/ibiscum/tpm2/tpm2tools"
+)
+
+// tpm2-tools context files start with it, tpm2tools.ParseContext reads them
+const contextMagic = 0xbadcc0de
+
+func public(data []byte) (*tpm2.TPMTPublic, error) {
+	return twoB[tpm2.TPMTPublic](data)
+}
+
+func attest(data []byte) (*tpm2.TPMSAttest, error) {
+	return twoB[tpm2.TPMSAttest](data)
+}
+
+func creationData(data []byte) (*tpm2.TPMSCreationData, error) {
+	return twoB[tpm2.TPMSCreationData](data)
+}
+
+func nvPublic(data []byte) (*tpm2.TPMSNVPublic, error) {
+	return twoB[tpm2.TPMSNVPublic](data)
+}
+
+func esysTR(data []byte) (*tpm2tools.Context, error) {
+	if len(data) >= 4 && binary.BigEndian.Uint32(data) == contextMagic {
+		return nil, fmt.Errorf("a context file")
+	}
+	return tpm2tools.ParseContext(data)
+}
+
+func decodePublic(data []byte) (Fields, error) {
+	pub, err := public(data)
+	if err != nil {
+		return nil, err
+	}
+	return publicFields(pub)
+}
+
+// publicFields decodes a public area, with the name it gives its object.
+func publicFields(pub *tpm2.TPMTPublic) (Fields, error) {
+	name, err := tpm2.ObjectName(pub)
+	if err != nil {
+		return nil, err
+	}
+	f := Fields{
+		{"name", hex.EncodeToString(name.Buffer)},
+		{"name-alg", alg(pub.NameAlg)},
+		{"attributes", objectAttrs(pub.ObjectAttributes)},
+		{"key-type", alg(pub.Type)},
+	}
+	switch pub.Type {
+	case tpm2.TPMAlgRSA:
+		parms, err := pub.Parameters.RSADetail()
+		if err != nil {
+			return nil, err
+		}
+		unique, err := pub.Unique.RSA()
+		if err != nil {
+			return nil, err
+		}
+		exponent := parms.Exponent
+		if exponent == 0 {
+			exponent = 65537
+		}
+		f = append(f,
+			Field{"exponent", exponent},
+			Field{"bits", uint16(parms.KeyBits)},
+			Field{"scheme", scheme(tpm2.Marshal(parms.Scheme))},
+			Field{"sym", sym(parms.Symmetric)},
+			Field{"rsa", hex.EncodeToString(unique.Buffer)},
+		)
+	case tpm2.TPMAlgECC:
+		parms, err := pub.Parameters.ECCDetail()
+		if err != nil {
+			return nil, err
+		}
+		unique, err := pub.Unique.ECC()
+		if err != nil {
+			return nil, err
+		}
+		f = append(f,
+			Field{"curve-id", curve(parms.CurveID)},
+			Field{"kdf", scheme(tpm2.Marshal(parms.KDF))},
+			Field{"scheme", scheme(tpm2.Marshal(parms.Scheme))},
+			Field{"sym", sym(parms.Symmetric)},
+			Field{"x", hex.EncodeToString(unique.X.Buffer)},
+			Field{"y", hex.EncodeToString(unique.Y.Buffer)},
+		)
+	case tpm2.TPMAlgKeyedHash:
+		parms, err := pub.Parameters.KeyedHashDetail()
+		if err != nil {
+			return nil, err
+		}
+		unique, err := pub.Unique.KeyedHash()
+		if err != nil {
+			return nil, err
+		}
+		f = append(f,
+			Field{"scheme", scheme(tpm2.Marshal(parms.Scheme))},
+			Field{"keyedhash", hex.EncodeToString(unique.Buffer)},
+		)
+	case tpm2.TPMAlgSymCipher:
+		parms, err := pub.Parameters.SymDetail()
+		if err != nil {
+			return nil, err
+		}
+		unique, err := pub.Unique.SymCipher()
+		if err != nil {
+			return nil, err
+		}
+		f = append(f,
+			Field{"sym", sym(parms.Sym)},
+			Field{"symcipher", hex.EncodeToString(unique.Buffer)},
+		)
+	}
+	return append(f, Field{"authorization-policy", hex.EncodeToString(pub.AuthPolicy.Buffer)}), nil
+}
+
+// scheme decodes a marshalled TPMT_*_SCHEME: the scheme, then for most
+// schemes the hash and for ECDAA a count, for XOR a KDF.
+func scheme(b []byte) Fields {
+	a := tpm2.TPMAlgID(binary.BigEndian.Uint16(b))
+	f := Fields{{"value", algName(a)}, {"raw", fmt.Sprintf("0x%x", uint16(a))}}
+	if len(b) >= 4 {
+		f = append(f, Field{"halg", alg(tpm2.TPMAlgID(binary.BigEndian.Uint16(b[2:])))})
+	}
+	if len(b) >= 6 {
+		if a == tpm2.TPMAlgXOR {
+			f = append(f, Field{"kdf", alg(tpm2.TPMAlgID(binary.BigEndian.Uint16(b[4:])))})
+		} else {
+			f = append(f, Field{"count", binary.BigEndian.Uint16(b[4:])})
+		}
+	}
+	return f
+}
+
+// sym decodes a symmetric definition: algorithm, key size and mode.
+func sym(s tpm2.TPMTSymDefObject) Fields {
+	b := tpm2.Marshal(s)
+	f := Fields{{"alg", alg(s.Algorithm)}}
+	if s.Algorithm == tpm2.TPMAlgNull || len(b) < 6 {
+		return f
+	}
+	return append(f,
+		Field{"keybits", binary.BigEndian.Uint16(b[2:])},
+		Field{"mode", alg(tpm2.TPMAlgID(binary.BigEndian.Uint16(b[4:])))},
+	)
+}
+
+// integrity splits an outer-wrapped buffer, a private area or credential,
+// into its integrity HMAC and the encrypted rest.
+func integrity(b []byte) ([]byte, []byte, bool) {
+	if len(b) < 2 {
+		return nil, nil, false
+	}
+	n := int(binary.BigEndian.Uint16(b))
+	switch n {
+	case 20, 32, 48, 64:
+	default:
+		return nil, nil, false
+	}
+	if len(b) <= 2+n {
+		return nil, nil, false
+	}
+	return b[2 : 2+n], b[2+n:], true
+}
+
+// decodePrivate decodes a TPM2B_PRIVATE or TPM2B_ID_OBJECT, or their
+// buffer as some recipes write it.  Without an integrity HMAC, a duplicate
+// that only has an inner wrapper or none, it's just the buffer.
+func decodePrivate(data []byte, hmacKey, encKey string) (Fields, error) {
+	b, ok := sized(data)
+	if !ok {
+		b = data
+	}
+	if len(b) == 0 {
+		return nil, fmt.Errorf("empty")
+	}
+	f := Fields{{"size", len(b)}}
+	if h, enc, ok := integrity(b); ok {
+		return append(f,
+			Field{hmacKey, hex.EncodeToString(h)},
+			Field{encKey, hex.EncodeToString(enc)},
+		), nil
+	}
+	return append(f, Field{"buffer", hex.EncodeToString(b)}), nil
+}
+
+// decodeSecret decodes a TPM2B_ENCRYPTED_SECRET or its buffer: an ECC
+// seed is the ephemeral point, an RSA one is an OAEP ciphertext.
+func decodeSecret(data []byte) Fields {
+	b, ok := sized(data)
+	if !ok {
+		b = data
+	}
+	f := Fields{{"size", len(b)}}
+	if eccSeed(b) {
+		p, _ := exact[tpm2.TPMSECCPoint](b)
+		return append(f, Field{"ecc-point", Fields{
+			{"x", hex.EncodeToString(p.X.Buffer)},
+			{"y", hex.EncodeToString(p.Y.Buffer)},
+		}})
+	}
+	return append(f, Field{"rsa-oaep", hex.EncodeToString(b)})
+}
+
+// eccSeed tells whether b is an ephemeral ECC point, the encrypted secret
+// for an ECC key.
+func eccSeed(b []byte) bool {
+	p, ok := exact[tpm2.TPMSECCPoint](b)
+	if !ok || len(p.X.Buffer) != len(p.Y.Buffer) {
+		return false
+	}
+	switch len(p.X.Buffer) {
+	case 32, 48, 66:
+		return true
+	}
+	return false
+}
+
+func decodeAttest(data []byte) (Fields, error) {
+	a, err := attest(data)
+	if err != nil {
+		return nil, err
+	}
+	typ, ok := attestNames[a.Type]
+	if !ok {
+		typ = "unknown"
+	}
+	f := Fields{
+		{"magic", fmt.Sprintf("0x%x", uint32(a.Magic))},
+		{"attest-type", Fields{{"value", typ}, {"raw", fmt.Sprintf("0x%x", uint16(a.Type))}}},
+		{"qualified-signer", hex.EncodeToString(a.QualifiedSigner.Buffer)},
+		{"extra-data", hex.EncodeToString(a.ExtraData.Buffer)},
+		{"clock-info", clockInfo(a.ClockInfo)},
+		{"firmware-version", fmt.Sprintf("0x%x", a.FirmwareVersion)},
+	}
+	var attested Fields
+	switch a.Type {
+	case tpm2.TPMSTAttestQuote:
+		q, err := a.Attested.Quote()
+		if err != nil {
+			return nil, err
+		}
+		attested = Fields{
+			{"pcr-select", pcrSelection(q.PCRSelect)},
+			{"pcr-digest", hex.EncodeToString(q.PCRDigest.Buffer)},
+		}
+	case tpm2.TPMSTAttestCertify:
+		c, err := a.Attested.Certify()
+		if err != nil {
+			return nil, err
+		}
+		attested = Fields{
+			{"name", hex.EncodeToString(c.Name.Buffer)},
+			{"qualified-name", hex.EncodeToString(c.QualifiedName.Buffer)},
+		}
+	case tpm2.TPMSTAttestCreation:
+		c, err := a.Attested.Creation()
+		if err != nil {
+			return nil, err
+		}
+		attested = Fields{
+			{"object-name", hex.EncodeToString(c.ObjectName.Buffer)},
+			{"creation-hash", hex.EncodeToString(c.CreationHash.Buffer)},
+		}
+	case tpm2.TPMSTAttestNV:
+		n, err := a.Attested.NV()
+		if err != nil {
+			return nil, err
+		}
+		attested = Fields{
+			{"index-name", hex.EncodeToString(n.IndexName.Buffer)},
+			{"offset", n.Offset},
+			{"nv-contents", hex.EncodeToString(n.NVContents.Buffer)},
+		}
+	case tpm2.TPMSTAttestNVDigest:
+		n, err := a.Attested.NVDigest()
+		if err != nil {
+			return nil, err
+		}
+		attested = Fields{
+			{"index-name", hex.EncodeToString(n.IndexName.Buffer)},
+			{"nv-digest", hex.EncodeToString(n.NVDigest.Buffer)},
+		}
+	case tpm2.TPMSTAttestTime:
+		t, err := a.Attested.Time()
+		if err != nil {
+			return nil, err
+		}
+		attested = Fields{
+			{"time", t.Time.Time},
+			{"clock-info", clockInfo(t.Time.ClockInfo)},
+			{"firmware-version", fmt.Sprintf("0x%x", t.FirmwareVersion)},
+		}
+	case tpm2.TPMSTAttestCommandAudit:
+		c, err := a.Attested.CommandAudit()
+		if err != nil {
+			return nil, err
+		}
+		attested = Fields{
+			{"audit-counter", c.AuditCounter},
+			{"digest-alg", alg(c.DigestAlg)},
+			{"audit-digest", hex.EncodeToString(c.AuditDigest.Buffer)},
+			{"command-digest", hex.EncodeToString(c.CommandDigest.Buffer)},
+		}
+	case tpm2.TPMSTAttestSessionAudit:
+		s, err := a.Attested.SessionAudit()
+		if err != nil {
+			return nil, err
+		}
+		attested = Fields{
+			{"exclusive-session", bool(s.ExclusiveSession)},
+			{"session-digest", hex.EncodeToString(s.SessionDigest.Buffer)},
+		}
+	}
+	return append(f, Field{typ, attested}), nil
+}
+
+func clockInfo(c tpm2.TPMSClockInfo) Fields {
+	return Fields{
+		{"clock", c.Clock},
+		{"reset-count", c.ResetCount},
+		{"restart-count", c.RestartCount},
+		{"safe", bool(c.Safe)},
+	}
+}
+
+// pcrSelection lists the selected PCRs of each bank.
+func pcrSelection(l tpm2.TPMLPCRSelection) []Fields {
+	var banks []Fields
+	for _, s := range l.PCRSelections {
+		pcrs := []int{}
+		for i, b := range s.PCRSelect {
+			for j := range 8 {
+				if b&(1<<j) != 0 {
+					pcrs = append(pcrs, i*8+j)
+				}
+			}
+		}
+		banks = append(banks, Fields{{"hash", alg(s.Hash)}, {"pcrs", pcrs}})
+	}
+	return banks
+}
+
+func decodeSignature(data []byte) (Fields, error) {
+	sig, ok := exact[tpm2.TPMTSignature](data)
+	if !ok {
+		return nil, fmt.Errorf("bad encoding")
+	}
+	f := Fields{{"sig-alg", alg(sig.SigAlg)}}
+	switch sig.SigAlg {
+	case tpm2.TPMAlgRSASSA, tpm2.TPMAlgRSAPSS:
+		s, err := tpm2.Unmarshal[tpm2.TPMSSignatureRSA](data[2:])
+		if err != nil {
+			return nil, err
+		}
+		f = append(f,
+			Field{"hash", alg(s.Hash)},
+			Field{"sig", hex.EncodeToString(s.Sig.Buffer)},
+		)
+	case tpm2.TPMAlgECDSA, tpm2.TPMAlgECDAA, tpm2.TPMAlgSM2, tpm2.TPMAlgECSchnorr:
+		// all ECC signatures are a TPMS_SIGNATURE_ECC
+		s, err := tpm2.Unmarshal[tpm2.TPMSSignatureECC](data[2:])
+		if err != nil {
+			return nil, err
+		}
+		f = append(f,
+			Field{"hash", alg(s.Hash)},
+			Field{"r", hex.EncodeToString(s.SignatureR.Buffer)},
+			Field{"s", hex.EncodeToString(s.SignatureS.Buffer)},
+		)
+	case tpm2.TPMAlgHMAC:
+		s, err := sig.Signature.HMAC()
+		if err != nil {
+			return nil, err
+		}
+		f = append(f,
+			Field{"hash", alg(s.HashAlg)},
+			Field{"digest", hex.EncodeToString(s.Digest)},
+		)
+	}
+	return f, nil
+}
+
+func decodeCreationData(data []byte) (Fields, error) {
+	c, err := creationData(data)
+	if err != nil {
+		return nil, err
+	}
+	return Fields{
+		{"pcr-select", pcrSelection(c.PCRSelect)},
+		{"pcr-digest", hex.EncodeToString(c.PCRDigest.Buffer)},
+		{"locality", fmt.Sprintf("0x%x", tpm2.Marshal(c.Locality))},
+		{"parent-name-alg", alg(c.ParentNameAlg)},
+		{"parent-name", hex.EncodeToString(c.ParentName.Buffer)},
+		{"parent-qualified-name", hex.EncodeToString(c.ParentQualifiedName.Buffer)},
+		{"outside-info", hex.EncodeToString(c.OutsideInfo.Buffer)},
+	}, nil
+}
+
+func decodeNVPublic(data []byte) (Fields, error) {
+	pub, err := nvPublic(data)
+	if err != nil {
+		return nil, err
+	}
+	return nvPublicFields(pub)
+}
+
+// nvPublicFields decodes an NV public area, with the name of the index.
+func nvPublicFields(pub *tpm2.TPMSNVPublic) (Fields, error) {
+	name, err := tpm2.NVName(pub)
+	if err != nil {
+		return nil, err
+	}
+	return Fields{
+		{"name", hex.EncodeToString(name.Buffer)},
+		{"nv-index", fmt.Sprintf("0x%x", uint32(pub.NVIndex))},
+		{"name-alg", alg(pub.NameAlg)},
+		{"attributes", nvAttrs(pub.Attributes)},
+		{"authorization-policy", hex.EncodeToString(pub.AuthPolicy.Buffer)},
+		{"size", pub.DataSize},
+	}, nil
+}
+
+func decodeContext(data []byte) (Fields, error) {
+	c, err := tpm2tools.ParseContext(data)
+	if err != nil {
+		return nil, err
+	}
+	var f Fields
+	if c.Saved == nil {
+		f = Fields{{"format", "serialized ESYS_TR"}}
+	} else {
+		f = Fields{
+			{"format", "context file"},
+			{"hierarchy", handle(c.Saved.Hierarchy)},
+			{"saved-handle", handle(c.Saved.SavedHandle)},
+			{"sequence", c.Saved.Sequence},
+			{"context-blob-size", len(c.Saved.ContextBlob.Buffer)},
+		}
+	}
+	if len(c.Name.Buffer) == 0 && c.Public == nil && c.NVPublic == nil {
+		return f, nil
+	}
+	f = append(f,
+		Field{"handle", handle(c.Handle)},
+		Field{"name", hex.EncodeToString(c.Name.Buffer)},
+	)
+	switch {
+	case c.Public != nil:
+		pub, err := c.Public.Contents()
+		if err != nil {
+			return nil, err
+		}
+		p, err := publicFields(pub)
+		if err != nil {
+			return nil, err
+		}
+		f = append(f, Field{"public", p})
+	case c.NVPublic != nil:
+		pub, err := c.NVPublic.Contents()
+		if err != nil {
+			return nil, err
+		}
+		p, err := nvPublicFields(pub)
+		if err != nil {
+			return nil, err
+		}
+		f = append(f, Field{"nv-public", p})
+	}
+	return f, nil
+}
@@ -0,0 +1,234 @@
+// Package tpm2print decodes TPM structures as they are written to files,
+// the way tpm2_print does: public and private areas, attestations,
+// signatures, saved contexts, credentials and seeds, creation data and TSS2
+// keyfiles, with algorithms and attributes by name and object names
+// computed.  The result prints as YAML-like text or as JSON.
+package tpm2print
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/google/go-tpm/tpm2"
+)
+
+// Field is a named value: a string, a number, a bool, Fields or a list of
+// Fields.
+type Field struct {
+	Key   string
+	Value any
+}
+
+// Fields is a decoded structure, its fields in the order of the structure.
+type Fields []Field
+
+// MarshalJSON writes f as a JSON object, keeping the order of the fields.
+func (f Fields) MarshalJSON() ([]byte, error) {
+	var b bytes.Buffer
+	b.WriteByte('{')
+	for i, field := range f {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		k, err := json.Marshal(field.Key)
+		if err != nil {
+			return nil, err
+		}
+		v, err := json.Marshal(field.Value)
+		if err != nil {
+			return nil, err
+		}
+		b.Write(k)
+		b.WriteByte(':')
+		b.Write(v)
+	}
+	b.WriteByte('}')
+	return b.Bytes(), nil
+}
+
+// WriteText writes f as tpm2_print does, one field per line and nested
+// structures indented.
+func (f Fields) WriteText(w io.Writer) error {
+	var b strings.Builder
+	f.text(&b, "")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func (f Fields) text(b *strings.Builder, indent string) {
+	for _, field := range f {
+		switch v := field.Value.(type) {
+		case Fields:
+			fmt.Fprintf(b, "%s%s:\n", indent, field.Key)
+			v.text(b, indent+"  ")
+		case []Fields:
+			fmt.Fprintf(b, "%s%s:\n", indent, field.Key)
+			for _, item := range v {
+				// the first field goes on the "- " line
+				var sub strings.Builder
+				item.text(&sub, indent+"    ")
+				fmt.Fprintf(b, "%s  - %s", indent, strings.TrimPrefix(sub.String(), indent+"    "))
+			}
+		case []int:
+			l := make([]string, len(v))
+			for i, n := range v {
+				l[i] = fmt.Sprint(n)
+			}
+			fmt.Fprintf(b, "%s%s: [%s]\n", indent, field.Key, strings.Join(l, ", "))
+		case string:
+			if v == "" {
+				fmt.Fprintf(b, "%s%s:\n", indent, field.Key)
+				continue
+			}
+			fmt.Fprintf(b, "%s%s: %s\n", indent, field.Key, v)
+		default:
+			fmt.Fprintf(b, "%s%s: %v\n", indent, field.Key, v)
+		}
+	}
+}
+
+// Types are the structures Decode knows, by the name it takes them by.
+var Types = []string{
+	"public",    // TPM2B_PUBLIC or TPMT_PUBLIC
+	"private",   // TPM2B_PRIVATE, or its buffer: -r, child.priv, dup.dup
+	"attest",    // TPM2B_ATTEST or TPMS_ATTEST
+	"signature", // TPMT_SIGNATURE
+	"context",   // tpm2-tools context file or serialized ESYS_TR
+	"idobject",  // TPM2B_ID_OBJECT or its buffer, from MakeCredential
+	"secret",    // TPM2B_ENCRYPTED_SECRET or its buffer: a credential secret or a seed
+	"creation",  // TPM2B_CREATION_DATA or TPMS_CREATION_DATA
+	"nvpublic",  // TPM2B_NV_PUBLIC or TPMS_NV_PUBLIC
+	"keyfile",   // TSS2 PEM keyfile
+}
+
+// Detect guesses the type of data.  Private areas, credentials and seeds
+// are random looking buffers, only a TPM2B_PRIVATE with an outer HMAC and
+// an ECC seed are told apart; the others need the type given.
+func Detect(data []byte) (string, error) {
+	if block, _ := pem.Decode(data); block != nil {
+		if block.Type == "TSS2 PRIVATE KEY" {
+			return "keyfile", nil
+		}
+		return "", fmt.Errorf("tpm2print: PEM %q isn't a TSS2 keyfile", block.Type)
+	}
+	if len(data) >= 4 && binary.BigEndian.Uint32(data) == contextMagic {
+		return "context", nil
+	}
+	if _, err := attest(data); err == nil {
+		return "attest", nil
+	}
+	if len(data) >= 2 {
+		// a signature starts with its scheme, and a public area of the same
+		// size can't
+		switch tpm2.TPMAlgID(binary.BigEndian.Uint16(data)) {
+		case tpm2.TPMAlgRSASSA, tpm2.TPMAlgRSAPSS, tpm2.TPMAlgECDSA, tpm2.TPMAlgECDAA,
+			tpm2.TPMAlgSM2, tpm2.TPMAlgECSchnorr, tpm2.TPMAlgHMAC:
+			if _, ok := exact[tpm2.TPMTSignature](data); ok {
+				return "signature", nil
+			}
+		}
+	}
+	if _, err := public(data); err == nil {
+		return "public", nil
+	}
+	if _, err := creationData(data); err == nil {
+		return "creation", nil
+	}
+	if _, err := nvPublic(data); err == nil {
+		return "nvpublic", nil
+	}
+	if _, err := esysTR(data); err == nil {
+		return "context", nil
+	}
+	if p, ok := sized(data); ok {
+		if eccSeed(p) {
+			return "secret", nil
+		}
+		if _, _, ok := integrity(p); ok {
+			return "private", nil
+		}
+	}
+	return "", fmt.Errorf("tpm2print: can't tell the type, give one of %s", strings.Join(Types, ", "))
+}
+
+// Decode decodes data as typ, one of Types, or guesses the type if typ is
+// empty.
+func Decode(data []byte, typ string) (Fields, error) {
+	if typ == "" {
+		var err error
+		if typ, err = Detect(data); err != nil {
+			return nil, err
+		}
+	}
+	var f Fields
+	var err error
+	switch typ {
+	case "public":
+		f, err = decodePublic(data)
+	case "private":
+		f, err = decodePrivate(data, "integrity-hmac", "encrypted-sensitive")
+	case "idobject":
+		f, err = decodePrivate(data, "integrity-hmac", "encrypted-identity")
+	case "attest":
+		f, err = decodeAttest(data)
+	case "signature":
+		f, err = decodeSignature(data)
+	case "context":
+		f, err = decodeContext(data)
+	case "secret":
+		f = decodeSecret(data)
+	case "creation":
+		f, err = decodeCreationData(data)
+	case "nvpublic":
+		f, err = decodeNVPublic(data)
+	case "keyfile":
+		f, err = decodeKeyfile(data)
+	default:
+		return nil, fmt.Errorf("tpm2print: unknown type %q, one of %s", typ, strings.Join(Types, ", "))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("tpm2print: not a %s: %v", typ, err)
+	}
+	return append(Fields{{"type", typ}}, f...), nil
+}
+
+// exact unmarshals a T that is all of data.
+func exact[T tpm2.Marshallable, P interface {
+	*T
+	tpm2.Unmarshallable
+}](data []byte) (*T, bool) {
+	t, err := tpm2.Unmarshal[T, P](data)
+	if err != nil || !bytes.Equal(tpm2.Marshal(*t), data) {
+		return nil, false
+	}
+	return t, true
+}
+
+// sized returns the contents of data if it's a TPM2B.
+func sized(data []byte) ([]byte, bool) {
+	if len(data) < 2 || int(binary.BigEndian.Uint16(data)) != len(data)-2 {
+		return nil, false
+	}
+	return data[2:], true
+}
+
+// twoB reads a T from data, with or without its TPM2B size.
+func twoB[T tpm2.Marshallable, P interface {
+	*T
+	tpm2.Unmarshallable
+}](data []byte) (*T, error) {
+	if b, ok := sized(data); ok {
+		if t, ok := exact[T, P](b); ok {
+			return t, nil
+		}
+	}
+	if t, ok := exact[T, P](data); ok {
+		return t, nil
+	}
+	return nil, fmt.Errorf("bad encoding")
+}
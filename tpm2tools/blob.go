@@ -0,0 +1,82 @@
+package tpm2tools
+
+import (
+	"crypto"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/pem"
+	"fmt"
+
+	"github.com/google/go-tpm/tpm2"
+)
+
+// ParsePublic reads a public area: a TPM2B_PUBLIC (tpm2_create -u,
+// tpm2_readpublic -o, -f tss) or a bare TPMT_PUBLIC (-f tpmt).
+func ParsePublic(data []byte) (*tpm2.TPM2BPublic, error) {
+	if len(data) > 2 && int(binary.BigEndian.Uint16(data)) == len(data)-2 {
+		if t, err := tpm2.Unmarshal[tpm2.TPMTPublic](data[2:]); err == nil {
+			pub := tpm2.New2B(*t)
+			return &pub, nil
+		}
+	}
+	t, err := tpm2.Unmarshal[tpm2.TPMTPublic](data)
+	if err != nil {
+		return nil, fmt.Errorf("tpm2tools: not a TPM2B_PUBLIC or TPMT_PUBLIC: %v", err)
+	}
+	pub := tpm2.New2B(*t)
+	return &pub, nil
+}
+
+// ParsePrivate reads a TPM2B_PRIVATE (tpm2_create -r).
+func ParsePrivate(data []byte) (*tpm2.TPM2BPrivate, error) {
+	priv, err := tpm2.Unmarshal[tpm2.TPM2BPrivate](data)
+	if err != nil || len(priv.Buffer)+2 != len(data) {
+		return nil, fmt.Errorf("tpm2tools: not a TPM2B_PRIVATE")
+	}
+	return priv, nil
+}
+
+// ParseName reads a name file (tpm2_readpublic -n, tpm2_load -n): the
+// name without a size, a nameAlg and digest or a 4 byte handle.
+func ParseName(data []byte) (*tpm2.TPM2BName, error) {
+	if len(data) == 4 {
+		return &tpm2.TPM2BName{Buffer: data}, nil
+	}
+	if len(data) < 2 {
+		return nil, fmt.Errorf("tpm2tools: short name")
+	}
+	h, err := tpm2.TPMAlgID(binary.BigEndian.Uint16(data)).Hash()
+	if err != nil || len(data) != 2+h.Size() {
+		return nil, fmt.Errorf("tpm2tools: not a name")
+	}
+	return &tpm2.TPM2BName{Buffer: data}, nil
+}
+
+// PublicKeyPEM returns the public key of pub as PEM, as tpm2_readpublic
+// -f pem writes it.
+func PublicKeyPEM(pub *tpm2.TPMTPublic) ([]byte, error) {
+	k, err := tpm2.Pub(*pub)
+	if err != nil {
+		return nil, fmt.Errorf("tpm2tools: %v", err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(k)
+	if err != nil {
+		return nil, fmt.Errorf("tpm2tools: %v", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), nil
+}
+
+// ParsePublicKey reads a PEM or DER public key (tpm2_readpublic -f pem or
+// -f der).  Its TPM public area, and so its name, depends on the template
+// the key was made with, see tpmwrap.FromPublicKey.
+func ParsePublicKey(data []byte) (crypto.PublicKey, error) {
+	der := data
+	if block, _ := pem.Decode(data); block != nil {
+		der = block.Bytes
+	}
+	k, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return nil, fmt.Errorf("tpm2tools: not a PEM or DER public key: %v", err)
+	}
+	return k, nil
+}
@@ -0,0 +1,258 @@
+// Package tpm2tools reads and writes the files tpm2-tools works with:
+// context files (-c), TPM2B_PUBLIC and TPM2B_PRIVATE blobs (-u/-r), name
+// files (-n) and PEM public keys (-f pem), so that keys move between
+// tpm2-tools and Go in both directions.
+package tpm2tools
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+
+	keyfile "github.com/foxboron/go-tpm-keyfiles"
+	"github.com/google/go-tpm/tpm2"
+	"github.com/google/go-tpm/tpm2/transport"
+	"github.com/ibiscum/tpm2/tpmkey"
+)
+
+// Context file header, tpm2-tools lib/files.c.
+const (
+	contextMagic   = 0xbadcc0de
+	contextVersion = 1
+)
+
+// ESAPI resource types, IESYSC_RESOURCE_TYPE.
+const (
+	resourceNone = 0
+	resourceKey  = 1
+	resourceNV   = 2
+)
+
+// Context is a tpm2-tools context file.  tpm2_createprimary -c, tpm2_load
+// -c and the like save a transient object with ESAPI: the TPMS_CONTEXT
+// from TPM2_ContextSave, its blob wrapped with the ESAPI metadata of the
+// object (handle, name and public area).  tpm2_evictcontrol -o and
+// tpm2_readpublic -t write only the metadata, a serialized ESYS_TR, which
+// names a persistent object or NV index; Saved is nil then.
+type Context struct {
+	// Saved is the saved context, with the blob the TPM returned.
+	Saved *tpm2.TPMSContext
+	// Handle is the object's handle when it was saved.
+	Handle tpm2.TPMHandle
+	Name   tpm2.TPM2BName
+	// Public is set for keys, NVPublic for NV indexes.
+	Public   *tpm2.TPM2BPublic
+	NVPublic *tpm2.TPM2BNVPublic
+}
+
+// ParseContext reads a context file or a serialized ESYS_TR.
+func ParseContext(data []byte) (*Context, error) {
+	if len(data) < 8 || binary.BigEndian.Uint32(data) != contextMagic {
+		c := &Context{}
+		rest, err := c.unmarshalResource(data)
+		if err != nil {
+			return nil, fmt.Errorf("tpm2tools: not a context file or serialized ESYS_TR: %v", err)
+		}
+		if len(rest) != 0 {
+			return nil, fmt.Errorf("tpm2tools: %d trailing bytes after ESYS_TR", len(rest))
+		}
+		return c, nil
+	}
+	if v := binary.BigEndian.Uint32(data[4:]); v != contextVersion {
+		return nil, fmt.Errorf("tpm2tools: unsupported context file version %d", v)
+	}
+	// hierarchy, savedHandle, sequence and the blob, in that order rather
+	// than TPMS_CONTEXT's
+	b := data[8:]
+	if len(b) < 18 {
+		return nil, fmt.Errorf("tpm2tools: short context file")
+	}
+	saved := &tpm2.TPMSContext{
+		Hierarchy:   tpm2.TPMHandle(binary.BigEndian.Uint32(b)),
+		SavedHandle: tpm2.TPMHandle(binary.BigEndian.Uint32(b[4:])),
+		Sequence:    binary.BigEndian.Uint64(b[8:]),
+	}
+	blob, rest, err := tpmkey.Split2B(b[16:])
+	if err != nil {
+		return nil, fmt.Errorf("tpm2tools: short context blob")
+	}
+	if len(rest) != 0 {
+		return nil, fmt.Errorf("tpm2tools: %d trailing bytes after context", len(rest))
+	}
+
+	c := &Context{Saved: saved}
+	// ESAPI wraps the TPM's blob as IESYS_CONTEXT_DATA: UINT32 reserved
+	// (0) || TPM2B_CONTEXT_DATA || IESYS_METADATA (UINT16 size ||
+	// IESYS_RESOURCE).  Contexts saved without ESAPI have the bare blob.
+	if len(blob) > 4 && binary.BigEndian.Uint32(blob) == 0 {
+		if tpmBlob, meta, err := tpmkey.Split2B(blob[4:]); err == nil && len(meta) >= 2 {
+			if rest, err := c.unmarshalResource(meta[2:]); err == nil && len(rest) == 0 {
+				saved.ContextBlob.Buffer = tpmBlob
+				return c, nil
+			}
+		}
+		*c = Context{Saved: saved}
+	}
+	saved.ContextBlob.Buffer = blob
+	return c, nil
+}
+
+// unmarshalResource reads an IESYS_RESOURCE: TPM2_HANDLE || TPM2B_NAME ||
+// UINT32 type || TPM2B_PUBLIC or TPM2B_NV_PUBLIC.
+func (c *Context) unmarshalResource(b []byte) ([]byte, error) {
+	if len(b) < 4 {
+		return nil, fmt.Errorf("short resource")
+	}
+	c.Handle = tpm2.TPMHandle(binary.BigEndian.Uint32(b))
+	name, b, err := tpmkey.Split2B(b[4:])
+	if err != nil {
+		return nil, fmt.Errorf("bad name")
+	}
+	c.Name = tpm2.TPM2BName{Buffer: name}
+	if len(b) < 4 {
+		return nil, fmt.Errorf("short resource")
+	}
+	typ := binary.BigEndian.Uint32(b)
+	b = b[4:]
+	switch typ {
+	case resourceNone:
+	case resourceKey:
+		pub, rest, err := tpmkey.Split2B(b)
+		if err != nil {
+			return nil, fmt.Errorf("bad public")
+		}
+		t, err := tpm2.Unmarshal[tpm2.TPMTPublic](pub)
+		if err != nil {
+			return nil, fmt.Errorf("bad public: %v", err)
+		}
+		p := tpm2.New2B(*t)
+		c.Public, b = &p, rest
+	case resourceNV:
+		pub, rest, err := tpmkey.Split2B(b)
+		if err != nil {
+			return nil, fmt.Errorf("bad NV public")
+		}
+		t, err := tpm2.Unmarshal[tpm2.TPMSNVPublic](pub)
+		if err != nil {
+			return nil, fmt.Errorf("bad NV public: %v", err)
+		}
+		p := tpm2.New2B(*t)
+		c.NVPublic, b = &p, rest
+	default:
+		return nil, fmt.Errorf("unsupported resource type %d (sessions aren't)", typ)
+	}
+	return b, nil
+}
+
+// resource marshals the IESYS_RESOURCE of c.
+func (c *Context) resource() []byte {
+	b := binary.BigEndian.AppendUint32(nil, uint32(c.Handle))
+	b = append(b, tpm2.Marshal(c.Name)...)
+	switch {
+	case c.Public != nil:
+		b = binary.BigEndian.AppendUint32(b, resourceKey)
+		b = append(b, tpm2.Marshal(*c.Public)...)
+	case c.NVPublic != nil:
+		b = binary.BigEndian.AppendUint32(b, resourceNV)
+		b = append(b, tpm2.Marshal(*c.NVPublic)...)
+	default:
+		b = binary.BigEndian.AppendUint32(b, resourceNone)
+	}
+	return b
+}
+
+// Marshal returns the file tpm2-tools reads c from: a context file, or a
+// serialized ESYS_TR if c has no saved context.
+func (c *Context) Marshal() []byte {
+	if c.Saved == nil {
+		return c.resource()
+	}
+	res := c.resource()
+	blob := binary.BigEndian.AppendUint32(nil, 0)
+	blob = append(blob, tpm2.Marshal(tpm2.TPM2BData{Buffer: c.Saved.ContextBlob.Buffer})...)
+	blob = binary.BigEndian.AppendUint16(blob, uint16(len(res)))
+	blob = append(blob, res...)
+
+	b := binary.BigEndian.AppendUint32(nil, contextMagic)
+	b = binary.BigEndian.AppendUint32(b, contextVersion)
+	b = binary.BigEndian.AppendUint32(b, uint32(c.Saved.Hierarchy))
+	b = binary.BigEndian.AppendUint32(b, uint32(c.Saved.SavedHandle))
+	b = binary.BigEndian.AppendUint64(b, c.Saved.Sequence)
+	return append(b, tpm2.Marshal(tpm2.TPM2BData{Buffer: blob})...)
+}
+
+// SaveContext saves the object at handle as tpm2-tools does for -c: a
+// transient object with TPM2_ContextSave, a persistent object or NV index
+// as a serialized ESYS_TR.
+func SaveContext(rwr transport.TPM, handle tpm2.TPMHandle) (*Context, error) {
+	c := &Context{Handle: handle}
+	if keyfile.IsMSO(handle, keyfile.TPM_HT_NV_INDEX) {
+		rsp, err := tpm2.NVReadPublic{NVIndex: handle}.Execute(rwr)
+		if err != nil {
+			return nil, fmt.Errorf("tpm2tools: can't read NV public of 0x%x: %v", handle, err)
+		}
+		c.Name, c.NVPublic = rsp.NVName, &rsp.NVPublic
+		return c, nil
+	}
+
+	rsp, err := tpm2.ReadPublic{ObjectHandle: handle}.Execute(rwr)
+	if err != nil {
+		return nil, fmt.Errorf("tpm2tools: can't read public of 0x%x: %v", handle, err)
+	}
+	c.Name, c.Public = rsp.Name, &rsp.OutPublic
+	if keyfile.IsMSO(handle, keyfile.TPM_HT_PERSISTENT) {
+		return c, nil
+	}
+	saved, err := tpm2.ContextSave{SaveHandle: handle}.Execute(rwr)
+	if err != nil {
+		return nil, fmt.Errorf("tpm2tools: can't save context of 0x%x: %v", handle, err)
+	}
+	c.Saved = &saved.Context
+	return c, nil
+}
+
+// Load makes the object of c available: a saved context is loaded with
+// TPM2_ContextLoad, which only works on the TPM that saved it and until its
+// next reset, a persistent object or NV index is checked to still have
+// c's name.  The returned func flushes a loaded context and must always be
+// called.
+func (c *Context) Load(rwr transport.TPM) (*tpm2.NamedHandle, func(), error) {
+	if c.Saved == nil {
+		var name tpm2.TPM2BName
+		if keyfile.IsMSO(c.Handle, keyfile.TPM_HT_NV_INDEX) {
+			rsp, err := tpm2.NVReadPublic{NVIndex: c.Handle}.Execute(rwr)
+			if err != nil {
+				return nil, nil, fmt.Errorf("tpm2tools: can't read NV public of 0x%x: %v", c.Handle, err)
+			}
+			name = rsp.NVName
+		} else {
+			rsp, err := tpm2.ReadPublic{ObjectHandle: c.Handle}.Execute(rwr)
+			if err != nil {
+				return nil, nil, fmt.Errorf("tpm2tools: can't read public of 0x%x: %v", c.Handle, err)
+			}
+			name = rsp.Name
+		}
+		if !bytes.Equal(name.Buffer, c.Name.Buffer) {
+			return nil, nil, fmt.Errorf("tpm2tools: 0x%x isn't the object the context names anymore", c.Handle)
+		}
+		return &tpm2.NamedHandle{Handle: c.Handle, Name: name}, func() {}, nil
+	}
+
+	rsp, err := tpm2.ContextLoad{Context: *c.Saved}.Execute(rwr)
+	if err != nil {
+		return nil, nil, fmt.Errorf("tpm2tools: can't load context: %v", err)
+	}
+	closer := func() {
+		_, _ = tpm2.FlushContext{FlushHandle: rsp.LoadedHandle}.Execute(rwr)
+	}
+	name := c.Name
+	if len(name.Buffer) == 0 {
+		pub, err := tpm2.ReadPublic{ObjectHandle: rsp.LoadedHandle}.Execute(rwr)
+		if err != nil {
+			closer()
+			return nil, nil, fmt.Errorf("tpm2tools: can't read public of loaded context: %v", err)
+		}
+		name = pub.Name
+	}
+	return &tpm2.NamedHandle{Handle: rsp.LoadedHandle, Name: name}, closer, nil
+}
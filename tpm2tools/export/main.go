@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/hex"
+	"flag"
+	"io"
+	"log"
+	"maps"
+	"net"
+	"os"
+	"slices"
+
+	keyfile "github.com/foxboron/go-tpm-keyfiles"
+	"github.com/google/go-tpm-tools/simulator"
+	"github.com/google/go-tpm/tpm2"
+	"github.com/google/go-tpm/tpm2/transport"
+	"github.com/google/go-tpm/tpmutil"
+	"github.com/ibiscum/tpm2/tpm2tools"
+	"github.com/ibiscum/tpm2/tpmkey"
+)
+
+var (
+	tpmPath   = flag.String("tpm-path", "/dev/tpmrm0", "Path to the TPM device (character device or a Unix socket).")
+	key       = flag.String("key", "key.pem", "TSS2 keyfile, loadable or sealed")
+	password  = flag.String("password", "", "key password, for -c")
+	public    = flag.String("u", "key.pub", "TPM2B_PUBLIC to write")
+	private   = flag.String("r", "key.priv", "TPM2B_PRIVATE to write")
+	nameOut   = flag.String("n", "", "optional name file to write")
+	pemOut    = flag.String("pem", "", "optional PEM public key to write")
+	parentCtx = flag.String("parent-ctx", "", "optional context file to save the keyfile's parent to, for tpm2_load -C (needs the TPM)")
+	ctxOut    = flag.String("c", "", "optional context file to save the loaded key to, for tpm2_sign -c and the like (needs the TPM)")
+)
+
+var TPMDEVICES = []string{"/dev/tpm0", "/dev/tpmrm0"}
+
+func OpenTPM(path string) (io.ReadWriteCloser, error) {
+	if slices.Contains(TPMDEVICES, path) {
+		return tpmutil.OpenTPM(path)
+	} else if path == "simulator" {
+		return simulator.GetWithFixedSeedInsecure(1073741825)
+	} else {
+		return net.Dial("tcp", path)
+	}
+}
+
+func main() {
+	flag.Parse()
+
+	b, err := os.ReadFile(*key)
+	if err != nil {
+		log.Fatalf("can't read keyfile: %v", err)
+	}
+	kf, err := keyfile.Decode(b)
+	if err != nil {
+		log.Fatalf("can't decode keyfile: %v", err)
+	}
+	if !kf.Keytype.Equal(keyfile.OIDLoadableKey) && !kf.Keytype.Equal(keyfile.OIDSealedKey) {
+		log.Fatalf("keyfile type %v has no TPM2B_PRIVATE to export, import it first", kf.Keytype)
+	}
+	pub, err := kf.Pubkey.Contents()
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	name, err := tpm2.ObjectName(pub)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	if len(kf.Policy) > 0 {
+		log.Printf("the key has a %d step policy, tpm2-tools needs it as a policy session (-p session:...)", len(kf.Policy))
+	}
+
+	files := map[string][]byte{
+		*public:  tpm2.Marshal(kf.Pubkey),
+		*private: tpm2.Marshal(kf.Privkey),
+		*nameOut: name.Buffer,
+	}
+	if *pemOut != "" {
+		if files[*pemOut], err = tpm2tools.PublicKeyPEM(pub); err != nil {
+			log.Fatalf("%v", err)
+		}
+	}
+
+	if *parentCtx != "" || *ctxOut != "" {
+		rwc, err := OpenTPM(*tpmPath)
+		if err != nil {
+			log.Fatalf("can't open TPM %q: %v", *tpmPath, err)
+		}
+		defer func() {
+			rwc.Close()
+		}()
+
+		rwr := transport.FromReadWriter(rwc)
+
+		parent, _, closer, err := tpmkey.FindParent(rwr, kf)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		defer closer()
+		if *parentCtx != "" {
+			c, err := tpm2tools.SaveContext(rwr, parent.Handle)
+			if err != nil {
+				log.Fatalf("%v", err)
+			}
+			files[*parentCtx] = c.Marshal()
+		}
+		if *ctxOut != "" {
+			k, err := tpmkey.LoadBlob(rwr, parent, kf.Pubkey, kf.Privkey, []byte(*password), kf.Policy)
+			if err != nil {
+				log.Fatalf("%v", err)
+			}
+			defer k.Close()
+			c, err := tpm2tools.SaveContext(rwr, k.Handle)
+			if err != nil {
+				log.Fatalf("%v", err)
+			}
+			files[*ctxOut] = c.Marshal()
+		}
+	}
+
+	delete(files, "")
+	for _, f := range slices.Sorted(maps.Keys(files)) {
+		if err := os.WriteFile(f, files[f], 0600); err != nil {
+			log.Fatalf("can't write %s: %v", f, err)
+		}
+	}
+	log.Printf("exported key %s from parent 0x%x", hex.EncodeToString(name.Buffer), kf.Parent)
+}
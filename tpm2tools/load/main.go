@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"flag"
+	"io"
+	"log"
+	"net"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	keyfile "github.com/foxboron/go-tpm-keyfiles"
+	"github.com/google/go-tpm-tools/simulator"
+	"github.com/google/go-tpm/tpm2"
+	"github.com/google/go-tpm/tpm2/transport"
+	"github.com/google/go-tpm/tpmutil"
+	"github.com/ibiscum/tpm2/tpm2tools"
+	"github.com/ibiscum/tpm2/tpmkey"
+	"github.com/ibiscum/tpm2/tpmtemplate"
+)
+
+var (
+	tpmPath       = flag.String("tpm-path", "/dev/tpmrm0", "Path to the TPM device (character device or a Unix socket).")
+	parent        = flag.String("C", "", "parent: a context file (tpm2_createprimary -c, tpm2_evictcontrol -o) or a handle")
+	public        = flag.String("u", "key.pub", "TPM2B_PUBLIC of the key (tpm2_create -u)")
+	private       = flag.String("r", "key.priv", "TPM2B_PRIVATE of the key (tpm2_create -r)")
+	password      = flag.String("password", "", "key password")
+	ctxOut        = flag.String("c", "", "optional context file to save the loaded key to")
+	nameOut       = flag.String("n", "", "optional name file to write")
+	keyfileOut    = flag.String("keyfile", "", "optional TSS2 keyfile to write")
+	keyfileParent = flag.String("keyfile-parent", "", "parent handle recorded in the keyfile (default: the persistent parent, or the hierarchy of an H-2 primary)")
+)
+
+var TPMDEVICES = []string{"/dev/tpm0", "/dev/tpmrm0"}
+
+func OpenTPM(path string) (io.ReadWriteCloser, error) {
+	if slices.Contains(TPMDEVICES, path) {
+		return tpmutil.OpenTPM(path)
+	} else if path == "simulator" {
+		return simulator.GetWithFixedSeedInsecure(1073741825)
+	} else {
+		return net.Dial("tcp", path)
+	}
+}
+
+func main() {
+	flag.Parse()
+
+	if *parent == "" {
+		log.Fatalf("-C is required")
+	}
+	b, err := os.ReadFile(*public)
+	if err != nil {
+		log.Fatalf("can't read public: %v", err)
+	}
+	pub, err := tpm2tools.ParsePublic(b)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	b, err = os.ReadFile(*private)
+	if err != nil {
+		log.Fatalf("can't read private: %v", err)
+	}
+	priv, err := tpm2tools.ParsePrivate(b)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	rwc, err := OpenTPM(*tpmPath)
+	if err != nil {
+		log.Fatalf("can't open TPM %q: %v", *tpmPath, err)
+	}
+	defer func() {
+		rwc.Close()
+	}()
+
+	rwr := transport.FromReadWriter(rwc)
+
+	// the parent is a handle, or a context file
+	var parentHandle *tpm2.NamedHandle
+	var parentCtx *tpm2tools.Context
+	var parentArg tpm2.TPMHandle
+	if h, err := strconv.ParseUint(*parent, 0, 32); err == nil {
+		var closer func()
+		parentArg = tpm2.TPMHandle(h)
+		parentHandle, closer, err = tpmkey.Parent(rwr, parentArg)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		defer closer()
+	} else {
+		b, err := os.ReadFile(*parent)
+		if err != nil {
+			log.Fatalf("can't read parent context: %v", err)
+		}
+		if parentCtx, err = tpm2tools.ParseContext(b); err != nil {
+			log.Fatalf("%v", err)
+		}
+		var closer func()
+		parentHandle, closer, err = parentCtx.Load(rwr)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		defer closer()
+	}
+
+	k, err := tpmkey.LoadBlob(rwr, parentHandle, *pub, *priv, []byte(*password), nil)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	defer k.Close()
+	log.Printf("loaded key %s under parent %s", hex.EncodeToString(k.Name.Buffer), hex.EncodeToString(parentHandle.Name.Buffer))
+
+	if *ctxOut != "" {
+		c, err := tpm2tools.SaveContext(rwr, k.Handle)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		if err := os.WriteFile(*ctxOut, c.Marshal(), 0600); err != nil {
+			log.Fatalf("can't write context: %v", err)
+		}
+		log.Printf("wrote %s", *ctxOut)
+	}
+	if *nameOut != "" {
+		if err := os.WriteFile(*nameOut, k.Name.Buffer, 0644); err != nil {
+			log.Fatalf("can't write name: %v", err)
+		}
+		log.Printf("wrote %s", *nameOut)
+	}
+
+	if *keyfileOut != "" {
+		var handle tpm2.TPMHandle
+		switch {
+		case *keyfileParent != "":
+			h, err := strconv.ParseUint(*keyfileParent, 0, 32)
+			if err != nil {
+				log.Fatalf("bad keyfile parent %q: %v", *keyfileParent, err)
+			}
+			handle = tpm2.TPMHandle(h)
+		case parentCtx == nil:
+			handle = parentArg
+		case parentCtx.Saved == nil:
+			handle = parentCtx.Handle
+		default:
+			// a keyfile names a transient parent by its hierarchy, meaning
+			// the H-2 primary there or one of the primaries loaders try
+			// after it; tpm2_createprimary makes one of them only with its
+			// defaults or given the template
+			handle = parentCtx.Saved.Hierarchy
+			names := tpmtemplate.ForParent(handle, keyfile.OIDLoadableKey, k.Public.Type)
+			var found bool
+			for _, name := range names {
+				p, closer, err := tpmkey.Primary(rwr, handle, tpmtemplate.Templates[name])
+				if err != nil {
+					continue
+				}
+				closer()
+				if found = bytes.Equal(p.Name.Buffer, parentHandle.Name.Buffer); found {
+					break
+				}
+			}
+			if !found {
+				log.Fatalf("the parent isn't any of the primaries of hierarchy 0x%x a keyfile stands for (%s), persist it and use -keyfile-parent", handle, strings.Join(names, ", "))
+			}
+		}
+		kf := keyfile.NewTPMKey(keyfile.OIDLoadableKey, *pub, *priv,
+			keyfile.WithParent(handle),
+			keyfile.WithUserAuth([]byte(*password)),
+		)
+		if t := k.Public; t.Type == tpm2.TPMAlgKeyedHash && !t.ObjectAttributes.SignEncrypt && !t.ObjectAttributes.Decrypt {
+			kf.Keytype = keyfile.OIDSealedKey
+		}
+		f, err := os.OpenFile(*keyfileOut, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if err != nil {
+			log.Fatalf("can't create keyfile: %v", err)
+		}
+		defer f.Close()
+		if err := keyfile.Encode(f, kf); err != nil {
+			log.Fatalf("can't write keyfile: %v", err)
+		}
+		log.Printf("wrote %s with parent 0x%x", *keyfileOut, handle)
+	}
+}
@@ -0,0 +1,331 @@
+package tpmkey
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/asn1"
+	"encoding/binary"
+	"fmt"
+	"math/big"
+	"slices"
+
+	keyfile "github.com/foxboron/go-tpm-keyfiles"
+	"github.com/google/go-tpm/tpm2"
+	"github.com/google/go-tpm/tpm2/transport"
+)
+
+// SignerPublic returns the public area a policy signer, an *rsa.PublicKey or
+// *ecdsa.PublicKey held outside the TPM, is loaded with to check its
+// signatures.  Its name is what PolicyAuthorize is bound to.
+func SignerPublic(pub crypto.PublicKey) (*tpm2.TPMTPublic, error) {
+	t := tpm2.TPMTPublic{
+		NameAlg: tpm2.TPMAlgSHA256,
+		ObjectAttributes: tpm2.TPMAObject{
+			UserWithAuth: true,
+			Decrypt:      true,
+			SignEncrypt:  true,
+		},
+	}
+	switch p := pub.(type) {
+	case *rsa.PublicKey:
+		exponent := uint32(p.E)
+		if p.E == 65537 {
+			exponent = 0
+		}
+		t.Type = tpm2.TPMAlgRSA
+		t.Parameters = tpm2.NewTPMUPublicParms(tpm2.TPMAlgRSA, &tpm2.TPMSRSAParms{
+			Symmetric: tpm2.TPMTSymDefObject{Algorithm: tpm2.TPMAlgNull},
+			Scheme:    tpm2.TPMTRSAScheme{Scheme: tpm2.TPMAlgNull},
+			KeyBits:   tpm2.TPMKeyBits(p.N.BitLen()),
+			Exponent:  exponent,
+		})
+		t.Unique = tpm2.NewTPMUPublicID(tpm2.TPMAlgRSA, &tpm2.TPM2BPublicKeyRSA{Buffer: p.N.Bytes()})
+	case *ecdsa.PublicKey:
+		curves := map[elliptic.Curve]tpm2.TPMECCCurve{
+			elliptic.P256(): tpm2.TPMECCNistP256,
+			elliptic.P384(): tpm2.TPMECCNistP384,
+			elliptic.P521(): tpm2.TPMECCNistP521,
+		}
+		curve, ok := curves[p.Curve]
+		if !ok {
+			return nil, fmt.Errorf("tpmkey: unsupported signer curve %s", p.Curve.Params().Name)
+		}
+		size := (p.Curve.Params().BitSize + 7) / 8
+		t.Type = tpm2.TPMAlgECC
+		t.Parameters = tpm2.NewTPMUPublicParms(tpm2.TPMAlgECC, &tpm2.TPMSECCParms{
+			Symmetric: tpm2.TPMTSymDefObject{Algorithm: tpm2.TPMAlgNull},
+			Scheme:    tpm2.TPMTECCScheme{Scheme: tpm2.TPMAlgNull},
+			CurveID:   curve,
+			KDF:       tpm2.TPMTKDFScheme{Scheme: tpm2.TPMAlgNull},
+		})
+		t.Unique = tpm2.NewTPMUPublicID(tpm2.TPMAlgECC, &tpm2.TPMSECCPoint{
+			X: tpm2.TPM2BECCParameter{Buffer: p.X.FillBytes(make([]byte, size))},
+			Y: tpm2.TPM2BECCParameter{Buffer: p.Y.FillBytes(make([]byte, size))},
+		})
+	default:
+		return nil, fmt.Errorf("tpmkey: unsupported signer key %T", pub)
+	}
+	return &t, nil
+}
+
+// nullSignature is a TPMT_SIGNATURE with sigAlg TPM_ALG_NULL.
+var nullSignature = binary.BigEndian.AppendUint16(nil, uint16(tpm2.TPMAlgNull))
+
+// AuthorizeStep returns a PolicyAuthorize step for signer and policyRef, as
+// draft-bottomley-tpm2-keys records it in the key's policy: TPM2B_PUBLIC ||
+// TPM2B_DIGEST policyRef || TPMT_SIGNATURE, with a NULL signature.  The
+// signed policies go to the keyfile's authPolicy, see AddSignedPolicy.
+func AuthorizeStep(signer *tpm2.TPMTPublic, policyRef []byte) *keyfile.TPMPolicy {
+	b := tpm2.Marshal(tpm2.New2B(*signer))
+	b = append(b, tpm2.Marshal(tpm2.TPM2BDigest{Buffer: policyRef})...)
+	b = append(b, nullSignature...)
+	return &keyfile.TPMPolicy{
+		CommandCode:   int(tpm2.TPMCCPolicyAuthorize),
+		CommandPolicy: b,
+	}
+}
+
+// splitPolicyAuthorize decodes a PolicyAuthorize step.  The signature is
+// nil when it is NULL.
+func splitPolicyAuthorize(b []byte) (*tpm2.TPMTPublic, []byte, *tpm2.TPMTSignature, error) {
+	pubBytes, rest, err := Split2B(b)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("tpmkey: bad PolicyAuthorize signer")
+	}
+	pub, err := tpm2.Unmarshal[tpm2.TPMTPublic](pubBytes)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("tpmkey: bad PolicyAuthorize signer: %v", err)
+	}
+	ref, rest, err := Split2B(rest)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("tpmkey: bad PolicyAuthorize policyRef")
+	}
+	if len(rest) == 0 || bytes.Equal(rest, nullSignature) {
+		return pub, ref, nil, nil
+	}
+	sig, err := tpm2.Unmarshal[tpm2.TPMTSignature](rest)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("tpmkey: bad PolicyAuthorize signature: %v", err)
+	}
+	return pub, ref, sig, nil
+}
+
+// AddSignedPolicy signs steps with signer, the key's PolicyAuthorize signer,
+// and appends them to the keyfile's authPolicy under name.  Using the key
+// then satisfies any one of its signed policies.  PCR steps need their
+// digest, as the policy is signed without the TPM.
+func AddSignedPolicy(k *keyfile.TPMKey, signer crypto.Signer, name string, steps []*keyfile.TPMPolicy) error {
+	if len(k.Policy) == 0 || tpm2.TPMCC(k.Policy[0].CommandCode) != tpm2.TPMCCPolicyAuthorize {
+		return fmt.Errorf("tpmkey: the key's policy isn't PolicyAuthorize")
+	}
+	keySign, ref, _, err := splitPolicyAuthorize(k.Policy[0].CommandPolicy)
+	if err != nil {
+		return err
+	}
+	signerPub, err := SignerPublic(signer.Public())
+	if err != nil {
+		return err
+	}
+	want, err := tpm2.ObjectName(keySign)
+	if err != nil {
+		return err
+	}
+	got, err := tpm2.ObjectName(signerPub)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(want.Buffer, got.Buffer) {
+		return fmt.Errorf("tpmkey: the key's policy is authorized by another signer")
+	}
+
+	pub, err := k.Pubkey.Contents()
+	if err != nil {
+		return fmt.Errorf("tpmkey: can't read key public: %v", err)
+	}
+	approved, err := PolicyDigest(steps, pub.NameAlg)
+	if err != nil {
+		return err
+	}
+
+	// TPM2_PolicyAuthorize checks a signature over
+	// H_nameAlg(approvedPolicy || policyRef), nameAlg being the signer's
+	h, err := keySign.NameAlg.Hash()
+	if err != nil {
+		return err
+	}
+	d := h.New()
+	d.Write(approved)
+	d.Write(ref)
+	digest := d.Sum(nil)
+	raw, err := signer.Sign(rand.Reader, digest, h)
+	if err != nil {
+		return fmt.Errorf("tpmkey: can't sign policy: %v", err)
+	}
+
+	var sig tpm2.TPMTSignature
+	switch keySign.Type {
+	case tpm2.TPMAlgRSA:
+		sig = tpm2.TPMTSignature{
+			SigAlg: tpm2.TPMAlgRSASSA,
+			Signature: tpm2.NewTPMUSignature(tpm2.TPMAlgRSASSA, &tpm2.TPMSSignatureRSA{
+				Hash: keySign.NameAlg,
+				Sig:  tpm2.TPM2BPublicKeyRSA{Buffer: raw},
+			}),
+		}
+	case tpm2.TPMAlgECC:
+		var rs struct{ R, S *big.Int }
+		if _, err := asn1.Unmarshal(raw, &rs); err != nil {
+			return fmt.Errorf("tpmkey: bad ECDSA signature: %v", err)
+		}
+		sig = tpm2.TPMTSignature{
+			SigAlg: tpm2.TPMAlgECDSA,
+			Signature: tpm2.NewTPMUSignature(tpm2.TPMAlgECDSA, &tpm2.TPMSSignatureECC{
+				Hash:       keySign.NameAlg,
+				SignatureR: tpm2.TPM2BECCParameter{Buffer: rs.R.Bytes()},
+				SignatureS: tpm2.TPM2BECCParameter{Buffer: rs.S.Bytes()},
+			}),
+		}
+	}
+
+	// the signer's public and policyRef are kept as the key's policy has
+	// them, only the NULL signature is replaced
+	pubBytes, rest, _ := Split2B(k.Policy[0].CommandPolicy)
+	refBytes, _, _ := Split2B(rest)
+	n := 4 + len(pubBytes) + len(refBytes)
+	b := append(k.Policy[0].CommandPolicy[:n:n], tpm2.Marshal(sig)...)
+
+	k.AuthPolicy = append(k.AuthPolicy, &keyfile.TPMAuthPolicy{
+		Name: name,
+		Policy: append(steps[:len(steps):len(steps)], &keyfile.TPMPolicy{
+			CommandCode:   int(tpm2.TPMCCPolicyAuthorize),
+			CommandPolicy: b,
+		}),
+	})
+	return nil
+}
+
+// authorize satisfies the PolicyAuthorize step with the first signed policy
+// in authPolicy that can be satisfied: its steps are replayed, and the
+// TPM checks the signature over the resulting digest.  Each candidate is
+// tried in a session of its own first, as a failed policy session can't be
+// rewound.
+func authorize(rwr transport.TPM, handle tpm2.TPMISHPolicy, step *keyfile.TPMPolicy, authPolicy []*keyfile.TPMAuthPolicy, nameAlg tpm2.TPMAlgID) error {
+	keySign, ref, _, err := splitPolicyAuthorize(step.CommandPolicy)
+	if err != nil {
+		return err
+	}
+	if len(authPolicy) == 0 {
+		return fmt.Errorf("tpmkey: PolicyAuthorize without signed policies")
+	}
+
+	var errs []error
+	for _, ap := range authPolicy {
+		if len(ap.Policy) == 0 {
+			continue
+		}
+		err := func() error {
+			sess, cleanup, err := tpm2.PolicySession(rwr, nameAlg, 16)
+			if err != nil {
+				return err
+			}
+			defer cleanup()
+			return runSignedPolicy(rwr, sess.Handle(), ap, keySign, ref, nameAlg)
+		}()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%q: %v", ap.Name, err))
+			continue
+		}
+		return runSignedPolicy(rwr, handle, ap, keySign, ref, nameAlg)
+	}
+	return fmt.Errorf("tpmkey: no signed policy could be satisfied: %v", errs)
+}
+
+// withoutPasswordFirst orders the signed policies that don't need the
+// key's password first.  A PolicyAuthValue step always succeeds, the
+// password is only checked by the command using the key, so without a
+// password those would otherwise win over the ones that don't need it.
+func withoutPasswordFirst(authPolicy []*keyfile.TPMAuthPolicy) []*keyfile.TPMAuthPolicy {
+	needsPassword := func(ap *keyfile.TPMAuthPolicy) bool {
+		return slices.ContainsFunc(ap.Policy, func(p *keyfile.TPMPolicy) bool {
+			return tpm2.TPMCC(p.CommandCode) == tpm2.TPMCCPolicyAuthValue
+		})
+	}
+	sorted := slices.Clone(authPolicy)
+	slices.SortStableFunc(sorted, func(a, b *keyfile.TPMAuthPolicy) int {
+		switch {
+		case !needsPassword(a) && needsPassword(b):
+			return -1
+		case needsPassword(a) && !needsPassword(b):
+			return 1
+		}
+		return 0
+	})
+	return sorted
+}
+
+// runSignedPolicy replays one signed policy and its final PolicyAuthorize.
+func runSignedPolicy(rwr transport.TPM, handle tpm2.TPMISHPolicy, ap *keyfile.TPMAuthPolicy, keySign *tpm2.TPMTPublic, ref []byte, nameAlg tpm2.TPMAlgID) error {
+	last := ap.Policy[len(ap.Policy)-1]
+	if tpm2.TPMCC(last.CommandCode) != tpm2.TPMCCPolicyAuthorize {
+		return fmt.Errorf("signed policy doesn't end in PolicyAuthorize")
+	}
+	signer, signedRef, sig, err := splitPolicyAuthorize(last.CommandPolicy)
+	if err != nil {
+		return err
+	}
+	if sig == nil {
+		return fmt.Errorf("signed policy has no signature")
+	}
+	if !bytes.Equal(tpm2.Marshal(*signer), tpm2.Marshal(*keySign)) || !bytes.Equal(signedRef, ref) {
+		return fmt.Errorf("signed policy is for another signer or key")
+	}
+
+	if err := replayPolicy(rwr, handle, ap.Policy[:len(ap.Policy)-1], nil, nameAlg); err != nil {
+		return err
+	}
+	rsp, err := tpm2.PolicyGetDigest{PolicySession: handle}.Execute(rwr)
+	if err != nil {
+		return fmt.Errorf("tpmkey: PolicyGetDigest failed: %v", err)
+	}
+	approved := rsp.PolicyDigest.Buffer
+
+	ext, err := tpm2.LoadExternal{
+		InPublic:  tpm2.New2B(*keySign),
+		Hierarchy: tpm2.TPMRHOwner,
+	}.Execute(rwr)
+	if err != nil {
+		return fmt.Errorf("tpmkey: can't load policy signer: %v", err)
+	}
+	defer flush(rwr, ext.ObjectHandle)
+
+	h, err := keySign.NameAlg.Hash()
+	if err != nil {
+		return err
+	}
+	d := h.New()
+	d.Write(approved)
+	d.Write(ref)
+	verified, err := tpm2.VerifySignature{
+		KeyHandle: ext.ObjectHandle,
+		Digest:    tpm2.TPM2BDigest{Buffer: d.Sum(nil)},
+		Signature: *sig,
+	}.Execute(rwr)
+	if err != nil {
+		return fmt.Errorf("tpmkey: bad policy signature: %v", err)
+	}
+	_, err = tpm2.PolicyAuthorize{
+		PolicySession:  handle,
+		ApprovedPolicy: tpm2.TPM2BDigest{Buffer: approved},
+		PolicyRef:      tpm2.TPM2BDigest{Buffer: ref},
+		KeySign:        ext.Name,
+		CheckTicket:    verified.Validation,
+	}.Execute(rwr)
+	if err != nil {
+		return fmt.Errorf("tpmkey: PolicyAuthorize failed: %v", err)
+	}
+	return nil
+}
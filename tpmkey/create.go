@@ -0,0 +1,127 @@
+package tpmkey
+
+import (
+	keyfile "github.com/foxboron/go-tpm-keyfiles"
+	"github.com/google/go-tpm/tpm2"
+	"github.com/google/go-tpm/tpm2/transport"
+)
+
+// RSATemplate returns a template for a non-exportable RSA key.  With scheme
+// TPMAlgNull the key can both sign (PKCS#1 v1.5 or PSS) and decrypt;
+// otherwise it is a signing key fixed to scheme/hash.
+func RSATemplate(bits int, scheme, hash tpm2.TPMAlgID) tpm2.TPMTPublic {
+	s := tpm2.TPMTRSAScheme{
+		Scheme: tpm2.TPMAlgNull,
+	}
+	switch scheme {
+	case tpm2.TPMAlgRSASSA:
+		s = tpm2.TPMTRSAScheme{
+			Scheme: scheme,
+			Details: tpm2.NewTPMUAsymScheme(
+				scheme,
+				&tpm2.TPMSSigSchemeRSASSA{
+					HashAlg: hash,
+				},
+			),
+		}
+	case tpm2.TPMAlgRSAPSS:
+		s = tpm2.TPMTRSAScheme{
+			Scheme: scheme,
+			Details: tpm2.NewTPMUAsymScheme(
+				scheme,
+				&tpm2.TPMSSigSchemeRSAPSS{
+					HashAlg: hash,
+				},
+			),
+		}
+	}
+	return tpm2.TPMTPublic{
+		Type:    tpm2.TPMAlgRSA,
+		NameAlg: tpm2.TPMAlgSHA256,
+		ObjectAttributes: tpm2.TPMAObject{
+			SignEncrypt:         true,
+			Decrypt:             scheme == tpm2.TPMAlgNull,
+			FixedTPM:            true,
+			FixedParent:         true,
+			SensitiveDataOrigin: true,
+			UserWithAuth:        true,
+		},
+		Parameters: tpm2.NewTPMUPublicParms(
+			tpm2.TPMAlgRSA,
+			&tpm2.TPMSRSAParms{
+				Scheme:  s,
+				KeyBits: tpm2.TPMKeyBits(bits),
+			},
+		),
+		Unique: tpm2.NewTPMUPublicID(
+			tpm2.TPMAlgRSA,
+			&tpm2.TPM2BPublicKeyRSA{
+				Buffer: make([]byte, bits/8),
+			},
+		),
+	}
+}
+
+// ECCTemplate returns a template for a non-exportable ECC key, see
+// RSATemplate for the meaning of scheme.  An unrestricted ECC key can be
+// used for ECDSA and ECDH.
+func ECCTemplate(curve tpm2.TPMECCCurve, scheme, hash tpm2.TPMAlgID) tpm2.TPMTPublic {
+	s := tpm2.TPMTECCScheme{
+		Scheme: tpm2.TPMAlgNull,
+	}
+	if scheme != tpm2.TPMAlgNull {
+		s = tpm2.TPMTECCScheme{
+			Scheme: scheme,
+			Details: tpm2.NewTPMUAsymScheme(
+				scheme,
+				&tpm2.TPMSSigSchemeECDSA{
+					HashAlg: hash,
+				},
+			),
+		}
+	}
+	return tpm2.TPMTPublic{
+		Type:    tpm2.TPMAlgECC,
+		NameAlg: tpm2.TPMAlgSHA256,
+		ObjectAttributes: tpm2.TPMAObject{
+			SignEncrypt:         true,
+			Decrypt:             scheme == tpm2.TPMAlgNull,
+			FixedTPM:            true,
+			FixedParent:         true,
+			SensitiveDataOrigin: true,
+			UserWithAuth:        true,
+		},
+		Parameters: tpm2.NewTPMUPublicParms(
+			tpm2.TPMAlgECC,
+			&tpm2.TPMSECCParms{
+				CurveID: curve,
+				Scheme:  s,
+			},
+		),
+	}
+}
+
+// Create creates a key from template under the owner SRK and returns it as a
+// loadable keyfile.  auth and pol work as for CreateHMAC.
+func Create(rwr transport.TPM, template tpm2.TPMTPublic, auth []byte, pol *Policy) (*keyfile.TPMKey, error) {
+	steps, err := applyPolicy(rwr, &template, auth, pol)
+	if err != nil {
+		return nil, err
+	}
+	return create(rwr, template, nil, auth, steps)
+}
+
+// applyPolicy sets the authPolicy of template when pol asks for one and
+// returns the steps to record in the keyfile.
+func applyPolicy(rwr transport.TPM, template *tpm2.TPMTPublic, auth []byte, pol *Policy) ([]*keyfile.TPMPolicy, error) {
+	if pol == nil || (len(pol.PCRs) == 0 && pol.Secret == 0 && pol.Signer == nil) {
+		return nil, nil
+	}
+	steps, digest, err := PolicySteps(rwr, pol, len(auth) > 0, template.NameAlg)
+	if err != nil {
+		return nil, err
+	}
+	template.AuthPolicy = tpm2.TPM2BDigest{Buffer: digest}
+	template.ObjectAttributes.UserWithAuth = false
+	return steps, nil
+}
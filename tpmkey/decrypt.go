@@ -0,0 +1,89 @@
+package tpmkey
+
+import (
+	"crypto/ecdh"
+	"fmt"
+
+	"github.com/google/go-tpm/tpm2"
+)
+
+// DecryptOAEP decrypts an RSA-OAEP ciphertext with the key.  A non-empty
+// label has to end in a zero byte: the TPM hashes it including the
+// terminator, so software encrypting to the key must pass the same bytes.
+func (k *Key) DecryptOAEP(ciphertext, label []byte, hash tpm2.TPMAlgID) ([]byte, error) {
+	if !k.Public.ObjectAttributes.Decrypt {
+		return nil, fmt.Errorf("tpmkey: key can't decrypt")
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	rsp, err := tpm2.RSADecrypt{
+		KeyHandle: k.AuthHandle(),
+		CipherText: tpm2.TPM2BPublicKeyRSA{
+			Buffer: ciphertext,
+		},
+		InScheme: tpm2.TPMTRSADecrypt{
+			Scheme: tpm2.TPMAlgOAEP,
+			Details: tpm2.NewTPMUAsymScheme(
+				tpm2.TPMAlgOAEP,
+				&tpm2.TPMSEncSchemeOAEP{
+					HashAlg: hash,
+				},
+			),
+		},
+		Label: tpm2.TPM2BData{
+			Buffer: label,
+		},
+	}.Execute(k.rwr)
+	if err != nil {
+		return nil, fmt.Errorf("tpmkey: RSA decrypt failed: %v", err)
+	}
+	return rsp.Message.Buffer, nil
+}
+
+// ECDH returns the x coordinate of the key's private scalar times pub, the
+// same shared secret crypto/ecdh computes, using TPM2_ECDH_ZGen.
+func (k *Key) ECDH(pub *ecdh.PublicKey) ([]byte, error) {
+	if !k.Public.ObjectAttributes.Decrypt {
+		return nil, fmt.Errorf("tpmkey: key can't do ECDH")
+	}
+	detail, err := k.Public.Parameters.ECCDetail()
+	if err != nil {
+		return nil, err
+	}
+	size := 32
+	switch detail.CurveID {
+	case tpm2.TPMECCNistP384:
+		size = 48
+	case tpm2.TPMECCNistP521:
+		size = 66
+	}
+	// uncompressed point: 0x04 || x || y
+	b := pub.Bytes()
+	if len(b) != 1+2*size || b[0] != 4 {
+		return nil, fmt.Errorf("tpmkey: peer key is not on the key's curve")
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	rsp, err := tpm2.ECDHZGen{
+		KeyHandle: k.AuthHandle(),
+		InPoint: tpm2.New2B(tpm2.TPMSECCPoint{
+			X: tpm2.TPM2BECCParameter{Buffer: b[1 : 1+size]},
+			Y: tpm2.TPM2BECCParameter{Buffer: b[1+size:]},
+		}),
+	}.Execute(k.rwr)
+	if err != nil {
+		return nil, fmt.Errorf("tpmkey: ECDH_ZGen failed: %v", err)
+	}
+	z, err := rsp.OutPoint.Contents()
+	if err != nil {
+		return nil, err
+	}
+	// the TPM may strip leading zeros
+	out := make([]byte, size)
+	copy(out[size-len(z.X.Buffer):], z.X.Buffer)
+	return out, nil
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"flag"
+	"io"
+	"log"
+	"net"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	keyfile "github.com/foxboron/go-tpm-keyfiles"
+	"github.com/google/go-tpm-tools/simulator"
+	"github.com/google/go-tpm/tpm2"
+	"github.com/google/go-tpm/tpm2/transport"
+	"github.com/google/go-tpm/tpmutil"
+	"github.com/ibiscum/tpm2/tpmkey"
+)
+
+var (
+	tpmPath  = flag.String("tpm-path", "/dev/tpmrm0", "Path to the TPM device (character device or a Unix socket).")
+	keyType  = flag.String("type", "ecc", "key type: rsa or ecc")
+	bits     = flag.Int("bits", 2048, "RSA key size")
+	curve    = flag.String("curve", "p256", "ECC curve: p256, p384 or p521")
+	scheme   = flag.String("scheme", "", "fix the signing scheme: rsassa, rsapss or ecdsa (default: unrestricted)")
+	hash     = flag.String("hash", "sha256", "hash of the fixed signing scheme")
+	out      = flag.String("out", "key.pem", "keyfile to write")
+	pcrs     = flag.String("pcrs", "", "comma separated PCRs to bind the key to (eg 7,23)")
+	password = flag.String("password", "", "optional key password")
+	pubout   = flag.String("pubout", "", "optional file to write the PEM public key to")
+)
+
+var TPMDEVICES = []string{"/dev/tpm0", "/dev/tpmrm0"}
+
+func OpenTPM(path string) (io.ReadWriteCloser, error) {
+	if slices.Contains(TPMDEVICES, path) {
+		return tpmutil.OpenTPM(path)
+	} else if path == "simulator" {
+		return simulator.GetWithFixedSeedInsecure(1073741825)
+	} else {
+		return net.Dial("tcp", path)
+	}
+}
+
+func main() {
+	flag.Parse()
+
+	schemes := map[string]tpm2.TPMAlgID{
+		"":       tpm2.TPMAlgNull,
+		"rsassa": tpm2.TPMAlgRSASSA,
+		"rsapss": tpm2.TPMAlgRSAPSS,
+		"ecdsa":  tpm2.TPMAlgECDSA,
+	}
+	hashes := map[string]tpm2.TPMAlgID{
+		"sha256": tpm2.TPMAlgSHA256,
+		"sha384": tpm2.TPMAlgSHA384,
+		"sha512": tpm2.TPMAlgSHA512,
+	}
+	curves := map[string]tpm2.TPMECCCurve{
+		"p256": tpm2.TPMECCNistP256,
+		"p384": tpm2.TPMECCNistP384,
+		"p521": tpm2.TPMECCNistP521,
+	}
+
+	s, ok := schemes[*scheme]
+	if !ok {
+		log.Fatalf("unknown scheme %q", *scheme)
+	}
+	h, ok := hashes[*hash]
+	if !ok {
+		log.Fatalf("unknown hash %q", *hash)
+	}
+
+	var template tpm2.TPMTPublic
+	switch *keyType {
+	case "rsa":
+		if s == tpm2.TPMAlgECDSA {
+			log.Fatalf("ecdsa is not an RSA scheme")
+		}
+		template = tpmkey.RSATemplate(*bits, s, h)
+	case "ecc":
+		c, ok := curves[*curve]
+		if !ok {
+			log.Fatalf("unknown curve %q", *curve)
+		}
+		if s != tpm2.TPMAlgNull && s != tpm2.TPMAlgECDSA {
+			log.Fatalf("%s is not an ECC scheme", *scheme)
+		}
+		template = tpmkey.ECCTemplate(c, s, h)
+	default:
+		log.Fatalf("unknown key type %q", *keyType)
+	}
+
+	var pol *tpmkey.Policy
+	if *pcrs != "" {
+		pol = &tpmkey.Policy{}
+		for _, s := range strings.Split(*pcrs, ",") {
+			i, err := strconv.ParseUint(s, 10, 32)
+			if err != nil {
+				log.Fatalf("bad pcr %q: %v", s, err)
+			}
+			pol.PCRs = append(pol.PCRs, uint(i))
+		}
+	}
+
+	rwc, err := OpenTPM(*tpmPath)
+	if err != nil {
+		log.Fatalf("can't open TPM %q: %v", *tpmPath, err)
+	}
+	defer func() {
+		rwc.Close()
+	}()
+
+	rwr := transport.FromReadWriter(rwc)
+
+	log.Printf("======= create %s key ========", *keyType)
+
+	k, err := tpmkey.Create(rwr, template, []byte(*password), pol)
+	if err != nil {
+		log.Fatalf("can't create key: %v", err)
+	}
+
+	f, err := os.OpenFile(*out, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		log.Fatalf("can't create keyfile: %v", err)
+	}
+	defer f.Close()
+	if err := keyfile.Encode(f, k); err != nil {
+		log.Fatalf("can't write keyfile: %v", err)
+	}
+
+	log.Printf("wrote %s", *out)
+
+	if *pubout != "" {
+		pub, err := k.PublicKey()
+		if err != nil {
+			log.Fatalf("can't get public key: %v", err)
+		}
+		der, err := x509.MarshalPKIXPublicKey(pub)
+		if err != nil {
+			log.Fatalf("can't encode public key: %v", err)
+		}
+		if err := os.WriteFile(*pubout, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0644); err != nil {
+			log.Fatalf("can't write public key: %v", err)
+		}
+		log.Printf("wrote %s", *pubout)
+	}
+}
@@ -0,0 +1,202 @@
+package tpmkey
+
+import (
+	"crypto/rand"
+	"fmt"
+
+	keyfile "github.com/foxboron/go-tpm-keyfiles"
+	"github.com/google/go-tpm/tpm2"
+	"github.com/google/go-tpm/tpm2/transport"
+)
+
+const (
+	maxInputBuffer = 1024
+)
+
+// CreateHMAC imports secret as a non-exportable keyed-hash HMAC key under the
+// owner SRK and returns it as a loadable keyfile.  hash selects the HMAC
+// digest (SHA1, SHA256, ...).  If pol is set the key can only be used while
+// the PCRs hold their current values and with the hierarchy's auth for
+// pol.Secret; auth, if set, is required as well.  With pol.Signer the
+// signed policies decide instead.
+func CreateHMAC(rwr transport.TPM, secret []byte, hash tpm2.TPMAlgID, auth []byte, pol *Policy) (*keyfile.TPMKey, error) {
+	template := tpm2.TPMTPublic{
+		Type:    tpm2.TPMAlgKeyedHash,
+		NameAlg: tpm2.TPMAlgSHA256,
+		ObjectAttributes: tpm2.TPMAObject{
+			FixedTPM:     true,
+			FixedParent:  true,
+			UserWithAuth: true,
+			SignEncrypt:  true,
+		},
+		Parameters: tpm2.NewTPMUPublicParms(tpm2.TPMAlgKeyedHash,
+			&tpm2.TPMSKeyedHashParms{
+				Scheme: tpm2.TPMTKeyedHashScheme{
+					Scheme: tpm2.TPMAlgHMAC,
+					Details: tpm2.NewTPMUSchemeKeyedHash(tpm2.TPMAlgHMAC,
+						&tpm2.TPMSSchemeHMAC{
+							HashAlg: hash,
+						}),
+				},
+			}),
+	}
+
+	steps, err := applyPolicy(rwr, &template, auth, pol)
+	if err != nil {
+		return nil, err
+	}
+
+	return create(rwr, template, secret, auth, steps)
+}
+
+// create creates template with the given sensitive data under the owner SRK.
+func create(rwr transport.TPM, template tpm2.TPMTPublic, data []byte, auth []byte, steps []*keyfile.TPMPolicy) (*keyfile.TPMKey, error) {
+	parent, closer, err := Parent(rwr, tpm2.TPMRHOwner)
+	if err != nil {
+		return nil, err
+	}
+	defer closer()
+
+	rsp, err := tpm2.Create{
+		ParentHandle: tpm2.AuthHandle{
+			Handle: parent.Handle,
+			Name:   parent.Name,
+			Auth:   tpm2.PasswordAuth(nil),
+		},
+		InPublic: tpm2.New2B(template),
+		InSensitive: tpm2.TPM2BSensitiveCreate{
+			Sensitive: &tpm2.TPMSSensitiveCreate{
+				UserAuth: tpm2.TPM2BAuth{
+					Buffer: auth,
+				},
+				Data: tpm2.NewTPMUSensitiveCreate(&tpm2.TPM2BSensitiveData{
+					Buffer: data,
+				}),
+			},
+		},
+	}.Execute(rwr)
+	if err != nil {
+		return nil, fmt.Errorf("tpmkey: can't create object: %v", err)
+	}
+
+	return keyfile.NewTPMKey(keyfile.OIDLoadableKey, rsp.OutPublic, rsp.OutPrivate,
+		keyfile.WithParent(tpm2.TPMRHOwner),
+		keyfile.WithUserAuth(auth),
+		keyfile.WithPolicy(steps),
+	), nil
+}
+
+// HMAC computes the HMAC of data with a keyed-hash key using an HMAC
+// sequence, so data is not limited to the TPM's input buffer size.
+func (k *Key) HMAC(data []byte) ([]byte, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	rspHS, err := tpm2.HmacStart{
+		Handle:  k.AuthHandle(),
+		HashAlg: tpm2.TPMAlgNull,
+	}.Execute(k.rwr)
+	if err != nil {
+		return nil, fmt.Errorf("tpmkey: HmacStart failed: %v", err)
+	}
+
+	authHandle := tpm2.AuthHandle{
+		Handle: rspHS.SequenceHandle,
+		Auth:   tpm2.PasswordAuth(nil),
+	}
+	for len(data) > maxInputBuffer {
+		_, err = tpm2.SequenceUpdate{
+			SequenceHandle: authHandle,
+			Buffer: tpm2.TPM2BMaxBuffer{
+				Buffer: data[:maxInputBuffer],
+			},
+		}.Execute(k.rwr)
+		if err != nil {
+			flush(k.rwr, rspHS.SequenceHandle)
+			return nil, fmt.Errorf("tpmkey: SequenceUpdate failed: %v", err)
+		}
+		data = data[maxInputBuffer:]
+	}
+
+	rspSC, err := tpm2.SequenceComplete{
+		SequenceHandle: authHandle,
+		Buffer: tpm2.TPM2BMaxBuffer{
+			Buffer: data,
+		},
+		Hierarchy: tpm2.TPMRHNull,
+	}.Execute(k.rwr)
+	if err != nil {
+		flush(k.rwr, rspHS.SequenceHandle)
+		return nil, fmt.Errorf("tpmkey: SequenceComplete failed: %v", err)
+	}
+	return rspSC.Result.Buffer, nil
+}
+
+// HMACExternal computes an HMAC on the TPM with a key supplied by the caller.
+// The key is loaded with LoadExternal into the null hierarchy for the one
+// operation and flushed afterwards.  It lets chains of derived keys (as in
+// AWS SigV4) stay on the TPM without persisting the intermediate keys.
+func HMACExternal(rwr transport.TPM, key []byte, hash tpm2.TPMAlgID, data []byte) ([]byte, error) {
+	seed := make([]byte, 32)
+	if _, err := rand.Read(seed); err != nil {
+		return nil, err
+	}
+	h, err := tpm2.TPMAlgSHA256.Hash()
+	if err != nil {
+		return nil, err
+	}
+	unique := h.New()
+	unique.Write(seed)
+	unique.Write(key)
+
+	public := tpm2.TPMTPublic{
+		Type:    tpm2.TPMAlgKeyedHash,
+		NameAlg: tpm2.TPMAlgSHA256,
+		ObjectAttributes: tpm2.TPMAObject{
+			UserWithAuth: true,
+			SignEncrypt:  true,
+		},
+		Parameters: tpm2.NewTPMUPublicParms(tpm2.TPMAlgKeyedHash,
+			&tpm2.TPMSKeyedHashParms{
+				Scheme: tpm2.TPMTKeyedHashScheme{
+					Scheme: tpm2.TPMAlgHMAC,
+					Details: tpm2.NewTPMUSchemeKeyedHash(tpm2.TPMAlgHMAC,
+						&tpm2.TPMSSchemeHMAC{
+							HashAlg: hash,
+						}),
+				},
+			}),
+		Unique: tpm2.NewTPMUPublicID(tpm2.TPMAlgKeyedHash,
+			&tpm2.TPM2BDigest{
+				Buffer: unique.Sum(nil),
+			}),
+	}
+
+	rsp, err := tpm2.LoadExternal{
+		InPublic: tpm2.New2B(public),
+		InPrivate: tpm2.New2B(tpm2.TPMTSensitive{
+			SensitiveType: tpm2.TPMAlgKeyedHash,
+			SeedValue: tpm2.TPM2BDigest{
+				Buffer: seed,
+			},
+			Sensitive: tpm2.NewTPMUSensitiveComposite(tpm2.TPMAlgKeyedHash,
+				&tpm2.TPM2BSensitiveData{
+					Buffer: key,
+				}),
+		}),
+		Hierarchy: tpm2.TPMRHNull,
+	}.Execute(rwr)
+	if err != nil {
+		return nil, fmt.Errorf("tpmkey: can't load external hmac key: %v", err)
+	}
+
+	k := &Key{
+		rwr:       rwr,
+		Handle:    rsp.ObjectHandle,
+		Name:      rsp.Name,
+		Public:    public,
+		transient: true,
+	}
+	defer k.Close()
+	return k.HMAC(data)
+}
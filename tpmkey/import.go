@@ -0,0 +1,141 @@
+package tpmkey
+
+import (
+	"crypto/rsa"
+	"fmt"
+
+	keyfile "github.com/foxboron/go-tpm-keyfiles"
+	"github.com/google/go-tpm/tpm2"
+	"github.com/google/go-tpm/tpm2/transport"
+)
+
+// ImportRSA imports an external RSA private key (eg a service account key)
+// under the owner SRK and returns it as a loadable keyfile.  The key is
+// created without a fixed scheme so both PKCS#1 v1.5 and PSS signatures
+// can be made.  As with tpm_import_external_rsa, the import is not wrapped
+// and should only be done on a trusted host.
+func ImportRSA(rwr transport.TPM, pk *rsa.PrivateKey, auth []byte) (*keyfile.TPMKey, error) {
+	exp := uint32(pk.PublicKey.E)
+	if exp == 65537 {
+		// 0 is the TPM's encoding for the default exponent
+		exp = 0
+	}
+	public := tpm2.TPMTPublic{
+		Type:    tpm2.TPMAlgRSA,
+		NameAlg: tpm2.TPMAlgSHA256,
+		ObjectAttributes: tpm2.TPMAObject{
+			UserWithAuth: true,
+			SignEncrypt:  true,
+			Decrypt:      true,
+		},
+		Parameters: tpm2.NewTPMUPublicParms(
+			tpm2.TPMAlgRSA,
+			&tpm2.TPMSRSAParms{
+				Exponent: exp,
+				KeyBits:  tpm2.TPMKeyBits(pk.N.BitLen()),
+			},
+		),
+		Unique: tpm2.NewTPMUPublicID(
+			tpm2.TPMAlgRSA,
+			&tpm2.TPM2BPublicKeyRSA{
+				Buffer: pk.N.Bytes(),
+			},
+		),
+	}
+
+	sensitive := tpm2.TPMTSensitive{
+		SensitiveType: tpm2.TPMAlgRSA,
+		AuthValue: tpm2.TPM2BAuth{
+			Buffer: auth,
+		},
+		Sensitive: tpm2.NewTPMUSensitiveComposite(
+			tpm2.TPMAlgRSA,
+			&tpm2.TPM2BPrivateKeyRSA{Buffer: pk.Primes[0].Bytes()},
+		),
+	}
+
+	return importSensitive(rwr, public, sensitive, auth)
+}
+
+// importSensitive imports an unwrapped object under the owner SRK.
+func importSensitive(rwr transport.TPM, public tpm2.TPMTPublic, sensitive tpm2.TPMTSensitive, auth []byte) (*keyfile.TPMKey, error) {
+	parent, closer, err := Parent(rwr, tpm2.TPMRHOwner)
+	if err != nil {
+		return nil, err
+	}
+	defer closer()
+
+	sens2B := tpm2.Marshal(tpm2.New2B(sensitive))
+
+	rsp, err := tpm2.Import{
+		ParentHandle: tpm2.AuthHandle{
+			Handle: parent.Handle,
+			Name:   parent.Name,
+			Auth:   tpm2.PasswordAuth(nil),
+		},
+		ObjectPublic: tpm2.New2B(public),
+		Duplicate:    tpm2.TPM2BPrivate{Buffer: sens2B},
+	}.Execute(rwr)
+	if err != nil {
+		return nil, fmt.Errorf("tpmkey: can't import key: %v", err)
+	}
+
+	return keyfile.NewTPMKey(keyfile.OIDLoadableKey, tpm2.New2B(public), rsp.OutPrivate,
+		keyfile.WithParent(tpm2.TPMRHOwner),
+		keyfile.WithUserAuth(auth),
+	), nil
+}
+
+// Import imports an importable keyfile, a duplicate wrapped to its parent
+// in software (tpmwrap) or by another TPM, and returns the loadable keyfile,
+// or a sealed data keyfile for a sealed object.  encryptionKey is the
+// AES-128-CFB inner wrapper key, nil if the duplicate has none.
+func Import(rwr transport.TPM, k *keyfile.TPMKey, encryptionKey []byte) (*keyfile.TPMKey, error) {
+	if !k.Keytype.Equal(keyfile.OIDImportableKey) {
+		return nil, fmt.Errorf("tpmkey: keyfile type %v isn't importable", k.Keytype)
+	}
+	pub, err := k.Pubkey.Contents()
+	if err != nil {
+		return nil, fmt.Errorf("tpmkey: can't read key public: %v", err)
+	}
+
+	var rsp *tpm2.ImportResponse
+	_, _, closer, err := withParent(rwr, k, "import", func(parent tpm2.AuthHandle) error {
+		var err error
+		rsp, err = importCommand(parent, k, encryptionKey).Execute(rwr)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	closer()
+
+	loadable := *k
+	loadable.Keytype = keyfile.OIDLoadableKey
+	if pub.Type == tpm2.TPMAlgKeyedHash && !pub.ObjectAttributes.SignEncrypt && !pub.ObjectAttributes.Decrypt {
+		loadable.Keytype = keyfile.OIDSealedKey
+	}
+	loadable.Privkey = rsp.OutPrivate
+	loadable.Secret = tpm2.TPM2BEncryptedSecret{}
+	return &loadable, nil
+}
+
+// importCommand is TPM2_Import of k's duplicate under parent.
+func importCommand(parent tpm2.AuthHandle, k *keyfile.TPMKey, encryptionKey []byte) tpm2.Import {
+	cmd := tpm2.Import{
+		ParentHandle: parent,
+		ObjectPublic: k.Pubkey,
+		Duplicate:    k.Privkey,
+		InSymSeed:    k.Secret,
+		Symmetric:    tpm2.TPMTSymDef{Algorithm: tpm2.TPMAlgNull},
+	}
+	if encryptionKey != nil {
+		cmd.EncryptionKey = tpm2.TPM2BData{Buffer: encryptionKey}
+		cmd.Symmetric = tpm2.TPMTSymDef{
+			Algorithm: tpm2.TPMAlgAES,
+			KeyBits:   tpm2.NewTPMUSymKeyBits(tpm2.TPMAlgAES, tpm2.TPMKeyBits(128)),
+			Mode:      tpm2.NewTPMUSymMode(tpm2.TPMAlgAES, tpm2.TPMAlgCFB),
+		}
+	}
+	return cmd
+}
@@ -0,0 +1,241 @@
+package tpmkey
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+
+	keyfile "github.com/foxboron/go-tpm-keyfiles"
+	"github.com/google/go-tpm/tpm2"
+	"github.com/google/go-tpm/tpm2/transport"
+	"github.com/ibiscum/tpm2/tpmtemplate"
+)
+
+// Parent returns a handle to the storage parent named by a keyfile.
+//
+// Following draft-bottomley-tpm2-keys, a permanent hierarchy handle (e.g.
+// 0x40000001) means "the H-2 ECC primary under that hierarchy" which is
+// recreated here, while a persistent handle (0x81xxxxxx) is used as is.  The
+// TCG EK handles (tpmtemplate.EKHandles) are recreated from the EK
+// templates when nothing is persisted there.  The returned func flushes any
+// transient primary and must always be called.  FindParent also tries the
+// other primaries a keyfile's handle may stand for.
+func Parent(rwr transport.TPM, handle tpm2.TPMHandle) (*tpm2.NamedHandle, func(), error) {
+	if keyfile.IsMSO(handle, keyfile.TPM_HT_PERSISTENT) {
+		rsp, err := tpm2.ReadPublic{
+			ObjectHandle: handle,
+		}.Execute(rwr)
+		if err != nil {
+			var errs []error
+			for _, name := range tpmtemplate.EKHandles[handle] {
+				h, closer, perr := Primary(rwr, tpm2.TPMRHEndorsement, tpmtemplate.Templates[name])
+				if perr == nil {
+					return h, closer, nil
+				}
+				errs = append(errs, perr)
+			}
+			if len(errs) > 0 {
+				return nil, nil, errs[0]
+			}
+			return nil, nil, fmt.Errorf("tpmkey: can't read parent 0x%x: %v", handle, err)
+		}
+		return &tpm2.NamedHandle{
+			Handle: handle,
+			Name:   rsp.Name,
+		}, func() {}, nil
+	}
+
+	return Primary(rwr, parentHierarchy(handle), keyfile.ECCSRK_H2_Template)
+}
+
+// parentHierarchy returns the hierarchy of the primary a keyfile's parent
+// handle stands for.
+func parentHierarchy(handle tpm2.TPMHandle) tpm2.TPMHandle {
+	switch {
+	case keyfile.IsMSO(handle, keyfile.TPM_HT_PERMANENT):
+		return handle
+	case keyfile.IsMSO(handle, keyfile.TPM_HT_PERSISTENT):
+		// only EKs are recreated
+		return tpm2.TPMRHEndorsement
+	}
+	// keys with a transient parent were created under the owner SRK
+	return tpm2.TPMRHOwner
+}
+
+// Persistent handles of the RSA 2048 and ECC P-256 EKs, TCG EK Credential
+// Profile section 2.2.1.5; tpmtemplate has the high range ones.
+const (
+	EKRSAHandle = tpmtemplate.EKRSA2048Handle
+	EKECCHandle = tpmtemplate.EKECC256Handle
+)
+
+// FindParent returns the parent k loads, or for an importable key imports,
+// under, and the name of its template in tpmtemplate.Templates, "" for a
+// persistent parent.  The returned func flushes a transient primary and
+// must always be called.
+//
+// A keyfile names its parent by a handle only.  A persistent key is taken
+// as is; for a hierarchy, or an EK handle with nothing persisted, the
+// primaries tpmtemplate.ForParent lists are created one after the other
+// until the key's private, wrapped to its parent's seed, is accepted.
+// Load and Import find the parent in the same way.
+func FindParent(rwr transport.TPM, k *keyfile.TPMKey) (*tpm2.NamedHandle, string, func(), error) {
+	what := "load"
+	if k.Keytype.Equal(keyfile.OIDImportableKey) {
+		what = "import"
+	}
+	return withParent(rwr, k, what, func(parent tpm2.AuthHandle) error {
+		if what == "import" {
+			_, err := importCommand(parent, k, nil).Execute(rwr)
+			return err
+		}
+		rsp, err := tpm2.Load{
+			ParentHandle: parent,
+			InPublic:     k.Pubkey,
+			InPrivate:    k.Privkey,
+		}.Execute(rwr)
+		if err != nil {
+			return err
+		}
+		flush(rwr, rsp.ObjectHandle)
+		return nil
+	})
+}
+
+// withParent calls try with the parent k names, authorized, or with each of
+// the primaries it may stand for until try succeeds or fails for another
+// reason than the key being made for another parent.  It returns the parent
+// try succeeded with, the name of its template and the func flushing it.
+// try returns the TPM's error as is; what is the operation, for messages.
+func withParent(rwr transport.TPM, k *keyfile.TPMKey, what string, try func(parent tpm2.AuthHandle) error) (*tpm2.NamedHandle, string, func(), error) {
+	if keyfile.IsMSO(k.Parent, keyfile.TPM_HT_PERSISTENT) {
+		rsp, err := tpm2.ReadPublic{
+			ObjectHandle: k.Parent,
+		}.Execute(rwr)
+		if err == nil {
+			parent := &tpm2.NamedHandle{
+				Handle: k.Parent,
+				Name:   rsp.Name,
+			}
+			if _, err := tryParent(rwr, parent, try); err != nil {
+				return nil, "", nil, fmt.Errorf("tpmkey: can't %s key: %v", what, err)
+			}
+			return parent, "", func() {}, nil
+		}
+		if _, ok := tpmtemplate.EKHandles[k.Parent]; !ok {
+			return nil, "", nil, fmt.Errorf("tpmkey: can't read parent 0x%x: %v", k.Parent, err)
+		}
+	}
+
+	alg := tpm2.TPMAlgNull
+	if pub, err := k.Pubkey.Contents(); err == nil {
+		alg = pub.Type
+	}
+	names := tpmtemplate.ForParent(k.Parent, k.Keytype, alg)
+	var first error
+	for _, name := range names {
+		parent, closer, err := Primary(rwr, parentHierarchy(k.Parent), tpmtemplate.Templates[name])
+		if err == nil {
+			var retry bool
+			if retry, err = tryParent(rwr, parent, try); err == nil {
+				return parent, name, closer, nil
+			}
+			closer()
+			if !retry {
+				return nil, "", nil, fmt.Errorf("tpmkey: can't %s key: %v", what, err)
+			}
+		}
+		if first == nil {
+			first = fmt.Errorf("%s: %v", name, err)
+		}
+	}
+	return nil, "", nil, fmt.Errorf("tpmkey: can't %s key under any primary 0x%x stands for (%s): %v", what, k.Parent, strings.Join(names, ", "), first)
+}
+
+// tryParent calls try with parent and the session authorizing it.  retry
+// reports that try failed because the key was made for another parent.
+func tryParent(rwr transport.TPM, parent *tpm2.NamedHandle, try func(parent tpm2.AuthHandle) error) (retry bool, err error) {
+	auth, err := ParentAuth(rwr, parent)
+	if err != nil {
+		return false, err
+	}
+	err = try(tpm2.AuthHandle{
+		Handle: parent.Handle,
+		Name:   parent.Name,
+		Auth:   auth,
+	})
+	return err != nil && wrongParent(err), err
+}
+
+// wrongParent reports whether err, from TPM2_Load or TPM2_Import, means the
+// key was wrapped for another parent: its integrity HMAC doesn't verify with
+// the parent's seed, or the import seed doesn't decrypt with its key.
+func wrongParent(err error) bool {
+	for _, rc := range []tpm2.TPMRC{tpm2.TPMRCIntegrity, tpm2.TPMRCValue, tpm2.TPMRCSize, tpm2.TPMRCECCPoint, tpm2.TPMRCKey, tpm2.TPMRCNoResult} {
+		if errors.Is(err, rc) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParentAuth returns the session authorizing parent for TPM2_Load and
+// TPM2_Import: an empty password for ordinary storage keys, or
+// PolicySecret(endorsement) for parents such as the EK that only allow the
+// TCG default EK policy.
+func ParentAuth(rwr transport.TPM, parent *tpm2.NamedHandle) (tpm2.Session, error) {
+	rsp, err := tpm2.ReadPublic{
+		ObjectHandle: parent.Handle,
+	}.Execute(rwr)
+	if err != nil {
+		return nil, fmt.Errorf("tpmkey: can't read parent 0x%x: %v", parent.Handle, err)
+	}
+	pub, err := rsp.OutPublic.Contents()
+	if err != nil {
+		return nil, fmt.Errorf("tpmkey: can't read parent public: %v", err)
+	}
+	if pub.ObjectAttributes.UserWithAuth {
+		return tpm2.PasswordAuth(nil), nil
+	}
+
+	calc, err := tpm2.NewPolicyCalculator(pub.NameAlg)
+	if err != nil {
+		return nil, err
+	}
+	if err := (tpm2.PolicySecret{AuthHandle: tpm2.TPMRHEndorsement}).Update(calc); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(calc.Hash().Digest, pub.AuthPolicy.Buffer) {
+		return nil, fmt.Errorf("tpmkey: parent 0x%x needs a policy other than PolicySecret(endorsement)", parent.Handle)
+	}
+	return tpm2.Policy(pub.NameAlg, 16, func(rwr transport.TPM, handle tpm2.TPMISHPolicy, nonceTPM tpm2.TPM2BNonce) error {
+		_, err := tpm2.PolicySecret{
+			AuthHandle: tpm2.AuthHandle{
+				Handle: tpm2.TPMRHEndorsement,
+				Auth:   tpm2.PasswordAuth(nil),
+			},
+			PolicySession: handle,
+			NonceTPM:      nonceTPM,
+		}.Execute(rwr)
+		return err
+	}), nil
+}
+
+// Primary creates a primary key from template under hierarchy.  The returned
+// func flushes it and must always be called.
+func Primary(rwr transport.TPM, hierarchy tpm2.TPMHandle, template tpm2.TPMTPublic) (*tpm2.NamedHandle, func(), error) {
+	rsp, err := tpm2.CreatePrimary{
+		PrimaryHandle: hierarchy,
+		InPublic:      tpm2.New2B(template),
+	}.Execute(rwr)
+	if err != nil {
+		return nil, nil, fmt.Errorf("tpmkey: can't create primary: %v", err)
+	}
+	return &tpm2.NamedHandle{
+		Handle: rsp.ObjectHandle,
+		Name:   rsp.Name,
+	}, func() {
+		flush(rwr, rsp.ObjectHandle)
+	}, nil
+}
@@ -0,0 +1,360 @@
+package tpmkey
+
+import (
+	"crypto"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+
+	keyfile "github.com/foxboron/go-tpm-keyfiles"
+	"github.com/google/go-tpm/tpm2"
+	"github.com/google/go-tpm/tpm2/transport"
+)
+
+// Policy binds a new object to the current value of a set of PCRs, to a
+// hierarchy's auth, or hands its policy over to a signer.
+type Policy struct {
+	PCRs []uint
+	// Bank is the PCR bank to read, SHA256 if unset.
+	Bank tpm2.TPMAlgID
+	// Secret, if set, adds PolicySecret against that hierarchy (e.g.
+	// TPMRHOwner), so using the object takes the hierarchy's auth.
+	Secret tpm2.TPMHandle
+	// Signer, an *rsa.PublicKey or *ecdsa.PublicKey, makes the policy
+	// PolicyAuthorize(Signer) instead: the object can be used with any
+	// policy Signer signs later with AddSignedPolicy, and PCRs, Secret and
+	// the password only apply through those.
+	Signer crypto.PublicKey
+}
+
+func (p *Policy) selection() tpm2.TPMLPCRSelection {
+	bank := p.Bank
+	if bank == 0 {
+		bank = tpm2.TPMAlgSHA256
+	}
+	return tpm2.TPMLPCRSelection{
+		PCRSelections: []tpm2.TPMSPCRSelection{
+			{
+				Hash:      bank,
+				PCRSelect: tpm2.PCClientCompatible.PCRs(p.PCRs...),
+			},
+		},
+	}
+}
+
+// PolicySteps builds the keyfile policy for an object bound to pol and,
+// when withAuth is set, to its userAuth through PolicyAuthValue.  The
+// current PCR values are read from the TPM and recorded in the PolicyPCR
+// step.  It also returns the resulting policy digest for the object template.
+func PolicySteps(rwr transport.TPM, pol *Policy, withAuth bool, nameAlg tpm2.TPMAlgID) ([]*keyfile.TPMPolicy, []byte, error) {
+	var steps []*keyfile.TPMPolicy
+
+	if pol != nil && pol.Signer != nil {
+		signer, err := SignerPublic(pol.Signer)
+		if err != nil {
+			return nil, nil, err
+		}
+		// a random policyRef keeps policies signed for one key from
+		// authorizing another key of the same signer
+		ref := make([]byte, 32)
+		if _, err := rand.Read(ref); err != nil {
+			return nil, nil, err
+		}
+		steps = append(steps, AuthorizeStep(signer, ref))
+		digest, err := PolicyDigest(steps, nameAlg)
+		if err != nil {
+			return nil, nil, err
+		}
+		return steps, digest, nil
+	}
+
+	calc, err := tpm2.NewPolicyCalculator(nameAlg)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if pol != nil && len(pol.PCRs) > 0 {
+		sel := pol.selection()
+		pcrDigest, err := pcrDigest(rwr, sel, nameAlg)
+		if err != nil {
+			return nil, nil, err
+		}
+		cmd := tpm2.PolicyPCR{
+			PcrDigest: tpm2.TPM2BDigest{Buffer: pcrDigest},
+			Pcrs:      sel,
+		}
+		if err := cmd.Update(calc); err != nil {
+			return nil, nil, err
+		}
+		steps = append(steps, PolicyPCRStep(sel, pcrDigest))
+	}
+
+	if pol != nil && pol.Secret != 0 {
+		if err := (tpm2.PolicySecret{AuthHandle: pol.Secret}).Update(calc); err != nil {
+			return nil, nil, err
+		}
+		steps = append(steps, PolicySecretStep(pol.Secret))
+	}
+
+	if withAuth {
+		if err := (tpm2.PolicyAuthValue{}).Update(calc); err != nil {
+			return nil, nil, err
+		}
+		steps = append(steps, &keyfile.TPMPolicy{
+			CommandCode: int(tpm2.TPMCCPolicyAuthValue),
+		})
+	}
+
+	return steps, calc.Hash().Digest, nil
+}
+
+// pcrDigest reads the selected PCRs and hashes their concatenated values the
+// way TPM2_PolicyPCR does.
+func pcrDigest(rwr transport.TPM, sel tpm2.TPMLPCRSelection, nameAlg tpm2.TPMAlgID) ([]byte, error) {
+	h, err := nameAlg.Hash()
+	if err != nil {
+		return nil, err
+	}
+	d := h.New()
+
+	// PCR_Read hands back at most 8 digests, so walk the selection one PCR
+	// at a time to keep the ordering obvious.
+	for _, s := range sel.PCRSelections {
+		for i, b := range s.PCRSelect {
+			for bit := 0; bit < 8; bit++ {
+				if b&(1<<bit) == 0 {
+					continue
+				}
+				rsp, err := tpm2.PCRRead{
+					PCRSelectionIn: tpm2.TPMLPCRSelection{
+						PCRSelections: []tpm2.TPMSPCRSelection{
+							{
+								Hash:      s.Hash,
+								PCRSelect: tpm2.PCClientCompatible.PCRs(uint(i*8 + bit)),
+							},
+						},
+					},
+				}.Execute(rwr)
+				if err != nil {
+					return nil, fmt.Errorf("tpmkey: can't read PCR %d: %v", i*8+bit, err)
+				}
+				if len(rsp.PCRValues.Digests) != 1 {
+					return nil, fmt.Errorf("tpmkey: PCR %d not available in bank %v", i*8+bit, s.Hash)
+				}
+				d.Write(rsp.PCRValues.Digests[0].Buffer)
+			}
+		}
+	}
+	return d.Sum(nil), nil
+}
+
+// replayPolicy executes the recorded policy commands against a policy
+// session.  A leading PolicyAuthorize is satisfied with one of the signed
+// policies in authPolicy.  nameAlg is the object's name algorithm.
+func replayPolicy(rwr transport.TPM, handle tpm2.TPMISHPolicy, steps []*keyfile.TPMPolicy, authPolicy []*keyfile.TPMAuthPolicy, nameAlg tpm2.TPMAlgID) error {
+	for i, step := range steps {
+		switch tpm2.TPMCC(step.CommandCode) {
+		case tpm2.TPMCCPolicyPCR:
+			digest, sel, err := splitPolicyPCR(step.CommandPolicy)
+			if err != nil {
+				return err
+			}
+			_, err = tpm2.PolicyPCR{
+				PolicySession: handle,
+				PcrDigest:     *digest,
+				Pcrs:          *sel,
+			}.Execute(rwr)
+			if err != nil {
+				return fmt.Errorf("tpmkey: PolicyPCR failed: %v", err)
+			}
+		case tpm2.TPMCCPolicySecret:
+			h, name, ref, err := splitPolicySecret(step.CommandPolicy)
+			if err != nil {
+				return err
+			}
+			// the authorizing entity is used with an empty password, which
+			// hierarchies have unless the owner set one
+			_, err = tpm2.PolicySecret{
+				AuthHandle: tpm2.AuthHandle{
+					Handle: h,
+					Name:   *name,
+					Auth:   tpm2.PasswordAuth(nil),
+				},
+				PolicySession: handle,
+				PolicyRef:     tpm2.TPM2BNonce{Buffer: ref},
+			}.Execute(rwr)
+			if err != nil {
+				return fmt.Errorf("tpmkey: PolicySecret failed: %v", err)
+			}
+		case tpm2.TPMCCPolicyAuthValue:
+			_, err := tpm2.PolicyAuthValue{
+				PolicySession: handle,
+			}.Execute(rwr)
+			if err != nil {
+				return fmt.Errorf("tpmkey: PolicyAuthValue failed: %v", err)
+			}
+		case tpm2.TPMCCPolicyAuthorize:
+			// PolicyAuthorize replaces the digest so far, anything before it
+			// would be lost
+			if i != 0 {
+				return fmt.Errorf("tpmkey: PolicyAuthorize has to be the first policy step")
+			}
+			if err := authorize(rwr, handle, step, authPolicy, nameAlg); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("tpmkey: unsupported policy command 0x%x", step.CommandCode)
+		}
+	}
+	return nil
+}
+
+// PolicyDigest computes the policy digest of steps for an object with
+// nameAlg, without a TPM.  PolicyPCR steps need their PCR digest.
+func PolicyDigest(steps []*keyfile.TPMPolicy, nameAlg tpm2.TPMAlgID) ([]byte, error) {
+	calc, err := tpm2.NewPolicyCalculator(nameAlg)
+	if err != nil {
+		return nil, err
+	}
+	for _, step := range steps {
+		switch tpm2.TPMCC(step.CommandCode) {
+		case tpm2.TPMCCPolicyPCR:
+			digest, sel, err := splitPolicyPCR(step.CommandPolicy)
+			if err != nil {
+				return nil, err
+			}
+			if len(digest.Buffer) == 0 {
+				return nil, fmt.Errorf("tpmkey: PolicyPCR step without PCR digest")
+			}
+			err = tpm2.PolicyPCR{PcrDigest: *digest, Pcrs: *sel}.Update(calc)
+			if err != nil {
+				return nil, err
+			}
+		case tpm2.TPMCCPolicySecret:
+			h, name, ref, err := splitPolicySecret(step.CommandPolicy)
+			if err != nil {
+				return nil, err
+			}
+			err = tpm2.PolicySecret{
+				AuthHandle: tpm2.NamedHandle{Handle: h, Name: *name},
+				PolicyRef:  tpm2.TPM2BNonce{Buffer: ref},
+			}.Update(calc)
+			if err != nil {
+				return nil, err
+			}
+		case tpm2.TPMCCPolicyAuthValue:
+			if err := (tpm2.PolicyAuthValue{}).Update(calc); err != nil {
+				return nil, err
+			}
+		case tpm2.TPMCCPolicyAuthorize:
+			signer, ref, _, err := splitPolicyAuthorize(step.CommandPolicy)
+			if err != nil {
+				return nil, err
+			}
+			name, err := tpm2.ObjectName(signer)
+			if err != nil {
+				return nil, err
+			}
+			err = tpm2.PolicyAuthorize{KeySign: *name, PolicyRef: tpm2.TPM2BDigest{Buffer: ref}}.Update(calc)
+			if err != nil {
+				return nil, err
+			}
+		default:
+			return nil, fmt.Errorf("tpmkey: unsupported policy command 0x%x", step.CommandCode)
+		}
+	}
+	return calc.Hash().Digest, nil
+}
+
+// PolicySecretStep returns a PolicySecret step for the hierarchy h (e.g.
+// TPMRHOwner or TPMRHEndorsement), recorded as draft-bottomley-tpm2-keys
+// does: TPM_HANDLE || TPM2B_NAME || TPM2B_NONCE policyRef (empty).
+func PolicySecretStep(h tpm2.TPMHandle) *keyfile.TPMPolicy {
+	b := binary.BigEndian.AppendUint32(nil, uint32(h))
+	b = append(b, tpm2.Marshal(*h.KnownName())...)
+	return &keyfile.TPMPolicy{
+		CommandCode:   int(tpm2.TPMCCPolicySecret),
+		CommandPolicy: append(b, tpm2.Marshal(tpm2.TPM2BNonce{})...),
+	}
+}
+
+// splitPolicySecret decodes a PolicySecret step.  Older versions of this
+// package recorded only the TPM2B_NAME of a hierarchy, which is its handle.
+func splitPolicySecret(b []byte) (tpm2.TPMHandle, *tpm2.TPM2BName, []byte, error) {
+	if len(b) == 6 && binary.BigEndian.Uint16(b) == 4 {
+		h := tpm2.TPMHandle(binary.BigEndian.Uint32(b[2:]))
+		return h, h.KnownName(), nil, nil
+	}
+	if len(b) < 6 {
+		return 0, nil, nil, fmt.Errorf("tpmkey: short PolicySecret entry")
+	}
+	h := tpm2.TPMHandle(binary.BigEndian.Uint32(b))
+	name, rest, err := Split2B(b[4:])
+	if err != nil {
+		return 0, nil, nil, fmt.Errorf("tpmkey: bad PolicySecret name: %v", err)
+	}
+	var ref []byte
+	if len(rest) > 0 {
+		if ref, _, err = Split2B(rest); err != nil {
+			return 0, nil, nil, fmt.Errorf("tpmkey: bad PolicySecret policyRef: %v", err)
+		}
+	}
+	return h, &tpm2.TPM2BName{Buffer: name}, ref, nil
+}
+
+// Split2B splits a TPM2B off the front of b and returns its contents and
+// the rest.
+func Split2B(b []byte) ([]byte, []byte, error) {
+	if len(b) < 2 || len(b) < 2+int(binary.BigEndian.Uint16(b)) {
+		return nil, nil, fmt.Errorf("short buffer")
+	}
+	n := 2 + int(binary.BigEndian.Uint16(b))
+	return b[2:n], b[n:], nil
+}
+
+// splitPolicyPCR decodes a PolicyPCR step: TPML_PCR_SELECTION || PCR digest,
+// the bytes TPM2_PolicyPCR hashes into the policy, as draft-bottomley-tpm2-keys
+// records it.  Older versions of this package wrote TPM2B_DIGEST ||
+// TPML_PCR_SELECTION, which is still read.
+func splitPolicyPCR(b []byte) (*tpm2.TPM2BDigest, *tpm2.TPMLPCRSelection, error) {
+	if n, ok := pcrSelectionSize(b); ok {
+		switch len(b) - n {
+		case 0, 20, 32, 48, 64:
+			sel, err := tpm2.Unmarshal[tpm2.TPMLPCRSelection](b[:n])
+			if err != nil {
+				return nil, nil, fmt.Errorf("tpmkey: bad PolicyPCR selection: %v", err)
+			}
+			return &tpm2.TPM2BDigest{Buffer: b[n:]}, sel, nil
+		}
+	}
+
+	digest, rest, err := Split2B(b)
+	if err != nil {
+		return nil, nil, fmt.Errorf("tpmkey: short PolicyPCR entry")
+	}
+	sel, err := tpm2.Unmarshal[tpm2.TPMLPCRSelection](rest)
+	if err != nil {
+		return nil, nil, fmt.Errorf("tpmkey: bad PolicyPCR selection: %v", err)
+	}
+	return &tpm2.TPM2BDigest{Buffer: digest}, sel, nil
+}
+
+// pcrSelectionSize returns the size of the TPML_PCR_SELECTION at the start
+// of b, if there plausibly is one.
+func pcrSelectionSize(b []byte) (int, bool) {
+	if len(b) < 4 {
+		return 0, false
+	}
+	count := binary.BigEndian.Uint32(b)
+	if count == 0 || count > 8 {
+		return 0, false
+	}
+	n := 4
+	for range count {
+		if len(b) < n+3 {
+			return 0, false
+		}
+		n += 3 + int(b[n+2])
+	}
+	return n, n <= len(b)
+}
@@ -0,0 +1,75 @@
+package tpmkey
+
+import (
+	"fmt"
+
+	keyfile "github.com/foxboron/go-tpm-keyfiles"
+	"github.com/google/go-tpm/tpm2"
+	"github.com/google/go-tpm/tpm2/transport"
+)
+
+// SealTemplate returns the template of a sealed data object (a keyed-hash
+// object without sign or decrypt), as tpm2_create -i makes.
+func SealTemplate(nameAlg tpm2.TPMAlgID) tpm2.TPMTPublic {
+	return tpm2.TPMTPublic{
+		Type:    tpm2.TPMAlgKeyedHash,
+		NameAlg: nameAlg,
+		ObjectAttributes: tpm2.TPMAObject{
+			FixedTPM:     true,
+			FixedParent:  true,
+			UserWithAuth: true,
+			NoDA:         true,
+		},
+		Parameters: tpm2.NewTPMUPublicParms(tpm2.TPMAlgKeyedHash,
+			&tpm2.TPMSKeyedHashParms{
+				Scheme: tpm2.TPMTKeyedHashScheme{
+					Scheme: tpm2.TPMAlgNull,
+				},
+			}),
+	}
+}
+
+// Seal seals data under the owner SRK and returns it as a sealed data
+// keyfile.  auth and pol work as for CreateHMAC.
+func Seal(rwr transport.TPM, data []byte, auth []byte, pol *Policy) (*keyfile.TPMKey, error) {
+	template := SealTemplate(tpm2.TPMAlgSHA256)
+	if len(auth) > 0 {
+		// a password protected secret should count towards the lockout
+		template.ObjectAttributes.NoDA = false
+	}
+	steps, err := applyPolicy(rwr, &template, auth, pol)
+	if err != nil {
+		return nil, err
+	}
+	k, err := create(rwr, template, data, auth, steps)
+	if err != nil {
+		return nil, err
+	}
+	k.Keytype = keyfile.OIDSealedKey
+	return k, nil
+}
+
+// Unseal returns the data of a sealed data object.
+func (k *Key) Unseal() ([]byte, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	rsp, err := tpm2.Unseal{
+		ItemHandle: k.AuthHandle(),
+	}.Execute(k.rwr)
+	if err != nil {
+		return nil, fmt.Errorf("tpmkey: unseal failed: %v", err)
+	}
+	return rsp.OutData.Buffer, nil
+}
+
+// PolicyPCRStep returns a PolicyPCR step for sel.  With an empty digest the
+// TPM checks against the PCR values at the time of use, which only makes
+// sense for objects whose authPolicy was computed elsewhere (eg by
+// tpm2_createpolicy for clevis).
+func PolicyPCRStep(sel tpm2.TPMLPCRSelection, digest []byte) *keyfile.TPMPolicy {
+	return &keyfile.TPMPolicy{
+		CommandCode:   int(tpm2.TPMCCPolicyPCR),
+		CommandPolicy: append(tpm2.Marshal(sel), digest...),
+	}
+}
@@ -0,0 +1,214 @@
+package tpmkey
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+
+	"github.com/google/go-tpm/tpm2"
+	"golang.org/x/crypto/cryptobyte"
+	"golang.org/x/crypto/cryptobyte/asn1"
+)
+
+// HashAlg maps a crypto.Hash to its TPM algorithm identifier.
+func HashAlg(h crypto.Hash) (tpm2.TPMAlgID, error) {
+	switch h {
+	case crypto.SHA1:
+		return tpm2.TPMAlgSHA1, nil
+	case crypto.SHA256:
+		return tpm2.TPMAlgSHA256, nil
+	case crypto.SHA384:
+		return tpm2.TPMAlgSHA384, nil
+	case crypto.SHA512:
+		return tpm2.TPMAlgSHA512, nil
+	}
+	return tpm2.TPMAlgNull, fmt.Errorf("tpmkey: unsupported hash %v", h)
+}
+
+// PublicKey returns the *rsa.PublicKey or *ecdsa.PublicKey of the key.
+func (k *Key) PublicKey() (crypto.PublicKey, error) {
+	return tpm2.Pub(k.Public)
+}
+
+// Scheme returns the signing scheme the key is restricted to and its hash,
+// or TPMAlgNull if the caller may pick one.
+func (k *Key) Scheme() (tpm2.TPMAlgID, tpm2.TPMAlgID) {
+	return PublicScheme(&k.Public)
+}
+
+// PublicScheme is Scheme for a public area that need not be loaded.
+func PublicScheme(public *tpm2.TPMTPublic) (tpm2.TPMAlgID, tpm2.TPMAlgID) {
+	var scheme tpm2.TPMAlgID
+	var details tpm2.TPMUAsymScheme
+	switch public.Type {
+	case tpm2.TPMAlgRSA:
+		d, err := public.Parameters.RSADetail()
+		if err != nil {
+			return tpm2.TPMAlgNull, tpm2.TPMAlgNull
+		}
+		scheme, details = d.Scheme.Scheme, d.Scheme.Details
+	case tpm2.TPMAlgECC:
+		d, err := public.Parameters.ECCDetail()
+		if err != nil {
+			return tpm2.TPMAlgNull, tpm2.TPMAlgNull
+		}
+		scheme, details = d.Scheme.Scheme, d.Scheme.Details
+	default:
+		return tpm2.TPMAlgNull, tpm2.TPMAlgNull
+	}
+	if scheme == tpm2.TPMAlgNull {
+		return tpm2.TPMAlgNull, tpm2.TPMAlgNull
+	}
+	return scheme, schemeHash(scheme, details)
+}
+
+func schemeHash(scheme tpm2.TPMAlgID, details tpm2.TPMUAsymScheme) tpm2.TPMAlgID {
+	switch scheme {
+	case tpm2.TPMAlgRSASSA:
+		if h, err := details.RSASSA(); err == nil {
+			return h.HashAlg
+		}
+	case tpm2.TPMAlgRSAPSS:
+		if h, err := details.RSAPSS(); err == nil {
+			return h.HashAlg
+		}
+	case tpm2.TPMAlgECDSA:
+		if h, err := details.ECDSA(); err == nil {
+			return h.HashAlg
+		}
+	}
+	return tpm2.TPMAlgNull
+}
+
+// SignDigest signs a pre-computed digest with the given scheme (RSASSA,
+// RSAPSS or ECDSA) and returns the raw TPM signature.  Keys created with a
+// fixed scheme refuse any other.
+func (k *Key) SignDigest(digest []byte, scheme tpm2.TPMAlgID, hash tpm2.TPMAlgID) (*tpm2.TPMTSignature, error) {
+	if fixed, fixedHash := k.Scheme(); fixed != tpm2.TPMAlgNull && (fixed != scheme || fixedHash != hash) {
+		return nil, fmt.Errorf("tpmkey: key is restricted to %v/%v, can't sign with %v/%v", fixed, fixedHash, scheme, hash)
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	rsp, err := tpm2.Sign{
+		KeyHandle: k.AuthHandle(),
+		Digest: tpm2.TPM2BDigest{
+			Buffer: digest,
+		},
+		InScheme: tpm2.TPMTSigScheme{
+			Scheme: scheme,
+			Details: tpm2.NewTPMUSigScheme(
+				scheme,
+				&tpm2.TPMSSchemeHash{
+					HashAlg: hash,
+				},
+			),
+		},
+		Validation: tpm2.TPMTTKHashCheck{
+			Tag:       tpm2.TPMSTHashCheck,
+			Hierarchy: tpm2.TPMRHNull,
+		},
+	}.Execute(k.rwr)
+	if err != nil {
+		// wrapped, for AuthFailed
+		return nil, fmt.Errorf("tpmkey: sign failed: %w", err)
+	}
+	return &rsp.Signature, nil
+}
+
+// AuthFailed reports whether err, from signing with a Key, means the key's
+// auth value was wrong.  Each such failure counts towards the TPM's
+// dictionary attack lockout unless the key has noDA, so callers shouldn't
+// retry with the same value.
+func AuthFailed(err error) bool {
+	return errors.Is(err, tpm2.TPMRCAuthFail) || errors.Is(err, tpm2.TPMRCBadAuth)
+}
+
+// Signer returns a crypto.Signer backed by the key.  RSA keys sign PKCS#1
+// v1.5 unless *rsa.PSSOptions are passed; ECDSA signatures are ASN.1 encoded
+// as crypto.Signer expects.
+func (k *Key) Signer() (crypto.Signer, error) {
+	if !k.Public.ObjectAttributes.SignEncrypt {
+		return nil, fmt.Errorf("tpmkey: key can't sign")
+	}
+	pub, err := k.PublicKey()
+	if err != nil {
+		return nil, err
+	}
+	return &signer{
+		key: k,
+		pub: pub,
+	}, nil
+}
+
+type signer struct {
+	key *Key
+	pub crypto.PublicKey
+}
+
+func (s *signer) Public() crypto.PublicKey {
+	return s.pub
+}
+
+func (s *signer) Sign(_ io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	hash, err := HashAlg(opts.HashFunc())
+	if err != nil {
+		return nil, err
+	}
+
+	switch s.pub.(type) {
+	case *rsa.PublicKey:
+		scheme := tpm2.TPMAlgRSASSA
+		if pss, ok := opts.(*rsa.PSSOptions); ok {
+			// the TPM picks the salt length itself: the digest size on
+			// FIPS style TPMs, the maximum otherwise.  Callers that insist
+			// on one or the other have to verify accordingly.
+			if pss.SaltLength != rsa.PSSSaltLengthAuto && pss.SaltLength != rsa.PSSSaltLengthEqualsHash && pss.SaltLength != opts.HashFunc().Size() {
+				return nil, fmt.Errorf("tpmkey: unsupported PSS salt length %d", pss.SaltLength)
+			}
+			scheme = tpm2.TPMAlgRSAPSS
+		}
+		sig, err := s.key.SignDigest(digest, scheme, hash)
+		if err != nil {
+			return nil, err
+		}
+		if scheme == tpm2.TPMAlgRSAPSS {
+			r, err := sig.Signature.RSAPSS()
+			if err != nil {
+				return nil, err
+			}
+			return r.Sig.Buffer, nil
+		}
+		r, err := sig.Signature.RSASSA()
+		if err != nil {
+			return nil, err
+		}
+		return r.Sig.Buffer, nil
+	case *ecdsa.PublicKey:
+		sig, err := s.key.SignDigest(digest, tpm2.TPMAlgECDSA, hash)
+		if err != nil {
+			return nil, err
+		}
+		e, err := sig.Signature.ECDSA()
+		if err != nil {
+			return nil, err
+		}
+		return ECDSAASN1(e)
+	}
+	return nil, fmt.Errorf("tpmkey: unsupported key type %T", s.pub)
+}
+
+// ECDSAASN1 encodes a TPM ECDSA signature as an ASN.1 SEQUENCE of r and s.
+func ECDSAASN1(sig *tpm2.TPMSSignatureECC) ([]byte, error) {
+	var b cryptobyte.Builder
+	b.AddASN1(asn1.SEQUENCE, func(b *cryptobyte.Builder) {
+		b.AddASN1BigInt(new(big.Int).SetBytes(sig.SignatureR.Buffer))
+		b.AddASN1BigInt(new(big.Int).SetBytes(sig.SignatureS.Buffer))
+	})
+	return b.Bytes()
+}
@@ -0,0 +1,196 @@
+// Package tpmkey loads keys that live inside the TPM, either from a TSS2 PEM
+// keyfile or from a persistent handle, and exposes the handful of operations
+// the other recipes in this repository build on.
+package tpmkey
+
+import (
+	"fmt"
+	"sync"
+
+	keyfile "github.com/foxboron/go-tpm-keyfiles"
+	"github.com/google/go-tpm/tpm2"
+	"github.com/google/go-tpm/tpm2/transport"
+)
+
+// Key is a loaded TPM object.  Operations on one Key are serialized.
+type Key struct {
+	mu     sync.Mutex
+	rwr    transport.TPM
+	Handle tpm2.TPMHandle
+	Name   tpm2.TPM2BName
+	Public tpm2.TPMTPublic
+
+	auth       []byte
+	policy     []*keyfile.TPMPolicy
+	authPolicy []*keyfile.TPMAuthPolicy
+
+	// transient keys are flushed on Close, persistent ones are left alone
+	transient bool
+}
+
+// Load loads a TSS2 loadable, importable or sealed data keyfile under its
+// parent, found as FindParent does.  Importable keys are imported first,
+// each time, as the openssl tpm2 engine and provider do; use Import to keep
+// the loadable keyfile.  auth is the object's userAuth and may be nil.  Any policy
+// recorded in the keyfile is replayed each time the key is used, a
+// PolicyAuthorize with the signed policies of its authPolicy.
+func Load(rwr transport.TPM, k *keyfile.TPMKey, auth []byte) (*Key, error) {
+	if k.Keytype.Equal(keyfile.OIDImportableKey) {
+		loadable, err := Import(rwr, k, nil)
+		if err != nil {
+			return nil, err
+		}
+		k = loadable
+	}
+	if !k.Keytype.Equal(keyfile.OIDLoadableKey) && !k.Keytype.Equal(keyfile.OIDSealedKey) {
+		return nil, fmt.Errorf("tpmkey: unsupported keyfile type %v", k.Keytype)
+	}
+
+	var rsp *tpm2.LoadResponse
+	_, _, closer, err := withParent(rwr, k, "load", func(parent tpm2.AuthHandle) error {
+		var err error
+		rsp, err = tpm2.Load{
+			ParentHandle: parent,
+			InPublic:     k.Pubkey,
+			InPrivate:    k.Privkey,
+		}.Execute(rwr)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	closer()
+
+	key, err := loaded(rwr, rsp, k.Pubkey, auth, k.Policy)
+	if err != nil {
+		return nil, err
+	}
+	key.authPolicy = k.AuthPolicy
+	return key, nil
+}
+
+// LoadBlob loads a public/private pair under an already loaded parent, for
+// blobs that don't come in a keyfile (tpm2_create -u/-r output, clevis,
+// systemd tokens...).  policy is replayed on each use, as for Load.
+func LoadBlob(rwr transport.TPM, parent *tpm2.NamedHandle, public tpm2.TPM2BPublic, private tpm2.TPM2BPrivate, auth []byte, policy []*keyfile.TPMPolicy) (*Key, error) {
+	parentAuth, err := ParentAuth(rwr, parent)
+	if err != nil {
+		return nil, err
+	}
+	rsp, err := tpm2.Load{
+		ParentHandle: tpm2.AuthHandle{
+			Handle: parent.Handle,
+			Name:   parent.Name,
+			Auth:   parentAuth,
+		},
+		InPublic:  public,
+		InPrivate: private,
+	}.Execute(rwr)
+	if err != nil {
+		return nil, fmt.Errorf("tpmkey: can't load key: %v", err)
+	}
+
+	return loaded(rwr, rsp, public, auth, policy)
+}
+
+// loaded returns the Key for an object TPM2_Load loaded from public.
+func loaded(rwr transport.TPM, rsp *tpm2.LoadResponse, public tpm2.TPM2BPublic, auth []byte, policy []*keyfile.TPMPolicy) (*Key, error) {
+	pub, err := public.Contents()
+	if err != nil {
+		flush(rwr, rsp.ObjectHandle)
+		return nil, fmt.Errorf("tpmkey: can't read key public: %v", err)
+	}
+
+	return &Key{
+		rwr:       rwr,
+		Handle:    rsp.ObjectHandle,
+		Name:      rsp.Name,
+		Public:    *pub,
+		auth:      auth,
+		policy:    policy,
+		transient: true,
+	}, nil
+}
+
+// LoadPersistent wraps an object already resident at a persistent handle.
+// Persistent objects carry no keyfile, so a policy, if any, has to be
+// supplied by the caller.
+func LoadPersistent(rwr transport.TPM, handle tpm2.TPMHandle, auth []byte, policy []*keyfile.TPMPolicy) (*Key, error) {
+	rsp, err := tpm2.ReadPublic{
+		ObjectHandle: handle,
+	}.Execute(rwr)
+	if err != nil {
+		return nil, fmt.Errorf("tpmkey: can't read public of handle 0x%x: %v", handle, err)
+	}
+	pub, err := rsp.OutPublic.Contents()
+	if err != nil {
+		return nil, fmt.Errorf("tpmkey: can't read key public: %v", err)
+	}
+	return &Key{
+		rwr:    rwr,
+		Handle: handle,
+		Name:   rsp.Name,
+		Public: *pub,
+		auth:   auth,
+		policy: policy,
+	}, nil
+}
+
+// Close flushes the key from the TPM if it was loaded transiently.
+func (k *Key) Close() error {
+	if !k.transient {
+		return nil
+	}
+	_, err := tpm2.FlushContext{
+		FlushHandle: k.Handle,
+	}.Execute(k.rwr)
+	return err
+}
+
+// SetAuth replaces the userAuth used for the key, eg once it has been
+// prompted for.  It doesn't change the auth value stored in the TPM.
+func (k *Key) SetAuth(auth []byte) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.auth = auth
+}
+
+// TPM returns the transport the key was loaded with.
+func (k *Key) TPM() transport.TPM {
+	return k.rwr
+}
+
+// AuthHandle returns the key handle together with a session that satisfies
+// the key's authorization: a password for plain keys, or a just-in-time
+// policy session replaying the keyfile policy.
+func (k *Key) AuthHandle() tpm2.AuthHandle {
+	return tpm2.AuthHandle{
+		Handle: k.Handle,
+		Name:   k.Name,
+		Auth:   k.session(),
+	}
+}
+
+func (k *Key) session() tpm2.Session {
+	if len(k.policy) == 0 {
+		return tpm2.PasswordAuth(k.auth)
+	}
+	return tpm2.Policy(k.Public.NameAlg, 16, func(rwr transport.TPM, handle tpm2.TPMISHPolicy, _ tpm2.TPM2BNonce) error {
+		authPolicy := k.authPolicy
+		if len(k.auth) == 0 {
+			authPolicy = withoutPasswordFirst(authPolicy)
+		}
+		if err := replayPolicy(rwr, handle, k.policy, authPolicy, k.Public.NameAlg); err != nil {
+			// go-tpm leaves the session loaded when its policy fails
+			flush(rwr, handle)
+			return err
+		}
+		return nil
+	}, tpm2.Auth(k.auth))
+}
+
+func flush(rwr transport.TPM, h tpm2.TPMHandle) {
+	_, _ = tpm2.FlushContext{
+		FlushHandle: h,
+	}.Execute(rwr)
+}
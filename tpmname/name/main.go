@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+
+	"github.com/google/go-tpm/tpm2"
+	"github.com/ibiscum/tpm2/tpmname"
+)
+
+var (
+	hierarchy = flag.String("hierarchy", "owner", "hierarchy of the primary: owner, endorsement, platform, null or a handle")
+	template  = flag.String("template", "", "template of the primary if given as a PEM or DER public key (or to override an EK certificate's): h2, srk-rsa, srk-ecc, ek-rsa, ek-ecc or a high range EK, see tpmwrap.Templates")
+	written   = flag.Bool("nv-written", false, "name of the NV index once written: sets TPMA_NV_WRITTEN, which the TPM sets on the first write")
+)
+
+var hierarchies = map[string]tpm2.TPMHandle{
+	"owner":       tpm2.TPMRHOwner,
+	"endorsement": tpm2.TPMRHEndorsement,
+	"platform":    tpm2.TPMRHPlatform,
+	"null":        tpm2.TPMRHNull,
+}
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [-hierarchy H] [-template T] primary [child...]\n       %s [-nv-written] nvpublic\n", os.Args[0], os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	h, ok := hierarchies[*hierarchy]
+	if !ok {
+		v, err := strconv.ParseUint(*hierarchy, 0, 32)
+		if err != nil {
+			log.Fatalf("bad hierarchy %q", *hierarchy)
+		}
+		h = tpm2.TPMHandle(v)
+	}
+
+	// the first file is the primary, the others its descendants in order
+	var objects []*tpm2.TPMTPublic
+	for i, file := range flag.Args() {
+		b, err := os.ReadFile(file)
+		if err != nil {
+			log.Fatalf("can't read %s: %v", file, err)
+		}
+		t := ""
+		if i == 0 {
+			t = *template
+		}
+		pub, err := tpmname.Parse(b, t)
+		if err != nil {
+			log.Fatalf("%s: %v", file, err)
+		}
+		if pub.NV != nil {
+			if flag.NArg() > 1 {
+				log.Fatalf("%s: an NV index has no parent or children", file)
+			}
+			pub.NV.Attributes.Written = pub.NV.Attributes.Written || *written
+			name, err := pub.Name()
+			if err != nil {
+				log.Fatalf("%v", err)
+			}
+			fmt.Printf("%s: nv index 0x%x\n  name: %s\n", file, pub.NV.NVIndex, hex.EncodeToString(name.Buffer))
+			return
+		}
+		objects = append(objects, pub.Object)
+	}
+
+	chain, err := tpmname.Chain(h, objects...)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	for i, l := range chain {
+		fmt.Printf("%s:\n  name: %s\n  qualified name: %s\n", flag.Arg(i), hex.EncodeToString(l.Name.Buffer), hex.EncodeToString(l.QualifiedName.Buffer))
+	}
+}
@@ -0,0 +1,141 @@
+// Package tpmname computes the names TPM 2.0 gives objects and NV indexes,
+// and qualified names along a parent chain, without the TPM.  A remote
+// party that knows a key's public area, or only its public key and the
+// template it was made from, can predict the name PolicyDuplicationSelect,
+// TPM2_MakeCredential or a salted session needs (Part 1, "Names" and
+// "Qualified Name").
+package tpmname
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+
+	keyfile "github.com/foxboron/go-tpm-keyfiles"
+	"github.com/google/go-tpm/tpm2"
+	"github.com/ibiscum/tpm2/tpm2tools"
+	"github.com/ibiscum/tpm2/tpmwrap"
+)
+
+// Public is what a name is computed from: the public area of an object or
+// of an NV index.
+type Public struct {
+	Object *tpm2.TPMTPublic
+	NV     *tpm2.TPMSNVPublic
+}
+
+// Name returns the name: nameAlg || H_nameAlg(public area).
+func (p *Public) Name() (*tpm2.TPM2BName, error) {
+	var name *tpm2.TPM2BName
+	var err error
+	if p.NV != nil {
+		name, err = tpm2.NVName(p.NV)
+	} else {
+		name, err = tpm2.ObjectName(p.Object)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("tpmname: %v", err)
+	}
+	return name, nil
+}
+
+// Parse reads a public area from data:
+//
+//   - a TPM2B_NV_PUBLIC or TPMS_NV_PUBLIC
+//   - a TPM2B_PUBLIC or TPMT_PUBLIC
+//   - an EK certificate, completed with the EK template of its key or the
+//     named one
+//   - a PEM or DER public key, completed with the named template, one of
+//     tpmwrap.Templates
+//   - a tpm2-tools context file or serialized ESYS_TR
+func Parse(data []byte, template string) (*Public, error) {
+	if nv, ok := parseNVPublic(data); ok {
+		return &Public{NV: nv}, nil
+	}
+	pub, err := tpmwrap.ParseParent(data, template)
+	if err == nil {
+		return &Public{Object: pub}, nil
+	}
+	if c, cerr := tpm2tools.ParseContext(data); cerr == nil {
+		switch {
+		case c.Public != nil:
+			t, err := c.Public.Contents()
+			if err != nil {
+				return nil, fmt.Errorf("tpmname: %v", err)
+			}
+			return &Public{Object: t}, nil
+		case c.NVPublic != nil:
+			t, err := c.NVPublic.Contents()
+			if err != nil {
+				return nil, fmt.Errorf("tpmname: %v", err)
+			}
+			return &Public{NV: t}, nil
+		}
+	}
+	return nil, err
+}
+
+// parseNVPublic reads a TPM2B_NV_PUBLIC or TPMS_NV_PUBLIC that is all of
+// data.
+func parseNVPublic(data []byte) (*tpm2.TPMSNVPublic, bool) {
+	b := data
+	if len(b) > 2 && int(binary.BigEndian.Uint16(b)) == len(b)-2 {
+		b = b[2:]
+	}
+	nv, err := tpm2.Unmarshal[tpm2.TPMSNVPublic](b)
+	if err != nil || !keyfile.IsMSO(nv.NVIndex, keyfile.TPM_HT_NV_INDEX) || !bytes.Equal(tpm2.Marshal(*nv), b) {
+		return nil, false
+	}
+	return nv, true
+}
+
+// Qualified returns the qualified name of the object called name under a
+// parent with the qualified name parent: nameAlg || H_nameAlg(parent ||
+// name), nameAlg being the object's.  A primary's parent is its hierarchy,
+// whose qualified name is its handle, see HierarchyName.
+func Qualified(parent, name tpm2.TPM2BName) (*tpm2.TPM2BName, error) {
+	if len(name.Buffer) < 2 {
+		return nil, fmt.Errorf("tpmname: a handle has no qualified name")
+	}
+	nameAlg := tpm2.TPMAlgID(binary.BigEndian.Uint16(name.Buffer))
+	h, err := nameAlg.Hash()
+	if err != nil {
+		return nil, fmt.Errorf("tpmname: %v", err)
+	}
+	d := h.New()
+	d.Write(parent.Buffer)
+	d.Write(name.Buffer)
+	return &tpm2.TPM2BName{Buffer: d.Sum(name.Buffer[:2:2])}, nil
+}
+
+// HierarchyName returns the name of a hierarchy, which is also its
+// qualified name: its handle.
+func HierarchyName(hierarchy tpm2.TPMHandle) tpm2.TPM2BName {
+	return tpm2.TPM2BName{Buffer: binary.BigEndian.AppendUint32(nil, uint32(hierarchy))}
+}
+
+// Link is an object of a chain with its name and qualified name.
+type Link struct {
+	Name          tpm2.TPM2BName
+	QualifiedName tpm2.TPM2BName
+}
+
+// Chain returns the names and qualified names of objects, a primary under
+// hierarchy followed by its descendants, each the parent of the next.
+func Chain(hierarchy tpm2.TPMHandle, objects ...*tpm2.TPMTPublic) ([]Link, error) {
+	parent := HierarchyName(hierarchy)
+	var chain []Link
+	for _, o := range objects {
+		name, err := tpm2.ObjectName(o)
+		if err != nil {
+			return nil, fmt.Errorf("tpmname: %v", err)
+		}
+		qn, err := Qualified(parent, *name)
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, Link{Name: *name, QualifiedName: *qn})
+		parent = *qn
+	}
+	return chain, nil
+}
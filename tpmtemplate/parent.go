@@ -0,0 +1,56 @@
+package tpmtemplate
+
+import (
+	"encoding/asn1"
+
+	keyfile "github.com/foxboron/go-tpm-keyfiles"
+	"github.com/google/go-tpm/tpm2"
+)
+
+// Persistent handles of the EKs, TCG EK Credential Profile section 2.2.1.5:
+// RSA 2048 and P-256 from the low or the high range, the others from the
+// high range.
+const (
+	EKRSA2048Handle tpm2.TPMHandle = 0x81010001
+	EKECC256Handle  tpm2.TPMHandle = 0x81010002
+	EKECC384Handle  tpm2.TPMHandle = 0x81010003
+	EKECC521Handle  tpm2.TPMHandle = 0x81010004
+	EKRSA3072Handle tpm2.TPMHandle = 0x81010006
+	EKRSA4096Handle tpm2.TPMHandle = 0x81010007
+)
+
+// EKHandles are the templates of the EK at each EK handle, the low range
+// first.
+var EKHandles = map[tpm2.TPMHandle][]string{
+	EKRSA2048Handle: {"ek-rsa", "ek-rsa2048-high"},
+	EKECC256Handle:  {"ek-ecc", "ek-ecc256-high"},
+	EKECC384Handle:  {"ek-ecc384"},
+	EKECC521Handle:  {"ek-ecc521"},
+	EKRSA3072Handle: {"ek-rsa3072"},
+	EKRSA4096Handle: {"ek-rsa4096"},
+}
+
+// ForParent returns the templates a key of keyfile type keytype and
+// algorithm alg, naming parent handle, may have been created under, the
+// most likely first; none for a persistent key other than an EK.
+//
+// A hierarchy handle means the H-2 primary of draft-bottomley-tpm2-keys,
+// but tpm2tss-genkey put keys with the old keyfile OID under an RSA primary
+// for RSA keys, and other tools name the hierarchy for whatever primary
+// they made there: the SRKs and the tpm2-tools defaults follow, those of
+// the key's own algorithm first.  An EK handle means the EK there, or the
+// one its templates recreate.
+func ForParent(handle tpm2.TPMHandle, keytype asn1.ObjectIdentifier, alg tpm2.TPMAlgID) []string {
+	if keyfile.IsMSO(handle, keyfile.TPM_HT_PERSISTENT) {
+		return EKHandles[handle]
+	}
+	rsa := []string{"tss-rsa", "srk-rsa", "tools-rsa"}
+	ecc := []string{"srk-ecc", "tools-ecc"}
+	if alg == tpm2.TPMAlgRSA {
+		if keytype.Equal(keyfile.OIDOldLoadableKey) {
+			return append(append([]string{"tss-rsa", "h2"}, rsa[1:]...), ecc...)
+		}
+		return append(append([]string{"h2"}, rsa...), ecc...)
+	}
+	return append(append([]string{"h2"}, ecc...), rsa...)
+}
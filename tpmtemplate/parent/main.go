@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"slices"
+
+	keyfile "github.com/foxboron/go-tpm-keyfiles"
+	"github.com/google/go-tpm-tools/simulator"
+	"github.com/google/go-tpm/tpm2/transport"
+	"github.com/google/go-tpm/tpmutil"
+	"github.com/ibiscum/tpm2/tpmkey"
+)
+
+var (
+	tpmPath = flag.String("tpm-path", "/dev/tpmrm0", "Path to the TPM device (character device or a Unix socket).")
+)
+
+var TPMDEVICES = []string{"/dev/tpm0", "/dev/tpmrm0"}
+
+func OpenTPM(path string) (io.ReadWriteCloser, error) {
+	if slices.Contains(TPMDEVICES, path) {
+		return tpmutil.OpenTPM(path)
+	} else if path == "simulator" {
+		return simulator.GetWithFixedSeedInsecure(1073741825)
+	} else {
+		return net.Dial("tcp", path)
+	}
+}
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [-tpm-path PATH] keyfile...\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	rwc, err := OpenTPM(*tpmPath)
+	if err != nil {
+		log.Fatalf("can't open TPM %q: %v", *tpmPath, err)
+	}
+	defer func() {
+		rwc.Close()
+	}()
+
+	rwr := transport.FromReadWriter(rwc)
+
+	for _, file := range flag.Args() {
+		b, err := os.ReadFile(file)
+		if err != nil {
+			log.Fatalf("can't read %s: %v", file, err)
+		}
+		kf, err := keyfile.Decode(b)
+		if err != nil {
+			log.Fatalf("%s: can't decode keyfile: %v", file, err)
+		}
+		parent, template, closer, err := tpmkey.FindParent(rwr, kf)
+		if err != nil {
+			log.Fatalf("%s: %v", file, err)
+		}
+		closer()
+		if template == "" {
+			template = "persistent"
+		}
+		fmt.Printf("%s:\n  parent handle: 0x%x\n  primary: %s\n  name: %s\n", file, kf.Parent, template, hex.EncodeToString(parent.Name.Buffer))
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/google/go-tpm-tools/simulator"
+	"github.com/google/go-tpm/tpm2"
+	"github.com/google/go-tpm/tpm2/transport"
+	"github.com/google/go-tpm/tpmutil"
+	"github.com/ibiscum/tpm2/tpm2tools"
+	"github.com/ibiscum/tpm2/tpmkey"
+	"github.com/ibiscum/tpm2/tpmtemplate"
+)
+
+var (
+	tpmPath   = flag.String("tpm-path", "/dev/tpmrm0", "Path to the TPM device (character device or a Unix socket).")
+	template  = flag.String("template", "h2", "template of the primary, one of "+strings.Join(tpmtemplate.Names(), ", "))
+	hierarchy = flag.String("hierarchy", "", "hierarchy: owner, endorsement, platform, null or a handle (default: endorsement for an EK, owner otherwise)")
+	out       = flag.String("out", "", "optional file to write the primary's TPM2B_PUBLIC to")
+	pemOut    = flag.String("pem", "", "optional file to write the primary's public key to as PEM")
+	list      = flag.Bool("list", false, "list the templates and exit")
+)
+
+var hierarchies = map[string]tpm2.TPMHandle{
+	"owner":       tpm2.TPMRHOwner,
+	"endorsement": tpm2.TPMRHEndorsement,
+	"platform":    tpm2.TPMRHPlatform,
+	"null":        tpm2.TPMRHNull,
+}
+
+var TPMDEVICES = []string{"/dev/tpm0", "/dev/tpmrm0"}
+
+func OpenTPM(path string) (io.ReadWriteCloser, error) {
+	if slices.Contains(TPMDEVICES, path) {
+		return tpmutil.OpenTPM(path)
+	} else if path == "simulator" {
+		return simulator.GetWithFixedSeedInsecure(1073741825)
+	} else {
+		return net.Dial("tcp", path)
+	}
+}
+
+func main() {
+	flag.Parse()
+
+	if *list {
+		for _, name := range tpmtemplate.Names() {
+			t := tpmtemplate.Templates[name]
+			fmt.Printf("%-16s %s\n", name, describe(&t))
+		}
+		return
+	}
+
+	t, err := tpmtemplate.Get(*template)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	h := tpm2.TPMRHOwner
+	if strings.HasPrefix(*template, "ek-") {
+		h = tpm2.TPMRHEndorsement
+	}
+	if *hierarchy != "" {
+		var ok bool
+		if h, ok = hierarchies[*hierarchy]; !ok {
+			v, err := strconv.ParseUint(*hierarchy, 0, 32)
+			if err != nil {
+				log.Fatalf("bad hierarchy %q", *hierarchy)
+			}
+			h = tpm2.TPMHandle(v)
+		}
+	}
+
+	rwc, err := OpenTPM(*tpmPath)
+	if err != nil {
+		log.Fatalf("can't open TPM %q: %v", *tpmPath, err)
+	}
+	defer func() {
+		rwc.Close()
+	}()
+
+	rwr := transport.FromReadWriter(rwc)
+
+	p, closer, err := tpmkey.Primary(rwr, h, t)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	defer closer()
+
+	rsp, err := tpm2.ReadPublic{
+		ObjectHandle: p.Handle,
+	}.Execute(rwr)
+	if err != nil {
+		log.Fatalf("can't read primary: %v", err)
+	}
+	log.Printf("primary %s of hierarchy 0x%x, name %s", *template, h, hex.EncodeToString(p.Name.Buffer))
+	if *out != "" {
+		if err := os.WriteFile(*out, tpm2.Marshal(rsp.OutPublic), 0644); err != nil {
+			log.Fatalf("can't write public: %v", err)
+		}
+		log.Printf("wrote %s", *out)
+	}
+	if *pemOut != "" {
+		pub, err := rsp.OutPublic.Contents()
+		if err != nil {
+			log.Fatalf("can't read public: %v", err)
+		}
+		b, err := tpm2tools.PublicKeyPEM(pub)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		if err := os.WriteFile(*pemOut, b, 0644); err != nil {
+			log.Fatalf("can't write public key: %v", err)
+		}
+		log.Printf("wrote %s", *pemOut)
+	}
+}
+
+// describe sums up a template: its key, name algorithm and how it's
+// authorized.
+func describe(t *tpm2.TPMTPublic) string {
+	var key string
+	var sym tpm2.TPMTSymDefObject
+	switch t.Type {
+	case tpm2.TPMAlgRSA:
+		rsa, _ := t.Parameters.RSADetail()
+		key = fmt.Sprintf("rsa %d", rsa.KeyBits)
+		sym = rsa.Symmetric
+	case tpm2.TPMAlgECC:
+		ecc, _ := t.Parameters.ECCDetail()
+		curve, _ := ecc.CurveID.Curve()
+		key = "ecc " + curve.Params().Name
+		sym = ecc.Symmetric
+	}
+	aes, _ := sym.KeyBits.AES()
+	nameAlg := map[tpm2.TPMAlgID]string{
+		tpm2.TPMAlgSHA256: "sha256",
+		tpm2.TPMAlgSHA384: "sha384",
+		tpm2.TPMAlgSHA512: "sha512",
+	}[t.NameAlg]
+	var auth []string
+	if t.ObjectAttributes.UserWithAuth {
+		auth = append(auth, "password")
+	}
+	if len(t.AuthPolicy.Buffer) > 0 {
+		auth = append(auth, "policy "+hex.EncodeToString(t.AuthPolicy.Buffer[:4])+"...")
+	}
+	if t.ObjectAttributes.NoDA {
+		auth = append(auth, "noDA")
+	}
+	return fmt.Sprintf("%-12s %s aes%d %s", key, nameAlg, *aes, strings.Join(auth, ", "))
+}
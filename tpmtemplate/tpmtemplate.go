@@ -0,0 +1,269 @@
+// Package tpmtemplate is a catalog of the standard primary key templates:
+// the SRKs of the TCG provisioning guidance, the H-2 primary TSS2 keyfiles
+// name by a hierarchy handle, the low and high range EKs of the TCG EK
+// Credential Profile and the primaries tpm2-tools and tpm2-tss-engine make
+// by default.  A primary is fully determined by its template and the
+// hierarchy's seed, so the same template recreates the same key, and a key
+// created under one primary only loads under that one.
+package tpmtemplate
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	keyfile "github.com/foxboron/go-tpm-keyfiles"
+	"github.com/google/go-tpm/tpm2"
+)
+
+// Templates are the templates by name.
+var Templates = map[string]tpm2.TPMTPublic{
+	// draft-bottomley-tpm2-keys: the primary a keyfile's hierarchy handle
+	// stands for, the ECC SRK with an empty unique field; tpm2-tss-engine
+	// and the openssl tpm2 provider make the same one
+	"h2": keyfile.ECCSRK_H2_Template,
+	// tpm2-tss-engine's RSA primary, the H-2 attributes with an RSA 2048 key
+	"tss-rsa": tssRSATemplate,
+	// the SRKs of the TCG TPM v2.0 Provisioning Guidance
+	"srk-rsa": tpm2.RSASRKTemplate,
+	"srk-ecc": tpm2.ECCSRKTemplate,
+	// the EK low range, templates L-1 and L-2, with PolicyA
+	"ek-rsa": tpm2.RSAEKTemplate,
+	"ek-ecc": tpm2.ECCEKTemplate,
+	// the EK high range, templates H-1 to H-4, H-6 and H-7, with PolicyB:
+	// PolicyA or PolicyC
+	"ek-rsa2048-high": ekHighTemplate(tpm2.TPMAlgRSA, 2048, tpm2.TPMAlgSHA256),
+	"ek-ecc256-high":  ekHighTemplate(tpm2.TPMAlgECC, 256, tpm2.TPMAlgSHA256),
+	"ek-ecc384":       ekHighTemplate(tpm2.TPMAlgECC, 384, tpm2.TPMAlgSHA384),
+	"ek-ecc521":       ekHighTemplate(tpm2.TPMAlgECC, 521, tpm2.TPMAlgSHA512),
+	"ek-rsa3072":      ekHighTemplate(tpm2.TPMAlgRSA, 3072, tpm2.TPMAlgSHA384),
+	"ek-rsa4096":      ekHighTemplate(tpm2.TPMAlgRSA, 4096, tpm2.TPMAlgSHA384),
+	// tpm2_createprimary -C o -G rsa|ecc, sha256
+	"tools-rsa": mustTools(tpm2.TPMAlgRSA, tpm2.TPMAlgSHA256),
+	"tools-ecc": mustTools(tpm2.TPMAlgECC, tpm2.TPMAlgSHA256),
+}
+
+// Names lists the names of Templates, sorted.
+func Names() []string {
+	return slices.Sorted(maps.Keys(Templates))
+}
+
+// Get returns the template called name.
+func Get(name string) (tpm2.TPMTPublic, error) {
+	t, ok := Templates[name]
+	if !ok {
+		return tpm2.TPMTPublic{}, fmt.Errorf("tpmtemplate: unknown template %q, one of %s", name, strings.Join(Names(), ", "))
+	}
+	return t, nil
+}
+
+// Same reports whether pub was created from template, which may only
+// differ in the unique field.  Templates that differ in their unique field
+// only, h2 and srk-ecc or tss-rsa and srk-rsa, can't be told apart so.
+func Same(pub *tpm2.TPMTPublic, template tpm2.TPMTPublic) bool {
+	if pub.Type != template.Type {
+		return false
+	}
+	template.Unique = pub.Unique
+	return bytes.Equal(tpm2.Marshal(*pub), tpm2.Marshal(template))
+}
+
+// tssRSATemplate is tpm2-tss-engine's primaryRsaTemplate.
+var tssRSATemplate = tpm2.TPMTPublic{
+	Type:    tpm2.TPMAlgRSA,
+	NameAlg: tpm2.TPMAlgSHA256,
+	ObjectAttributes: tpm2.TPMAObject{
+		FixedTPM:            true,
+		FixedParent:         true,
+		SensitiveDataOrigin: true,
+		UserWithAuth:        true,
+		NoDA:                true,
+		Restricted:          true,
+		Decrypt:             true,
+	},
+	Parameters: tpm2.NewTPMUPublicParms(tpm2.TPMAlgRSA, &tpm2.TPMSRSAParms{
+		Symmetric: tpm2.TPMTSymDefObject{
+			Algorithm: tpm2.TPMAlgAES,
+			KeyBits:   tpm2.NewTPMUSymKeyBits(tpm2.TPMAlgAES, tpm2.TPMKeyBits(128)),
+			Mode:      tpm2.NewTPMUSymMode(tpm2.TPMAlgAES, tpm2.TPMAlgCFB),
+		},
+		Scheme:  tpm2.TPMTRSAScheme{Scheme: tpm2.TPMAlgNull},
+		KeyBits: 2048,
+	}),
+	Unique: tpm2.NewTPMUPublicID(tpm2.TPMAlgRSA, &tpm2.TPM2BPublicKeyRSA{}),
+}
+
+// Tools returns the primary tpm2_createprimary makes by default for -G ecc
+// or -G rsa and -g nameAlg: a restricted decryption key with AES-128-CFB, no
+// noDA and an empty unique.  clevis and systemd-cryptenroll (before it moved
+// to the SRK) seal under it.
+func Tools(alg, nameAlg tpm2.TPMAlgID) (tpm2.TPMTPublic, error) {
+	attrs := tpm2.TPMAObject{
+		FixedTPM:            true,
+		FixedParent:         true,
+		SensitiveDataOrigin: true,
+		UserWithAuth:        true,
+		Restricted:          true,
+		Decrypt:             true,
+	}
+	sym := tpm2.TPMTSymDefObject{
+		Algorithm: tpm2.TPMAlgAES,
+		KeyBits:   tpm2.NewTPMUSymKeyBits(tpm2.TPMAlgAES, tpm2.TPMKeyBits(128)),
+		Mode:      tpm2.NewTPMUSymMode(tpm2.TPMAlgAES, tpm2.TPMAlgCFB),
+	}
+
+	switch alg {
+	case tpm2.TPMAlgECC:
+		return tpm2.TPMTPublic{
+			Type:             tpm2.TPMAlgECC,
+			NameAlg:          nameAlg,
+			ObjectAttributes: attrs,
+			Parameters: tpm2.NewTPMUPublicParms(tpm2.TPMAlgECC,
+				&tpm2.TPMSECCParms{
+					Symmetric: sym,
+					Scheme: tpm2.TPMTECCScheme{
+						Scheme: tpm2.TPMAlgNull,
+					},
+					CurveID: tpm2.TPMECCNistP256,
+					KDF: tpm2.TPMTKDFScheme{
+						Scheme: tpm2.TPMAlgNull,
+					},
+				}),
+			Unique: tpm2.NewTPMUPublicID(tpm2.TPMAlgECC, &tpm2.TPMSECCPoint{}),
+		}, nil
+	case tpm2.TPMAlgRSA:
+		return tpm2.TPMTPublic{
+			Type:             tpm2.TPMAlgRSA,
+			NameAlg:          nameAlg,
+			ObjectAttributes: attrs,
+			Parameters: tpm2.NewTPMUPublicParms(tpm2.TPMAlgRSA,
+				&tpm2.TPMSRSAParms{
+					Symmetric: sym,
+					Scheme: tpm2.TPMTRSAScheme{
+						Scheme: tpm2.TPMAlgNull,
+					},
+					KeyBits: 2048,
+				}),
+			Unique: tpm2.NewTPMUPublicID(tpm2.TPMAlgRSA, &tpm2.TPM2BPublicKeyRSA{}),
+		}, nil
+	}
+	return tpm2.TPMTPublic{}, fmt.Errorf("tpmtemplate: no default primary for algorithm 0x%x", alg)
+}
+
+func mustTools(alg, nameAlg tpm2.TPMAlgID) tpm2.TPMTPublic {
+	t, err := Tools(alg, nameAlg)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+// EKPolicyA is PolicyA of the TCG EK Credential Profile by hash, the
+// authPolicy of the low range EKs: PolicySecret(endorsement).
+var EKPolicyA = map[tpm2.TPMAlgID][]byte{
+	tpm2.TPMAlgSHA256: mustHex("837197674484b3f81a90cc8d46a5d724fd52d76e06520b64f2a1da1b331469aa"),
+	tpm2.TPMAlgSHA384: mustHex("8bbf2266537c171cb56e403c4dc1d4b64f432611dc386e6f532050c3278c930e143e8bb1133824ccb431053871c6db53"),
+	tpm2.TPMAlgSHA512: mustHex("1e3b76502c8a1425aa0b7b3fc646a1b0fae063b03b5368f9c4cddecaff0891dd682bac1a85d4d832b781ea451915de5fc5bf0dc4a1917cd42fa041e3f998e0ee"),
+}
+
+// EKPolicyC is PolicyC of the TCG EK Credential Profile by hash:
+// PolicyAuthorizeNV of the policy NV index at EKPolicyNVHandles, where the
+// platform vendor can write another policy for the high range EKs.
+var EKPolicyC = map[tpm2.TPMAlgID][]byte{
+	tpm2.TPMAlgSHA256: mustHex("3767e2edd43ff45a3a7e1eaefcef78643dca964632e7aad82c673a30d8633fde"),
+	tpm2.TPMAlgSHA384: mustHex("d6032ce61f2fb3c240eb3cf6a33237ef2b6a16f4293c22b455e261cffd217ad5b4947c2d73e63005eed2dc2b3593d165"),
+	tpm2.TPMAlgSHA512: mustHex("589ee1e146544716e8deafe6db247b01b81e9f9c7dd16b814aa159138749105fba5388dd1dea702f35240c184933121e2c61b8f50d3ef91393a49a38c3f73fc8"),
+}
+
+// EKPolicyNVHandles are the policy NV indexes EKPolicyC authorizes with,
+// by hash.
+var EKPolicyNVHandles = map[tpm2.TPMAlgID]tpm2.TPMHandle{
+	tpm2.TPMAlgSHA256: 0x01C07F01,
+	tpm2.TPMAlgSHA384: 0x01C07F02,
+	tpm2.TPMAlgSHA512: 0x01C07F03,
+}
+
+// EKPolicyB is PolicyB of the TCG EK Credential Profile by hash, the
+// authPolicy of the high range EKs: PolicyOR of EKPolicyA and EKPolicyC.
+var EKPolicyB = map[tpm2.TPMAlgID][]byte{
+	tpm2.TPMAlgSHA256: mustPolicyOr(tpm2.TPMAlgSHA256, EKPolicyA[tpm2.TPMAlgSHA256], EKPolicyC[tpm2.TPMAlgSHA256]),
+	tpm2.TPMAlgSHA384: mustPolicyOr(tpm2.TPMAlgSHA384, EKPolicyA[tpm2.TPMAlgSHA384], EKPolicyC[tpm2.TPMAlgSHA384]),
+	tpm2.TPMAlgSHA512: mustPolicyOr(tpm2.TPMAlgSHA512, EKPolicyA[tpm2.TPMAlgSHA512], EKPolicyC[tpm2.TPMAlgSHA512]),
+}
+
+func mustPolicyOr(nameAlg tpm2.TPMAlgID, branches ...[]byte) []byte {
+	calc, err := tpm2.NewPolicyCalculator(nameAlg)
+	if err != nil {
+		panic(err)
+	}
+	var digests []tpm2.TPM2BDigest
+	for _, b := range branches {
+		digests = append(digests, tpm2.TPM2BDigest{Buffer: b})
+	}
+	if err := (tpm2.PolicyOr{PHashList: tpm2.TPMLDigest{Digests: digests}}).Update(calc); err != nil {
+		panic(err)
+	}
+	return calc.Hash().Digest
+}
+
+func mustHex(s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+// ekHighTemplate returns a high range EK template: unlike the low range,
+// userWithAuth is set, the unique field is empty, the authPolicy is PolicyB
+// and keys above 2048 bits or P-256 use AES-256.
+func ekHighTemplate(alg tpm2.TPMAlgID, bits int, nameAlg tpm2.TPMAlgID) tpm2.TPMTPublic {
+	symBits := tpm2.TPMKeyBits(128)
+	if nameAlg != tpm2.TPMAlgSHA256 {
+		symBits = 256
+	}
+	sym := tpm2.TPMTSymDefObject{
+		Algorithm: tpm2.TPMAlgAES,
+		KeyBits:   tpm2.NewTPMUSymKeyBits(tpm2.TPMAlgAES, symBits),
+		Mode:      tpm2.NewTPMUSymMode(tpm2.TPMAlgAES, tpm2.TPMAlgCFB),
+	}
+	t := tpm2.TPMTPublic{
+		Type:    alg,
+		NameAlg: nameAlg,
+		ObjectAttributes: tpm2.TPMAObject{
+			FixedTPM:            true,
+			FixedParent:         true,
+			SensitiveDataOrigin: true,
+			UserWithAuth:        true,
+			AdminWithPolicy:     true,
+			Restricted:          true,
+			Decrypt:             true,
+		},
+		AuthPolicy: tpm2.TPM2BDigest{Buffer: EKPolicyB[nameAlg]},
+	}
+	if alg == tpm2.TPMAlgRSA {
+		t.Parameters = tpm2.NewTPMUPublicParms(tpm2.TPMAlgRSA, &tpm2.TPMSRSAParms{
+			Symmetric: sym,
+			Scheme:    tpm2.TPMTRSAScheme{Scheme: tpm2.TPMAlgNull},
+			KeyBits:   tpm2.TPMKeyBits(bits),
+		})
+		t.Unique = tpm2.NewTPMUPublicID(tpm2.TPMAlgRSA, &tpm2.TPM2BPublicKeyRSA{})
+		return t
+	}
+	curve := map[int]tpm2.TPMECCCurve{
+		256: tpm2.TPMECCNistP256,
+		384: tpm2.TPMECCNistP384,
+		521: tpm2.TPMECCNistP521,
+	}[bits]
+	t.Parameters = tpm2.NewTPMUPublicParms(tpm2.TPMAlgECC, &tpm2.TPMSECCParms{
+		Symmetric: sym,
+		Scheme:    tpm2.TPMTECCScheme{Scheme: tpm2.TPMAlgNull},
+		CurveID:   curve,
+		KDF:       tpm2.TPMTKDFScheme{Scheme: tpm2.TPMAlgNull},
+	})
+	t.Unique = tpm2.NewTPMUPublicID(tpm2.TPMAlgECC, &tpm2.TPMSECCPoint{})
+	return t
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/hex"
+	"flag"
+	"io"
+	"log"
+	"net"
+	"os"
+	"slices"
+
+	keyfile "github.com/foxboron/go-tpm-keyfiles"
+	"github.com/google/go-tpm-tools/simulator"
+	"github.com/google/go-tpm/tpm2/transport"
+	"github.com/google/go-tpm/tpmutil"
+	"github.com/ibiscum/tpm2/tpmkey"
+)
+
+var (
+	tpmPath = flag.String("tpm-path", "/dev/tpmrm0", "Path to the TPM device (character device or a Unix socket).")
+	in      = flag.String("in", "", "importable TSS2 keyfile made by wrap")
+	out     = flag.String("out", "", "loadable TSS2 keyfile to write")
+)
+
+var TPMDEVICES = []string{"/dev/tpm0", "/dev/tpmrm0"}
+
+func OpenTPM(path string) (io.ReadWriteCloser, error) {
+	if slices.Contains(TPMDEVICES, path) {
+		return tpmutil.OpenTPM(path)
+	} else if path == "simulator" {
+		return simulator.GetWithFixedSeedInsecure(1073741825)
+	} else {
+		return net.Dial("tcp", path)
+	}
+}
+
+func main() {
+	flag.Parse()
+
+	if *in == "" || *out == "" {
+		log.Fatalf("--in and --out are required")
+	}
+	data, err := os.ReadFile(*in)
+	if err != nil {
+		log.Fatalf("can't read keyfile: %v", err)
+	}
+	kf, err := keyfile.Decode(data)
+	if err != nil {
+		log.Fatalf("can't decode keyfile: %v", err)
+	}
+
+	rwc, err := OpenTPM(*tpmPath)
+	if err != nil {
+		log.Fatalf("can't open TPM %q: %v", *tpmPath, err)
+	}
+	defer func() {
+		rwc.Close()
+	}()
+
+	rwr := transport.FromReadWriter(rwc)
+
+	loadable, err := tpmkey.Import(rwr, kf, nil)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	// check that it loads before writing it
+	k, err := tpmkey.Load(rwr, loadable, nil)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	name := hex.EncodeToString(k.Name.Buffer)
+	k.Close()
+
+	f, err := os.OpenFile(*out, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		log.Fatalf("can't create keyfile: %v", err)
+	}
+	defer f.Close()
+	if err := keyfile.Encode(f, loadable); err != nil {
+		log.Fatalf("can't write keyfile: %v", err)
+	}
+	log.Printf("imported key %s under parent 0x%x, wrote %s", name, loadable.Parent, *out)
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/hex"
+	"flag"
+	"io"
+	"log"
+	"net"
+	"os"
+	"slices"
+	"strconv"
+
+	"github.com/google/go-tpm-tools/simulator"
+	"github.com/google/go-tpm/tpm2"
+	"github.com/google/go-tpm/tpm2/transport"
+	"github.com/google/go-tpm/tpmutil"
+	"github.com/ibiscum/tpm2/tpmkey"
+)
+
+var (
+	tpmPath = flag.String("tpm-path", "/dev/tpmrm0", "Path to the TPM device (character device or a Unix socket).")
+	parent  = flag.String("parent", "0x40000001", "parent: a hierarchy (its H-2 SRK), a persistent key, or the EK handle 0x81010001 (RSA) or 0x81010002 (ECC)")
+	out     = flag.String("out", "parent.pub", "file to write the parent's TPM2B_PUBLIC to")
+)
+
+var TPMDEVICES = []string{"/dev/tpm0", "/dev/tpmrm0"}
+
+func OpenTPM(path string) (io.ReadWriteCloser, error) {
+	if slices.Contains(TPMDEVICES, path) {
+		return tpmutil.OpenTPM(path)
+	} else if path == "simulator" {
+		return simulator.GetWithFixedSeedInsecure(1073741825)
+	} else {
+		return net.Dial("tcp", path)
+	}
+}
+
+func main() {
+	flag.Parse()
+
+	p, err := strconv.ParseUint(*parent, 0, 32)
+	if err != nil {
+		log.Fatalf("bad parent %q: %v", *parent, err)
+	}
+
+	rwc, err := OpenTPM(*tpmPath)
+	if err != nil {
+		log.Fatalf("can't open TPM %q: %v", *tpmPath, err)
+	}
+	defer func() {
+		rwc.Close()
+	}()
+
+	rwr := transport.FromReadWriter(rwc)
+
+	h, closer, err := tpmkey.Parent(rwr, tpm2.TPMHandle(p))
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	defer closer()
+
+	rsp, err := tpm2.ReadPublic{
+		ObjectHandle: h.Handle,
+	}.Execute(rwr)
+	if err != nil {
+		log.Fatalf("can't read parent: %v", err)
+	}
+	if err := os.WriteFile(*out, tpm2.Marshal(rsp.OutPublic), 0644); err != nil {
+		log.Fatalf("can't write parent: %v", err)
+	}
+	log.Printf("parent 0x%x, name %s, wrote %s", p, hex.EncodeToString(rsp.Name.Buffer), *out)
+}
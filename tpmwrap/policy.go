@@ -0,0 +1,112 @@
+package tpmwrap
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+
+	keyfile "github.com/foxboron/go-tpm-keyfiles"
+	"github.com/google/go-tpm/tpm2"
+	"github.com/ibiscum/tpm2/tpmkey"
+)
+
+// Policy is the authorization policy of a wrapped object.  Unlike
+// tpmkey.PolicySteps it is computed without the target TPM, so the PCR
+// values have to be known in advance: from a reference measurement, a quote
+// or an event log replay.
+type Policy struct {
+	// PCRs maps PCR indexes to the values expected on the target.
+	PCRs map[uint][]byte
+	// Bank is the PCR bank the values are from, SHA256 if unset.
+	Bank tpm2.TPMAlgID
+	// Secret, if set, adds PolicySecret against that hierarchy (e.g.
+	// TPMRHOwner or TPMRHEndorsement), so using the object takes the
+	// hierarchy's auth.
+	Secret tpm2.TPMHandle
+	// AuthValue adds PolicyAuthValue, so the object's password is still
+	// needed on top of the policy.
+	AuthValue bool
+}
+
+// Steps returns the keyfile policy steps and the policy digest for an
+// object with nameAlg.  The steps run in the order PolicyPCR, PolicySecret,
+// PolicyAuthValue.
+func (p *Policy) Steps(nameAlg tpm2.TPMAlgID) ([]*keyfile.TPMPolicy, []byte, error) {
+	var steps []*keyfile.TPMPolicy
+
+	calc, err := tpm2.NewPolicyCalculator(nameAlg)
+	if err != nil {
+		return nil, nil, fmt.Errorf("tpmwrap: %v", err)
+	}
+
+	if len(p.PCRs) > 0 {
+		bank := p.Bank
+		if bank == 0 {
+			bank = tpm2.TPMAlgSHA256
+		}
+		bankHash, err := bank.Hash()
+		if err != nil {
+			return nil, nil, fmt.Errorf("tpmwrap: bad PCR bank: %v", err)
+		}
+		h, err := nameAlg.Hash()
+		if err != nil {
+			return nil, nil, fmt.Errorf("tpmwrap: %v", err)
+		}
+		// TPM2_PolicyPCR hashes the values in PCR index order
+		indexes := slices.Sorted(maps.Keys(p.PCRs))
+		d := h.New()
+		for _, i := range indexes {
+			if len(p.PCRs[i]) != bankHash.Size() {
+				return nil, nil, fmt.Errorf("tpmwrap: PCR %d value is %d bytes, the bank's are %d", i, len(p.PCRs[i]), bankHash.Size())
+			}
+			d.Write(p.PCRs[i])
+		}
+		sel := tpm2.TPMLPCRSelection{
+			PCRSelections: []tpm2.TPMSPCRSelection{
+				{
+					Hash:      bank,
+					PCRSelect: tpm2.PCClientCompatible.PCRs(indexes...),
+				},
+			},
+		}
+		cmd := tpm2.PolicyPCR{
+			PcrDigest: tpm2.TPM2BDigest{Buffer: d.Sum(nil)},
+			Pcrs:      sel,
+		}
+		if err := cmd.Update(calc); err != nil {
+			return nil, nil, fmt.Errorf("tpmwrap: %v", err)
+		}
+		steps = append(steps, tpmkey.PolicyPCRStep(sel, cmd.PcrDigest.Buffer))
+	}
+
+	if p.Secret != 0 {
+		if err := (tpm2.PolicySecret{AuthHandle: p.Secret}).Update(calc); err != nil {
+			return nil, nil, fmt.Errorf("tpmwrap: %v", err)
+		}
+		steps = append(steps, tpmkey.PolicySecretStep(p.Secret))
+	}
+
+	if p.AuthValue {
+		if err := (tpm2.PolicyAuthValue{}).Update(calc); err != nil {
+			return nil, nil, fmt.Errorf("tpmwrap: %v", err)
+		}
+		steps = append(steps, &keyfile.TPMPolicy{
+			CommandCode: int(tpm2.TPMCCPolicyAuthValue),
+		})
+	}
+
+	return steps, calc.Hash().Digest, nil
+}
+
+// Apply sets the authPolicy of public from p and clears userWithAuth, so
+// the object can only be used through the policy.  It returns the steps to
+// record in the keyfile.
+func (p *Policy) Apply(public *tpm2.TPMTPublic) ([]*keyfile.TPMPolicy, error) {
+	steps, digest, err := p.Steps(public.NameAlg)
+	if err != nil {
+		return nil, err
+	}
+	public.AuthPolicy = tpm2.TPM2BDigest{Buffer: digest}
+	public.ObjectAttributes.UserWithAuth = false
+	return steps, nil
+}
@@ -0,0 +1,169 @@
+package tpmwrap
+
+import (
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/pem"
+	"fmt"
+	"strings"
+
+	"github.com/google/go-tpm/tpm2"
+	"github.com/ibiscum/tpm2/tpmkey"
+	"github.com/ibiscum/tpm2/tpmtemplate"
+)
+
+// Templates are the primaries a target parent can be derived from when only
+// its public key is known, the catalog of tpmtemplate.  The key in the EK
+// certificate, or the PEM public key tpm2_readpublic -f pem prints for the
+// SRK, is filled into the template's unique field to get the parent's
+// public area.
+var Templates = tpmtemplate.Templates
+
+// templateNames lists the names of Templates, for messages.
+func templateNames() string {
+	return strings.Join(tpmtemplate.Names(), ", ")
+}
+
+// ParsePublic reads a parent public area: a TPM2B_PUBLIC (tpm2_readpublic
+// -o, tpm2_createprimary -u) or a bare TPMT_PUBLIC.
+func ParsePublic(data []byte) (*tpm2.TPMTPublic, error) {
+	if len(data) > 2 && int(binary.BigEndian.Uint16(data)) == len(data)-2 {
+		pub, err := tpm2.Unmarshal[tpm2.TPM2BPublic](data)
+		if err == nil {
+			if t, err := pub.Contents(); err == nil {
+				return t, nil
+			}
+		}
+	}
+	t, err := tpm2.Unmarshal[tpm2.TPMTPublic](data)
+	if err != nil {
+		return nil, fmt.Errorf("tpmwrap: not a TPM2B_PUBLIC or TPMT_PUBLIC: %v", err)
+	}
+	return t, nil
+}
+
+// FromEKCertificate returns the EK public area for the key in an EK
+// certificate: an RSA 2048 or ECC P-256 EK from the low range templates,
+// larger keys from the high range ones.  An RSA 2048 or P-256 high range EK
+// needs its template given to FromPublicKey.
+func FromEKCertificate(cert *x509.Certificate) (*tpm2.TPMTPublic, error) {
+	var template string
+	switch pub := cert.PublicKey.(type) {
+	case *rsa.PublicKey:
+		template = map[int]string{
+			2048: "ek-rsa",
+			3072: "ek-rsa3072",
+			4096: "ek-rsa4096",
+		}[pub.N.BitLen()]
+	case *ecdsa.PublicKey:
+		template = map[string]string{
+			"P-256": "ek-ecc",
+			"P-384": "ek-ecc384",
+			"P-521": "ek-ecc521",
+		}[pub.Curve.Params().Name]
+	default:
+		return nil, fmt.Errorf("tpmwrap: unsupported EK certificate key %T", cert.PublicKey)
+	}
+	if template == "" {
+		return nil, fmt.Errorf("tpmwrap: no EK template for the certificate's key")
+	}
+	return FromPublicKey(cert.PublicKey, Templates[template])
+}
+
+// FromPublicKey fills pub, an *rsa.PublicKey or *ecdsa.PublicKey, into
+// template.  The key type and size have to be the template's.
+func FromPublicKey(pub crypto.PublicKey, template tpm2.TPMTPublic) (*tpm2.TPMTPublic, error) {
+	t := template
+	switch p := pub.(type) {
+	case *rsa.PublicKey:
+		params, err := t.Parameters.RSADetail()
+		if err != nil || t.Type != tpm2.TPMAlgRSA {
+			return nil, fmt.Errorf("tpmwrap: RSA key for a non-RSA template")
+		}
+		if p.N.BitLen() != int(params.KeyBits) {
+			return nil, fmt.Errorf("tpmwrap: RSA %d key for an RSA %d template", p.N.BitLen(), params.KeyBits)
+		}
+		if p.E != 65537 || (params.Exponent != 0 && params.Exponent != 65537) {
+			return nil, fmt.Errorf("tpmwrap: RSA exponent %d doesn't match the template", p.E)
+		}
+		t.Unique = tpm2.NewTPMUPublicID(tpm2.TPMAlgRSA, &tpm2.TPM2BPublicKeyRSA{Buffer: p.N.Bytes()})
+	case *ecdsa.PublicKey:
+		params, err := t.Parameters.ECCDetail()
+		if err != nil || t.Type != tpm2.TPMAlgECC {
+			return nil, fmt.Errorf("tpmwrap: EC key for a non-ECC template")
+		}
+		curve, err := params.CurveID.Curve()
+		if err != nil || curve != p.Curve {
+			return nil, fmt.Errorf("tpmwrap: %s key doesn't match the template curve", p.Curve.Params().Name)
+		}
+		ecdhPub, err := p.ECDH()
+		if err != nil {
+			return nil, fmt.Errorf("tpmwrap: bad EC key: %v", err)
+		}
+		t.Unique = tpm2.NewTPMUPublicID(tpm2.TPMAlgECC, eccPoint(ecdhPub))
+	default:
+		return nil, fmt.Errorf("tpmwrap: unsupported parent key %T", pub)
+	}
+	return &t, nil
+}
+
+// eccPoint splits an uncompressed point, 0x04 || X || Y.
+func eccPoint(pub *ecdh.PublicKey) *tpm2.TPMSECCPoint {
+	b := pub.Bytes()
+	size := (len(b) - 1) / 2
+	return &tpm2.TPMSECCPoint{
+		X: tpm2.TPM2BECCParameter{Buffer: b[1 : 1+size]},
+		Y: tpm2.TPM2BECCParameter{Buffer: b[1+size:]},
+	}
+}
+
+// ParseParent reads a target parent from data: an EK certificate (PEM or
+// DER), a TPM2B_PUBLIC or TPMT_PUBLIC, or a PEM or DER public key completed
+// with the named template.  The template, if given, also overrides the one
+// an EK certificate's key implies.
+func ParseParent(data []byte, template string) (*tpm2.TPMTPublic, error) {
+	der := data
+	if block, _ := pem.Decode(data); block != nil {
+		der = block.Bytes
+	}
+	if cert, err := x509.ParseCertificate(der); err == nil {
+		if template == "" {
+			return FromEKCertificate(cert)
+		}
+		t, ok := Templates[template]
+		if !ok {
+			return nil, fmt.Errorf("tpmwrap: unknown template %q, one of %s", template, templateNames())
+		}
+		return FromPublicKey(cert.PublicKey, t)
+	}
+	if pub, err := x509.ParsePKIXPublicKey(der); err == nil {
+		t, ok := Templates[template]
+		if !ok {
+			return nil, fmt.Errorf("tpmwrap: a public key needs the parent's template, one of %s", templateNames())
+		}
+		return FromPublicKey(pub, t)
+	}
+	return ParsePublic(data)
+}
+
+// SRKHandle is the persistent SRK handle of the TCG provisioning guidance.
+const SRKHandle tpm2.TPMHandle = 0x81000001
+
+// DefaultHandle returns the handle a keyfile wrapped to parent names: the
+// owner hierarchy for the H-2 SRK, the TCG EK handle for an EK made from the
+// default EK template, and the persistent SRK handle for anything else.
+func DefaultHandle(parent *tpm2.TPMTPublic) tpm2.TPMHandle {
+	switch {
+	case tpmtemplate.Same(parent, Templates["h2"]):
+		return tpm2.TPMRHOwner
+	case tpmtemplate.Same(parent, Templates["ek-rsa"]):
+		return tpmkey.EKRSAHandle
+	case tpmtemplate.Same(parent, Templates["ek-ecc"]):
+		return tpmkey.EKECCHandle
+	}
+	return SRKHandle
+}
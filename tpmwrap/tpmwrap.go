@@ -0,0 +1,190 @@
+// Package tpmwrap wraps keys for a TPM storage parent in software: the
+// duplicate, seed and public that TPM2_Import takes, made without the
+// target TPM (TPM 2.0 Part 1, "Protected Storage" and "Duplication").
+//
+// The outer wrapper protects the object to the parent: a seed is
+// encapsulated to the parent's public key (RSA-OAEP or ECDH, label
+// "DUPLICATE"), AES-CFB and HMAC keys are derived from it with KDFa, and the
+// HMAC binds the encrypted sensitive area to the object's name.  The
+// optional inner wrapper additionally encrypts it with a random AES-128 key
+// handed to TPM2_Import separately, so the blob alone is not enough to
+// import the key.  Unwrap reverses both for a parent whose private key is
+// held in software, such as an offline escrow key.
+package tpmwrap
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"fmt"
+
+	keyfile "github.com/foxboron/go-tpm-keyfiles"
+	"github.com/google/go-tpm/tpm2"
+)
+
+const (
+	labelDuplicate = "DUPLICATE"
+	labelStorage   = "STORAGE"
+	labelIntegrity = "INTEGRITY"
+)
+
+// Blob is a wrapped object, the arguments of TPM2_Import.
+type Blob struct {
+	Public    tpm2.TPM2BPublic
+	Duplicate tpm2.TPM2BPrivate
+	Seed      tpm2.TPM2BEncryptedSecret
+	// EncryptionKey is the inner wrapper key, nil without inner wrapper.
+	EncryptionKey []byte
+}
+
+// Symmetric returns the inner wrapper algorithm to pass to TPM2_Import.
+func (b *Blob) Symmetric() tpm2.TPMTSymDef {
+	if b.EncryptionKey == nil {
+		return tpm2.TPMTSymDef{Algorithm: tpm2.TPMAlgNull}
+	}
+	return innerSymmetric
+}
+
+// innerSymmetric is AES-128-CFB, the one inner wrapper every TPM supports.
+var innerSymmetric = tpm2.TPMTSymDef{
+	Algorithm: tpm2.TPMAlgAES,
+	KeyBits:   tpm2.NewTPMUSymKeyBits(tpm2.TPMAlgAES, tpm2.TPMKeyBits(128)),
+	Mode:      tpm2.NewTPMUSymMode(tpm2.TPMAlgAES, tpm2.TPMAlgCFB),
+}
+
+// Wrap wraps public and sensitive for parent, a storage key (restricted
+// decryption RSA or ECC key with an AES-CFB symmetric definition).  With
+// inner an inner wrapper is added too, which objects with
+// encryptedDuplication set require.
+func Wrap(parent *tpm2.TPMTPublic, public *tpm2.TPMTPublic, sensitive *tpm2.TPMTSensitive, inner bool) (*Blob, error) {
+	if public.ObjectAttributes.FixedTPM || public.ObjectAttributes.FixedParent {
+		return nil, fmt.Errorf("tpmwrap: fixedTPM and fixedParent objects can't be imported")
+	}
+	if public.ObjectAttributes.EncryptedDuplication && !inner {
+		return nil, fmt.Errorf("tpmwrap: encryptedDuplication objects need an inner wrapper")
+	}
+	name, err := tpm2.ObjectName(public)
+	if err != nil {
+		return nil, fmt.Errorf("tpmwrap: %v", err)
+	}
+
+	blob := &Blob{Public: tpm2.New2B(*public)}
+	data := tpm2.Marshal(tpm2.New2B(*sensitive))
+	if inner {
+		blob.EncryptionKey = make([]byte, 16)
+		if _, err := rand.Read(blob.EncryptionKey); err != nil {
+			return nil, err
+		}
+		if data, err = innerWrap(public.NameAlg, name.Buffer, blob.EncryptionKey, data); err != nil {
+			return nil, err
+		}
+	}
+
+	dup, seed, err := outerWrap(parent, name.Buffer, data)
+	if err != nil {
+		return nil, err
+	}
+	blob.Duplicate = tpm2.TPM2BPrivate{Buffer: dup}
+	blob.Seed = tpm2.TPM2BEncryptedSecret{Buffer: seed}
+	return blob, nil
+}
+
+// Keyfile wraps public and sensitive for parent and returns them as an
+// importable TSS2 keyfile naming handle as the parent, with policy as the
+// keyfile policy.  The importable format has no room for an inner wrapper
+// key, so only the outer wrapper is used.
+func Keyfile(parent *tpm2.TPMTPublic, handle tpm2.TPMHandle, public *tpm2.TPMTPublic, sensitive *tpm2.TPMTSensitive, policy []*keyfile.TPMPolicy) (*keyfile.TPMKey, error) {
+	blob, err := Wrap(parent, public, sensitive, false)
+	if err != nil {
+		return nil, err
+	}
+	return keyfile.NewTPMKey(keyfile.OIDImportableKey, blob.Public, blob.Duplicate,
+		keyfile.WithSecret(blob.Seed),
+		keyfile.WithParent(handle),
+		keyfile.WithPolicy(policy),
+		keyfile.WithUserAuth(sensitive.AuthValue.Buffer),
+	), nil
+}
+
+// innerWrap encrypts the TPM2B_SENSITIVE sens, prefixed with its integrity
+// digest H_nameAlg(sens || name), with key.
+func innerWrap(nameAlg tpm2.TPMAlgID, name, key, sens []byte) ([]byte, error) {
+	h, err := nameAlg.Hash()
+	if err != nil {
+		return nil, fmt.Errorf("tpmwrap: %v", err)
+	}
+	digest := h.New()
+	digest.Write(sens)
+	digest.Write(name)
+	plain := append(tpm2.Marshal(tpm2.TPM2BDigest{Buffer: digest.Sum(nil)}), sens...)
+	return cfb(key, plain, true)
+}
+
+// outerWrap encrypts data to parent and returns the duplicate, the outer
+// HMAC followed by the ciphertext, and the encrypted seed.
+func outerWrap(parent *tpm2.TPMTPublic, name, data []byte) ([]byte, []byte, error) {
+	if !parent.ObjectAttributes.Restricted || !parent.ObjectAttributes.Decrypt {
+		return nil, nil, fmt.Errorf("tpmwrap: parent isn't a storage key")
+	}
+	kem, err := tpm2.ImportEncapsulationKey(parent)
+	if err != nil {
+		return nil, nil, fmt.Errorf("tpmwrap: unsupported parent: %v", err)
+	}
+	seed, encSeed, err := kem.Encapsulate(rand.Reader, labelDuplicate)
+	if err != nil {
+		return nil, nil, fmt.Errorf("tpmwrap: %v", err)
+	}
+	symKey, hmacKey, err := outerKeys(kem, seed, name)
+	if err != nil {
+		return nil, nil, err
+	}
+	enc, err := cfb(symKey, data, true)
+	if err != nil {
+		return nil, nil, err
+	}
+	h, _ := kem.NameAlg().Hash()
+	mac := hmac.New(h.New, hmacKey)
+	mac.Write(enc)
+	mac.Write(name)
+	dup := append(tpm2.Marshal(tpm2.TPM2BDigest{Buffer: mac.Sum(nil)}), enc...)
+	return dup, encSeed, nil
+}
+
+// outerKeys derives the outer wrapper's AES and HMAC keys from seed.
+func outerKeys(kem tpm2.LabeledEncapsulationKey, seed, name []byte) ([]byte, []byte, error) {
+	sym := kem.SymmetricParameters()
+	if sym.Algorithm != tpm2.TPMAlgAES {
+		return nil, nil, fmt.Errorf("tpmwrap: parent symmetric algorithm isn't AES")
+	}
+	if mode, err := sym.Mode.AES(); err != nil || *mode != tpm2.TPMAlgCFB {
+		return nil, nil, fmt.Errorf("tpmwrap: parent symmetric mode isn't CFB")
+	}
+	bits, err := sym.KeyBits.AES()
+	if err != nil {
+		return nil, nil, fmt.Errorf("tpmwrap: %v", err)
+	}
+	h, err := kem.NameAlg().Hash()
+	if err != nil {
+		return nil, nil, fmt.Errorf("tpmwrap: %v", err)
+	}
+	symKey := tpm2.KDFa(h, seed, labelStorage, name, nil, int(*bits))
+	hmacKey := tpm2.KDFa(h, seed, labelIntegrity, nil, nil, h.Size()*8)
+	return symKey, hmacKey, nil
+}
+
+// cfb runs AES-CFB with a zero IV, as all TPM wrappers do.
+func cfb(key, data []byte, encrypt bool) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("tpmwrap: %v", err)
+	}
+	out := make([]byte, len(data))
+	iv := make([]byte, block.BlockSize())
+	if encrypt {
+		cipher.NewCFBEncrypter(block, iv).XORKeyStream(out, data)
+	} else {
+		cipher.NewCFBDecrypter(block, iv).XORKeyStream(out, data)
+	}
+	return out, nil
+}
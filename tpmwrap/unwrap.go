@@ -0,0 +1,150 @@
+package tpmwrap
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/rsa"
+	"fmt"
+
+	"github.com/google/go-tpm/tpm2"
+)
+
+// Unwrap is TPM2_Import in software, for a parent whose private key priv
+// (*rsa.PrivateKey or *ecdsa.PrivateKey) is held outside a TPM: it recovers
+// the seed, checks the outer HMAC and returns the sensitive area of public.
+// encryptionKey is the inner wrapper key, nil if the duplicate has none.
+func Unwrap(priv crypto.PrivateKey, parent *tpm2.TPMTPublic, public *tpm2.TPMTPublic, duplicate, seed, encryptionKey []byte) (*tpm2.TPMTSensitive, error) {
+	name, err := tpm2.ObjectName(public)
+	if err != nil {
+		return nil, fmt.Errorf("tpmwrap: %v", err)
+	}
+	kem, err := tpm2.ImportEncapsulationKey(parent)
+	if err != nil {
+		return nil, fmt.Errorf("tpmwrap: unsupported parent: %v", err)
+	}
+	s, err := decapsulate(priv, parent, seed)
+	if err != nil {
+		return nil, err
+	}
+	symKey, hmacKey, err := outerKeys(kem, s, name.Buffer)
+	if err != nil {
+		return nil, err
+	}
+
+	mac, enc, err := splitDigest(duplicate)
+	if err != nil {
+		return nil, err
+	}
+	h, _ := kem.NameAlg().Hash()
+	want := hmac.New(h.New, hmacKey)
+	want.Write(enc)
+	want.Write(name.Buffer)
+	if !hmac.Equal(mac, want.Sum(nil)) {
+		return nil, fmt.Errorf("tpmwrap: outer integrity check failed, the duplicate isn't for this parent or this public")
+	}
+	data, err := cfb(symKey, enc, false)
+	if err != nil {
+		return nil, err
+	}
+
+	if encryptionKey != nil {
+		if data, err = innerUnwrap(public.NameAlg, name.Buffer, encryptionKey, data); err != nil {
+			return nil, err
+		}
+	}
+	sens, err := tpm2.Unmarshal[tpm2.TPM2BSensitive](data)
+	if err != nil {
+		return nil, fmt.Errorf("tpmwrap: bad sensitive area: %v", err)
+	}
+	t, err := sens.Contents()
+	if err != nil {
+		return nil, fmt.Errorf("tpmwrap: bad sensitive area: %v", err)
+	}
+	if t.SensitiveType != public.Type {
+		return nil, fmt.Errorf("tpmwrap: sensitive area type 0x%x doesn't match the public 0x%x", t.SensitiveType, public.Type)
+	}
+	return t, nil
+}
+
+// innerUnwrap decrypts an inner wrapper and checks its integrity digest.
+func innerUnwrap(nameAlg tpm2.TPMAlgID, name, key, data []byte) ([]byte, error) {
+	plain, err := cfb(key, data, false)
+	if err != nil {
+		return nil, err
+	}
+	h, err := nameAlg.Hash()
+	if err != nil {
+		return nil, fmt.Errorf("tpmwrap: %v", err)
+	}
+	// a wrong key decrypts to garbage, which may not even parse
+	integrity, sens, err := splitDigest(plain)
+	if err == nil {
+		digest := h.New()
+		digest.Write(sens)
+		digest.Write(name)
+		if hmac.Equal(integrity, digest.Sum(nil)) {
+			return sens, nil
+		}
+	}
+	return nil, fmt.Errorf("tpmwrap: inner integrity check failed, wrong inner wrapper key")
+}
+
+// splitDigest splits a TPM2B_DIGEST off the front of b.
+func splitDigest(b []byte) ([]byte, []byte, error) {
+	d, err := tpm2.Unmarshal[tpm2.TPM2BDigest](b)
+	if err != nil {
+		return nil, nil, fmt.Errorf("tpmwrap: bad duplicate: %v", err)
+	}
+	return d.Buffer, b[2+len(d.Buffer):], nil
+}
+
+// decapsulate recovers the seed encrypted to parent: RSA-OAEP with the
+// parent's name hash and label "DUPLICATE", or ECDH with the ephemeral point
+// followed by KDFe.
+func decapsulate(priv crypto.PrivateKey, parent *tpm2.TPMTPublic, seed []byte) ([]byte, error) {
+	h, err := parent.NameAlg.Hash()
+	if err != nil {
+		return nil, fmt.Errorf("tpmwrap: %v", err)
+	}
+	switch k := priv.(type) {
+	case *rsa.PrivateKey:
+		unique, err := parent.Unique.RSA()
+		if err != nil || parent.Type != tpm2.TPMAlgRSA || !bytes.Equal(k.N.Bytes(), unique.Buffer) {
+			return nil, fmt.Errorf("tpmwrap: private key doesn't match the parent")
+		}
+		s, err := rsa.DecryptOAEP(h.New(), nil, k, seed, []byte(labelDuplicate+"\x00"))
+		if err != nil {
+			return nil, fmt.Errorf("tpmwrap: can't decrypt seed: %v", err)
+		}
+		return s, nil
+	case *ecdsa.PrivateKey:
+		ecdhPriv, err := k.ECDH()
+		if err != nil {
+			return nil, fmt.Errorf("tpmwrap: bad EC key: %v", err)
+		}
+		unique, err := parent.Unique.ECC()
+		if err != nil || parent.Type != tpm2.TPMAlgECC {
+			return nil, fmt.Errorf("tpmwrap: private key doesn't match the parent")
+		}
+		pubX := eccPoint(ecdhPriv.PublicKey()).X.Buffer
+		if !bytes.Equal(pubX, unique.X.Buffer) {
+			return nil, fmt.Errorf("tpmwrap: private key doesn't match the parent")
+		}
+		point, err := tpm2.Unmarshal[tpm2.TPMSECCPoint](seed)
+		if err != nil {
+			return nil, fmt.Errorf("tpmwrap: bad encrypted seed: %v", err)
+		}
+		eph, err := ecdhPriv.Curve().NewPublicKey(append(append([]byte{4}, point.X.Buffer...), point.Y.Buffer...))
+		if err != nil {
+			return nil, fmt.Errorf("tpmwrap: bad ephemeral point: %v", err)
+		}
+		z, err := ecdhPriv.ECDH(eph)
+		if err != nil {
+			return nil, fmt.Errorf("tpmwrap: %v", err)
+		}
+		return tpm2.KDFe(h, z, labelDuplicate, point.X.Buffer, pubX, h.Size()*8), nil
+	}
+	return nil, fmt.Errorf("tpmwrap: unsupported parent private key %T", priv)
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/hex"
+	"flag"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	keyfile "github.com/foxboron/go-tpm-keyfiles"
+	"github.com/google/go-tpm/tpm2"
+	"github.com/ibiscum/tpm2/keyimport"
+	"github.com/ibiscum/tpm2/tpmwrap"
+)
+
+var (
+	parent       = flag.String("parent", "", "target parent: EK certificate (PEM or DER), TPM2B_PUBLIC, or PEM/DER public key with --template")
+	template     = flag.String("template", "", "template of a --parent public key or EK certificate: h2, srk-rsa, srk-ecc, ek-rsa, ek-ecc or a high range EK, see tpmwrap.Templates")
+	parentHandle = flag.String("parent-handle", "", "parent handle recorded in the keyfile (default: 0x40000001 for the H-2 SRK, 0x81010001/2 for an EK, 0x81000001 otherwise)")
+	in           = flag.String("in", "", "key or secret to wrap: PEM or DER private key, JWK, or the raw secret with --type hmac|aes|sealed")
+	keyType      = flag.String("type", "auto", "auto (private key or JWK), hmac, aes or sealed")
+	hash         = flag.String("hash", "sha256", "HMAC hash: sha1, sha256, sha384 or sha512")
+	password     = flag.String("password", "", "optional password of the wrapped key")
+	pcrs         = flag.String("pcrs", "", "bind the key to PCR values expected on the target: comma separated index=hex (eg 7=3d45...,23=0000...)")
+	pcrBank      = flag.String("pcr-bank", "sha256", "bank of the --pcrs values")
+	policySecret = flag.String("policy-secret", "", "bind the key to a hierarchy's auth with PolicySecret: owner or endorsement")
+	out          = flag.String("out", "", "importable TSS2 keyfile to write")
+	tools        = flag.String("tools", "", "also write PREFIX.pub, PREFIX.dpriv and PREFIX.seed for tpm2_import")
+)
+
+var hashes = map[string]tpm2.TPMAlgID{
+	"sha1":   tpm2.TPMAlgSHA1,
+	"sha256": tpm2.TPMAlgSHA256,
+	"sha384": tpm2.TPMAlgSHA384,
+	"sha512": tpm2.TPMAlgSHA512,
+}
+
+func main() {
+	flag.Parse()
+
+	if *parent == "" || *in == "" || *out == "" {
+		log.Fatalf("--parent, --in and --out are required")
+	}
+	h, ok := hashes[*hash]
+	if !ok {
+		log.Fatalf("unknown hash %q", *hash)
+	}
+
+	data, err := os.ReadFile(*parent)
+	if err != nil {
+		log.Fatalf("can't read parent: %v", err)
+	}
+	parentPub, err := tpmwrap.ParseParent(data, *template)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	parentName, err := tpm2.ObjectName(parentPub)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	handle := tpmwrap.DefaultHandle(parentPub)
+	if *parentHandle != "" {
+		p, err := strconv.ParseUint(*parentHandle, 0, 32)
+		if err != nil {
+			log.Fatalf("bad parent handle %q: %v", *parentHandle, err)
+		}
+		handle = tpm2.TPMHandle(p)
+	}
+
+	data, err = os.ReadFile(*in)
+	if err != nil {
+		log.Fatalf("can't read key: %v", err)
+	}
+	k, err := keyimport.Parse(data, *keyType, h, []byte(*password))
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	var pol tpmwrap.Policy
+	if *pcrs != "" {
+		bank, ok := hashes[*pcrBank]
+		if !ok {
+			log.Fatalf("unknown PCR bank %q", *pcrBank)
+		}
+		pol.Bank = bank
+		pol.PCRs = map[uint][]byte{}
+		for _, s := range strings.Split(*pcrs, ",") {
+			i, v, ok := strings.Cut(s, "=")
+			if !ok {
+				log.Fatalf("bad PCR %q, want index=hex", s)
+			}
+			idx, err := strconv.ParseUint(i, 10, 8)
+			if err != nil {
+				log.Fatalf("bad PCR index %q: %v", i, err)
+			}
+			value, err := hex.DecodeString(v)
+			if err != nil {
+				log.Fatalf("bad PCR %d value: %v", idx, err)
+			}
+			pol.PCRs[uint(idx)] = value
+		}
+	}
+	switch *policySecret {
+	case "":
+	case "owner":
+		pol.Secret = tpm2.TPMRHOwner
+	case "endorsement":
+		pol.Secret = tpm2.TPMRHEndorsement
+	default:
+		log.Fatalf("unknown --policy-secret hierarchy %q", *policySecret)
+	}
+	var steps []*keyfile.TPMPolicy
+	if len(pol.PCRs) > 0 || pol.Secret != 0 {
+		// as for keys made in the TPM, a password is still asked for on top
+		pol.AuthValue = *password != ""
+		if steps, err = pol.Apply(&k.Public); err != nil {
+			log.Fatalf("%v", err)
+		}
+	}
+
+	kf, err := tpmwrap.Keyfile(parentPub, handle, &k.Public, &k.Sensitive, steps)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	f, err := os.OpenFile(*out, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		log.Fatalf("can't create keyfile: %v", err)
+	}
+	defer f.Close()
+	if err := keyfile.Encode(f, kf); err != nil {
+		log.Fatalf("can't write keyfile: %v", err)
+	}
+
+	if *tools != "" {
+		for ext, b := range map[string][]byte{
+			".pub":   tpm2.Marshal(kf.Pubkey),
+			".dpriv": tpm2.Marshal(kf.Privkey),
+			".seed":  tpm2.Marshal(kf.Secret),
+		} {
+			if err := os.WriteFile(*tools+ext, b, 0600); err != nil {
+				log.Fatalf("can't write %s: %v", *tools+ext, err)
+			}
+		}
+	}
+
+	name, err := tpm2.ObjectName(&k.Public)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	log.Printf("wrapped key %s for parent %s (handle 0x%x), wrote %s", hex.EncodeToString(name.Buffer), hex.EncodeToString(parentName.Buffer), handle, *out)
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"crypto"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	keyfile "github.com/foxboron/go-tpm-keyfiles"
+	"github.com/google/go-tpm/tpm2"
+	"github.com/ibiscum/tpm2/tpmkey"
+	"github.com/ibiscum/tpm2/tpmwrap"
+)
+
+var (
+	key            = flag.String("key", "key.pem", "keyfile created with --signer")
+	signerKey      = flag.String("signer", "signer.pem", "policy signer private key, PEM or DER")
+	name           = flag.String("name", "", "name of the signed policy in the keyfile")
+	pcrs           = flag.String("pcrs", "", "comma separated PCR values the policy allows, index=hex (eg 7=...,23=...)")
+	pcrBank        = flag.String("pcr-bank", "sha256", "PCR bank of the values: sha1, sha256 or sha384")
+	policySecret   = flag.String("policy-secret", "", "require a hierarchy's auth: owner or endorsement")
+	passwordPolicy = flag.Bool("password-policy", false, "require the key password (PolicyAuthValue)")
+	out            = flag.String("out", "", "keyfile to write, with the signed policy added")
+)
+
+func main() {
+	flag.Parse()
+
+	if *out == "" {
+		log.Fatalf("--out is required")
+	}
+	hashes := map[string]tpm2.TPMAlgID{
+		"sha1":   tpm2.TPMAlgSHA1,
+		"sha256": tpm2.TPMAlgSHA256,
+		"sha384": tpm2.TPMAlgSHA384,
+	}
+
+	var pol tpmwrap.Policy
+	if *pcrs != "" {
+		bank, ok := hashes[*pcrBank]
+		if !ok {
+			log.Fatalf("unknown PCR bank %q", *pcrBank)
+		}
+		pol.Bank = bank
+		pol.PCRs = map[uint][]byte{}
+		for _, s := range strings.Split(*pcrs, ",") {
+			i, v, ok := strings.Cut(s, "=")
+			if !ok {
+				log.Fatalf("bad PCR %q, want index=hex", s)
+			}
+			idx, err := strconv.ParseUint(i, 10, 8)
+			if err != nil {
+				log.Fatalf("bad PCR index %q: %v", i, err)
+			}
+			value, err := hex.DecodeString(v)
+			if err != nil {
+				log.Fatalf("bad PCR %d value: %v", idx, err)
+			}
+			pol.PCRs[uint(idx)] = value
+		}
+	}
+	switch *policySecret {
+	case "":
+	case "owner":
+		pol.Secret = tpm2.TPMRHOwner
+	case "endorsement":
+		pol.Secret = tpm2.TPMRHEndorsement
+	default:
+		log.Fatalf("unknown --policy-secret hierarchy %q", *policySecret)
+	}
+	pol.AuthValue = *passwordPolicy
+	if len(pol.PCRs) == 0 && pol.Secret == 0 && !pol.AuthValue {
+		log.Fatalf("an empty policy would authorize anyone, give --pcrs, --policy-secret or --password-policy")
+	}
+
+	b, err := os.ReadFile(*key)
+	if err != nil {
+		log.Fatalf("can't read keyfile: %v", err)
+	}
+	k, err := keyfile.Decode(b)
+	if err != nil {
+		log.Fatalf("can't decode keyfile: %v", err)
+	}
+	pub, err := k.Pubkey.Contents()
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	steps, digest, err := pol.Steps(pub.NameAlg)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	b, err = os.ReadFile(*signerKey)
+	if err != nil {
+		log.Fatalf("can't read signer: %v", err)
+	}
+	signer, err := parseSigner(b)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	policyName := *name
+	if policyName == "" {
+		policyName = strconv.Itoa(len(k.AuthPolicy) + 1)
+	}
+	if err := tpmkey.AddSignedPolicy(k, signer, policyName, steps); err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	f, err := os.OpenFile(*out, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		log.Fatalf("can't create keyfile: %v", err)
+	}
+	defer f.Close()
+	if err := keyfile.Encode(f, k); err != nil {
+		log.Fatalf("can't write keyfile: %v", err)
+	}
+	log.Printf("signed policy %q (%s), %d signed policies, wrote %s", policyName, hex.EncodeToString(digest), len(k.AuthPolicy), *out)
+}
+
+// parseSigner reads a PKCS#8, PKCS#1 or SEC 1 private key.
+func parseSigner(data []byte) (crypto.Signer, error) {
+	der := data
+	if block, _ := pem.Decode(data); block != nil {
+		der = block.Bytes
+	}
+	if k, err := x509.ParsePKCS8PrivateKey(der); err == nil {
+		if s, ok := k.(crypto.Signer); ok {
+			return s, nil
+		}
+	}
+	if k, err := x509.ParsePKCS1PrivateKey(der); err == nil {
+		return k, nil
+	}
+	if k, err := x509.ParseECPrivateKey(der); err == nil {
+		return k, nil
+	}
+	return nil, fmt.Errorf("not a PKCS#8, PKCS#1 or SEC 1 private key")
+}
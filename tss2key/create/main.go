@@ -0,0 +1,155 @@
+package main
+
+import (
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"flag"
+	"io"
+	"log"
+	"net"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	keyfile "github.com/foxboron/go-tpm-keyfiles"
+	"github.com/google/go-tpm-tools/simulator"
+	"github.com/google/go-tpm/tpm2"
+	"github.com/google/go-tpm/tpm2/transport"
+	"github.com/google/go-tpm/tpmutil"
+	"github.com/ibiscum/tpm2/tpmkey"
+)
+
+var (
+	tpmPath      = flag.String("tpm-path", "/dev/tpmrm0", "Path to the TPM device (character device or a Unix socket).")
+	keyType      = flag.String("type", "ecc", "key type: rsa, ecc, hmac or sealed")
+	in           = flag.String("in", "", "HMAC secret or data to seal")
+	password     = flag.String("password", "", "optional key password")
+	pcrs         = flag.String("pcrs", "", "comma separated PCRs to bind the key to, at their current values (eg 7,23)")
+	pcrBank      = flag.String("pcr-bank", "sha256", "PCR bank: sha1, sha256 or sha384")
+	policySecret = flag.String("policy-secret", "", "also require a hierarchy's auth: owner or endorsement")
+	signer       = flag.String("signer", "", "public key, PEM or DER, whose signed policies authorize the key instead (PolicyAuthorize)")
+	description  = flag.String("description", "", "optional keyfile description")
+	out          = flag.String("out", "key.pem", "keyfile to write")
+)
+
+var TPMDEVICES = []string{"/dev/tpm0", "/dev/tpmrm0"}
+
+func OpenTPM(path string) (io.ReadWriteCloser, error) {
+	if slices.Contains(TPMDEVICES, path) {
+		return tpmutil.OpenTPM(path)
+	} else if path == "simulator" {
+		return simulator.GetWithFixedSeedInsecure(1073741825)
+	} else {
+		return net.Dial("tcp", path)
+	}
+}
+
+func main() {
+	flag.Parse()
+
+	banks := map[string]tpm2.TPMAlgID{
+		"sha1":   tpm2.TPMAlgSHA1,
+		"sha256": tpm2.TPMAlgSHA256,
+		"sha384": tpm2.TPMAlgSHA384,
+	}
+
+	var data []byte
+	if *in != "" {
+		var err error
+		if data, err = os.ReadFile(*in); err != nil {
+			log.Fatalf("can't read %s: %v", *in, err)
+		}
+	} else if *keyType == "hmac" || *keyType == "sealed" {
+		log.Fatalf("--in is required for %s keys", *keyType)
+	}
+
+	pol := &tpmkey.Policy{}
+	if *pcrs != "" {
+		bank, ok := banks[*pcrBank]
+		if !ok {
+			log.Fatalf("unknown PCR bank %q", *pcrBank)
+		}
+		pol.Bank = bank
+		for _, s := range strings.Split(*pcrs, ",") {
+			i, err := strconv.ParseUint(s, 10, 32)
+			if err != nil {
+				log.Fatalf("bad pcr %q: %v", s, err)
+			}
+			pol.PCRs = append(pol.PCRs, uint(i))
+		}
+	}
+	switch *policySecret {
+	case "":
+	case "owner":
+		pol.Secret = tpm2.TPMRHOwner
+	case "endorsement":
+		pol.Secret = tpm2.TPMRHEndorsement
+	default:
+		log.Fatalf("unknown --policy-secret hierarchy %q", *policySecret)
+	}
+	if *signer != "" {
+		if len(pol.PCRs) > 0 || pol.Secret != 0 {
+			log.Fatalf("--pcrs and --policy-secret go in the signed policies with --signer, see authorize")
+		}
+		b, err := os.ReadFile(*signer)
+		if err != nil {
+			log.Fatalf("can't read signer: %v", err)
+		}
+		if block, _ := pem.Decode(b); block != nil {
+			b = block.Bytes
+		}
+		if pol.Signer, err = x509.ParsePKIXPublicKey(b); err != nil {
+			log.Fatalf("can't parse signer: %v", err)
+		}
+	}
+
+	rwc, err := OpenTPM(*tpmPath)
+	if err != nil {
+		log.Fatalf("can't open TPM %q: %v", *tpmPath, err)
+	}
+	defer func() {
+		rwc.Close()
+	}()
+
+	rwr := transport.FromReadWriter(rwc)
+
+	auth := []byte(*password)
+	var k *keyfile.TPMKey
+	switch *keyType {
+	case "rsa":
+		k, err = tpmkey.Create(rwr, tpmkey.RSATemplate(2048, tpm2.TPMAlgNull, tpm2.TPMAlgNull), auth, pol)
+	case "ecc":
+		k, err = tpmkey.Create(rwr, tpmkey.ECCTemplate(tpm2.TPMECCNistP256, tpm2.TPMAlgNull, tpm2.TPMAlgNull), auth, pol)
+	case "hmac":
+		k, err = tpmkey.CreateHMAC(rwr, data, tpm2.TPMAlgSHA256, auth, pol)
+	case "sealed":
+		k, err = tpmkey.Seal(rwr, data, auth, pol)
+	default:
+		log.Fatalf("unknown key type %q", *keyType)
+	}
+	if err != nil {
+		log.Fatalf("can't create key: %v", err)
+	}
+	k.Description = *description
+
+	pub, err := k.Pubkey.Contents()
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	name, err := tpm2.ObjectName(pub)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	f, err := os.OpenFile(*out, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		log.Fatalf("can't create keyfile: %v", err)
+	}
+	defer f.Close()
+	if err := keyfile.Encode(f, k); err != nil {
+		log.Fatalf("can't write keyfile: %v", err)
+	}
+	log.Printf("created %s key %s with %d policy steps, wrote %s", *keyType, hex.EncodeToString(name.Buffer), len(k.Policy), *out)
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"io"
+	"log"
+	"net"
+	"os"
+	"slices"
+
+	keyfile "github.com/foxboron/go-tpm-keyfiles"
+	"github.com/google/go-tpm-tools/simulator"
+	"github.com/google/go-tpm/tpm2"
+	"github.com/google/go-tpm/tpm2/transport"
+	"github.com/google/go-tpm/tpmutil"
+	"github.com/ibiscum/tpm2/tpmkey"
+)
+
+var (
+	tpmPath  = flag.String("tpm-path", "/dev/tpmrm0", "Path to the TPM device (character device or a Unix socket).")
+	key      = flag.String("key", "key.pem", "TSS2 keyfile: loadable, importable or sealed data")
+	password = flag.String("password", "", "key password")
+	data     = flag.String("data", "foo", "data to sign or HMAC")
+)
+
+var TPMDEVICES = []string{"/dev/tpm0", "/dev/tpmrm0"}
+
+func OpenTPM(path string) (io.ReadWriteCloser, error) {
+	if slices.Contains(TPMDEVICES, path) {
+		return tpmutil.OpenTPM(path)
+	} else if path == "simulator" {
+		return simulator.GetWithFixedSeedInsecure(1073741825)
+	} else {
+		return net.Dial("tcp", path)
+	}
+}
+
+func main() {
+	flag.Parse()
+
+	b, err := os.ReadFile(*key)
+	if err != nil {
+		log.Fatalf("can't read keyfile: %v", err)
+	}
+	kf, err := keyfile.Decode(b)
+	if err != nil {
+		log.Fatalf("can't decode keyfile: %v", err)
+	}
+	for _, ap := range kf.AuthPolicy {
+		log.Printf("signed policy %q, %d steps", ap.Name, len(ap.Policy))
+	}
+
+	rwc, err := OpenTPM(*tpmPath)
+	if err != nil {
+		log.Fatalf("can't open TPM %q: %v", *tpmPath, err)
+	}
+	defer func() {
+		rwc.Close()
+	}()
+
+	rwr := transport.FromReadWriter(rwc)
+
+	k, err := tpmkey.Load(rwr, kf, []byte(*password))
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	defer k.Close()
+
+	switch {
+	case k.Public.Type == tpm2.TPMAlgKeyedHash && !k.Public.ObjectAttributes.SignEncrypt:
+		secret, err := k.Unseal()
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		log.Printf("unsealed %q", secret)
+	case k.Public.Type == tpm2.TPMAlgKeyedHash:
+		mac, err := k.HMAC([]byte(*data))
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		log.Printf("HMAC %s", hex.EncodeToString(mac))
+	default:
+		s, err := k.Signer()
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		digest := sha256.Sum256([]byte(*data))
+		sig, err := s.Sign(rand.Reader, digest[:], crypto.SHA256)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		switch pub := s.Public().(type) {
+		case *rsa.PublicKey:
+			err = rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], sig)
+		case *ecdsa.PublicKey:
+			if !ecdsa.VerifyASN1(pub, digest[:], sig) {
+				err = errors.New("ECDSA verification error")
+			}
+		}
+		if err != nil {
+			log.Fatalf("signature doesn't verify: %v", err)
+		}
+		log.Printf("signature %s verified", hex.EncodeToString(sig))
+	}
+}